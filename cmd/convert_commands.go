@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/parser"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	convertFormat    string
+	convertOutput    string
+	convertFamily    string
+	convertColumns   []string
+	convertTemplates []string
+)
+
+// Convert local log files without touching Azure, the status file or the
+// data path.
+var convertCmd = &cobra.Command{
+	Use:   "convert [file...]",
+	Short: "Convert local log files to csv, tsv, json, flat, cef, protobuf, ecs, ocsf, zeek, zeek-json, eve or template.",
+	Long: `Convert blobs downloaded from the insights-logs containers, or json files written by the file destination.
+Files may be gzipped. With no file, or -, standard input is read.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initViper()
+		log.SetOutput(os.Stderr)
+		if debug {
+			log.SetLevel(log.DebugLevel)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runConvert(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().StringVar(&convertFormat, "format", parser.FormatCSV, "Output format. csv, tsv, json, flat, cef, protobuf, ecs, ocsf, zeek, zeek-json, eve or template")
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "Output file. Defaults to standard output")
+	convertCmd.Flags().StringVar(&convertFamily, "family", "", "Only convert this log family. nsg_flow, nsg_event, appgw_access or appgw_firewall")
+	convertCmd.Flags().StringArrayVar(&convertColumns, "columns", nil, "Columns of a log family as family=column,column. Overrides csv_columns")
+	convertCmd.Flags().StringArrayVar(&convertTemplates, "template", nil, "Template file of a log family as family=path. Overrides template_files")
+}
+
+func runConvert(files []string) error {
+	columns := map[string][]string{}
+	err := viper.UnmarshalKey("csv_columns", &columns)
+	if err != nil {
+		return err
+	}
+	flagColumns, err := parser.ParseColumns(convertColumns)
+	if err != nil {
+		return err
+	}
+	for family, familyColumns := range flagColumns {
+		columns[family] = familyColumns
+	}
+	templates := viper.GetStringMapString("template_files")
+	for _, setting := range convertTemplates {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("invalid template %q. expected family=path", setting)
+		}
+		templates[parts[0]] = parts[1]
+	}
+	formatter, err := parser.NewEventFormatterWithOptions(convertFormat, parser.FormatOptions{Columns: columns, Templates: templates})
+	if err != nil {
+		return err
+	}
+	enrichers, err := initEnrichers(viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	records := [][]byte{}
+	families := map[string]bool{}
+	for _, name := range files {
+		fileEvents, err := readConvertFile(name)
+		if err != nil {
+			return err
+		}
+		for _, event := range fileEvents {
+			if convertFamily != "" && event.LogFamily() != convertFamily {
+				continue
+			}
+			for _, enricher := range enrichers {
+				enricher.Enrich(event)
+			}
+			record, err := formatter.Format(event)
+			if err == parser.ErrSkipEvent {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			families[event.LogFamily()] = true
+			records = append(records, parser.FrameRecord(formatter, record))
+		}
+	}
+
+	var header []byte
+	if headerFormatter, ok := formatter.(parser.HeaderFormatter); ok {
+		family := convertFamily
+		if family == "" {
+			names := []string{}
+			for name := range families {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(names) > 1 {
+				return fmt.Errorf("input holds %s events. choose one with --family", strings.Join(names, ", "))
+			}
+			if len(names) == 1 {
+				family = names[0]
+			}
+		}
+		if family != "" {
+			header, err = headerFormatter.Header(family)
+			if err != nil {
+				return err
+			}
+			header = append(header, '\n')
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if convertOutput != "" {
+		file, err := os.Create(convertOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+	writer.Write(header)
+	for _, record := range records {
+		writer.Write(record)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	log.WithField("events", len(records)).Debug("converted events")
+	return nil
+}
+
+func readConvertFile(name string) ([]*parser.CEFEvent, error) {
+	if name == "-" {
+		return parser.ReadEvents(os.Stdin)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parser.ReadEvents(file)
+}
@@ -7,6 +7,8 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -16,7 +18,6 @@ var (
 	containerName   string
 	nsgAzureClient  parser.AzureClient
 	syslogClient    parser.CEFSyslogClient
-	fileClient      parser.FileClient
 	daemon          bool
 	pollInterval    int
 	prefix          string
@@ -24,6 +25,10 @@ var (
 	serveHttp       bool
 	serveBind       string
 	destinationType string
+	geoIPEnrichers  = map[parser.GeoIPConfig]*parser.GeoIPEnricher{}
+	inventories     = map[string]*parser.AssetInventory{}
+	classifiers     = map[string]*parser.TrafficClassifier{}
+	threatIntels    = map[string]*parser.ThreatIntel{}
 )
 
 var processCmd = &cobra.Command{
@@ -31,26 +36,39 @@ var processCmd = &cobra.Command{
 	Short: "Process NSG Files from Azure Blob Storage",
 	Run: func(cmd *cobra.Command, args []string) {
 		initClient()
-		var processFunc func()
-		switch destinationType = viper.GetString("destination"); destinationType {
-		case parser.DestinationFile:
-			initFileClient()
-			processFunc = processFiles
-		case parser.DestinationSyslog:
-			initSyslog()
-			processFunc = processSyslog
-		default:
-			log.Fatalf("type must be one of file or syslog")
+		afterTime, err := parseBeginTime(viper.GetString("begin_time"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		destinations, err := initDestinations()
+		if err != nil {
+			log.Fatal(err)
 		}
 		if serveHttp {
 			go startHttpServer()
 		}
-		for {
-			processFunc()
-			if !daemon {
-				break
+		// Each destination polls on its own, so a slow destination does not
+		// hold back the others.
+		var wg sync.WaitGroup
+		for _, destination := range destinations {
+			wg.Add(1)
+			go func(destination *parser.Destination) {
+				defer wg.Done()
+				for {
+					processDestination(destination, afterTime)
+					if !daemon {
+						break
+					}
+					time.Sleep(time.Duration(pollInterval) * time.Second)
+				}
+			}(destination)
+		}
+		wg.Wait()
+		for _, destination := range destinations {
+			err := destination.Close()
+			if err != nil {
+				log.Error(err)
 			}
-			time.Sleep(time.Duration(pollInterval) * time.Second)
 		}
 	},
 }
@@ -59,7 +77,7 @@ func init() {
 	processCmd.PersistentFlags().BoolVarP(&daemon, "daemon", "d", false, "")
 
 	processCmd.PersistentFlags().String("prefix", "", "Azure Blob Prefix. Optional")
-	processCmd.PersistentFlags().String("destination", "file", "file or syslog")
+	processCmd.PersistentFlags().String("destination", "file", "file, syslog, splunk, loganalytics, kafka, lumberjack, gelf, loki, ipfix, parquet or stream")
 
 	processCmd.PersistentFlags().String("storage_account_name", "", "Azure Account Name")
 	processCmd.PersistentFlags().String("storage_account_key", "", "Azure Account Key")
@@ -68,13 +86,97 @@ func init() {
 
 	processCmd.PersistentFlags().Int("poll_interval", 60, "Interval in Seconds to check Storage Account for Log updates.")
 
+	processCmd.PersistentFlags().Int("aggregation_window", 0, "Summarize flow tuples per conversation over windows of this many seconds. 0 to send every tuple")
+	processCmd.PersistentFlags().Bool("aggregation_ignore_source_port", false, "Summarize tuples that differ only in their source port together?")
+	processCmd.PersistentFlags().Int("aggregation_flush_delay", 900, "Send aggregation windows this many seconds after their end without a later tuple. 0 to wait for a later tuple")
+	processCmd.PersistentFlags().Int("session_timeout", 0, "Join version 2 flow tuples into connection records, expiring sessions without an End tuple after this many seconds. 0 to send every tuple")
+	processCmd.PersistentFlags().String("session_counters", "delta", "Version 2 counters are delta, counting since the previous tuple, or cumulative")
+
+	processCmd.PersistentFlags().String("geoip_city_database", "", "MaxMind DB file, such as GeoLite2-City.mmdb, to add the location of public addresses")
+	processCmd.PersistentFlags().String("geoip_asn_database", "", "MaxMind DB file, such as GeoLite2-ASN.mmdb, to add the autonomous system of public addresses")
+	processCmd.PersistentFlags().Int("geoip_cache_size", 10000, "Number of addresses to cache GeoIP lookups for")
+	processCmd.PersistentFlags().Int("geoip_reload_interval", 60, "Interval in Seconds to check GeoIP databases for updates")
+
+	processCmd.PersistentFlags().String("inventory_file", "", "CSV or JSON inventory mapping IPs and MACs to VM, NIC, subnet, VNet, application and owner")
+	processCmd.PersistentFlags().Int("inventory_reload_interval", 60, "Interval in Seconds to check the inventory file for updates")
+
+	processCmd.PersistentFlags().String("service_tags_file", "", "Azure IP Ranges and Service Tags JSON file to classify Azure addresses with")
+	processCmd.PersistentFlags().Int("service_tags_reload_interval", 60, "Interval in Seconds to check the service tags file for updates")
+	processCmd.PersistentFlags().StringSlice("traffic_peered_cidrs", []string{}, "Address spaces of peered VNets. cidr,cidr")
+	processCmd.PersistentFlags().StringSlice("traffic_onprem_cidrs", []string{}, "On-premises address spaces. cidr,cidr")
+
+	processCmd.PersistentFlags().StringSlice("pseudonymize_cidrs", []string{}, "Pseudonymize the addresses of these ranges with pseudonym_keys. cidr,cidr")
+	processCmd.PersistentFlags().Bool("pseudonymize_macs", false, "Pseudonymize MACs with pseudonym_keys")
+
+	processCmd.PersistentFlags().Int("threat_severity", 8, "CEF severity, 1-10, to raise events matching a threat feed to")
+	processCmd.PersistentFlags().Int("threat_reload_interval", 60, "Interval in Seconds to check threat feeds for updates")
+
 	processCmd.PersistentFlags().Bool("serve_http", false, "Serve an HTTP Endpoint with Status Details?")
 	processCmd.PersistentFlags().String("serve_http_bind", "127.0.0.1:9889", "IP:PORT on which to serve. 0.0.0.0 for all.")
 
+	processCmd.PersistentFlags().String("file_path", "", "Directory for output files. Defaults to output in data_path")
+	processCmd.PersistentFlags().String("file_format", "json", "File format. json, flat, cef, csv, tsv, protobuf, ecs, ocsf, zeek, zeek-json, eve or template")
+	processCmd.PersistentFlags().String("file_partition", "category={{.Family}}/nsg={{.Resource}}/date={{.Date}}/hour={{.Hour}}", "Partition directory template")
+	processCmd.PersistentFlags().Int("file_rotate_size", 128, "Rotate files after this many MB")
+	processCmd.PersistentFlags().Int("file_rotate_interval", 300, "Rotate files after this many seconds")
+	processCmd.PersistentFlags().Bool("file_gzip", false, "Gzip compress rotated files?")
+	processCmd.PersistentFlags().String("file_extension", "txt", "Extension of files written with the template format")
+
 	processCmd.PersistentFlags().String("syslog_protocol", "tcp", "Syslog Protocol. tcp or udp")
 	processCmd.PersistentFlags().String("syslog_host", "127.0.0.1", "Syslog Hostname or IP")
 	processCmd.PersistentFlags().String("syslog_port", "5514", "Syslog Port")
 
+	processCmd.PersistentFlags().String("splunk_url", "", "Splunk HTTP Event Collector base URL. e.g. https://splunk:8088")
+	processCmd.PersistentFlags().String("splunk_token", "", "Splunk HTTP Event Collector token")
+	processCmd.PersistentFlags().String("splunk_index", "", "Splunk index. Defaults to the index configured for the token.")
+	processCmd.PersistentFlags().Bool("splunk_gzip", false, "Gzip compress requests to Splunk?")
+	processCmd.PersistentFlags().Bool("splunk_ack", false, "Wait for Splunk indexer acknowledgement before checkpointing?")
+
+	processCmd.PersistentFlags().String("loganalytics_workspace_id", "", "Log Analytics Workspace ID")
+	processCmd.PersistentFlags().String("loganalytics_shared_key", "", "Log Analytics Workspace primary or secondary key")
+
+	processCmd.PersistentFlags().StringSlice("kafka_brokers", []string{}, "Kafka bootstrap brokers. host:port,host:port")
+	processCmd.PersistentFlags().String("kafka_topic", "nsg-parser", "Kafka topic. A template using {{.Family}}, {{.Resource}}, {{.Subscription}} and {{.ResourceGroup}}")
+	processCmd.PersistentFlags().String("kafka_key", "nsg", "Kafka message key. none, nsg or tuple")
+	processCmd.PersistentFlags().String("kafka_format", "json", "Kafka message format. json, flat, cef, protobuf, ecs, ocsf, zeek-json, eve or template")
+	processCmd.PersistentFlags().String("kafka_compression", "none", "Kafka compression. none, gzip, snappy or lz4")
+	processCmd.PersistentFlags().String("kafka_required_acks", "leader", "Acks required before checkpointing. none, leader or all")
+	processCmd.PersistentFlags().Bool("kafka_tls", false, "Connect to Kafka brokers with TLS?")
+	processCmd.PersistentFlags().String("kafka_sasl_mechanism", "", "Kafka SASL mechanism. PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+	processCmd.PersistentFlags().String("kafka_sasl_username", "", "Kafka SASL username")
+	processCmd.PersistentFlags().String("kafka_sasl_password", "", "Kafka SASL password")
+
+	processCmd.PersistentFlags().StringSlice("lumberjack_hosts", []string{}, "Logstash beats input hosts. host:port,host:port")
+	processCmd.PersistentFlags().Int("lumberjack_window_size", 1024, "Events sent per window before waiting for an ACK")
+	processCmd.PersistentFlags().Int("lumberjack_compression_level", 3, "zlib compression level. 0 disables compression")
+	processCmd.PersistentFlags().Bool("lumberjack_tls", false, "Connect to Logstash with TLS?")
+	processCmd.PersistentFlags().String("lumberjack_schema", "", "Send ecs or ocsf documents instead of events")
+
+	processCmd.PersistentFlags().String("gelf_address", "", "Graylog GELF input host:port")
+	processCmd.PersistentFlags().String("gelf_protocol", "udp", "GELF transport. udp or tcp")
+	processCmd.PersistentFlags().String("gelf_compression", "gzip", "GELF UDP compression. gzip, zlib or none")
+	processCmd.PersistentFlags().Int("gelf_chunk_size", 1420, "Maximum GELF UDP datagram size")
+
+	processCmd.PersistentFlags().String("loki_url", "", "Loki base URL. e.g. http://loki:3100")
+	processCmd.PersistentFlags().String("loki_tenant_id", "", "Loki tenant, sent as X-Scope-OrgID")
+	processCmd.PersistentFlags().String("loki_format", "json", "Loki line format. json, flat, cef, ecs, ocsf, zeek-json, eve or template")
+	processCmd.PersistentFlags().Bool("loki_gzip", false, "Gzip compress pushes to Loki?")
+
+	processCmd.PersistentFlags().String("ipfix_collector", "", "IPFIX / NetFlow collector host:port")
+	processCmd.PersistentFlags().Int("ipfix_version", 10, "10 for IPFIX or 9 for NetFlow v9")
+	processCmd.PersistentFlags().Int("ipfix_mtu", 1400, "Maximum IPFIX message size")
+
+	processCmd.PersistentFlags().String("parquet_path", "", "Parquet output directory. Defaults to data_path/parquet")
+	processCmd.PersistentFlags().String("parquet_partition", "", "Parquet partition template. Must include {{.Family}}")
+	processCmd.PersistentFlags().String("parquet_compression", "snappy", "Parquet compression: snappy, zstd or none")
+	processCmd.PersistentFlags().Int("parquet_row_group_size", 100000, "Rows per Parquet row group")
+	processCmd.PersistentFlags().Int("parquet_rotate_size", 128, "Rotate Parquet files after this many MB of journaled events")
+	processCmd.PersistentFlags().Int("parquet_rotate_interval", 300, "Rotate Parquet files after this many seconds")
+
+	processCmd.PersistentFlags().String("stream_address", "", "TCP address for the stream destination. Defaults to stdout")
+	processCmd.PersistentFlags().String("stream_format", "protobuf", "Stream format. protobuf, json, flat, cef, csv, tsv, ecs, ocsf, zeek, zeek-json, eve or template")
+	processCmd.PersistentFlags().Int("stream_timeout", 30, "Stream connect and write timeout in seconds")
+
 	viper.BindPFlag("prefix", processCmd.PersistentFlags().Lookup("prefix"))
 	viper.BindPFlag("destination", processCmd.PersistentFlags().Lookup("destination"))
 	viper.BindPFlag("begin_time", processCmd.PersistentFlags().Lookup("begin_time"))
@@ -82,13 +184,91 @@ func init() {
 	viper.BindPFlag("storage_account_name", processCmd.PersistentFlags().Lookup("storage_account_name"))
 	viper.BindPFlag("storage_account_key", processCmd.PersistentFlags().Lookup("storage_account_key"))
 	viper.BindPFlag("container_name", processCmd.PersistentFlags().Lookup("container_name"))
+	viper.BindPFlag("aggregation_window", processCmd.PersistentFlags().Lookup("aggregation_window"))
+	viper.BindPFlag("aggregation_ignore_source_port", processCmd.PersistentFlags().Lookup("aggregation_ignore_source_port"))
+	viper.BindPFlag("aggregation_flush_delay", processCmd.PersistentFlags().Lookup("aggregation_flush_delay"))
+	viper.BindPFlag("session_timeout", processCmd.PersistentFlags().Lookup("session_timeout"))
+	viper.BindPFlag("session_counters", processCmd.PersistentFlags().Lookup("session_counters"))
+	viper.BindPFlag("geoip_city_database", processCmd.PersistentFlags().Lookup("geoip_city_database"))
+	viper.BindPFlag("geoip_asn_database", processCmd.PersistentFlags().Lookup("geoip_asn_database"))
+	viper.BindPFlag("geoip_cache_size", processCmd.PersistentFlags().Lookup("geoip_cache_size"))
+	viper.BindPFlag("geoip_reload_interval", processCmd.PersistentFlags().Lookup("geoip_reload_interval"))
+	viper.BindPFlag("inventory_file", processCmd.PersistentFlags().Lookup("inventory_file"))
+	viper.BindPFlag("inventory_reload_interval", processCmd.PersistentFlags().Lookup("inventory_reload_interval"))
+	viper.BindPFlag("service_tags_file", processCmd.PersistentFlags().Lookup("service_tags_file"))
+	viper.BindPFlag("service_tags_reload_interval", processCmd.PersistentFlags().Lookup("service_tags_reload_interval"))
+	viper.BindPFlag("traffic_peered_cidrs", processCmd.PersistentFlags().Lookup("traffic_peered_cidrs"))
+	viper.BindPFlag("traffic_onprem_cidrs", processCmd.PersistentFlags().Lookup("traffic_onprem_cidrs"))
+	viper.BindPFlag("pseudonymize_cidrs", processCmd.PersistentFlags().Lookup("pseudonymize_cidrs"))
+	viper.BindPFlag("pseudonymize_macs", processCmd.PersistentFlags().Lookup("pseudonymize_macs"))
+	viper.BindPFlag("threat_severity", processCmd.PersistentFlags().Lookup("threat_severity"))
+	viper.BindPFlag("threat_reload_interval", processCmd.PersistentFlags().Lookup("threat_reload_interval"))
 	viper.BindPFlag("serve_http", processCmd.PersistentFlags().Lookup("serve_http"))
 	viper.BindPFlag("serve_http_bind", processCmd.PersistentFlags().Lookup("serve_http_bind"))
 
+	viper.BindPFlag("file_path", processCmd.PersistentFlags().Lookup("file_path"))
+	viper.BindPFlag("file_format", processCmd.PersistentFlags().Lookup("file_format"))
+	viper.BindPFlag("file_partition", processCmd.PersistentFlags().Lookup("file_partition"))
+	viper.BindPFlag("file_rotate_size", processCmd.PersistentFlags().Lookup("file_rotate_size"))
+	viper.BindPFlag("file_rotate_interval", processCmd.PersistentFlags().Lookup("file_rotate_interval"))
+	viper.BindPFlag("file_gzip", processCmd.PersistentFlags().Lookup("file_gzip"))
+	viper.BindPFlag("file_extension", processCmd.PersistentFlags().Lookup("file_extension"))
+
 	viper.BindPFlag("syslog_protocol", processCmd.PersistentFlags().Lookup("syslog_protocol"))
 	viper.BindPFlag("syslog_host", processCmd.PersistentFlags().Lookup("syslog_host"))
 	viper.BindPFlag("syslog_port", processCmd.PersistentFlags().Lookup("syslog_port"))
 
+	viper.BindPFlag("splunk_url", processCmd.PersistentFlags().Lookup("splunk_url"))
+	viper.BindPFlag("splunk_token", processCmd.PersistentFlags().Lookup("splunk_token"))
+	viper.BindPFlag("splunk_index", processCmd.PersistentFlags().Lookup("splunk_index"))
+	viper.BindPFlag("splunk_gzip", processCmd.PersistentFlags().Lookup("splunk_gzip"))
+	viper.BindPFlag("splunk_ack", processCmd.PersistentFlags().Lookup("splunk_ack"))
+
+	viper.BindPFlag("loganalytics_workspace_id", processCmd.PersistentFlags().Lookup("loganalytics_workspace_id"))
+	viper.BindPFlag("loganalytics_shared_key", processCmd.PersistentFlags().Lookup("loganalytics_shared_key"))
+
+	viper.BindPFlag("kafka_brokers", processCmd.PersistentFlags().Lookup("kafka_brokers"))
+	viper.BindPFlag("kafka_topic", processCmd.PersistentFlags().Lookup("kafka_topic"))
+	viper.BindPFlag("kafka_key", processCmd.PersistentFlags().Lookup("kafka_key"))
+	viper.BindPFlag("kafka_format", processCmd.PersistentFlags().Lookup("kafka_format"))
+	viper.BindPFlag("kafka_compression", processCmd.PersistentFlags().Lookup("kafka_compression"))
+	viper.BindPFlag("kafka_required_acks", processCmd.PersistentFlags().Lookup("kafka_required_acks"))
+	viper.BindPFlag("kafka_tls", processCmd.PersistentFlags().Lookup("kafka_tls"))
+	viper.BindPFlag("kafka_sasl_mechanism", processCmd.PersistentFlags().Lookup("kafka_sasl_mechanism"))
+	viper.BindPFlag("kafka_sasl_username", processCmd.PersistentFlags().Lookup("kafka_sasl_username"))
+	viper.BindPFlag("kafka_sasl_password", processCmd.PersistentFlags().Lookup("kafka_sasl_password"))
+
+	viper.BindPFlag("lumberjack_hosts", processCmd.PersistentFlags().Lookup("lumberjack_hosts"))
+	viper.BindPFlag("lumberjack_window_size", processCmd.PersistentFlags().Lookup("lumberjack_window_size"))
+	viper.BindPFlag("lumberjack_compression_level", processCmd.PersistentFlags().Lookup("lumberjack_compression_level"))
+	viper.BindPFlag("lumberjack_tls", processCmd.PersistentFlags().Lookup("lumberjack_tls"))
+	viper.BindPFlag("lumberjack_schema", processCmd.PersistentFlags().Lookup("lumberjack_schema"))
+
+	viper.BindPFlag("gelf_address", processCmd.PersistentFlags().Lookup("gelf_address"))
+	viper.BindPFlag("gelf_protocol", processCmd.PersistentFlags().Lookup("gelf_protocol"))
+	viper.BindPFlag("gelf_compression", processCmd.PersistentFlags().Lookup("gelf_compression"))
+	viper.BindPFlag("gelf_chunk_size", processCmd.PersistentFlags().Lookup("gelf_chunk_size"))
+
+	viper.BindPFlag("loki_url", processCmd.PersistentFlags().Lookup("loki_url"))
+	viper.BindPFlag("loki_tenant_id", processCmd.PersistentFlags().Lookup("loki_tenant_id"))
+	viper.BindPFlag("loki_format", processCmd.PersistentFlags().Lookup("loki_format"))
+	viper.BindPFlag("loki_gzip", processCmd.PersistentFlags().Lookup("loki_gzip"))
+
+	viper.BindPFlag("ipfix_collector", processCmd.PersistentFlags().Lookup("ipfix_collector"))
+	viper.BindPFlag("ipfix_version", processCmd.PersistentFlags().Lookup("ipfix_version"))
+	viper.BindPFlag("ipfix_mtu", processCmd.PersistentFlags().Lookup("ipfix_mtu"))
+
+	viper.BindPFlag("parquet_path", processCmd.PersistentFlags().Lookup("parquet_path"))
+	viper.BindPFlag("parquet_partition", processCmd.PersistentFlags().Lookup("parquet_partition"))
+	viper.BindPFlag("parquet_compression", processCmd.PersistentFlags().Lookup("parquet_compression"))
+	viper.BindPFlag("parquet_row_group_size", processCmd.PersistentFlags().Lookup("parquet_row_group_size"))
+	viper.BindPFlag("parquet_rotate_size", processCmd.PersistentFlags().Lookup("parquet_rotate_size"))
+	viper.BindPFlag("parquet_rotate_interval", processCmd.PersistentFlags().Lookup("parquet_rotate_interval"))
+
+	viper.BindPFlag("stream_address", processCmd.PersistentFlags().Lookup("stream_address"))
+	viper.BindPFlag("stream_format", processCmd.PersistentFlags().Lookup("stream_format"))
+	viper.BindPFlag("stream_timeout", processCmd.PersistentFlags().Lookup("stream_timeout"))
+
 	RootCmd.AddCommand(processCmd)
 }
 
@@ -118,36 +298,360 @@ func initClient() {
 }
 
 func initSyslog() {
-	slProtocol := viper.GetString("syslog_protocol")
-	slHost := viper.GetString("syslog_host")
-	slPort := viper.GetString("syslog_port")
-	err := syslogClient.Initialize(slProtocol, slHost, slPort)
+	err := initSyslogClient(&syslogClient, viper.GetViper())
 	if err != nil {
-		log.Fatalf("error initializing syslog client %s", err)
+		log.Fatal(err)
 	}
 }
 
-func initFileClient() {
-	fileClient.Initialize(dataPath)
+func initSyslogClient(client *parser.CEFSyslogClient, settings *viper.Viper) error {
+	slProtocol := settings.GetString("syslog_protocol")
+	slHost := settings.GetString("syslog_host")
+	slPort := settings.GetString("syslog_port")
+	err := client.Initialize(slProtocol, slHost, slPort)
+	if err != nil {
+		return fmt.Errorf("error initializing syslog client %s", err)
+	}
+	return nil
 }
 
-func processFiles() {
-	beginTime := viper.GetString("begin_time")
-	afterTime, err := time.Parse(timeLayout, fmt.Sprintf("%s-00-00-GMT", beginTime))
-	err = nsgAzureClient.ProcessBlobsAfter(afterTime, fileClient, "file")
+// initDestinations initializes the destinations listed in destinations or,
+// without a list, the single destination. A single destination keeps the job
+// name, and so the status file, of its type.
+func initDestinations() ([]*parser.Destination, error) {
+	entries := []map[string]interface{}{}
+	err := viper.UnmarshalKey("destinations", &entries)
 	if err != nil {
-		log.Error(err)
+		return nil, fmt.Errorf("error reading destinations %s", err)
 	}
+	if len(entries) == 0 {
+		destinationType := viper.GetString("destination")
+		sender, err := newEventSender(destinationType, viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		sender, err = initFlowStage(sender, viper.GetViper(), destinationType)
+		if err != nil {
+			return nil, err
+		}
+		destination, err := parser.NewDestination(destinationType, destinationType, nil, sender)
+		if err != nil {
+			return nil, err
+		}
+		destination.Enrichers, err = initEnrichers(viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		destination.Filters, err = initFilters(destination.Name, viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		destination.Pseudonymizer, err = initPseudonymizer(viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		destinations := []*parser.Destination{destination}
+		return destinations, initRoutes(destinations)
+	}
+
+	destinations := []*parser.Destination{}
+	names := map[string]bool{}
+	for i, entry := range entries {
+		settings := destinationSettings(entry)
+		name := settings.GetString("name")
+		if name == "" {
+			return nil, fmt.Errorf("destination %d has no name", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("destination name %s is used twice", name)
+		}
+		names[name] = true
+		sender, err := newEventSender(settings.GetString("type"), settings)
+		if err == nil {
+			sender, err = initFlowStage(sender, settings, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		destination, err := parser.NewDestination(name, settings.GetString("type"), settings.GetStringSlice("families"), sender)
+		if err != nil {
+			return nil, err
+		}
+		destination.Enrichers, err = initEnrichers(settings)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		destination.Filters, err = initFilters(name, settings)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		destination.Pseudonymizer, err = initPseudonymizer(settings)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		destinations = append(destinations, destination)
+	}
+	err = initRoutes(destinations)
+	if err != nil {
+		return nil, err
+	}
+	return destinations, nil
 }
 
-func processSyslog() {
-	beginTime := viper.GetString("begin_time")
+// initFlowStage wraps sender in a flow aggregator when aggregation_window is
+// set, or in a sessionizer when session_timeout is set, keeping their state in
+// data_path.
+func initFlowStage(sender parser.EventSender, settings *viper.Viper, name string) (parser.EventSender, error) {
+	window := settings.GetInt("aggregation_window")
+	timeout := settings.GetInt("session_timeout")
+	switch {
+	case window > 0 && timeout > 0:
+		return nil, fmt.Errorf("aggregation_window and session_timeout cannot be combined")
+	case window > 0:
+		stateFile := filepath.Join(dataPath, fmt.Sprintf("nsg-parser-aggregation-%s.json", name))
+		flushDelay := time.Duration(settings.GetInt("aggregation_flush_delay")) * time.Second
+		aggregator, err := parser.NewFlowAggregator(time.Duration(window)*time.Second, flushDelay, stateFile, sender)
+		if err != nil {
+			return nil, err
+		}
+		aggregator.IgnoreSourcePort = settings.GetBool("aggregation_ignore_source_port")
+		return aggregator, nil
+	case timeout > 0:
+		stateFile := filepath.Join(dataPath, fmt.Sprintf("nsg-parser-sessions-%s.json", name))
+		return parser.NewSessionizer(time.Duration(timeout)*time.Second, settings.GetString("session_counters"), stateFile, sender)
+	default:
+		return sender, nil
+	}
+}
+
+// initEnrichers returns the enrichers configured in settings, the inventory
+// first so that traffic is classified by the VNets it sets. Destinations with
+// the same settings share one enricher, and so its data and cache.
+func initEnrichers(settings *viper.Viper) ([]parser.EventEnricher, error) {
+	enrichers := []parser.EventEnricher{}
+	if path := settings.GetString("inventory_file"); path != "" {
+		reloadInterval := time.Duration(settings.GetInt("inventory_reload_interval")) * time.Second
+		key := fmt.Sprintf("%s|%s", path, reloadInterval)
+		inventory, ok := inventories[key]
+		if !ok {
+			var err error
+			inventory, err = parser.NewAssetInventory(path, reloadInterval)
+			if err != nil {
+				return nil, err
+			}
+			inventories[key] = inventory
+		}
+		enrichers = append(enrichers, inventory)
+	}
+	trafficConfig := parser.TrafficConfig{}
+	err := settings.Unmarshal(&trafficConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error reading traffic settings %s", err)
+	}
+	if trafficConfig.Enabled() {
+		key := fmt.Sprintf("%v", trafficConfig)
+		classifier, ok := classifiers[key]
+		if !ok {
+			classifier, err = parser.NewTrafficClassifier(trafficConfig)
+			if err != nil {
+				return nil, err
+			}
+			classifiers[key] = classifier
+		}
+		enrichers = append(enrichers, classifier)
+	}
+	config := parser.GeoIPConfig{}
+	err = settings.Unmarshal(&config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading geoip settings %s", err)
+	}
+	if config.CityDatabase != "" || config.ASNDatabase != "" {
+		enricher, ok := geoIPEnrichers[config]
+		if !ok {
+			enricher, err = parser.NewGeoIPEnricher(config)
+			if err != nil {
+				return nil, err
+			}
+			geoIPEnrichers[config] = enricher
+		}
+		enrichers = append(enrichers, enricher)
+	}
+	threatConfig := parser.ThreatConfig{}
+	err = settings.Unmarshal(&threatConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error reading threat settings %s", err)
+	}
+	if len(threatConfig.Feeds) > 0 {
+		key := fmt.Sprintf("%v", threatConfig)
+		intel, ok := threatIntels[key]
+		if !ok {
+			intel, err = parser.NewThreatIntel(threatConfig)
+			if err != nil {
+				return nil, err
+			}
+			threatIntels[key] = intel
+		}
+		enrichers = append(enrichers, intel)
+	}
+	return enrichers, nil
+}
+
+// initFilters returns the filter chain of filters and filter_default for the
+// destination name, or nil without filters.
+func initFilters(name string, settings *viper.Viper) (*parser.FilterChain, error) {
+	filters := []parser.Filter{}
+	err := settings.UnmarshalKey("filters", &filters)
+	if err != nil {
+		return nil, fmt.Errorf("error reading filters %s", err)
+	}
+	defaultMode := settings.GetString("filter_default")
+	if len(filters) == 0 && defaultMode == "" {
+		return nil, nil
+	}
+	return parser.NewFilterChain(name, filters, defaultMode)
+}
+
+// initPseudonymizer returns the pseudonymizer configured in settings, or nil
+// when nothing is to be pseudonymized.
+func initPseudonymizer(settings *viper.Viper) (*parser.Pseudonymizer, error) {
+	config := parser.PseudonymConfig{}
+	err := settings.Unmarshal(&config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pseudonym settings %s", err)
+	}
+	if !config.Enabled() {
+		return nil, nil
+	}
+	return parser.NewPseudonymizer(config)
+}
+
+// initRoutes sets the route table of routes and default_route on every
+// destination. Without routes every destination receives every event.
+func initRoutes(destinations []*parser.Destination) error {
+	routes := []parser.Route{}
+	err := viper.UnmarshalKey("routes", &routes)
+	if err != nil {
+		return fmt.Errorf("error reading routes %s", err)
+	}
+	defaultRoute := viper.GetStringSlice("default_route")
+	if len(routes) == 0 && len(defaultRoute) == 0 {
+		return nil
+	}
+	names := make([]string, len(destinations))
+	for i, destination := range destinations {
+		names[i] = destination.Name
+	}
+	table, err := parser.NewRouteTable(routes, defaultRoute, names)
+	if err != nil {
+		return err
+	}
+	if len(defaultRoute) == 0 {
+		log.Warn("no default_route, events matching no route are dropped")
+	}
+	for _, destination := range destinations {
+		destination.Routes = table
+	}
+	return nil
+}
+
+// destinationSettings returns the global settings overridden by the keys of
+// a destinations entry.
+func destinationSettings(entry map[string]interface{}) *viper.Viper {
+	settings := viper.New()
+	for key, value := range viper.AllSettings() {
+		settings.Set(key, value)
+	}
+	for key, value := range entry {
+		settings.Set(key, value)
+	}
+	return settings
+}
+
+// newEventSender initializes a client of destinationType from settings.
+func newEventSender(destinationType string, settings *viper.Viper) (parser.EventSender, error) {
+	var sender parser.EventSender
+	var err error
+	switch destinationType {
+	case parser.DestinationFile:
+		client, config := &parser.FileClient{}, parser.FileConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationSyslog:
+		client := &parser.CEFSyslogClient{}
+		err = initSyslogClient(client, settings)
+		sender = client
+	case parser.DestinationSplunk:
+		client, config := &parser.SplunkClient{}, parser.SplunkConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationLogAnalytics:
+		client, config := &parser.LogAnalyticsClient{}, parser.LogAnalyticsConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationKafka:
+		client, config := &parser.KafkaClient{}, parser.KafkaConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationLumberjack:
+		client, config := &parser.LumberjackClient{}, parser.LumberjackConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationGelf:
+		client, config := &parser.GelfClient{}, parser.GelfConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationLoki:
+		client, config := &parser.LokiClient{}, parser.LokiConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationIpfix:
+		client, config := &parser.IpfixClient{}, parser.IpfixConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationParquet:
+		client, config := &parser.ParquetClient{}, parser.ParquetConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	case parser.DestinationStream:
+		client, config := &parser.StreamClient{}, parser.StreamConfig{}
+		err = initSender(settings, &config, func() error { return client.Initialize(config) })
+		sender = client
+	default:
+		return nil, fmt.Errorf("type must be one of file, syslog, splunk, loganalytics, kafka, lumberjack, gelf, loki, ipfix, parquet or stream")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sender, nil
+}
+
+// initSender reads config from settings and runs initialize.
+func initSender(settings *viper.Viper, config interface{}, initialize func() error) error {
+	err := settings.Unmarshal(config)
+	if err != nil {
+		return fmt.Errorf("error reading config %s", err)
+	}
+	err = initialize()
+	if err != nil {
+		return fmt.Errorf("error initializing client %s", err)
+	}
+	return nil
+}
+
+// parseBeginTime parses begin_time, an hour such as 2017-06-16-12.
+func parseBeginTime(beginTime string) (time.Time, error) {
 	afterTime, err := time.Parse(timeLayout, fmt.Sprintf("%s-00-00-GMT", beginTime))
-	err = nsgAzureClient.ProcessBlobsAfter(afterTime, syslogClient, "syslog")
 	if err != nil {
-		log.Error(err)
+		return time.Time{}, fmt.Errorf("invalid begin_time %q. expected YYYY-MM-DD-HH: %s", beginTime, err)
 	}
+	return afterTime, nil
+}
 
+func processDestination(destination *parser.Destination, afterTime time.Time) {
+	err := nsgAzureClient.ProcessBlobsAfter(afterTime, destination, destination.Name)
+	if err != nil {
+		log.Error(err)
+	}
 }
 
 func startHttpServer() {
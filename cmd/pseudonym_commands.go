@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+var (
+	pseudonymDestination string
+	pseudonymKeyID       string
+)
+
+var pseudonymCmd = &cobra.Command{
+	Use:   "pseudonym",
+	Short: "Manage the keys of pseudonymized destinations and reverse their pseudonyms.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initViper()
+		log.SetOutput(os.Stderr)
+		if debug {
+			log.SetLevel(log.DebugLevel)
+		}
+	},
+}
+
+// Restore the addresses of a pseudonymized destination. Only those holding
+// its keys can.
+var pseudonymReverseCmd = &cobra.Command{
+	Use:   "reverse [value...]",
+	Short: "Restore the addresses and MACs of pseudonymized values or events.",
+	Long: `Restore the addresses and MACs pseudonymized for a destination, using the pseudonym_keys and
+pseudonymize_cidrs of its configuration. Values are addresses, networks or MACs. With no value, lines of events
+written by the destination are read from standard input and written with their addresses restored, each with the
+key named in its pseudonymKey.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runPseudonymReverse(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var pseudonymKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Print a new random key for pseudonym_keys.",
+	Run: func(cmd *cobra.Command, args []string) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hex.EncodeToString(key))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pseudonymCmd)
+	pseudonymCmd.AddCommand(pseudonymReverseCmd)
+	pseudonymCmd.AddCommand(pseudonymKeyCmd)
+	pseudonymReverseCmd.Flags().StringVar(&pseudonymDestination, "destination", "", "Name of the destinations entry. Defaults to the top level settings")
+	pseudonymReverseCmd.Flags().StringVar(&pseudonymKeyID, "key", "", "Id of the key. Defaults to the key named in each event, or the latest key")
+}
+
+func runPseudonymReverse(values []string) error {
+	settings := viper.GetViper()
+	if pseudonymDestination != "" {
+		entries := []map[string]interface{}{}
+		err := viper.UnmarshalKey("destinations", &entries)
+		if err != nil {
+			return fmt.Errorf("error reading destinations %s", err)
+		}
+		settings = nil
+		for _, entry := range entries {
+			if name, _ := entry["name"].(string); name == pseudonymDestination {
+				settings = destinationSettings(entry)
+			}
+		}
+		if settings == nil {
+			return fmt.Errorf("no destination %s", pseudonymDestination)
+		}
+	}
+	pseudonymizer, err := initPseudonymizer(settings)
+	if err != nil {
+		return err
+	}
+	if pseudonymizer == nil {
+		return fmt.Errorf("nothing is pseudonymized. set pseudonymize_cidrs or pseudonymize_macs")
+	}
+	logger := log.WithFields(log.Fields{"destination": pseudonymDestination, "key": pseudonymKeyID})
+	if len(values) > 0 {
+		for _, value := range values {
+			original, err := pseudonymizer.Reverse(value, pseudonymKeyID)
+			if err != nil {
+				return err
+			}
+			fmt.Println(original)
+		}
+		logger.WithField("values", len(values)).Info("reversed pseudonyms")
+		return nil
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	writer := bufio.NewWriter(os.Stdout)
+	lines := 0
+	for scanner.Scan() {
+		original, err := pseudonymizer.Reverse(scanner.Text(), pseudonymKeyID)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lines+1, err)
+		}
+		writer.WriteString(strings.TrimRight(original, "\r") + "\n")
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	logger.WithField("lines", lines).Info("reversed pseudonyms")
+	return writer.Flush()
+}
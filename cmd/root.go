@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/dimitertodorov/nsg-parser/parser"
 	rotatelogs "github.com/lestrrat/go-file-rotatelogs"
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
@@ -66,6 +67,10 @@ func initProxy() {
 func initLogging() {
 	stdoutLog = log.New()
 	stdoutLog.Out = os.Stdout
+	// Keep stdout clean for events streamed to it.
+	if streamsToStdout() {
+		stdoutLog.Out = os.Stderr
+	}
 
 	log.SetOutput(os.Stdout)
 	log.SetFormatter(&log.TextFormatter{})
@@ -143,3 +148,19 @@ func cfgFilePath() (string, error) {
 	}
 	return filepath.Abs(cfgFile)
 }
+
+// streamsToStdout reports whether a stream destination writes to stdout.
+func streamsToStdout() bool {
+	entries := []map[string]interface{}{}
+	viper.UnmarshalKey("destinations", &entries)
+	if len(entries) == 0 {
+		return viper.GetString("destination") == parser.DestinationStream && viper.GetString("stream_address") == ""
+	}
+	for _, entry := range entries {
+		settings := destinationSettings(entry)
+		if settings.GetString("type") == parser.DestinationStream && settings.GetString("stream_address") == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -27,7 +27,6 @@ func (p *nsgParserService) Start(s service.Service) error {
 
 func (p *nsgParserService) run() {
 	initClient()
-	initFileClient()
 	daemon = true
 	processCmd.Run(processCmd, []string{})
 }
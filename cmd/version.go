@@ -29,7 +29,7 @@ func init() {
 
 func runVersion() {
 	if short != false {
-		fmt.Printf(version.Version)
+		fmt.Print(version.Version)
 		return
 	}
 	fmt.Println(version.Print("nsg-parser"))
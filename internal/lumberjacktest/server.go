@@ -0,0 +1,205 @@
+// Package lumberjacktest provides a Lumberjack v2 server for testing
+// Lumberjack clients.
+package lumberjacktest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+const (
+	protocolVersion byte = '2'
+
+	frameWindow     byte = 'W'
+	frameJSON       byte = 'J'
+	frameCompressed byte = 'C'
+	frameAck        byte = 'A'
+)
+
+var errUnexpectedFrame = errors.New("lumberjacktest: unexpected frame")
+
+// Server is a Lumberjack v2 server for tests. It accepts JSON and
+// compressed frames, keeps every document it receives and acks each window.
+type Server struct {
+	// AckEvery, when set, sends an intermediate ACK every AckEvery documents
+	// in addition to the final ACK of the window.
+	AckEvery int
+	// WithholdAcks stops the server from acking, so clients time out.
+	WithholdAcks bool
+
+	listener  net.Listener
+	mutex     sync.Mutex
+	documents []map[string]interface{}
+	windows   int
+	wg        sync.WaitGroup
+}
+
+// NewServer starts a Server on a random local port. It serves TLS
+// when tlsConfig is set.
+func NewServer(tlsConfig *tls.Config) (*Server, error) {
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{listener: listener}
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the host:port the server is listening on.
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Close stops the server. Open connections are closed by their clients.
+func (server *Server) Close() {
+	server.listener.Close()
+	server.wg.Wait()
+}
+
+// Documents returns every document received so far, in order.
+func (server *Server) Documents() []map[string]interface{} {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]map[string]interface{}{}, server.documents...)
+}
+
+// Windows returns the number of windows received so far.
+func (server *Server) Windows() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.windows
+}
+
+func (server *Server) serve() {
+	defer server.wg.Done()
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		var header [6]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return
+		}
+		if header[0] != protocolVersion || header[1] != frameWindow {
+			return
+		}
+		count := binary.BigEndian.Uint32(header[2:])
+		server.mutex.Lock()
+		server.windows++
+		server.mutex.Unlock()
+
+		received := uint32(0)
+		for received < count {
+			documents, err := readDataFrames(reader)
+			if err != nil {
+				return
+			}
+			for _, document := range documents {
+				received++
+				server.mutex.Lock()
+				server.documents = append(server.documents, document)
+				server.mutex.Unlock()
+				if server.WithholdAcks {
+					continue
+				}
+				if received == count || (server.AckEvery > 0 && int(received)%server.AckEvery == 0) {
+					if err := writeAck(conn, received); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// readDataFrames reads a single JSON frame, or a compressed frame which may
+// hold many JSON frames.
+func readDataFrames(reader io.Reader) ([]map[string]interface{}, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != protocolVersion {
+		return nil, errUnexpectedFrame
+	}
+	switch header[1] {
+	case frameJSON:
+		var lengths [8]byte
+		if _, err := io.ReadFull(reader, lengths[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(lengths[4:]))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil, err
+		}
+		document := map[string]interface{}{}
+		if err := json.Unmarshal(payload, &document); err != nil {
+			return nil, err
+		}
+		return []map[string]interface{}{document}, nil
+	case frameCompressed:
+		var length [4]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(reader, compressed); err != nil {
+			return nil, err
+		}
+		zreader, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		frames, err := ioutil.ReadAll(zreader)
+		if err != nil {
+			return nil, err
+		}
+		documents := []map[string]interface{}{}
+		inner := bytes.NewReader(frames)
+		for inner.Len() > 0 {
+			frameDocuments, err := readDataFrames(inner)
+			if err != nil {
+				return nil, err
+			}
+			documents = append(documents, frameDocuments...)
+		}
+		return documents, nil
+	default:
+		return nil, errUnexpectedFrame
+	}
+}
+
+func writeAck(w io.Writer, seq uint32) error {
+	var frame [6]byte
+	frame[0] = protocolVersion
+	frame[1] = frameAck
+	binary.BigEndian.PutUint32(frame[2:], seq)
+	_, err := w.Write(frame[:])
+	return err
+}
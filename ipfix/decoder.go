@@ -0,0 +1,141 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errShortMessage = errors.New("ipfix: short message")
+
+// DataRecord is a decoded data record, keyed by information element ID.
+type DataRecord struct {
+	Template uint16
+	Values   map[uint16][]byte
+}
+
+// Uint returns the value of element id as an unsigned integer.
+func (record DataRecord) Uint(id uint16) uint64 {
+	var value uint64
+	for _, b := range record.Values[id] {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// Message is a decoded NetFlow v9 or IPFIX message.
+type Message struct {
+	Version    int
+	Domain     uint32
+	Sequence   uint32
+	ExportTime time.Time
+	Templates  []Template
+	Records    []DataRecord
+}
+
+// Decoder decodes messages, remembering templates per observation domain.
+// Data sets for templates it has not seen yet are skipped.
+type Decoder struct {
+	templates map[uint32]map[uint16]Template
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{templates: map[uint32]map[uint16]Template{}}
+}
+
+// Decode decodes a single message. Record values do not share memory with
+// packet, so the caller may reuse its buffer.
+func (decoder *Decoder) Decode(packet []byte) (*Message, error) {
+	if len(packet) < 2 {
+		return nil, errShortMessage
+	}
+	packet = append([]byte{}, packet...)
+	message := &Message{Version: int(binary.BigEndian.Uint16(packet))}
+	var body []byte
+	switch message.Version {
+	case VersionIPFIX:
+		if len(packet) < ipfixHeaderLength {
+			return nil, errShortMessage
+		}
+		length := int(binary.BigEndian.Uint16(packet[2:]))
+		if length != len(packet) {
+			return nil, fmt.Errorf("ipfix: message length %d does not match packet length %d", length, len(packet))
+		}
+		message.ExportTime = time.Unix(int64(binary.BigEndian.Uint32(packet[4:])), 0)
+		message.Sequence = binary.BigEndian.Uint32(packet[8:])
+		message.Domain = binary.BigEndian.Uint32(packet[12:])
+		body = packet[ipfixHeaderLength:]
+	case VersionNetFlow9:
+		if len(packet) < netflow9HeaderLength {
+			return nil, errShortMessage
+		}
+		message.ExportTime = time.Unix(int64(binary.BigEndian.Uint32(packet[8:])), 0)
+		message.Sequence = binary.BigEndian.Uint32(packet[12:])
+		message.Domain = binary.BigEndian.Uint32(packet[16:])
+		body = packet[netflow9HeaderLength:]
+	default:
+		return nil, fmt.Errorf("ipfix: unsupported version %d", message.Version)
+	}
+
+	templates, ok := decoder.templates[message.Domain]
+	if !ok {
+		templates = map[uint16]Template{}
+		decoder.templates[message.Domain] = templates
+	}
+	for len(body) > 0 {
+		if len(body) < setHeaderLength {
+			return nil, errShortMessage
+		}
+		setID := binary.BigEndian.Uint16(body)
+		setLength := int(binary.BigEndian.Uint16(body[2:]))
+		if setLength < setHeaderLength || setLength > len(body) {
+			return nil, fmt.Errorf("ipfix: invalid set length %d", setLength)
+		}
+		set := body[setHeaderLength:setLength]
+		body = body[setLength:]
+
+		switch {
+		case setID == 2 && message.Version == VersionIPFIX, setID == 0 && message.Version == VersionNetFlow9:
+			for len(set) >= 4 {
+				template := Template{ID: binary.BigEndian.Uint16(set)}
+				count := int(binary.BigEndian.Uint16(set[2:]))
+				set = set[4:]
+				if count == 0 {
+					break
+				}
+				if len(set) < 4*count {
+					return nil, errShortMessage
+				}
+				for i := 0; i < count; i++ {
+					id := binary.BigEndian.Uint16(set[4*i:])
+					if id&0x8000 != 0 {
+						return nil, fmt.Errorf("ipfix: enterprise elements are not supported")
+					}
+					template.Fields = append(template.Fields, Field{ID: id, Length: binary.BigEndian.Uint16(set[4*i+2:])})
+				}
+				set = set[4*count:]
+				templates[template.ID] = template
+				message.Templates = append(message.Templates, template)
+			}
+		case setID >= minDataSetID:
+			template, ok := templates[setID]
+			if !ok {
+				continue
+			}
+			recordLength := template.RecordLength()
+			// Anything shorter than a record at the end of the set is padding.
+			for recordLength > 0 && len(set) >= recordLength {
+				record := DataRecord{Template: setID, Values: map[uint16][]byte{}}
+				offset := 0
+				for _, field := range template.Fields {
+					record.Values[field.ID] = set[offset : offset+int(field.Length)]
+					offset += int(field.Length)
+				}
+				set = set[recordLength:]
+				message.Records = append(message.Records, record)
+			}
+		}
+	}
+	return message, nil
+}
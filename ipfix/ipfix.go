@@ -0,0 +1,308 @@
+// Package ipfix encodes flow records as IPFIX (RFC 7011) or NetFlow v9
+// (RFC 3954) messages and decodes them again.
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	VersionNetFlow9 = 9
+	VersionIPFIX    = 10
+
+	DefaultMTU             = 1400
+	DefaultTemplateRefresh = time.Minute
+
+	ipfixHeaderLength    = 16
+	netflow9HeaderLength = 20
+	setHeaderLength      = 4
+	minDataSetID         = 256
+)
+
+// Information elements used by the exporter. NetFlow v9 shares the IDs below 128
+// and collectors such as nfdump also accept the IPFIX ones above.
+const (
+	OctetDeltaCount          uint16 = 1
+	PacketDeltaCount         uint16 = 2
+	ProtocolIdentifier       uint16 = 4
+	SourceTransportPort      uint16 = 7
+	SourceIPv4Address        uint16 = 8
+	DestinationTransportPort uint16 = 11
+	DestinationIPv4Address   uint16 = 12
+	PostOctetDeltaCount      uint16 = 23
+	PostPacketDeltaCount     uint16 = 24
+	SourceIPv6Address        uint16 = 27
+	DestinationIPv6Address   uint16 = 28
+	FlowDirection            uint16 = 61
+	FlowStartMilliseconds    uint16 = 152
+	FirewallEvent            uint16 = 233
+)
+
+// Field is a fixed length information element in a template.
+type Field struct {
+	ID     uint16
+	Length uint16
+}
+
+// Template describes the layout of data records. IDs must be 256 or above.
+type Template struct {
+	ID     uint16
+	Fields []Field
+}
+
+// RecordLength returns the length of a data record using t.
+func (t Template) RecordLength() int {
+	length := 0
+	for _, field := range t.Fields {
+		length += int(field.Length)
+	}
+	return length
+}
+
+// Record is an encoded data record for Template.
+type Record struct {
+	Template uint16
+	Data     []byte
+}
+
+// Config holds the exporter settings.
+type Config struct {
+	Version         int
+	MTU             int
+	TemplateRefresh time.Duration
+	Templates       []Template
+}
+
+type domainState struct {
+	sequence     uint32
+	templateSent time.Time
+}
+
+// Exporter writes records as messages to w, one message per Write, which
+// suits a connected UDP socket. Templates are sent for each observation domain
+// before its first data records and again every TemplateRefresh.
+type Exporter struct {
+	writer    io.Writer
+	config    Config
+	templates map[uint16]Template
+	domains   map[uint32]*domainState
+	started   time.Time
+	mutex     sync.Mutex
+}
+
+// NewExporter validates config and returns an Exporter writing to w.
+func NewExporter(w io.Writer, config Config) (*Exporter, error) {
+	if config.Version == 0 {
+		config.Version = VersionIPFIX
+	}
+	if config.Version != VersionIPFIX && config.Version != VersionNetFlow9 {
+		return nil, fmt.Errorf("ipfix: unsupported version %d. expected 9 or 10", config.Version)
+	}
+	if config.MTU <= 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.TemplateRefresh <= 0 {
+		config.TemplateRefresh = DefaultTemplateRefresh
+	}
+	templates := map[uint16]Template{}
+	for _, template := range config.Templates {
+		if template.ID < minDataSetID {
+			return nil, fmt.Errorf("ipfix: template id %d is reserved", template.ID)
+		}
+		if headerLength(config.Version)+setHeaderLength+templateLength(template) > config.MTU {
+			return nil, fmt.Errorf("ipfix: template %d does not fit in mtu %d", template.ID, config.MTU)
+		}
+		templates[template.ID] = template
+	}
+	return &Exporter{
+		writer:    w,
+		config:    config,
+		templates: templates,
+		domains:   map[uint32]*domainState{},
+		started:   time.Now(),
+	}, nil
+}
+
+// Export writes records for an observation domain, packing as many records
+// into each message as the MTU allows.
+func (exporter *Exporter) Export(domain uint32, records []Record) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	state, ok := exporter.domains[domain]
+	if !ok {
+		state = &domainState{}
+		exporter.domains[domain] = state
+	}
+
+	for len(records) > 0 || exporter.templatesDue(state) {
+		now := time.Now()
+		message := newMessageBuilder(exporter.config.Version, exporter.config.MTU)
+		if exporter.templatesDue(state) {
+			exporter.addTemplates(message)
+			state.templateSent = now
+		}
+		written := 0
+		for _, record := range records {
+			template, ok := exporter.templates[record.Template]
+			if !ok {
+				return fmt.Errorf("ipfix: unknown template %d", record.Template)
+			}
+			if len(record.Data) != template.RecordLength() {
+				return fmt.Errorf("ipfix: record of %d bytes does not match template %d", len(record.Data), record.Template)
+			}
+			if !message.addRecord(record) {
+				break
+			}
+			written++
+		}
+		if written == 0 && len(records) > 0 && message.records == 0 && message.sets == 0 {
+			return fmt.Errorf("ipfix: record does not fit in mtu %d", exporter.config.MTU)
+		}
+		records = records[written:]
+
+		packet := message.finish(exporter.header(domain, state, message, now))
+		if _, err := exporter.writer.Write(packet); err != nil {
+			return err
+		}
+		if exporter.config.Version == VersionIPFIX {
+			state.sequence += uint32(written)
+		} else {
+			state.sequence++
+		}
+	}
+	return nil
+}
+
+func (exporter *Exporter) templatesDue(state *domainState) bool {
+	return state.templateSent.IsZero() || time.Since(state.templateSent) >= exporter.config.TemplateRefresh
+}
+
+func (exporter *Exporter) addTemplates(message *messageBuilder) {
+	setID := uint16(2)
+	if exporter.config.Version == VersionNetFlow9 {
+		setID = 0
+	}
+	for _, template := range exporter.config.Templates {
+		body := make([]byte, 0, templateLength(template))
+		body = appendUint16(body, template.ID)
+		body = appendUint16(body, uint16(len(template.Fields)))
+		for _, field := range template.Fields {
+			body = appendUint16(body, field.ID)
+			body = appendUint16(body, field.Length)
+		}
+		message.addSet(setID, body)
+	}
+}
+
+func (exporter *Exporter) header(domain uint32, state *domainState, message *messageBuilder, now time.Time) []byte {
+	header := make([]byte, 0, headerLength(exporter.config.Version))
+	header = appendUint16(header, uint16(exporter.config.Version))
+	if exporter.config.Version == VersionIPFIX {
+		header = appendUint16(header, uint16(message.length()))
+		header = appendUint32(header, uint32(now.Unix()))
+		header = appendUint32(header, state.sequence)
+		header = appendUint32(header, domain)
+		return header
+	}
+	header = appendUint16(header, uint16(message.records+message.templates))
+	header = appendUint32(header, uint32(now.Sub(exporter.started)/time.Millisecond))
+	header = appendUint32(header, uint32(now.Unix()))
+	header = appendUint32(header, state.sequence)
+	header = appendUint32(header, domain)
+	return header
+}
+
+func headerLength(version int) int {
+	if version == VersionNetFlow9 {
+		return netflow9HeaderLength
+	}
+	return ipfixHeaderLength
+}
+
+func templateLength(template Template) int {
+	return 4 + 4*len(template.Fields)
+}
+
+// messageBuilder accumulates sets up to the MTU. Consecutive records for the
+// same template share a data set.
+type messageBuilder struct {
+	version   int
+	mtu       int
+	body      []byte
+	setStart  int
+	setID     uint16
+	sets      int
+	records   int
+	templates int
+}
+
+func newMessageBuilder(version, mtu int) *messageBuilder {
+	return &messageBuilder{version: version, mtu: mtu, setStart: -1}
+}
+
+func (message *messageBuilder) length() int {
+	return headerLength(message.version) + len(message.body)
+}
+
+func (message *messageBuilder) closeSet() {
+	if message.setStart < 0 {
+		return
+	}
+	// NetFlow v9 and IPFIX both allow padding sets to a 4 byte boundary.
+	for (len(message.body)-message.setStart)%4 != 0 {
+		message.body = append(message.body, 0)
+	}
+	binary.BigEndian.PutUint16(message.body[message.setStart+2:], uint16(len(message.body)-message.setStart))
+	message.setStart = -1
+}
+
+func (message *messageBuilder) addSet(setID uint16, body []byte) {
+	message.closeSet()
+	message.body = appendUint16(message.body, setID)
+	message.body = appendUint16(message.body, uint16(setHeaderLength+len(body)))
+	message.body = append(message.body, body...)
+	message.sets++
+	message.templates++
+}
+
+func (message *messageBuilder) addRecord(record Record) bool {
+	needed := len(record.Data)
+	if message.setStart < 0 || message.setID != record.Template {
+		needed += setHeaderLength + 3
+	}
+	if message.length()+needed > message.mtu {
+		return false
+	}
+	if message.setStart < 0 || message.setID != record.Template {
+		message.closeSet()
+		message.setStart = len(message.body)
+		message.setID = record.Template
+		message.body = appendUint16(message.body, record.Template)
+		message.body = appendUint16(message.body, 0)
+		message.sets++
+	}
+	message.body = append(message.body, record.Data...)
+	message.records++
+	return true
+}
+
+func (message *messageBuilder) finish(header []byte) []byte {
+	message.closeSet()
+	if message.version == VersionIPFIX {
+		binary.BigEndian.PutUint16(header[2:], uint16(message.length()))
+	}
+	return append(header, message.body...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
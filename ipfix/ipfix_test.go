@@ -0,0 +1,110 @@
+package ipfix
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type packetRecorder struct {
+	packets [][]byte
+}
+
+func (recorder *packetRecorder) Write(p []byte) (int, error) {
+	recorder.packets = append(recorder.packets, append([]byte{}, p...))
+	return len(p), nil
+}
+
+var testTemplates = []Template{
+	{ID: 256, Fields: []Field{{SourceIPv4Address, 4}, {DestinationTransportPort, 2}, {ProtocolIdentifier, 1}}},
+	{ID: 257, Fields: []Field{{SourceIPv4Address, 4}, {PacketDeltaCount, 8}}},
+}
+
+func testRecords(count int) []Record {
+	records := []Record{}
+	for i := 0; i < count; i++ {
+		if i%3 == 0 {
+			data := []byte{10, 0, 0, byte(i)}
+			data = append(data, 0, 0, 0, 0, 0, 0, 0, byte(i))
+			records = append(records, Record{Template: 257, Data: data})
+		} else {
+			records = append(records, Record{Template: 256, Data: []byte{10, 0, 0, byte(i), 1, 187, 6}})
+		}
+	}
+	return records
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	for _, version := range []int{VersionIPFIX, VersionNetFlow9} {
+		recorder := &packetRecorder{}
+		exporter, err := NewExporter(recorder, Config{Version: version, MTU: 200, Templates: testTemplates})
+		require.Nil(t, err)
+
+		require.Nil(t, exporter.Export(42, testRecords(100)))
+		require.Nil(t, exporter.Export(7, testRecords(3)))
+		require.True(t, len(recorder.packets) > 2, "expected records to be split across packets")
+
+		decoder := NewDecoder()
+		records := map[uint32][]DataRecord{}
+		sequences := map[uint32][]uint32{}
+		templateMessages := 0
+		for _, packet := range recorder.packets {
+			assert.True(t, len(packet) <= 200)
+			message, err := decoder.Decode(packet)
+			require.Nil(t, err)
+			assert.Equal(t, version, message.Version)
+			if len(message.Templates) > 0 {
+				templateMessages++
+			}
+			records[message.Domain] = append(records[message.Domain], message.Records...)
+			sequences[message.Domain] = append(sequences[message.Domain], message.Sequence)
+		}
+		assert.Equal(t, 2, templateMessages, "templates are sent once per domain")
+		require.Equal(t, 100, len(records[42]))
+		assert.Equal(t, 3, len(records[7]))
+		assert.Equal(t, uint64(99), records[42][99].Uint(PacketDeltaCount))
+		assert.Equal(t, uint64(443), records[42][98].Uint(DestinationTransportPort))
+
+		if version == VersionIPFIX {
+			// IPFIX sequence numbers count data records.
+			assert.Equal(t, uint32(0), sequences[42][0])
+			assert.True(t, sequences[42][len(sequences[42])-1] < 100)
+		} else {
+			// NetFlow v9 sequence numbers count packets.
+			for i, sequence := range sequences[42] {
+				assert.Equal(t, uint32(i), sequence)
+			}
+		}
+	}
+}
+
+func TestTemplateRefresh(t *testing.T) {
+	recorder := &packetRecorder{}
+	exporter, err := NewExporter(recorder, Config{TemplateRefresh: time.Millisecond, Templates: testTemplates})
+	require.Nil(t, err)
+
+	require.Nil(t, exporter.Export(1, testRecords(2)))
+	time.Sleep(5 * time.Millisecond)
+	require.Nil(t, exporter.Export(1, testRecords(2)))
+
+	decoder := NewDecoder()
+	for _, packet := range recorder.packets {
+		message, err := decoder.Decode(packet)
+		require.Nil(t, err)
+		assert.Equal(t, 2, len(message.Templates))
+		assert.Equal(t, 2, len(message.Records))
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	_, err := NewExporter(&packetRecorder{}, Config{Version: 5})
+	assert.Error(t, err)
+	_, err = NewExporter(&packetRecorder{}, Config{Templates: []Template{{ID: 2}}})
+	assert.Error(t, err)
+
+	exporter, err := NewExporter(&packetRecorder{}, Config{Templates: testTemplates})
+	require.Nil(t, err)
+	assert.Error(t, exporter.Export(1, []Record{{Template: 300, Data: []byte{1}}}))
+	assert.Error(t, exporter.Export(1, []Record{{Template: 256, Data: []byte{1}}}))
+}
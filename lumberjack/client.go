@@ -0,0 +1,126 @@
+package lumberjack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	DefaultWindowSize       = 1024
+	DefaultCompressionLevel = 3
+	DefaultTimeout          = 30 * time.Second
+)
+
+// Config holds the settings for a single connection.
+// A CompressionLevel of 0 sends uncompressed JSON frames.
+type Config struct {
+	WindowSize       int
+	CompressionLevel int
+	Timeout          time.Duration
+	TLS              *tls.Config
+}
+
+// Client sends JSON documents to a Lumberjack v2 server. Documents are sent in
+// windows and Send only returns once the server has acked every one of them.
+type Client struct {
+	config Config
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to addr, using TLS when config.TLS is set.
+func Dial(addr string, config Config) (*Client, error) {
+	if config.WindowSize <= 0 {
+		config.WindowSize = DefaultWindowSize
+	}
+	if config.CompressionLevel < 0 || config.CompressionLevel > zlib.BestCompression {
+		return nil, fmt.Errorf("lumberjack: compression level must be between 0 and %d", zlib.BestCompression)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	var conn net.Conn
+	var err error
+	if config.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Client{config: config, conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Close closes the connection.
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// Send sends documents, each of which must be a JSON object, and returns
+// the number of documents acked by the server. On error the count can be used
+// to tell how far delivery got.
+func (client *Client) Send(documents [][]byte) (int, error) {
+	acked := 0
+	for start := 0; start < len(documents); start += client.config.WindowSize {
+		end := start + client.config.WindowSize
+		if end > len(documents) {
+			end = len(documents)
+		}
+		windowAcked, err := client.sendWindow(documents[start:end])
+		acked += windowAcked
+		if err != nil {
+			return acked, err
+		}
+	}
+	return acked, nil
+}
+
+func (client *Client) sendWindow(documents [][]byte) (int, error) {
+	var frames bytes.Buffer
+	for i, document := range documents {
+		appendJSONFrame(&frames, uint32(i+1), document)
+	}
+	payload := frames.Bytes()
+	if client.config.CompressionLevel > 0 {
+		var err error
+		payload, err = compressFrames(payload, client.config.CompressionLevel)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	client.conn.SetWriteDeadline(time.Now().Add(client.config.Timeout))
+	if err := writeWindow(client.conn, uint32(len(documents))); err != nil {
+		return 0, err
+	}
+	if _, err := client.conn.Write(payload); err != nil {
+		return 0, err
+	}
+
+	// Logstash sends an ACK for the last processed sequence number while it
+	// works through a window, which also acts as a keepalive. The deadline is
+	// extended on each one.
+	acked := uint32(0)
+	for acked < uint32(len(documents)) {
+		client.conn.SetReadDeadline(time.Now().Add(client.config.Timeout))
+		seq, err := readAck(client.reader)
+		if err != nil {
+			return int(acked), err
+		}
+		if seq > uint32(len(documents)) {
+			return int(acked), fmt.Errorf("lumberjack: ack %d beyond window of %d", seq, len(documents))
+		}
+		if seq > acked {
+			acked = seq
+		}
+	}
+	return int(acked), nil
+}
@@ -0,0 +1,107 @@
+package lumberjack
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/internal/lumberjacktest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testDocuments(count int) [][]byte {
+	documents := [][]byte{}
+	for i := 0; i < count; i++ {
+		documents = append(documents, []byte(fmt.Sprintf(`{"n":%d,"message":"flow"}`, i)))
+	}
+	return documents
+}
+
+func TestSendWindows(t *testing.T) {
+	for _, level := range []int{0, DefaultCompressionLevel} {
+		server, err := lumberjacktest.NewServer(nil)
+		require.Nil(t, err)
+		server.AckEvery = 7
+
+		client, err := Dial(server.Addr(), Config{WindowSize: 20, CompressionLevel: level})
+		require.Nil(t, err)
+
+		acked, err := client.Send(testDocuments(50))
+		assert.Nil(t, err)
+		assert.Equal(t, 50, acked)
+		assert.Equal(t, 3, server.Windows())
+
+		documents := server.Documents()
+		require.Equal(t, 50, len(documents))
+		assert.Equal(t, float64(49), documents[49]["n"])
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestSendAckTimeout(t *testing.T) {
+	server, err := lumberjacktest.NewServer(nil)
+	require.Nil(t, err)
+	defer server.Close()
+	server.WithholdAcks = true
+
+	client, err := Dial(server.Addr(), Config{Timeout: 100 * time.Millisecond})
+	require.Nil(t, err)
+	defer client.Close()
+
+	acked, err := client.Send(testDocuments(5))
+	assert.Error(t, err)
+	assert.Equal(t, 0, acked)
+}
+
+func TestSendTLS(t *testing.T) {
+	serverConfig, pool := testTLSConfig(t)
+	server, err := lumberjacktest.NewServer(serverConfig)
+	require.Nil(t, err)
+	defer server.Close()
+
+	_, err = Dial(server.Addr(), Config{TLS: &tls.Config{}})
+	assert.Error(t, err, "untrusted certificate should fail the handshake")
+
+	client, err := Dial(server.Addr(), Config{TLS: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}})
+	require.Nil(t, err)
+	defer client.Close()
+
+	acked, err := client.Send(testDocuments(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, acked)
+}
+
+// testTLSConfig creates a self-signed certificate for 127.0.0.1.
+func testTLSConfig(t *testing.T) (*tls.Config, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
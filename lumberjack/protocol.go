@@ -0,0 +1,77 @@
+// Package lumberjack implements the client side of the Lumberjack v2 protocol
+// spoken by the Logstash beats input.
+package lumberjack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	protocolVersion byte = '2'
+
+	frameWindow     byte = 'W'
+	frameJSON       byte = 'J'
+	frameCompressed byte = 'C'
+	frameAck        byte = 'A'
+)
+
+var errUnexpectedFrame = errors.New("lumberjack: unexpected frame")
+
+// writeWindow writes the window frame announcing count events.
+func writeWindow(w io.Writer, count uint32) error {
+	var frame [6]byte
+	frame[0] = protocolVersion
+	frame[1] = frameWindow
+	binary.BigEndian.PutUint32(frame[2:], count)
+	_, err := w.Write(frame[:])
+	return err
+}
+
+// appendJSONFrame appends a JSON data frame with sequence number seq.
+func appendJSONFrame(buf *bytes.Buffer, seq uint32, payload []byte) {
+	var header [10]byte
+	header[0] = protocolVersion
+	header[1] = frameJSON
+	binary.BigEndian.PutUint32(header[2:], seq)
+	binary.BigEndian.PutUint32(header[6:], uint32(len(payload)))
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+// compressFrames wraps frames in a single zlib compressed frame.
+func compressFrames(frames []byte, level int) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer, err := zlib.NewWriterLevel(&compressed, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(frames); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 6, 6+compressed.Len())
+	result[0] = protocolVersion
+	result[1] = frameCompressed
+	binary.BigEndian.PutUint32(result[2:], uint32(compressed.Len()))
+	return append(result, compressed.Bytes()...), nil
+}
+
+// readAck reads a single ACK frame and returns its sequence number.
+func readAck(r io.Reader) (uint32, error) {
+	var frame [6]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		return 0, err
+	}
+	if frame[0] != protocolVersion || frame[1] != frameAck {
+		return 0, fmt.Errorf("%s %q", errUnexpectedFrame, frame[:2])
+	}
+	return binary.BigEndian.Uint32(frame[2:]), nil
+}
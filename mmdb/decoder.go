@@ -0,0 +1,205 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Data section types, see the MaxMind DB format specification.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth limits the nesting of decoded values, so that a corrupt database
+// cannot recurse without end.
+const maxDepth = 64
+
+// decoder decodes values of a data section. Pointers are offsets from the
+// start of the section.
+type decoder struct {
+	buffer []byte
+}
+
+// decode decodes the value at offset and returns it with the offset after it.
+// Maps decode to map[string]interface{}, arrays to []interface{}, unsigned
+// integers to uint64, int32 to int64, doubles and floats to float64.
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("mmdb: data nested too deeply")
+	}
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+	return d.decodeValue(kind, size, offset, depth)
+}
+
+// control reads the control byte at offset and returns the type and size of
+// the value, and the offset of its payload.
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, 0, 0, fmt.Errorf("mmdb: offset %d beyond data section", offset)
+	}
+	control := d.buffer[offset]
+	offset++
+	kind := int(control >> 5)
+	if kind == typeExtended {
+		if offset >= uint(len(d.buffer)) {
+			return 0, 0, 0, fmt.Errorf("mmdb: unexpected end of data section")
+		}
+		kind = 7 + int(d.buffer[offset])
+		offset++
+		if kind < typeInt32 || kind > typeFloat {
+			return 0, 0, 0, fmt.Errorf("mmdb: invalid extended type %d", kind)
+		}
+	}
+	size := uint(control & 0x1f)
+	if kind == typePointer {
+		return kind, size, offset, nil
+	}
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(d.buffer)) {
+			return 0, 0, 0, fmt.Errorf("mmdb: unexpected end of data section")
+		}
+		value := uint(0)
+		for _, b := range d.buffer[offset : offset+extra] {
+			value = value<<8 | uint(b)
+		}
+		switch extra {
+		case 1:
+			size = 29 + value
+		case 2:
+			size = 285 + value
+		case 3:
+			size = 65821 + value
+		}
+		offset += extra
+	}
+	return kind, size, offset, nil
+}
+
+// pointer reads the pointer with the size bits of its control byte.
+func (d *decoder) pointer(size uint, offset uint) (uint, uint, error) {
+	length := (size>>3)&0x3 + 1
+	if offset+length > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("mmdb: unexpected end of data section")
+	}
+	value := uint(0)
+	if length != 4 {
+		value = size & 0x7
+	}
+	for _, b := range d.buffer[offset : offset+length] {
+		value = value<<8 | uint(b)
+	}
+	switch length {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, offset + length, nil
+}
+
+func (d *decoder) decodeValue(kind int, size uint, offset uint, depth int) (interface{}, uint, error) {
+	switch kind {
+	case typeMap:
+		values := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("mmdb: map key is not a string")
+			}
+			values[name], offset, err = d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return values, offset, nil
+	case typeArray:
+		values := make([]interface{}, size)
+		for i := range values {
+			var err error
+			values[i], offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return values, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("mmdb: value beyond data section")
+	}
+	payload := d.buffer[offset : offset+size]
+	next := offset + size
+	switch kind {
+	case typeString:
+		return string(payload), next, nil
+	case typeBytes:
+		return append([]byte{}, payload...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("mmdb: invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("mmdb: invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("mmdb: invalid integer size %d", size)
+		}
+		value := uint64(0)
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("mmdb: invalid integer size %d", size)
+		}
+		value := uint32(0)
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int64(int32(value)), next, nil
+	case typeUint128:
+		// Too wide for uint64, returned as big-endian bytes.
+		return append([]byte{}, payload...), next, nil
+	default:
+		return nil, 0, fmt.Errorf("mmdb: unexpected type %d in data section", kind)
+	}
+}
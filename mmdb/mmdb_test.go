@@ -0,0 +1,190 @@
+package mmdb
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+)
+
+func mustNetwork(t *testing.T, cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	require.Nil(t, err)
+	return network
+}
+
+func city(country, name string, latitude, longitude float64) map[string]interface{} {
+	return map[string]interface{}{
+		"country":  map[string]interface{}{"iso_code": country, "geoname_id": uint32(6252001)},
+		"city":     map[string]interface{}{"names": map[string]interface{}{"en": name}},
+		"location": map[string]interface{}{"latitude": latitude, "longitude": longitude, "accuracy_radius": uint16(1000)},
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	for _, recordSize := range []uint{24, 28, 32} {
+		writer := NewWriter("GeoLite2-City")
+		writer.RecordSize = recordSize
+		require.Nil(t, writer.Insert(mustNetwork(t, "40.64.0.0/10"), city("US", "Boydton", 36.6534, -78.375)))
+		require.Nil(t, writer.Insert(mustNetwork(t, "40.85.232.0/24"), city("CA", "Toronto", 43.6547, -79.3623)))
+		require.Nil(t, writer.Insert(mustNetwork(t, "2603:1030::/32"), city("US", "Boydton", 36.6534, -78.375)))
+		var buffer bytes.Buffer
+		_, err := writer.WriteTo(&buffer)
+		require.Nil(t, err)
+
+		reader, err := FromBytes(buffer.Bytes())
+		require.Nil(t, err)
+		assert.Equal(t, "GeoLite2-City", reader.Metadata.DatabaseType)
+		assert.Equal(t, uint(6), reader.Metadata.IPVersion)
+		assert.Equal(t, recordSize, reader.Metadata.RecordSize)
+		assert.Equal(t, []string{"en"}, reader.Metadata.Languages)
+
+		value, prefix, err := reader.Lookup(net.ParseIP("40.85.232.72"))
+		require.Nil(t, err)
+		assert.Equal(t, 24, prefix)
+		record := value.(map[string]interface{})
+		assert.Equal(t, "CA", record["country"].(map[string]interface{})["iso_code"])
+		assert.Equal(t, "Toronto", record["city"].(map[string]interface{})["names"].(map[string]interface{})["en"])
+		assert.Equal(t, 43.6547, record["location"].(map[string]interface{})["latitude"])
+		assert.Equal(t, uint64(1000), record["location"].(map[string]interface{})["accuracy_radius"])
+
+		// The rest of the /10 keeps its data.
+		value, prefix, err = reader.Lookup(net.ParseIP("40.85.233.1"))
+		require.Nil(t, err)
+		assert.Equal(t, 24, prefix)
+		assert.Equal(t, "US", value.(map[string]interface{})["country"].(map[string]interface{})["iso_code"])
+		value, _, err = reader.Lookup(net.ParseIP("40.127.255.255"))
+		require.Nil(t, err)
+		assert.NotNil(t, value)
+
+		value, prefix, err = reader.Lookup(net.ParseIP("2603:1030:1::1"))
+		require.Nil(t, err)
+		assert.Equal(t, 32, prefix)
+		assert.Equal(t, "Boydton", value.(map[string]interface{})["city"].(map[string]interface{})["names"].(map[string]interface{})["en"])
+
+		for _, ip := range []string{"10.1.2.3", "40.128.0.1", "2001:db8::1"} {
+			value, _, err = reader.Lookup(net.ParseIP(ip))
+			require.Nil(t, err)
+			assert.Nil(t, value, ip)
+		}
+	}
+}
+
+func TestWriterReaderTypes(t *testing.T) {
+	writer := NewWriter("Test")
+	writer.IPVersion = 4
+	value := map[string]interface{}{
+		"short":   "AS8075",
+		"long":    strings.Repeat("a", 300),
+		"longer":  strings.Repeat("b", 70000),
+		"int":     int32(-42),
+		"uint16":  uint16(443),
+		"uint64":  uint64(1) << 40,
+		"zero":    uint32(0),
+		"float":   float32(1.5),
+		"bool":    true,
+		"bytes":   []byte{1, 2, 3},
+		"array":   []interface{}{"AS8075", "AS8075", uint32(8075)},
+		"nested":  map[string]interface{}{"short": "AS8075"},
+		"missing": false,
+	}
+	require.Nil(t, writer.Insert(mustNetwork(t, "13.64.0.0/11"), value))
+	require.NotNil(t, writer.Insert(mustNetwork(t, "2603:1030::/32"), value))
+	var buffer bytes.Buffer
+	_, err := writer.WriteTo(&buffer)
+	require.Nil(t, err)
+
+	reader, err := FromBytes(buffer.Bytes())
+	require.Nil(t, err)
+	decoded, prefix, err := reader.Lookup(net.ParseIP("13.66.1.1"))
+	require.Nil(t, err)
+	assert.Equal(t, 11, prefix)
+	assert.Equal(t, map[string]interface{}{
+		"short":   "AS8075",
+		"long":    strings.Repeat("a", 300),
+		"longer":  strings.Repeat("b", 70000),
+		"int":     int64(-42),
+		"uint16":  uint64(443),
+		"uint64":  uint64(1) << 40,
+		"zero":    uint64(0),
+		"float":   1.5,
+		"bool":    true,
+		"bytes":   []byte{1, 2, 3},
+		"array":   []interface{}{"AS8075", "AS8075", uint64(8075)},
+		"nested":  map[string]interface{}{"short": "AS8075"},
+		"missing": false,
+	}, decoded)
+
+	_, _, err = reader.Lookup(net.ParseIP("2603:1030::1"))
+	assert.Error(t, err)
+}
+
+func TestFromBytesErrors(t *testing.T) {
+	_, err := FromBytes([]byte("not a database"))
+	assert.Error(t, err)
+
+	var buffer bytes.Buffer
+	_, err = NewWriter("Test").WriteTo(&buffer)
+	require.Nil(t, err)
+	data := buffer.Bytes()
+	// Drop the search tree.
+	_, err = FromBytes(data[7:])
+	assert.Error(t, err)
+}
+
+// The GeoIP2-City-Test and GeoLite2-ASN-Test databases in testdata are
+// MaxMind's own test databases from github.com/maxmind/MaxMind-DB (commit
+// 16e5535a80d9, MIT license), written by their reference writer.
+func TestReaderMaxMindTestDatabases(t *testing.T) {
+	reader, err := Open("../testdata/GeoIP2-City-Test.mmdb")
+	require.Nil(t, err)
+	assert.Equal(t, "GeoIP2-City", reader.Metadata.DatabaseType)
+	assert.Equal(t, uint(6), reader.Metadata.IPVersion)
+	assert.Contains(t, reader.Metadata.Languages, "en")
+
+	value, prefix, err := reader.Lookup(net.ParseIP("81.2.69.160"))
+	require.Nil(t, err)
+	assert.Equal(t, 27, prefix)
+	record := value.(map[string]interface{})
+	assert.Equal(t, "London", record["city"].(map[string]interface{})["names"].(map[string]interface{})["en"])
+	assert.Equal(t, uint64(2643743), record["city"].(map[string]interface{})["geoname_id"])
+	assert.Equal(t, "GB", record["country"].(map[string]interface{})["iso_code"])
+	assert.Equal(t, "United Kingdom", record["country"].(map[string]interface{})["names"].(map[string]interface{})["en"])
+	assert.Equal(t, 51.5142, record["location"].(map[string]interface{})["latitude"])
+	assert.Equal(t, -0.0931, record["location"].(map[string]interface{})["longitude"])
+
+	value, prefix, err = reader.Lookup(net.ParseIP("89.160.20.112"))
+	require.Nil(t, err)
+	assert.Equal(t, 28, prefix)
+	record = value.(map[string]interface{})
+	assert.Equal(t, "Linköping", record["city"].(map[string]interface{})["names"].(map[string]interface{})["en"])
+	assert.Equal(t, "SE", record["country"].(map[string]interface{})["iso_code"])
+
+	value, prefix, err = reader.Lookup(net.ParseIP("2001:218::1"))
+	require.Nil(t, err)
+	assert.Equal(t, 32, prefix)
+	record = value.(map[string]interface{})
+	assert.Equal(t, "JP", record["country"].(map[string]interface{})["iso_code"])
+	assert.NotContains(t, record, "city")
+
+	value, _, err = reader.Lookup(net.ParseIP("10.1.2.3"))
+	require.Nil(t, err)
+	assert.Nil(t, value)
+
+	reader, err = Open("../testdata/GeoLite2-ASN-Test.mmdb")
+	require.Nil(t, err)
+	assert.Equal(t, "GeoLite2-ASN", reader.Metadata.DatabaseType)
+
+	value, prefix, err = reader.Lookup(net.ParseIP("1.0.0.1"))
+	require.Nil(t, err)
+	assert.Equal(t, 24, prefix)
+	record = value.(map[string]interface{})
+	assert.Equal(t, uint64(15169), record["autonomous_system_number"])
+	assert.Equal(t, "Google Inc.", record["autonomous_system_organization"])
+
+	value, _, err = reader.Lookup(net.ParseIP("12.81.92.1"))
+	require.Nil(t, err)
+	assert.Equal(t, "AT&T Services", value.(map[string]interface{})["autonomous_system_organization"])
+}
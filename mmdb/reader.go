@@ -0,0 +1,180 @@
+// Package mmdb reads MaxMind DB files, the format of the GeoIP2 and GeoLite2
+// databases. See https://maxmind.github.io/MaxMind-DB/.
+package mmdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// metadataMarker precedes the metadata at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the size of the zeros between the search tree and
+// the data section.
+const dataSectionSeparator = 16
+
+// Metadata describes a database.
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+	Languages    []string
+	BuildEpoch   uint64
+	Description  map[string]string
+}
+
+// Reader looks up addresses in a database held in memory.
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte
+	data      decoder
+	ipv4Start uint
+	ipv4Depth int
+}
+
+// Open reads the database at path.
+func Open(path string) (*Reader, error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buffer)
+}
+
+// FromBytes reads a database from buffer.
+func FromBytes(buffer []byte) (*Reader, error) {
+	start := bytes.LastIndex(buffer, metadataMarker)
+	if start == -1 {
+		return nil, fmt.Errorf("mmdb: no metadata, not a MaxMind DB file")
+	}
+	metadataDecoder := decoder{buffer: buffer[start+len(metadataMarker):]}
+	value, _, err := metadataDecoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: invalid metadata: %s", err)
+	}
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("mmdb: invalid metadata")
+	}
+	reader := &Reader{}
+	metadata := &reader.Metadata
+	metadata.NodeCount = uint(metadataUint(values, "node_count"))
+	metadata.RecordSize = uint(metadataUint(values, "record_size"))
+	metadata.IPVersion = uint(metadataUint(values, "ip_version"))
+	metadata.BuildEpoch = metadataUint(values, "build_epoch")
+	metadata.DatabaseType, _ = values["database_type"].(string)
+	languages, _ := values["languages"].([]interface{})
+	for _, language := range languages {
+		if language, ok := language.(string); ok {
+			metadata.Languages = append(metadata.Languages, language)
+		}
+	}
+	description, _ := values["description"].(map[string]interface{})
+	metadata.Description = map[string]string{}
+	for language, text := range description {
+		metadata.Description[language], _ = text.(string)
+	}
+	if major := metadataUint(values, "binary_format_major_version"); major != 2 {
+		return nil, fmt.Errorf("mmdb: unsupported format version %d", major)
+	}
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("mmdb: unsupported ip version %d", metadata.IPVersion)
+	}
+
+	treeSize := metadata.NodeCount * metadata.RecordSize / 4
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, fmt.Errorf("mmdb: search tree beyond end of file")
+	}
+	reader.tree = buffer[:treeSize]
+	reader.data = decoder{buffer: buffer[treeSize+dataSectionSeparator : start]}
+
+	// IPv4 addresses are found under ::/96 of IPv6 databases.
+	if metadata.IPVersion == 6 {
+		node := uint(0)
+		for reader.ipv4Depth = 0; reader.ipv4Depth < 96 && node < metadata.NodeCount; reader.ipv4Depth++ {
+			node = reader.record(node, 0)
+		}
+		reader.ipv4Start = node
+	}
+	return reader, nil
+}
+
+// Lookup returns the data of the network containing ip and its prefix
+// length, or nil when ip is not in the database.
+func (reader *Reader) Lookup(ip net.IP) (interface{}, int, error) {
+	address := ip.To4()
+	node, depth := uint(0), 0
+	if address != nil {
+		node, depth = reader.ipv4Start, reader.ipv4Depth
+	} else {
+		if reader.Metadata.IPVersion == 4 {
+			return nil, 0, fmt.Errorf("mmdb: cannot look up %s in an IPv4 database", ip)
+		}
+		address = ip.To16()
+		if address == nil {
+			return nil, 0, fmt.Errorf("mmdb: invalid address")
+		}
+	}
+	nodeCount := reader.Metadata.NodeCount
+	bits := len(address) * 8
+	i := 0
+	for ; i < bits && node < nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-uint(i%8))) & 1
+		node = reader.record(node, bit)
+	}
+	if node == nodeCount {
+		return nil, 0, nil
+	}
+	if node < nodeCount {
+		return nil, 0, fmt.Errorf("mmdb: invalid search tree")
+	}
+	offset := node - nodeCount - dataSectionSeparator
+	value, _, err := reader.data.decode(offset, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	prefix := depth + i
+	if len(address) == net.IPv4len && reader.Metadata.IPVersion == 6 {
+		// Prefixes of IPv4 addresses count from the start of the address.
+		prefix -= 96
+		if prefix < 0 {
+			prefix = 0
+		}
+	}
+	return value, prefix, nil
+}
+
+// record returns the left or right record of node.
+func (reader *Reader) record(node uint, right uint) uint {
+	switch reader.Metadata.RecordSize {
+	case 24:
+		offset := node*6 + right*3
+		b := reader.tree[offset : offset+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := reader.tree[node*7 : node*7+7]
+		if right == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		offset := node*8 + right*4
+		b := reader.tree[offset : offset+4]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3])
+	}
+}
+
+func metadataUint(values map[string]interface{}, key string) uint64 {
+	value, _ := values[key].(uint64)
+	return value
+}
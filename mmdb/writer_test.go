@@ -0,0 +1,260 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// Writer builds a database to test the reader with. Values are maps, arrays,
+// strings, []byte, bool, float64, float32, int32, uint16, uint32 and uint64.
+// Repeated strings are written once and referenced with pointers.
+type Writer struct {
+	IPVersion    uint
+	RecordSize   uint
+	DatabaseType string
+	Languages    []string
+	Description  map[string]string
+
+	nodes   [][2]writerRecord
+	data    bytes.Buffer
+	strings map[string]uint
+}
+
+// writerRecord points to a node, to data or to nothing.
+type writerRecord struct {
+	node   int
+	data   uint
+	isData bool
+}
+
+// NewWriter returns a writer of an IPv6 database with 28 bit records.
+func NewWriter(databaseType string) *Writer {
+	return &Writer{
+		IPVersion:    6,
+		RecordSize:   28,
+		DatabaseType: databaseType,
+		Languages:    []string{"en"},
+		Description:  map[string]string{"en": databaseType},
+		nodes:        [][2]writerRecord{{}},
+		strings:      map[string]uint{},
+	}
+}
+
+// Insert sets the data of network, replacing the data of any network it
+// contains. Insert networks from the widest to the narrowest.
+func (writer *Writer) Insert(network *net.IPNet, value interface{}) error {
+	address := network.IP.To4()
+	ones, _ := network.Mask.Size()
+	if address == nil {
+		if writer.IPVersion == 4 {
+			return fmt.Errorf("mmdb: cannot insert %s in an IPv4 database", network)
+		}
+		address = network.IP.To16()
+	} else if writer.IPVersion == 6 {
+		address = append(make(net.IP, 12), address...)
+		ones += 96
+	}
+	offset := uint(writer.data.Len())
+	err := writer.encode(value)
+	if err != nil {
+		return err
+	}
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := address[i/8] >> (7 - uint(i%8)) & 1
+		if i == ones-1 {
+			writer.nodes[node][bit] = writerRecord{data: offset, isData: true}
+			break
+		}
+		record := writer.nodes[node][bit]
+		if record.node == 0 {
+			// Split data records, so that the rest of the network keeps
+			// its data.
+			writer.nodes = append(writer.nodes, [2]writerRecord{record, record})
+			record = writerRecord{node: len(writer.nodes) - 1}
+			writer.nodes[node][bit] = record
+		}
+		node = record.node
+	}
+	return nil
+}
+
+// WriteTo writes the database.
+func (writer *Writer) WriteTo(w io.Writer) (int64, error) {
+	nodeCount := uint(len(writer.nodes))
+	if writer.RecordSize != 24 && writer.RecordSize != 28 && writer.RecordSize != 32 {
+		return 0, fmt.Errorf("mmdb: unsupported record size %d", writer.RecordSize)
+	}
+	if uint64(nodeCount)+dataSectionSeparator+uint64(writer.data.Len()) >= 1<<writer.RecordSize {
+		return 0, fmt.Errorf("mmdb: database too large for record size %d", writer.RecordSize)
+	}
+	var output bytes.Buffer
+	for _, node := range writer.nodes {
+		left, right := writer.recordValue(node[0], nodeCount), writer.recordValue(node[1], nodeCount)
+		switch writer.RecordSize {
+		case 24:
+			output.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			output.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>20&0xf0 | right>>24&0x0f),
+				byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&output, binary.BigEndian, uint32(left))
+			binary.Write(&output, binary.BigEndian, uint32(right))
+		}
+	}
+	output.Write(make([]byte, dataSectionSeparator))
+	output.Write(writer.data.Bytes())
+	output.Write(metadataMarker)
+
+	languages := make([]interface{}, len(writer.Languages))
+	for i, language := range writer.Languages {
+		languages[i] = language
+	}
+	description := map[string]interface{}{}
+	for language, text := range writer.Description {
+		description[language] = text
+	}
+	metadata := &Writer{strings: map[string]uint{}}
+	err := metadata.encode(map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(writer.RecordSize),
+		"ip_version":                  uint16(writer.IPVersion),
+		"database_type":               writer.DatabaseType,
+		"languages":                   languages,
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"description":                 description,
+	})
+	if err != nil {
+		return 0, err
+	}
+	output.Write(metadata.data.Bytes())
+	return output.WriteTo(w)
+}
+
+func (writer *Writer) recordValue(record writerRecord, nodeCount uint) uint {
+	switch {
+	case record.isData:
+		return nodeCount + dataSectionSeparator + record.data
+	case record.node != 0:
+		return uint(record.node)
+	default:
+		return nodeCount
+	}
+}
+
+func (writer *Writer) encode(value interface{}) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		writer.control(typeMap, uint(len(value)))
+		for key, item := range value {
+			writer.encodeString(key)
+			err := writer.encode(item)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		writer.control(typeArray, uint(len(value)))
+		for _, item := range value {
+			err := writer.encode(item)
+			if err != nil {
+				return err
+			}
+		}
+	case string:
+		writer.encodeString(value)
+	case []byte:
+		writer.control(typeBytes, uint(len(value)))
+		writer.data.Write(value)
+	case bool:
+		size := uint(0)
+		if value {
+			size = 1
+		}
+		writer.control(typeBool, size)
+	case float64:
+		writer.control(typeDouble, 8)
+		binary.Write(&writer.data, binary.BigEndian, math.Float64bits(value))
+	case float32:
+		writer.control(typeFloat, 4)
+		binary.Write(&writer.data, binary.BigEndian, math.Float32bits(value))
+	case int32:
+		writer.control(typeInt32, 4)
+		binary.Write(&writer.data, binary.BigEndian, value)
+	case uint16:
+		writer.encodeUint(typeUint16, uint64(value))
+	case uint32:
+		writer.encodeUint(typeUint32, uint64(value))
+	case uint64:
+		writer.encodeUint(typeUint64, value)
+	default:
+		return fmt.Errorf("mmdb: cannot encode %T", value)
+	}
+	return nil
+}
+
+func (writer *Writer) encodeString(value string) {
+	if offset, ok := writer.strings[value]; ok {
+		writer.pointer(offset)
+		return
+	}
+	writer.strings[value] = uint(writer.data.Len())
+	writer.control(typeString, uint(len(value)))
+	writer.data.WriteString(value)
+}
+
+// encodeUint writes value without its leading zero bytes.
+func (writer *Writer) encodeUint(kind int, value uint64) {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, value)
+	payload = bytes.TrimLeft(payload, "\x00")
+	writer.control(kind, uint(len(payload)))
+	writer.data.Write(payload)
+}
+
+func (writer *Writer) pointer(offset uint) {
+	switch {
+	case offset < 2048:
+		writer.data.Write([]byte{typePointer<<5 | byte(offset>>8), byte(offset)})
+	case offset < 526336:
+		offset -= 2048
+		writer.data.Write([]byte{typePointer<<5 | 1<<3 | byte(offset>>16), byte(offset >> 8), byte(offset)})
+	case offset < 134744064:
+		offset -= 526336
+		writer.data.Write([]byte{typePointer<<5 | 2<<3 | byte(offset>>24), byte(offset >> 16), byte(offset >> 8), byte(offset)})
+	default:
+		writer.data.Write([]byte{typePointer<<5 | 3<<3})
+		binary.Write(&writer.data, binary.BigEndian, uint32(offset))
+	}
+}
+
+func (writer *Writer) control(kind int, size uint) {
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	default:
+		extra = []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+		size = 31
+	}
+	if kind <= typeMap {
+		writer.data.WriteByte(byte(kind)<<5 | byte(size))
+	} else {
+		writer.data.WriteByte(byte(size))
+		writer.data.WriteByte(byte(kind - 7))
+	}
+	writer.data.Write(extra)
+}
@@ -0,0 +1,965 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: nsg.proto
+
+/*
+Package nsgpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	nsg.proto
+
+It has these top-level messages:
+
+	Event
+	FlowTuple
+	NsgEvent
+	AppGwAccess
+	AppGwFirewall
+*/
+package nsgpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type Protocol int32
+
+const (
+	Protocol_PROTOCOL_UNKNOWN Protocol = 0
+	Protocol_TCP              Protocol = 1
+	Protocol_UDP              Protocol = 2
+)
+
+var Protocol_name = map[int32]string{
+	0: "PROTOCOL_UNKNOWN",
+	1: "TCP",
+	2: "UDP",
+}
+var Protocol_value = map[string]int32{
+	"PROTOCOL_UNKNOWN": 0,
+	"TCP":              1,
+	"UDP":              2,
+}
+
+func (x Protocol) String() string {
+	return proto.EnumName(Protocol_name, int32(x))
+}
+func (Protocol) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type Direction int32
+
+const (
+	Direction_DIRECTION_UNKNOWN Direction = 0
+	Direction_INBOUND           Direction = 1
+	Direction_OUTBOUND          Direction = 2
+)
+
+var Direction_name = map[int32]string{
+	0: "DIRECTION_UNKNOWN",
+	1: "INBOUND",
+	2: "OUTBOUND",
+}
+var Direction_value = map[string]int32{
+	"DIRECTION_UNKNOWN": 0,
+	"INBOUND":           1,
+	"OUTBOUND":          2,
+}
+
+func (x Direction) String() string {
+	return proto.EnumName(Direction_name, int32(x))
+}
+func (Direction) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type Decision int32
+
+const (
+	Decision_DECISION_UNKNOWN Decision = 0
+	Decision_ALLOWED          Decision = 1
+	Decision_DENIED           Decision = 2
+)
+
+var Decision_name = map[int32]string{
+	0: "DECISION_UNKNOWN",
+	1: "ALLOWED",
+	2: "DENIED",
+}
+var Decision_value = map[string]int32{
+	"DECISION_UNKNOWN": 0,
+	"ALLOWED":          1,
+	"DENIED":           2,
+}
+
+func (x Decision) String() string {
+	return proto.EnumName(Decision_name, int32(x))
+}
+func (Decision) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+type FlowState int32
+
+const (
+	FlowState_FLOW_STATE_UNKNOWN FlowState = 0
+	FlowState_BEGIN              FlowState = 1
+	FlowState_CONTINUING         FlowState = 2
+	FlowState_END                FlowState = 3
+)
+
+var FlowState_name = map[int32]string{
+	0: "FLOW_STATE_UNKNOWN",
+	1: "BEGIN",
+	2: "CONTINUING",
+	3: "END",
+}
+var FlowState_value = map[string]int32{
+	"FLOW_STATE_UNKNOWN": 0,
+	"BEGIN":              1,
+	"CONTINUING":         2,
+	"END":                3,
+}
+
+func (x FlowState) String() string {
+	return proto.EnumName(FlowState_name, int32(x))
+}
+func (FlowState) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+// Event is one decoded log event. Exactly one record is set, according to
+// the log family.
+type Event struct {
+	// Event time in milliseconds since the Unix epoch.
+	TimeMs int64 `protobuf:"varint,1,opt,name=time_ms,json=timeMs" json:"time_ms,omitempty"`
+	// Azure log category, e.g. NetworkSecurityGroupFlowEvent.
+	Category string `protobuf:"bytes,2,opt,name=category" json:"category,omitempty"`
+	// Azure operation name, e.g. NetworkSecurityGroupFlowEvents.
+	OperationName  string `protobuf:"bytes,3,opt,name=operation_name,json=operationName" json:"operation_name,omitempty"`
+	SubscriptionId string `protobuf:"bytes,4,opt,name=subscription_id,json=subscriptionId" json:"subscription_id,omitempty"`
+	ResourceGroup  string `protobuf:"bytes,5,opt,name=resource_group,json=resourceGroup" json:"resource_group,omitempty"`
+	// NSG or application gateway name.
+	ResourceName string `protobuf:"bytes,6,opt,name=resource_name,json=resourceName" json:"resource_name,omitempty"`
+	// The CEF extension of the event, including fields added by enrichment.
+	Extension map[string]string `protobuf:"bytes,7,rep,name=extension" json:"extension,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Types that are valid to be assigned to Record:
+	//	*Event_Flow
+	//	*Event_NsgEvent
+	//	*Event_AppgwAccess
+	//	*Event_AppgwFirewall
+	Record isEvent_Record `protobuf_oneof:"record"`
+}
+
+func (m *Event) Reset()                    { *m = Event{} }
+func (m *Event) String() string            { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()               {}
+func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type isEvent_Record interface{ isEvent_Record() }
+
+type Event_Flow struct {
+	Flow *FlowTuple `protobuf:"bytes,10,opt,name=flow,oneof"`
+}
+type Event_NsgEvent struct {
+	NsgEvent *NsgEvent `protobuf:"bytes,11,opt,name=nsg_event,json=nsgEvent,oneof"`
+}
+type Event_AppgwAccess struct {
+	AppgwAccess *AppGwAccess `protobuf:"bytes,12,opt,name=appgw_access,json=appgwAccess,oneof"`
+}
+type Event_AppgwFirewall struct {
+	AppgwFirewall *AppGwFirewall `protobuf:"bytes,13,opt,name=appgw_firewall,json=appgwFirewall,oneof"`
+}
+
+func (*Event_Flow) isEvent_Record()          {}
+func (*Event_NsgEvent) isEvent_Record()      {}
+func (*Event_AppgwAccess) isEvent_Record()   {}
+func (*Event_AppgwFirewall) isEvent_Record() {}
+
+func (m *Event) GetRecord() isEvent_Record {
+	if m != nil {
+		return m.Record
+	}
+	return nil
+}
+
+func (m *Event) GetTimeMs() int64 {
+	if m != nil {
+		return m.TimeMs
+	}
+	return 0
+}
+
+func (m *Event) GetCategory() string {
+	if m != nil {
+		return m.Category
+	}
+	return ""
+}
+
+func (m *Event) GetOperationName() string {
+	if m != nil {
+		return m.OperationName
+	}
+	return ""
+}
+
+func (m *Event) GetSubscriptionId() string {
+	if m != nil {
+		return m.SubscriptionId
+	}
+	return ""
+}
+
+func (m *Event) GetResourceGroup() string {
+	if m != nil {
+		return m.ResourceGroup
+	}
+	return ""
+}
+
+func (m *Event) GetResourceName() string {
+	if m != nil {
+		return m.ResourceName
+	}
+	return ""
+}
+
+func (m *Event) GetExtension() map[string]string {
+	if m != nil {
+		return m.Extension
+	}
+	return nil
+}
+
+func (m *Event) GetFlow() *FlowTuple {
+	if x, ok := m.GetRecord().(*Event_Flow); ok {
+		return x.Flow
+	}
+	return nil
+}
+
+func (m *Event) GetNsgEvent() *NsgEvent {
+	if x, ok := m.GetRecord().(*Event_NsgEvent); ok {
+		return x.NsgEvent
+	}
+	return nil
+}
+
+func (m *Event) GetAppgwAccess() *AppGwAccess {
+	if x, ok := m.GetRecord().(*Event_AppgwAccess); ok {
+		return x.AppgwAccess
+	}
+	return nil
+}
+
+func (m *Event) GetAppgwFirewall() *AppGwFirewall {
+	if x, ok := m.GetRecord().(*Event_AppgwFirewall); ok {
+		return x.AppgwFirewall
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*Event) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _Event_OneofMarshaler, _Event_OneofUnmarshaler, _Event_OneofSizer, []interface{}{
+		(*Event_Flow)(nil),
+		(*Event_NsgEvent)(nil),
+		(*Event_AppgwAccess)(nil),
+		(*Event_AppgwFirewall)(nil),
+	}
+}
+
+func _Event_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*Event)
+	// record
+	switch x := m.Record.(type) {
+	case *Event_Flow:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Flow); err != nil {
+			return err
+		}
+	case *Event_NsgEvent:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.NsgEvent); err != nil {
+			return err
+		}
+	case *Event_AppgwAccess:
+		b.EncodeVarint(12<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.AppgwAccess); err != nil {
+			return err
+		}
+	case *Event_AppgwFirewall:
+		b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.AppgwFirewall); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("Event.Record has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _Event_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*Event)
+	switch tag {
+	case 10: // record.flow
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(FlowTuple)
+		err := b.DecodeMessage(msg)
+		m.Record = &Event_Flow{msg}
+		return true, err
+	case 11: // record.nsg_event
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(NsgEvent)
+		err := b.DecodeMessage(msg)
+		m.Record = &Event_NsgEvent{msg}
+		return true, err
+	case 12: // record.appgw_access
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(AppGwAccess)
+		err := b.DecodeMessage(msg)
+		m.Record = &Event_AppgwAccess{msg}
+		return true, err
+	case 13: // record.appgw_firewall
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(AppGwFirewall)
+		err := b.DecodeMessage(msg)
+		m.Record = &Event_AppgwFirewall{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _Event_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*Event)
+	// record
+	switch x := m.Record.(type) {
+	case *Event_Flow:
+		s := proto.Size(x.Flow)
+		n += proto.SizeVarint(10<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Event_NsgEvent:
+		s := proto.Size(x.NsgEvent)
+		n += proto.SizeVarint(11<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Event_AppgwAccess:
+		s := proto.Size(x.AppgwAccess)
+		n += proto.SizeVarint(12<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *Event_AppgwFirewall:
+		s := proto.Size(x.AppgwFirewall)
+		n += proto.SizeVarint(13<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+// FlowTuple is one NSG flow log tuple.
+type FlowTuple struct {
+	SystemId string `protobuf:"bytes,1,opt,name=system_id,json=systemId" json:"system_id,omitempty"`
+	Rule     string `protobuf:"bytes,2,opt,name=rule" json:"rule,omitempty"`
+	// MAC address of the VM NIC, colon separated.
+	Mac             string    `protobuf:"bytes,3,opt,name=mac" json:"mac,omitempty"`
+	SourceIp        string    `protobuf:"bytes,4,opt,name=source_ip,json=sourceIp" json:"source_ip,omitempty"`
+	DestinationIp   string    `protobuf:"bytes,5,opt,name=destination_ip,json=destinationIp" json:"destination_ip,omitempty"`
+	SourcePort      uint32    `protobuf:"varint,6,opt,name=source_port,json=sourcePort" json:"source_port,omitempty"`
+	DestinationPort uint32    `protobuf:"varint,7,opt,name=destination_port,json=destinationPort" json:"destination_port,omitempty"`
+	Protocol        Protocol  `protobuf:"varint,8,opt,name=protocol,enum=nsgparser.Protocol" json:"protocol,omitempty"`
+	Direction       Direction `protobuf:"varint,9,opt,name=direction,enum=nsgparser.Direction" json:"direction,omitempty"`
+	Decision        Decision  `protobuf:"varint,10,opt,name=decision,enum=nsgparser.Decision" json:"decision,omitempty"`
+	// Flow log version, 1 or 2.
+	Version uint32 `protobuf:"varint,11,opt,name=version" json:"version,omitempty"`
+	// Version 2 only.
+	FlowState FlowState `protobuf:"varint,12,opt,name=flow_state,json=flowState,enum=nsgparser.FlowState" json:"flow_state,omitempty"`
+	// Version 2 counters. Zero on BEGIN tuples and in version 1.
+	PacketsSourceToDestination uint64 `protobuf:"varint,13,opt,name=packets_source_to_destination,json=packetsSourceToDestination" json:"packets_source_to_destination,omitempty"`
+	BytesSourceToDestination   uint64 `protobuf:"varint,14,opt,name=bytes_source_to_destination,json=bytesSourceToDestination" json:"bytes_source_to_destination,omitempty"`
+	PacketsDestinationToSource uint64 `protobuf:"varint,15,opt,name=packets_destination_to_source,json=packetsDestinationToSource" json:"packets_destination_to_source,omitempty"`
+	BytesDestinationToSource   uint64 `protobuf:"varint,16,opt,name=bytes_destination_to_source,json=bytesDestinationToSource" json:"bytes_destination_to_source,omitempty"`
+}
+
+func (m *FlowTuple) Reset()                    { *m = FlowTuple{} }
+func (m *FlowTuple) String() string            { return proto.CompactTextString(m) }
+func (*FlowTuple) ProtoMessage()               {}
+func (*FlowTuple) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *FlowTuple) GetSystemId() string {
+	if m != nil {
+		return m.SystemId
+	}
+	return ""
+}
+
+func (m *FlowTuple) GetRule() string {
+	if m != nil {
+		return m.Rule
+	}
+	return ""
+}
+
+func (m *FlowTuple) GetMac() string {
+	if m != nil {
+		return m.Mac
+	}
+	return ""
+}
+
+func (m *FlowTuple) GetSourceIp() string {
+	if m != nil {
+		return m.SourceIp
+	}
+	return ""
+}
+
+func (m *FlowTuple) GetDestinationIp() string {
+	if m != nil {
+		return m.DestinationIp
+	}
+	return ""
+}
+
+func (m *FlowTuple) GetSourcePort() uint32 {
+	if m != nil {
+		return m.SourcePort
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetDestinationPort() uint32 {
+	if m != nil {
+		return m.DestinationPort
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetProtocol() Protocol {
+	if m != nil {
+		return m.Protocol
+	}
+	return Protocol_PROTOCOL_UNKNOWN
+}
+
+func (m *FlowTuple) GetDirection() Direction {
+	if m != nil {
+		return m.Direction
+	}
+	return Direction_DIRECTION_UNKNOWN
+}
+
+func (m *FlowTuple) GetDecision() Decision {
+	if m != nil {
+		return m.Decision
+	}
+	return Decision_DECISION_UNKNOWN
+}
+
+func (m *FlowTuple) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetFlowState() FlowState {
+	if m != nil {
+		return m.FlowState
+	}
+	return FlowState_FLOW_STATE_UNKNOWN
+}
+
+func (m *FlowTuple) GetPacketsSourceToDestination() uint64 {
+	if m != nil {
+		return m.PacketsSourceToDestination
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetBytesSourceToDestination() uint64 {
+	if m != nil {
+		return m.BytesSourceToDestination
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetPacketsDestinationToSource() uint64 {
+	if m != nil {
+		return m.PacketsDestinationToSource
+	}
+	return 0
+}
+
+func (m *FlowTuple) GetBytesDestinationToSource() uint64 {
+	if m != nil {
+		return m.BytesDestinationToSource
+	}
+	return 0
+}
+
+// NsgEvent is an NSG rule event, logged when a rule is applied to a NIC.
+type NsgEvent struct {
+	SystemId           string    `protobuf:"bytes,1,opt,name=system_id,json=systemId" json:"system_id,omitempty"`
+	VnetResourceGuid   string    `protobuf:"bytes,2,opt,name=vnet_resource_guid,json=vnetResourceGuid" json:"vnet_resource_guid,omitempty"`
+	SubnetPrefix       string    `protobuf:"bytes,3,opt,name=subnet_prefix,json=subnetPrefix" json:"subnet_prefix,omitempty"`
+	MacAddress         string    `protobuf:"bytes,4,opt,name=mac_address,json=macAddress" json:"mac_address,omitempty"`
+	PrimaryIpv4Address string    `protobuf:"bytes,5,opt,name=primary_ipv4_address,json=primaryIpv4Address" json:"primary_ipv4_address,omitempty"`
+	RuleName           string    `protobuf:"bytes,6,opt,name=rule_name,json=ruleName" json:"rule_name,omitempty"`
+	Direction          Direction `protobuf:"varint,7,opt,name=direction,enum=nsgparser.Direction" json:"direction,omitempty"`
+	Priority           uint32    `protobuf:"varint,8,opt,name=priority" json:"priority,omitempty"`
+	// allow or block.
+	Type string `protobuf:"bytes,9,opt,name=type" json:"type,omitempty"`
+	// Rule conditions such as sourceIP, destinationIP and the port ranges.
+	Conditions map[string]string `protobuf:"bytes,10,rep,name=conditions" json:"conditions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *NsgEvent) Reset()                    { *m = NsgEvent{} }
+func (m *NsgEvent) String() string            { return proto.CompactTextString(m) }
+func (*NsgEvent) ProtoMessage()               {}
+func (*NsgEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *NsgEvent) GetSystemId() string {
+	if m != nil {
+		return m.SystemId
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetVnetResourceGuid() string {
+	if m != nil {
+		return m.VnetResourceGuid
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetSubnetPrefix() string {
+	if m != nil {
+		return m.SubnetPrefix
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetMacAddress() string {
+	if m != nil {
+		return m.MacAddress
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetPrimaryIpv4Address() string {
+	if m != nil {
+		return m.PrimaryIpv4Address
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetRuleName() string {
+	if m != nil {
+		return m.RuleName
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetDirection() Direction {
+	if m != nil {
+		return m.Direction
+	}
+	return Direction_DIRECTION_UNKNOWN
+}
+
+func (m *NsgEvent) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *NsgEvent) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *NsgEvent) GetConditions() map[string]string {
+	if m != nil {
+		return m.Conditions
+	}
+	return nil
+}
+
+// AppGwAccess is an Application Gateway access log record.
+type AppGwAccess struct {
+	InstanceId    string `protobuf:"bytes,1,opt,name=instance_id,json=instanceId" json:"instance_id,omitempty"`
+	ClientIp      string `protobuf:"bytes,2,opt,name=client_ip,json=clientIp" json:"client_ip,omitempty"`
+	ClientPort    uint32 `protobuf:"varint,3,opt,name=client_port,json=clientPort" json:"client_port,omitempty"`
+	HttpMethod    string `protobuf:"bytes,4,opt,name=http_method,json=httpMethod" json:"http_method,omitempty"`
+	RequestUri    string `protobuf:"bytes,5,opt,name=request_uri,json=requestUri" json:"request_uri,omitempty"`
+	RequestQuery  string `protobuf:"bytes,6,opt,name=request_query,json=requestQuery" json:"request_query,omitempty"`
+	UserAgent     string `protobuf:"bytes,7,opt,name=user_agent,json=userAgent" json:"user_agent,omitempty"`
+	HttpStatus    uint32 `protobuf:"varint,8,opt,name=http_status,json=httpStatus" json:"http_status,omitempty"`
+	HttpVersion   string `protobuf:"bytes,9,opt,name=http_version,json=httpVersion" json:"http_version,omitempty"`
+	ReceivedBytes uint64 `protobuf:"varint,10,opt,name=received_bytes,json=receivedBytes" json:"received_bytes,omitempty"`
+	SentBytes     uint64 `protobuf:"varint,11,opt,name=sent_bytes,json=sentBytes" json:"sent_bytes,omitempty"`
+	// Milliseconds.
+	TimeTaken  uint64 `protobuf:"varint,12,opt,name=time_taken,json=timeTaken" json:"time_taken,omitempty"`
+	SslEnabled bool   `protobuf:"varint,13,opt,name=ssl_enabled,json=sslEnabled" json:"ssl_enabled,omitempty"`
+}
+
+func (m *AppGwAccess) Reset()                    { *m = AppGwAccess{} }
+func (m *AppGwAccess) String() string            { return proto.CompactTextString(m) }
+func (*AppGwAccess) ProtoMessage()               {}
+func (*AppGwAccess) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *AppGwAccess) GetInstanceId() string {
+	if m != nil {
+		return m.InstanceId
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetClientIp() string {
+	if m != nil {
+		return m.ClientIp
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetClientPort() uint32 {
+	if m != nil {
+		return m.ClientPort
+	}
+	return 0
+}
+
+func (m *AppGwAccess) GetHttpMethod() string {
+	if m != nil {
+		return m.HttpMethod
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetRequestUri() string {
+	if m != nil {
+		return m.RequestUri
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetRequestQuery() string {
+	if m != nil {
+		return m.RequestQuery
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetUserAgent() string {
+	if m != nil {
+		return m.UserAgent
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetHttpStatus() uint32 {
+	if m != nil {
+		return m.HttpStatus
+	}
+	return 0
+}
+
+func (m *AppGwAccess) GetHttpVersion() string {
+	if m != nil {
+		return m.HttpVersion
+	}
+	return ""
+}
+
+func (m *AppGwAccess) GetReceivedBytes() uint64 {
+	if m != nil {
+		return m.ReceivedBytes
+	}
+	return 0
+}
+
+func (m *AppGwAccess) GetSentBytes() uint64 {
+	if m != nil {
+		return m.SentBytes
+	}
+	return 0
+}
+
+func (m *AppGwAccess) GetTimeTaken() uint64 {
+	if m != nil {
+		return m.TimeTaken
+	}
+	return 0
+}
+
+func (m *AppGwAccess) GetSslEnabled() bool {
+	if m != nil {
+		return m.SslEnabled
+	}
+	return false
+}
+
+// AppGwFirewall is an Application Gateway WAF log record.
+type AppGwFirewall struct {
+	InstanceId     string `protobuf:"bytes,1,opt,name=instance_id,json=instanceId" json:"instance_id,omitempty"`
+	ClientIp       string `protobuf:"bytes,2,opt,name=client_ip,json=clientIp" json:"client_ip,omitempty"`
+	ClientPort     uint32 `protobuf:"varint,3,opt,name=client_port,json=clientPort" json:"client_port,omitempty"`
+	RequestUri     string `protobuf:"bytes,4,opt,name=request_uri,json=requestUri" json:"request_uri,omitempty"`
+	RuleSetType    string `protobuf:"bytes,5,opt,name=rule_set_type,json=ruleSetType" json:"rule_set_type,omitempty"`
+	RuleSetVersion string `protobuf:"bytes,6,opt,name=rule_set_version,json=ruleSetVersion" json:"rule_set_version,omitempty"`
+	RuleId         string `protobuf:"bytes,7,opt,name=rule_id,json=ruleId" json:"rule_id,omitempty"`
+	Message        string `protobuf:"bytes,8,opt,name=message" json:"message,omitempty"`
+	// Blocked, Detected or Allowed.
+	Action  string                 `protobuf:"bytes,9,opt,name=action" json:"action,omitempty"`
+	Site    string                 `protobuf:"bytes,10,opt,name=site" json:"site,omitempty"`
+	Details *AppGwFirewall_Details `protobuf:"bytes,11,opt,name=details" json:"details,omitempty"`
+}
+
+func (m *AppGwFirewall) Reset()                    { *m = AppGwFirewall{} }
+func (m *AppGwFirewall) String() string            { return proto.CompactTextString(m) }
+func (*AppGwFirewall) ProtoMessage()               {}
+func (*AppGwFirewall) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *AppGwFirewall) GetInstanceId() string {
+	if m != nil {
+		return m.InstanceId
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetClientIp() string {
+	if m != nil {
+		return m.ClientIp
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetClientPort() uint32 {
+	if m != nil {
+		return m.ClientPort
+	}
+	return 0
+}
+
+func (m *AppGwFirewall) GetRequestUri() string {
+	if m != nil {
+		return m.RequestUri
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetRuleSetType() string {
+	if m != nil {
+		return m.RuleSetType
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetRuleSetVersion() string {
+	if m != nil {
+		return m.RuleSetVersion
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetRuleId() string {
+	if m != nil {
+		return m.RuleId
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetAction() string {
+	if m != nil {
+		return m.Action
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetSite() string {
+	if m != nil {
+		return m.Site
+	}
+	return ""
+}
+
+func (m *AppGwFirewall) GetDetails() *AppGwFirewall_Details {
+	if m != nil {
+		return m.Details
+	}
+	return nil
+}
+
+type AppGwFirewall_Details struct {
+	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
+	Data    string `protobuf:"bytes,2,opt,name=data" json:"data,omitempty"`
+	File    string `protobuf:"bytes,3,opt,name=file" json:"file,omitempty"`
+	Line    string `protobuf:"bytes,4,opt,name=line" json:"line,omitempty"`
+}
+
+func (m *AppGwFirewall_Details) Reset()                    { *m = AppGwFirewall_Details{} }
+func (m *AppGwFirewall_Details) String() string            { return proto.CompactTextString(m) }
+func (*AppGwFirewall_Details) ProtoMessage()               {}
+func (*AppGwFirewall_Details) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4, 0} }
+
+func (m *AppGwFirewall_Details) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *AppGwFirewall_Details) GetData() string {
+	if m != nil {
+		return m.Data
+	}
+	return ""
+}
+
+func (m *AppGwFirewall_Details) GetFile() string {
+	if m != nil {
+		return m.File
+	}
+	return ""
+}
+
+func (m *AppGwFirewall_Details) GetLine() string {
+	if m != nil {
+		return m.Line
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*Event)(nil), "nsgparser.Event")
+	proto.RegisterType((*FlowTuple)(nil), "nsgparser.FlowTuple")
+	proto.RegisterType((*NsgEvent)(nil), "nsgparser.NsgEvent")
+	proto.RegisterType((*AppGwAccess)(nil), "nsgparser.AppGwAccess")
+	proto.RegisterType((*AppGwFirewall)(nil), "nsgparser.AppGwFirewall")
+	proto.RegisterType((*AppGwFirewall_Details)(nil), "nsgparser.AppGwFirewall.Details")
+	proto.RegisterEnum("nsgparser.Protocol", Protocol_name, Protocol_value)
+	proto.RegisterEnum("nsgparser.Direction", Direction_name, Direction_value)
+	proto.RegisterEnum("nsgparser.Decision", Decision_name, Decision_value)
+	proto.RegisterEnum("nsgparser.FlowState", FlowState_name, FlowState_value)
+}
+
+func init() { proto.RegisterFile("nsg.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 1349 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x56, 0x41, 0x6f, 0xdb, 0x46,
+	0x13, 0xb5, 0x2c, 0xd9, 0x12, 0x47, 0x96, 0xac, 0x6f, 0x3f, 0x37, 0x21, 0x1c, 0x04, 0x71, 0x1d,
+	0x14, 0x75, 0x8d, 0x42, 0x2d, 0x9c, 0x1c, 0xd2, 0xb4, 0x3e, 0xc8, 0x96, 0x62, 0x0b, 0x75, 0x24,
+	0x97, 0x96, 0x1b, 0xa0, 0x17, 0x82, 0x26, 0xd7, 0xca, 0x22, 0x14, 0xc9, 0xec, 0x2e, 0xe5, 0xe8,
+	0xbf, 0x15, 0xe8, 0xb1, 0xf7, 0xde, 0xfa, 0x6f, 0x8a, 0x99, 0x25, 0x29, 0x3a, 0x76, 0x8a, 0xf6,
+	0xd2, 0xdb, 0xee, 0xdb, 0xf7, 0x66, 0x97, 0x3b, 0xb3, 0x6f, 0x08, 0x56, 0xa4, 0xa6, 0xdd, 0x44,
+	0xc6, 0x3a, 0x66, 0x38, 0x4c, 0x3c, 0xa9, 0xb8, 0xdc, 0xfd, 0xad, 0x06, 0x6b, 0x83, 0x39, 0x8f,
+	0x34, 0x7b, 0x08, 0x75, 0x2d, 0x66, 0xdc, 0x9d, 0x29, 0xbb, 0xb2, 0x53, 0xd9, 0xab, 0x3a, 0xeb,
+	0x38, 0x7d, 0xad, 0xd8, 0x36, 0x34, 0x7c, 0x4f, 0xf3, 0x69, 0x2c, 0x17, 0xf6, 0xea, 0x4e, 0x65,
+	0xcf, 0x72, 0x8a, 0x39, 0xfb, 0x02, 0xda, 0x71, 0xc2, 0xa5, 0xa7, 0x45, 0x1c, 0xb9, 0x91, 0x37,
+	0xe3, 0x76, 0x95, 0x18, 0xad, 0x02, 0x1d, 0x79, 0x33, 0xce, 0xbe, 0x84, 0x4d, 0x95, 0x5e, 0x29,
+	0x5f, 0x8a, 0x84, 0x98, 0x22, 0xb0, 0x6b, 0xc4, 0x6b, 0x97, 0xe1, 0x61, 0x80, 0xf1, 0x24, 0x57,
+	0x71, 0x2a, 0x7d, 0xee, 0x4e, 0x65, 0x9c, 0x26, 0xf6, 0x9a, 0x89, 0x97, 0xa3, 0x27, 0x08, 0xb2,
+	0xa7, 0x50, 0x00, 0x66, 0xd7, 0x75, 0x62, 0x6d, 0xe4, 0x20, 0x6d, 0x7a, 0x08, 0x16, 0xff, 0xa0,
+	0x79, 0xa4, 0x44, 0x1c, 0xd9, 0xf5, 0x9d, 0xea, 0x5e, 0xf3, 0xe0, 0x49, 0xb7, 0xf8, 0xf2, 0x2e,
+	0x7d, 0x75, 0x77, 0x90, 0x33, 0x06, 0x91, 0x96, 0x0b, 0x67, 0xa9, 0x60, 0xfb, 0x50, 0xbb, 0x0e,
+	0xe3, 0x1b, 0x1b, 0x76, 0x2a, 0x7b, 0xcd, 0x83, 0xad, 0x92, 0xf2, 0x55, 0x18, 0xdf, 0x4c, 0xd2,
+	0x24, 0xe4, 0xa7, 0x2b, 0x0e, 0x71, 0xd8, 0x01, 0xdd, 0xae, 0xcb, 0x31, 0xa4, 0xdd, 0x24, 0xc1,
+	0xff, 0x4b, 0x82, 0x91, 0x9a, 0xd2, 0x6e, 0xa7, 0x2b, 0x4e, 0x23, 0xca, 0xc6, 0xec, 0x7b, 0xd8,
+	0xf0, 0x92, 0x64, 0x7a, 0xe3, 0x7a, 0xbe, 0xcf, 0x95, 0xb2, 0x37, 0x48, 0xf6, 0xa0, 0x24, 0xeb,
+	0x25, 0xc9, 0xc9, 0x4d, 0x8f, 0x56, 0x4f, 0x57, 0x9c, 0x26, 0xb1, 0xcd, 0x94, 0xf5, 0xa0, 0x6d,
+	0xc4, 0xd7, 0x42, 0xf2, 0x1b, 0x2f, 0x0c, 0xed, 0x16, 0xc9, 0xed, 0x8f, 0xe5, 0xaf, 0xb2, 0xf5,
+	0xd3, 0x15, 0xa7, 0x45, 0x8a, 0x1c, 0xd8, 0xfe, 0x01, 0xda, 0xb7, 0x3f, 0x9e, 0x75, 0xa0, 0xfa,
+	0x8e, 0x2f, 0x28, 0xfb, 0x96, 0x83, 0x43, 0xb6, 0x05, 0x6b, 0x73, 0x2f, 0x4c, 0x79, 0x96, 0x77,
+	0x33, 0x79, 0xb9, 0xfa, 0xa2, 0x72, 0xd4, 0x80, 0x75, 0xc9, 0xfd, 0x58, 0x06, 0xbb, 0xbf, 0xaf,
+	0x81, 0x55, 0xdc, 0x08, 0x7b, 0x04, 0x96, 0x5a, 0x28, 0xcd, 0x67, 0x98, 0x63, 0x13, 0xa9, 0x61,
+	0x80, 0x61, 0xc0, 0x18, 0xd4, 0x64, 0x1a, 0xe6, 0xd1, 0x68, 0x8c, 0x9b, 0xce, 0x3c, 0x3f, 0x2b,
+	0x1b, 0x1c, 0x52, 0x08, 0x93, 0x5a, 0x91, 0x64, 0x65, 0xd2, 0x30, 0xc0, 0x30, 0xc1, 0x02, 0x09,
+	0xb8, 0xd2, 0x22, 0x32, 0x25, 0x27, 0x8a, 0x02, 0x29, 0xa1, 0xc3, 0x84, 0x3d, 0x81, 0x66, 0x16,
+	0x23, 0x89, 0xa5, 0xa6, 0xf2, 0x68, 0x39, 0x60, 0xa0, 0xf3, 0x58, 0x6a, 0xf6, 0x15, 0x74, 0xca,
+	0x71, 0x88, 0x55, 0x27, 0xd6, 0x66, 0x09, 0x27, 0xea, 0x37, 0xd0, 0xa0, 0x67, 0xe3, 0xc7, 0xa1,
+	0xdd, 0xd8, 0xa9, 0xec, 0xb5, 0x6f, 0xe5, 0xf6, 0x3c, 0x5b, 0x72, 0x0a, 0x12, 0x56, 0x43, 0x20,
+	0x24, 0xf7, 0x31, 0x82, 0x6d, 0x91, 0xa2, 0x5c, 0x3e, 0xfd, 0x7c, 0xcd, 0x59, 0xd2, 0x70, 0x93,
+	0x80, 0xfb, 0x82, 0x6a, 0x15, 0xee, 0x6c, 0xd2, 0xcf, 0x96, 0x9c, 0x82, 0xc4, 0x6c, 0xa8, 0xcf,
+	0xb9, 0x24, 0x7e, 0x93, 0xce, 0x9d, 0x4f, 0xd9, 0x33, 0x00, 0x2c, 0x4a, 0x57, 0x69, 0x4f, 0x73,
+	0x7b, 0xe3, 0xce, 0xfe, 0x98, 0xac, 0x0b, 0x5c, 0x73, 0xac, 0xeb, 0x7c, 0xc8, 0x7a, 0xf0, 0x38,
+	0xf1, 0xfc, 0x77, 0x5c, 0x2b, 0x37, 0xbb, 0x38, 0x1d, 0xbb, 0xa5, 0x9b, 0xa0, 0xfa, 0xaa, 0x39,
+	0xdb, 0x19, 0xe9, 0x82, 0x38, 0x93, 0xb8, 0xbf, 0x64, 0xb0, 0x43, 0x78, 0x74, 0xb5, 0xd0, 0xfc,
+	0x53, 0x01, 0xda, 0x14, 0xc0, 0x26, 0xca, 0x7d, 0xf2, 0xd2, 0x09, 0xca, 0x99, 0xd1, 0x71, 0x16,
+	0xcf, 0xde, 0xbc, 0x75, 0x82, 0x92, 0x74, 0x12, 0x9b, 0x78, 0xcb, 0x13, 0xdc, 0x1f, 0xa0, 0x53,
+	0x3a, 0xc1, 0x3d, 0xf2, 0xdd, 0x3f, 0xaa, 0xd0, 0xc8, 0x9f, 0xea, 0xdf, 0x17, 0xf2, 0xd7, 0xc0,
+	0xe6, 0x11, 0xd7, 0xee, 0xd2, 0xab, 0x52, 0x11, 0x64, 0x65, 0xdd, 0xc1, 0x15, 0x27, 0xb7, 0xab,
+	0x54, 0x04, 0xe8, 0x56, 0x2a, 0xbd, 0x42, 0x7e, 0x22, 0xf9, 0xb5, 0xf8, 0x90, 0x15, 0xfb, 0x86,
+	0x01, 0xcf, 0x09, 0xc3, 0x8a, 0x9d, 0x79, 0xbe, 0xeb, 0x05, 0x81, 0x44, 0x37, 0x30, 0x75, 0x0f,
+	0x33, 0xcf, 0xef, 0x19, 0x84, 0x7d, 0x0b, 0x5b, 0x89, 0x14, 0x33, 0x4f, 0x2e, 0x5c, 0x91, 0xcc,
+	0x9f, 0x17, 0x4c, 0x53, 0xff, 0x2c, 0x5b, 0x1b, 0x26, 0xf3, 0xe7, 0xb9, 0xe2, 0x11, 0x58, 0xf8,
+	0xc4, 0xca, 0x0e, 0xd9, 0x40, 0x80, 0xdc, 0xf1, 0x56, 0x91, 0xd6, 0xff, 0x59, 0x91, 0x6e, 0xe3,
+	0x4b, 0x10, 0xb1, 0x14, 0x7a, 0x41, 0x2f, 0xa1, 0xe5, 0x14, 0x73, 0x7c, 0xdb, 0x7a, 0x91, 0x70,
+	0xaa, 0x77, 0xcb, 0xa1, 0x31, 0x3b, 0x06, 0xf0, 0xe3, 0x28, 0x10, 0x28, 0x56, 0x36, 0x90, 0x05,
+	0x3f, 0xbd, 0xc7, 0x17, 0xbb, 0xc7, 0x05, 0xcb, 0xd8, 0x70, 0x49, 0xb6, 0x7d, 0x08, 0x9b, 0x1f,
+	0x2d, 0xff, 0x1b, 0xa3, 0xda, 0xfd, 0xb5, 0x0a, 0xcd, 0x92, 0x91, 0xe2, 0x3d, 0x8b, 0x48, 0x69,
+	0x2f, 0xf2, 0xf9, 0x32, 0xb3, 0x90, 0x43, 0xc3, 0x00, 0x6f, 0xcd, 0x0f, 0x05, 0x8f, 0x34, 0x9a,
+	0x4b, 0xde, 0xef, 0x08, 0x30, 0xbe, 0x92, 0x2d, 0x92, 0x63, 0x54, 0x8d, 0xaf, 0x18, 0x88, 0xcc,
+	0xe2, 0x09, 0x34, 0xdf, 0x6a, 0x9d, 0xb8, 0x33, 0xae, 0xdf, 0xc6, 0x79, 0x97, 0x03, 0x84, 0x5e,
+	0x13, 0x82, 0x04, 0xc9, 0xdf, 0xa7, 0x5c, 0x69, 0x37, 0x95, 0x22, 0xcb, 0x1e, 0x64, 0xd0, 0xa5,
+	0x14, 0xa6, 0xb7, 0x19, 0xc2, 0xfb, 0x94, 0xcb, 0xc5, 0xb2, 0xb7, 0x11, 0xf8, 0x13, 0x62, 0xec,
+	0x31, 0x40, 0xaa, 0xb8, 0x74, 0xbd, 0x29, 0x76, 0x9c, 0x3a, 0x31, 0x2c, 0x44, 0x7a, 0x08, 0x14,
+	0xa7, 0x40, 0x0b, 0x48, 0x55, 0x96, 0x2b, 0x3a, 0xc5, 0x05, 0x21, 0xec, 0x73, 0xd8, 0x20, 0x42,
+	0x6e, 0x21, 0x26, 0x6b, 0x24, 0xfa, 0xd9, 0x40, 0xa6, 0x15, 0xfb, 0x5c, 0xcc, 0x79, 0xe0, 0xd2,
+	0x93, 0x21, 0x5f, 0xaa, 0x39, 0xad, 0x1c, 0x3d, 0x42, 0x10, 0x4f, 0xa2, 0xf0, 0x3e, 0x0c, 0xa5,
+	0x49, 0x14, 0x0b, 0x91, 0x62, 0x99, 0xfe, 0x2a, 0xb4, 0xf7, 0x8e, 0x47, 0x64, 0x46, 0x35, 0xc7,
+	0x42, 0x64, 0x82, 0x00, 0xf9, 0xb4, 0x0a, 0x5d, 0x1e, 0x79, 0x57, 0x21, 0x0f, 0xc8, 0x64, 0x1a,
+	0x0e, 0x28, 0x15, 0x0e, 0x0c, 0xb2, 0xfb, 0x67, 0x15, 0x5a, 0xb7, 0x1a, 0xd9, 0x7f, 0x90, 0xc0,
+	0x72, 0x7e, 0x6a, 0x77, 0xf2, 0xb3, 0x0b, 0x2d, 0x7a, 0x55, 0x8a, 0x6b, 0x97, 0x2a, 0xde, 0xa4,
+	0xb0, 0x89, 0xe0, 0x05, 0xd7, 0x13, 0x2c, 0xfc, 0x3d, 0xe8, 0x14, 0x9c, 0xfc, 0x8a, 0x4d, 0x1a,
+	0xdb, 0x19, 0x2d, 0xbf, 0xe5, 0x87, 0x50, 0x27, 0xa6, 0x08, 0xb2, 0x2c, 0xae, 0xe3, 0x74, 0x18,
+	0xa0, 0xbf, 0xcf, 0xb8, 0x52, 0xde, 0x94, 0x53, 0xfa, 0x2c, 0x27, 0x9f, 0xb2, 0x07, 0xb0, 0xee,
+	0x2d, 0x7b, 0x8b, 0xe5, 0x64, 0x33, 0x7c, 0x81, 0x4a, 0x68, 0x4e, 0x69, 0xb2, 0x1c, 0x1a, 0xb3,
+	0x97, 0x50, 0x0f, 0xb8, 0xf6, 0x44, 0xa8, 0xb2, 0xdf, 0x92, 0x9d, 0x4f, 0xfd, 0x20, 0x74, 0xfb,
+	0x86, 0xe7, 0xe4, 0x82, 0x6d, 0x17, 0xea, 0x19, 0x56, 0x3e, 0x4c, 0xe5, 0xf6, 0x61, 0x18, 0xd4,
+	0x02, 0x4f, 0x7b, 0x79, 0x4b, 0xc7, 0x31, 0x62, 0xd7, 0x22, 0xcc, 0x7f, 0x05, 0x69, 0x8c, 0x58,
+	0x28, 0x22, 0x9e, 0xdd, 0x27, 0x8d, 0xf7, 0x0f, 0xa0, 0x91, 0x77, 0x4f, 0xb6, 0x05, 0x9d, 0x73,
+	0x67, 0x3c, 0x19, 0x1f, 0x8f, 0xcf, 0xdc, 0xcb, 0xd1, 0x8f, 0xa3, 0xf1, 0x9b, 0x51, 0x67, 0x85,
+	0xd5, 0xa1, 0x3a, 0x39, 0x3e, 0xef, 0x54, 0x70, 0x70, 0xd9, 0x3f, 0xef, 0xac, 0xee, 0x1f, 0x82,
+	0x55, 0x58, 0x13, 0xfb, 0x0c, 0xfe, 0xd7, 0x1f, 0x3a, 0x83, 0xe3, 0xc9, 0x70, 0x3c, 0x2a, 0xa9,
+	0x9a, 0x50, 0x1f, 0x8e, 0x8e, 0xc6, 0x97, 0xa3, 0x7e, 0xa7, 0xc2, 0x36, 0xa0, 0x31, 0xbe, 0x9c,
+	0x98, 0xd9, 0xea, 0xfe, 0x77, 0xd0, 0xc8, 0x7b, 0x29, 0x6e, 0xd9, 0x1f, 0x1c, 0x0f, 0x2f, 0xee,
+	0x88, 0x7b, 0x67, 0x67, 0xe3, 0x37, 0x03, 0x14, 0x03, 0xac, 0xf7, 0x07, 0xa3, 0xe1, 0x00, 0xa5,
+	0x27, 0xe6, 0x37, 0xc7, 0xb4, 0xcb, 0x07, 0xc0, 0x5e, 0x9d, 0x8d, 0xdf, 0xb8, 0x17, 0x93, 0xde,
+	0x64, 0x50, 0x52, 0x5b, 0xb0, 0x76, 0x34, 0x38, 0x19, 0x8e, 0x3a, 0x15, 0xd6, 0x06, 0x38, 0x1e,
+	0x8f, 0x26, 0xc3, 0xd1, 0xe5, 0x70, 0x74, 0xd2, 0x59, 0xc5, 0x4f, 0x18, 0x8c, 0xfa, 0x9d, 0xea,
+	0xd1, 0x0b, 0x78, 0xea, 0xc7, 0xb3, 0xee, 0x54, 0xe8, 0xb7, 0xe9, 0x55, 0x37, 0x10, 0x33, 0xa1,
+	0xb9, 0xd4, 0x71, 0x10, 0xcb, 0x78, 0xbe, 0xcc, 0xce, 0x11, 0xb6, 0x22, 0xba, 0x9e, 0xf3, 0xca,
+	0x2f, 0x6b, 0x08, 0x5f, 0x5d, 0xad, 0xd3, 0x2f, 0xc6, 0xb3, 0xbf, 0x06, 0x00, 0x05, 0x4d, 0x9f,
+	0xc1, 0xcb, 0x0b, 0x00, 0x00,
+}
@@ -0,0 +1,97 @@
+package nsgpb
+
+//go:generate protoc --go_out=. nsg.proto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+)
+
+// MaxEventSize bounds the size of a single event read by a Decoder.
+const MaxEventSize = 16 * 1024 * 1024
+
+// AppendDelimited appends the varint size of data followed by data to buf.
+func AppendDelimited(buf, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// Encoder writes length-delimited events to a stream.
+type Encoder struct {
+	writer io.Writer
+	buffer []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w}
+}
+
+// Encode writes event preceded by its size as a varint.
+func (encoder *Encoder) Encode(event *Event) error {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	encoder.buffer = AppendDelimited(encoder.buffer[:0], data)
+	_, err = encoder.writer.Write(encoder.buffer)
+	return err
+}
+
+// Decoder reads length-delimited events from a stream, such as the output of
+// the nsg-parser protobuf format.
+type Decoder struct {
+	reader *bufio.Reader
+	buffer []byte
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{reader: bufio.NewReader(r)}
+}
+
+// Decode reads the next event. It returns io.EOF at the end of the stream and
+// io.ErrUnexpectedEOF if the stream ends inside an event.
+func (decoder *Decoder) Decode() (*Event, error) {
+	size, err := binary.ReadUvarint(decoder.reader)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if size > MaxEventSize {
+		return nil, fmt.Errorf("nsgpb: event of %d bytes exceeds %d", size, MaxEventSize)
+	}
+	if uint64(cap(decoder.buffer)) < size {
+		decoder.buffer = make([]byte, size)
+	}
+	data := decoder.buffer[:size]
+	_, err = io.ReadFull(decoder.reader, data)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	event := &Event{}
+	err = proto.Unmarshal(data, event)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// DecodeAll reads every event of a stream.
+func DecodeAll(r io.Reader) ([]*Event, error) {
+	decoder := NewDecoder(r)
+	events := []*Event{}
+	for {
+		event, err := decoder.Decode()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,80 @@
+package nsgpb
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testEvents() []*Event {
+	return []*Event{
+		{
+			TimeMs:       1497038813000,
+			Category:     "NetworkSecurityGroupFlowEvent",
+			ResourceName: "NSGNAME-NSG",
+			Extension:    map[string]string{"src": "10.193.160.4"},
+			Record: &Event_Flow{Flow: &FlowTuple{
+				SourceIp:                 "10.193.160.4",
+				DestinationPort:          443,
+				Protocol:                 Protocol_TCP,
+				Decision:                 Decision_ALLOWED,
+				Version:                  2,
+				FlowState:                FlowState_END,
+				BytesSourceToDestination: 29952,
+			}},
+		},
+		{},
+		{
+			Category: "ApplicationGatewayFirewallLog",
+			Record: &Event_AppgwFirewall{AppgwFirewall: &AppGwFirewall{
+				RuleId:  "960015",
+				Details: &AppGwFirewall_Details{Message: strings.Repeat("x", 300)},
+			}},
+		},
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := NewEncoder(buffer)
+	for _, event := range testEvents() {
+		require.Nil(t, encoder.Encode(event))
+	}
+
+	events, err := DecodeAll(bytes.NewReader(buffer.Bytes()))
+	require.Nil(t, err)
+	require.Len(t, events, 3)
+	for i, expected := range testEvents() {
+		assert.True(t, proto.Equal(expected, events[i]), "event %d: %s", i, events[i])
+	}
+	assert.Equal(t, uint64(29952), events[0].GetFlow().BytesSourceToDestination)
+	assert.Nil(t, events[0].GetAppgwAccess())
+	assert.Equal(t, "960015", events[2].GetAppgwFirewall().RuleId)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	require.Nil(t, NewEncoder(buffer).Encode(testEvents()[0]))
+	data := buffer.Bytes()
+
+	decoder := NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	_, err := decoder.Decode()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	decoder = NewDecoder(bytes.NewReader([]byte{0x80}))
+	_, err = decoder.Decode()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	decoder = NewDecoder(bytes.NewReader(AppendDelimited(nil, make([]byte, MaxEventSize+1))))
+	_, err = decoder.Decode()
+	assert.NotNil(t, err)
+
+	decoder = NewDecoder(bytes.NewReader(AppendDelimited(nil, []byte{0xff, 0xff})))
+	_, err = decoder.Decode()
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
@@ -0,0 +1,406 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlowAggregator summarizes flow tuples before they are sent. Tuples of the
+// same NIC, 5-tuple, rule, action and direction within a window become one
+// event, which starts at the first tuple and carries the last tuple time in
+// end, the tuple count in cnt and, for version 2 flows, the summed counters.
+// Other log families are sent unchanged.
+//
+// Clients usually connect from a new ephemeral port each time. With
+// IgnoreSourcePort set, tuples differing only in their source port are
+// summarized together and the summary has no source port.
+//
+// Windows are aligned to the epoch. A window is sent once a tuple of the same
+// NSG and NIC at or after its end is seen, so windows span blob reads, or
+// FlushDelay after its end by the clock, for NICs that stop logging. Tuples
+// arriving after their window was sent start another summary of it.
+//
+// Open windows are saved to a state file before SendEvents returns, so the
+// tuples they hold survive restarts once their blob range is checkpointed.
+// Close saves them for the next run rather than sending them.
+type FlowAggregator struct {
+	Window           time.Duration
+	FlushDelay       time.Duration
+	IgnoreSourcePort bool
+	Sender           EventSender
+
+	path  string
+	mutex sync.Mutex
+	state aggregatorState
+	done  chan struct{}
+}
+
+type aggregatorState struct {
+	// Summaries are the open windows and Watermarks the latest tuple time
+	// seen of each NSG and NIC.
+	Summaries  map[string]*flowSummary `json:"summaries"`
+	Watermarks map[string]time.Time    `json:"watermarks"`
+}
+
+type flowSummary struct {
+	Event    CEFEvent  `json:"event"`
+	NIC      string    `json:"nic"`
+	End      time.Time `json:"end"`
+	LastSeen time.Time `json:"lastSeen"`
+	Count    int64     `json:"count"`
+	Counters []int64   `json:"counters,omitempty"`
+}
+
+// aggregatorFlushCheck is how often windows past FlushDelay are looked for.
+const aggregatorFlushCheck = 10 * time.Second
+
+// flowCounterKeys are the version 2 counter extensions summed by summaries.
+var flowCounterKeys = []string{"cn1", "out", "cn2", "in"}
+
+// NewFlowAggregator returns an aggregator sending to sender, with the open
+// windows saved in stateFile. Windows are sent flushDelay after their end
+// without a later tuple, or only on later tuples with a flushDelay of 0.
+func NewFlowAggregator(window, flushDelay time.Duration, stateFile string, sender EventSender) (*FlowAggregator, error) {
+	if window < time.Second {
+		return nil, fmt.Errorf("aggregation window must be at least a second")
+	}
+	if flushDelay < 0 {
+		return nil, fmt.Errorf("aggregation flush delay must not be negative")
+	}
+	aggregator := &FlowAggregator{
+		Window:     window,
+		FlushDelay: flushDelay,
+		Sender:     sender,
+		path:       stateFile,
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading aggregation state: %s", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &aggregator.state)
+		if err != nil {
+			return nil, fmt.Errorf("error reading aggregation state %s: %s", stateFile, err)
+		}
+	}
+	if aggregator.state.Summaries == nil {
+		aggregator.state.Summaries = map[string]*flowSummary{}
+	}
+	if aggregator.state.Watermarks == nil {
+		aggregator.state.Watermarks = map[string]time.Time{}
+	}
+	if flushDelay > 0 {
+		aggregator.done = make(chan struct{})
+		go aggregator.flushLoop(aggregator.done)
+	}
+	return aggregator, nil
+}
+
+// SendEvents adds the flow tuples of events to their windows and sends the
+// other events with the windows closed by them. The windows are saved after
+// sending. If sending or saving fails they are left as they were, so the
+// retried events are not counted twice.
+func (aggregator *FlowAggregator) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+
+	changed := map[string]*flowSummary{}
+	watermarks := map[string]time.Time{}
+	send := []*CEFEvent{}
+	for _, event := range events {
+		if event.LogFamily() != LogFamilyNsgFlow {
+			send = append(send, event)
+			continue
+		}
+		nic := flowNIC(event)
+		key := aggregator.flowKey(event, nic)
+		summary, ok := changed[key]
+		if !ok {
+			summary = aggregator.state.Summaries[key].copy()
+			if summary == nil {
+				summary = aggregator.newSummary(event, nic)
+			}
+			changed[key] = summary
+		}
+		summary.add(event)
+		watermark, ok := watermarks[nic]
+		if !ok {
+			watermark = aggregator.state.Watermarks[nic]
+		}
+		if event.Time.After(watermark) {
+			watermarks[nic] = event.Time
+		}
+	}
+
+	closed := []string{}
+	summaries := []*flowSummary{}
+	for key, summary := range aggregator.state.Summaries {
+		if _, ok := changed[key]; !ok && aggregator.closes(summary, watermarks) {
+			closed = append(closed, key)
+			summaries = append(summaries, summary)
+		}
+	}
+	for key, summary := range changed {
+		if aggregator.closes(summary, watermarks) {
+			closed = append(closed, key)
+			summaries = append(summaries, summary)
+		}
+	}
+	send = append(send, summaryEvents(summaries)...)
+
+	if len(send) > 0 {
+		err := aggregator.Sender.SendEvents(logFile, send)
+		if err != nil {
+			return err
+		}
+	}
+	state := aggregator.state.copy()
+	for key, summary := range changed {
+		state.Summaries[key] = summary
+	}
+	for _, key := range closed {
+		delete(state.Summaries, key)
+	}
+	for nic, watermark := range watermarks {
+		state.Watermarks[nic] = watermark
+	}
+	return aggregator.save(state)
+}
+
+// OpenWindows returns the number of open windows.
+func (aggregator *FlowAggregator) OpenWindows() int {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+	return len(aggregator.state.Summaries)
+}
+
+// flushLoop sends the windows past FlushDelay until Close.
+func (aggregator *FlowAggregator) flushLoop(done chan struct{}) {
+	ticker := time.NewTicker(aggregatorFlushCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			err := aggregator.flushExpired(now)
+			if err != nil {
+				log.Errorf("error sending expired aggregation windows: %s", err)
+			}
+		}
+	}
+}
+
+// flushExpired sends the windows that ended FlushDelay before now.
+func (aggregator *FlowAggregator) flushExpired(now time.Time) error {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+
+	expiry := now.Add(-aggregator.FlushDelay)
+	closed := []string{}
+	summaries := []*flowSummary{}
+	for key, summary := range aggregator.state.Summaries {
+		if summary.End.Before(expiry) {
+			closed = append(closed, key)
+			summaries = append(summaries, summary)
+		}
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+	err := aggregator.Sender.SendEvents(nil, summaryEvents(summaries))
+	if err != nil {
+		return err
+	}
+	state := aggregator.state.copy()
+	for _, key := range closed {
+		delete(state.Summaries, key)
+	}
+	log.WithField("windows", len(closed)).Debug("sent expired aggregation windows")
+	return aggregator.save(state)
+}
+
+// Close saves the open windows and closes the sender. Open windows are not
+// sent, they are continued by the next run.
+func (aggregator *FlowAggregator) Close() error {
+	if aggregator.done != nil {
+		close(aggregator.done)
+		aggregator.done = nil
+	}
+	aggregator.mutex.Lock()
+	err := aggregator.save(aggregator.state)
+	aggregator.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if closer, ok := aggregator.Sender.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// save replaces the state file with state, so that a crash leaves the
+// previous state, and makes it the current state.
+func (aggregator *FlowAggregator) save(state aggregatorState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(filepath.Dir(aggregator.path), "."+filepath.Base(aggregator.path))
+	err = ioutil.WriteFile(temp, data, 0666)
+	if err != nil {
+		return fmt.Errorf("error saving aggregation state: %s", err)
+	}
+	err = os.Rename(temp, aggregator.path)
+	if err != nil {
+		return fmt.Errorf("error saving aggregation state: %s", err)
+	}
+	aggregator.state = state
+	return nil
+}
+
+func (state aggregatorState) copy() aggregatorState {
+	copied := aggregatorState{
+		Summaries:  make(map[string]*flowSummary, len(state.Summaries)),
+		Watermarks: make(map[string]time.Time, len(state.Watermarks)),
+	}
+	for key, summary := range state.Summaries {
+		copied.Summaries[key] = summary
+	}
+	for nic, watermark := range state.Watermarks {
+		copied.Watermarks[nic] = watermark
+	}
+	return copied
+}
+
+// closes reports whether a tuple at or after the end of the window of
+// summary was seen, in this batch or before.
+func (aggregator *FlowAggregator) closes(summary *flowSummary, watermarks map[string]time.Time) bool {
+	watermark, ok := watermarks[summary.NIC]
+	if !ok {
+		watermark = aggregator.state.Watermarks[summary.NIC]
+	}
+	return !watermark.Before(summary.End)
+}
+
+func (aggregator *FlowAggregator) newSummary(event *CEFEvent, nic string) *flowSummary {
+	summary := &flowSummary{
+		Event: *event,
+		NIC:   nic,
+		End:   aggregator.windowStart(event.Time).Add(aggregator.Window),
+	}
+	summary.Event.Extension = copyExtension(event.Extension)
+	if aggregator.IgnoreSourcePort {
+		delete(summary.Event.Extension, "spt")
+	}
+	return summary
+}
+
+// windowStart returns the start of the window of t.
+func (aggregator *FlowAggregator) windowStart(t time.Time) time.Time {
+	window := int64(aggregator.Window / time.Second)
+	return time.Unix(t.Unix()-t.Unix()%window, 0)
+}
+
+// flowKey identifies the window of a tuple.
+func (aggregator *FlowAggregator) flowKey(event *CEFEvent, nic string) string {
+	extension := event.Extension
+	sourcePort := extension["spt"]
+	if aggregator.IgnoreSourcePort {
+		sourcePort = ""
+	}
+	return strings.Join([]string{
+		strconv.FormatInt(aggregator.windowStart(event.Time).Unix(), 10), nic,
+		extension["src"], sourcePort, extension["dst"], extension["dpt"], extension["proto"],
+		extension["cs1"], extension["categoryOutcome"], extension["deviceDirection"],
+	}, "|")
+}
+
+// flowNIC identifies the NSG and NIC of a tuple, which are logged to the same
+// blob in time order.
+func flowNIC(event *CEFEvent) string {
+	extension := event.Extension
+	return strings.Join([]string{extension["cs3"], extension["cs4"], extension["cs2"], extension["smac"] + extension["dmac"]}, "|")
+}
+
+func (summary *flowSummary) copy() *flowSummary {
+	if summary == nil {
+		return nil
+	}
+	copied := *summary
+	if summary.Counters != nil {
+		copied.Counters = append([]int64{}, summary.Counters...)
+	}
+	return &copied
+}
+
+func (summary *flowSummary) add(event *CEFEvent) {
+	summary.Count++
+	if event.Time.Before(summary.Event.Time) {
+		summary.Event.Time = event.Time
+		summary.Event.Extension = copyExtension(summary.Event.Extension)
+		summary.Event.Extension["start"] = event.Extension["start"]
+	}
+	if !event.Time.Before(summary.LastSeen) {
+		summary.LastSeen = event.Time
+		if state, ok := event.Extension["cs5"]; ok && state != summary.Event.Extension["cs5"] {
+			summary.Event.Extension = copyExtension(summary.Event.Extension)
+			summary.Event.Extension["cs5"] = state
+		}
+	}
+	// Begin tuples carry no counters.
+	if event.Extension["cn1"] == "" {
+		return
+	}
+	if summary.Counters == nil {
+		summary.Counters = make([]int64, len(flowCounterKeys))
+	}
+	for i, key := range flowCounterKeys {
+		value, _ := strconv.ParseInt(event.Extension[key], 10, 64)
+		summary.Counters[i] += value
+	}
+}
+
+// summaryEvents returns the events of summaries in time order.
+func summaryEvents(summaries []*flowSummary) []*CEFEvent {
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Event.Time.Before(summaries[j].Event.Time) })
+	events := make([]*CEFEvent, len(summaries))
+	for i, summary := range summaries {
+		events[i] = summary.summaryEvent()
+	}
+	return events
+}
+
+// summaryEvent returns the event of a summary.
+func (summary *flowSummary) summaryEvent() *CEFEvent {
+	event := summary.Event
+	event.Extension = copyExtension(summary.Event.Extension)
+	event.Extension["end"] = strconv.FormatInt(summary.LastSeen.UnixNano()/int64(time.Millisecond), 10)
+	event.Extension["cnt"] = strconv.FormatInt(summary.Count, 10)
+	if summary.Counters != nil {
+		for i, key := range flowCounterKeys {
+			event.Extension[key] = strconv.FormatInt(summary.Counters[i], 10)
+		}
+		event.Extension["cn1label"] = "Packets Sent"
+		event.Extension["cn2label"] = "Packets Received"
+	}
+	return &event
+}
+
+func copyExtension(extension map[string]string) map[string]string {
+	copied := make(map[string]string, len(extension)+4)
+	for key, value := range extension {
+		copied[key] = value
+	}
+	return copied
+}
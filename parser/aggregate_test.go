@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestFlowAggregator(t *testing.T, window time.Duration, sender EventSender) (*FlowAggregator, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-aggregate")
+	require.Nil(t, err)
+	aggregator, err := NewFlowAggregator(window, 0, filepath.Join(dir, "aggregation.json"), sender)
+	require.Nil(t, err)
+	return aggregator, dir
+}
+
+func sumExtension(events []*CEFEvent, key string) int64 {
+	var sum int64
+	for _, event := range events {
+		value, _ := strconv.ParseInt(event.Extension[key], 10, 64)
+		sum += value
+	}
+	return sum
+}
+
+func TestFlowAggregator(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events.json", t)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	sender := &recordingSender{}
+	aggregator, dir := newTestFlowAggregator(t, time.Minute, sender)
+	defer os.RemoveAll(dir)
+	aggregator.IgnoreSourcePort = true
+
+	// Send in two reads, so that windows span them.
+	half := len(events) / 2
+	require.Nil(t, aggregator.SendEvents(nil, events[:half]))
+	sent := len(sender.events)
+	require.Nil(t, aggregator.SendEvents(nil, events[half:]))
+	assert.True(t, len(sender.events) > sent)
+	require.Nil(t, aggregator.flushExpired(time.Now()))
+	assert.Equal(t, 0, aggregator.OpenWindows())
+	require.Nil(t, aggregator.Close())
+
+	assert.Equal(t, int64(len(events)), sumExtension(sender.events, "cnt"))
+	assert.True(t, len(sender.events)*10 < len(events), "%d summaries of %d tuples", len(sender.events), len(events))
+	windows := map[string]bool{}
+	for _, event := range sender.events {
+		key := aggregator.flowKey(event, flowNIC(event))
+		assert.False(t, windows[key], "window %s sent twice", key)
+		windows[key] = true
+
+		flowLog, err := NewNsgFlowLog(event)
+		require.Nil(t, err)
+		assert.True(t, flowLog.TupleCount > 0)
+		assert.True(t, flowLog.LastSeen >= flowLog.Time)
+		assert.True(t, flowLog.LastSeen-flowLog.Time < 60)
+		assert.Equal(t, flowLog.Time/60, flowLog.LastSeen/60)
+		assert.Equal(t, 0, flowLog.SourcePort)
+	}
+}
+
+func TestFlowAggregatorCounters(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	sender := &recordingSender{}
+	aggregator, dir := newTestFlowAggregator(t, time.Hour, sender)
+	defer os.RemoveAll(dir)
+	appGwEvents := loadTestAppGwEvents(t)
+	require.Nil(t, aggregator.SendEvents(nil, append(append([]*CEFEvent{}, events...), appGwEvents...)))
+	require.Nil(t, aggregator.flushExpired(time.Now()))
+	require.Nil(t, aggregator.Close())
+
+	flows := []*CEFEvent{}
+	for _, event := range sender.events {
+		if event.LogFamily() == LogFamilyNsgFlow {
+			flows = append(flows, event)
+		}
+	}
+	assert.Len(t, sender.events, len(flows)+len(appGwEvents))
+	for _, key := range flowCounterKeys {
+		assert.Equal(t, sumExtension(events, key), sumExtension(flows, key), key)
+	}
+	assert.Equal(t, int64(len(events)), sumExtension(flows, "cnt"))
+}
+
+func TestFlowAggregatorRetry(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events.json", t)
+	sender := &recordingSender{err: fmt.Errorf("connection refused")}
+	aggregator, dir := newTestFlowAggregator(t, time.Minute, sender)
+	defer os.RemoveAll(dir)
+
+	// A failed read is retried without counting its tuples twice.
+	assert.Error(t, aggregator.SendEvents(nil, events))
+	sender.err = nil
+	require.Nil(t, aggregator.SendEvents(nil, events))
+	require.Nil(t, aggregator.flushExpired(time.Now()))
+	require.Nil(t, aggregator.Close())
+	assert.Equal(t, int64(len(events)), sumExtension(sender.events, "cnt"))
+
+	stateFile := filepath.Join(dir, "aggregation.json")
+	_, err := NewFlowAggregator(time.Millisecond, 0, stateFile, sender)
+	assert.Error(t, err)
+	_, err = NewFlowAggregator(time.Minute, -time.Second, stateFile, sender)
+	assert.Error(t, err)
+	require.Nil(t, ioutil.WriteFile(stateFile, []byte("{"), 0644))
+	_, err = NewFlowAggregator(time.Minute, 0, stateFile, sender)
+	assert.Error(t, err)
+}
+
+func TestFlowAggregatorRestart(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events.json", t)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	sender := &recordingSender{}
+	aggregator, dir := newTestFlowAggregator(t, time.Minute, sender)
+	defer os.RemoveAll(dir)
+
+	// The open windows of a checkpointed read survive a restart.
+	half := len(events) / 2
+	require.Nil(t, aggregator.SendEvents(nil, events[:half]))
+	open := aggregator.OpenWindows()
+	require.True(t, open > 0)
+	require.Nil(t, aggregator.Close())
+
+	aggregator, err := NewFlowAggregator(time.Minute, 0, filepath.Join(dir, "aggregation.json"), sender)
+	require.Nil(t, err)
+	assert.Equal(t, open, aggregator.OpenWindows())
+	require.Nil(t, aggregator.SendEvents(nil, events[half:]))
+	require.Nil(t, aggregator.flushExpired(time.Now()))
+	require.Nil(t, aggregator.Close())
+
+	assert.Equal(t, int64(len(events)), sumExtension(sender.events, "cnt"))
+	windows := map[string]bool{}
+	for _, event := range sender.events {
+		key := aggregator.flowKey(event, flowNIC(event))
+		assert.False(t, windows[key], "window %s sent twice", key)
+		windows[key] = true
+	}
+}
+
+func TestFlowAggregatorFlushDelay(t *testing.T) {
+	now := time.Now()
+	tuple := func(at time.Time, state string) string {
+		return fmt.Sprintf("%d,10.5.16.4,94.102.49.190,44931,443,T,O,A,%s,1,100,1,100", at.Unix(), state)
+	}
+	dir, err := ioutil.TempDir("", "nsg-parser-aggregate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sender := &recordingSender{}
+	aggregator, err := NewFlowAggregator(time.Minute, time.Hour, filepath.Join(dir, "aggregation.json"), sender)
+	require.Nil(t, err)
+	defer aggregator.Close()
+
+	// The NIC logs no later tuple, so only the clock closes the window.
+	require.Nil(t, aggregator.SendEvents(nil, sessionTestEvents(t,
+		tuple(now.Add(-10*time.Minute), "C"), tuple(now.Add(-10*time.Minute+time.Second), "C"))))
+	assert.Equal(t, 1, aggregator.OpenWindows())
+	require.Nil(t, aggregator.flushExpired(now))
+	assert.Len(t, sender.events, 0)
+
+	require.Nil(t, aggregator.flushExpired(now.Add(2*time.Hour)))
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, "2", sender.events[0].Extension["cnt"])
+	assert.Equal(t, 0, aggregator.OpenWindows())
+}
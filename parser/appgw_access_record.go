@@ -84,7 +84,7 @@ func (record *AzureAppGwEventRecord) convertApplicationGatewayEventsToCEF(option
 	var errors []error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Errorf("Recovered in convertApplicationGatewayEventsToCEF", r)
+			log.Errorf("Recovered in convertApplicationGatewayEventsToCEF: %v", r)
 		}
 	}()
 	if record.Time.After(options.StartTime) {
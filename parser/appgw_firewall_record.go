@@ -83,7 +83,7 @@ func (record *AzureAppGwFirewallEventRecord) convertAppGatewayFirewallEventsToCE
 	var errors []error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Errorf("Recovered in convertAppGatewayFirewallEventsToCEF", r)
+			log.Errorf("Recovered in convertAppGatewayFirewallEventsToCEF: %v", r)
 		}
 	}()
 	if record.Time.After(options.StartTime) {
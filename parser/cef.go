@@ -29,7 +29,7 @@ type CEFEvent struct {
 	CEFVersion         *int              `json:"cef_version"`
 	DeviceVendor       *string           `json:"device_vendor"`
 	DeviceProduct      *string           `json:"device_product"`
-	DeviceVersion      *string           `json:"device_product"`
+	DeviceVersion      *string           `json:"device_version"`
 	DeviceEventClassId string            `json:"device_event_class_id"`
 	Time               time.Time         `json:"time"`
 	Name               string            `json:"name"`
@@ -72,6 +72,12 @@ var cefOutcomeMap = map[string]string{
 	"D": "Deny",
 }
 
+var flowStateMap = map[string]string{
+	"B": "Begin",
+	"C": "Continuing",
+	"E": "End",
+}
+
 func init() {
 	tpl, err := template.New("cefEventTemplate").Parse(cefTemplateText)
 	if err != nil {
@@ -100,18 +106,43 @@ func NewAzureCEFEventForProduct(deviceProduct string) CEFEvent {
 	}
 }
 
+// LogFamily returns the log family the event was extracted from.
+func (event *CEFEvent) LogFamily() string {
+	switch event.DeviceEventClassId {
+	case "NetworkSecurityGroupFlowEvents", EventClassIdFlow:
+		return LogFamilyNsgFlow
+	case "NetworkSecurityGroupEvents":
+		return LogFamilyNsgEvent
+	case "ApplicationGatewayAccessLog":
+		return LogFamilyAppGwAccess
+	case "ApplicationGatewayFirewallLog":
+		return LogFamilyAppGwFirewall
+	default:
+		return ""
+	}
+}
+
 func (event *CEFEvent) SyslogText() (string, error) {
-	var templateText bytes.Buffer
-	err := cefTemplate.Execute(&templateText, event)
+	cefText, err := event.CEFText()
 	if err != nil {
 		return "", err
 	}
 
 	if event.Time != (time.Time{}) {
-		return fmt.Sprintf("%s|%s", event.Time.Format(CEFTimeFormat), templateText.String()), nil
+		return fmt.Sprintf("%s|%s", event.Time.Format(CEFTimeFormat), cefText), nil
 	} else {
-		return templateText.String(), nil
+		return cefText, nil
+	}
+}
+
+// CEFText renders the event as a CEF line without the syslog timestamp prefix.
+func (event *CEFEvent) CEFText() (string, error) {
+	var templateText bytes.Buffer
+	err := cefTemplate.Execute(&templateText, event)
+	if err != nil {
+		return "", err
 	}
+	return templateText.String(), nil
 }
 
 func (event *CEFEvent) ExtensionText() (string, error) {
@@ -189,7 +220,18 @@ func (client *CEFSyslogClient) SendEvent(event CEFEvent) error {
 	if err != nil {
 		return fmt.Errorf("event_format_error %s", err)
 	}
-	fmt.Fprintf(client.writer, "%s", logText)
+	_, err = fmt.Fprintf(client.writer, "%s", logText)
+	return err
+}
+
+// SendEvents writes each event as a syslog message.
+func (client *CEFSyslogClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	for _, event := range events {
+		err := client.SendEvent(*event)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
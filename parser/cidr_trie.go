@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"net"
+)
+
+// cidrTrie is a path compressed binary trie of networks, for longest prefix
+// matches against large lists. IPv4 networks are stored as IPv4-mapped IPv6
+// networks, so both families share one trie.
+type cidrTrie struct {
+	root *cidrNode
+	size int
+}
+
+type cidrNode struct {
+	key      [16]byte
+	bits     int
+	values   []interface{}
+	children [2]*cidrNode
+}
+
+// cidrMatch is a network of the trie containing an address.
+type cidrMatch struct {
+	// Bits is the prefix length, counted from the start of IPv4 addresses
+	// for IPv4 networks.
+	Bits   int
+	Values []interface{}
+}
+
+func newCIDRTrie() *cidrTrie {
+	return &cidrTrie{}
+}
+
+// Insert adds value to network. A network may hold several values.
+func (trie *cidrTrie) Insert(network *net.IPNet, value interface{}) {
+	key, bits := networkKey(network)
+	trie.size++
+	next := &trie.root
+	for {
+		node := *next
+		if node == nil {
+			*next = &cidrNode{key: maskKey(key, bits), bits: bits, values: []interface{}{value}}
+			return
+		}
+		common := commonBits(node.key, key, minInt(node.bits, bits))
+		if common < node.bits {
+			split := &cidrNode{key: maskKey(key, common), bits: common}
+			split.children[keyBit(node.key, common)] = node
+			*next = split
+			node = split
+		}
+		if node.bits == bits {
+			node.values = append(node.values, value)
+			return
+		}
+		next = &node.children[keyBit(key, node.bits)]
+	}
+}
+
+// Len returns the number of values inserted.
+func (trie *cidrTrie) Len() int {
+	return trie.size
+}
+
+// Matches returns the networks containing ip, from the widest to the
+// narrowest.
+func (trie *cidrTrie) Matches(ip net.IP) []cidrMatch {
+	address := ip.To16()
+	if address == nil {
+		return nil
+	}
+	var key [16]byte
+	copy(key[:], address)
+	offset := 0
+	if ip.To4() != nil {
+		offset = 96
+	}
+	matches := []cidrMatch{}
+	node := trie.root
+	for node != nil && commonBits(node.key, key, node.bits) == node.bits {
+		if len(node.values) > 0 {
+			matches = append(matches, cidrMatch{Bits: node.bits - offset, Values: node.values})
+		}
+		if node.bits == 128 {
+			break
+		}
+		node = node.children[keyBit(key, node.bits)]
+	}
+	return matches
+}
+
+// Longest returns the narrowest network containing ip.
+func (trie *cidrTrie) Longest(ip net.IP) (cidrMatch, bool) {
+	matches := trie.Matches(ip)
+	if len(matches) == 0 {
+		return cidrMatch{}, false
+	}
+	return matches[len(matches)-1], true
+}
+
+func networkKey(network *net.IPNet) ([16]byte, int) {
+	var key [16]byte
+	copy(key[:], network.IP.To16())
+	bits, _ := network.Mask.Size()
+	if len(network.Mask) == net.IPv4len {
+		bits += 96
+	}
+	return key, bits
+}
+
+func keyBit(key [16]byte, i int) int {
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// commonBits returns the number of leading bits a and b share, up to max.
+func commonBits(a, b [16]byte, max int) int {
+	i := 0
+	for i < max && a[i/8] == b[i/8] && i%8 == 0 && i+8 <= max {
+		i += 8
+	}
+	for i < max && keyBit(a, i) == keyBit(b, i) {
+		i++
+	}
+	return i
+}
+
+func maskKey(key [16]byte, bits int) [16]byte {
+	var masked [16]byte
+	for i := 0; i < bits/8; i++ {
+		masked[i] = key[i]
+	}
+	if bits%8 != 0 {
+		masked[bits/8] = key[bits/8] & byte(0xff<<(8-uint(bits%8)))
+	}
+	return masked
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func insertCIDR(t *testing.T, trie *cidrTrie, cidr string) {
+	_, network, err := net.ParseCIDR(cidr)
+	require.Nil(t, err)
+	trie.Insert(network, cidr)
+}
+
+func TestCIDRTrie(t *testing.T) {
+	trie := newCIDRTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.5.16.0/24", "10.5.0.0/16", "0.0.0.0/0", "40.85.232.72/32", "2603:1030::/32", "2603:1030:1::/48"} {
+		insertCIDR(t, trie, cidr)
+	}
+	insertCIDR(t, trie, "10.5.16.0/24")
+	assert.Equal(t, 8, trie.Len())
+
+	matches := trie.Matches(net.ParseIP("10.5.16.4"))
+	require.Equal(t, 4, len(matches))
+	assert.Equal(t, []int{0, 8, 16, 24}, []int{matches[0].Bits, matches[1].Bits, matches[2].Bits, matches[3].Bits})
+	assert.Equal(t, []interface{}{"10.5.16.0/24", "10.5.16.0/24"}, matches[3].Values)
+
+	match, ok := trie.Longest(net.ParseIP("10.5.17.1"))
+	require.True(t, ok)
+	assert.Equal(t, "10.5.0.0/16", match.Values[0])
+	match, ok = trie.Longest(net.ParseIP("40.85.232.72"))
+	require.True(t, ok)
+	assert.Equal(t, 32, match.Bits)
+	match, ok = trie.Longest(net.ParseIP("40.85.232.73"))
+	require.True(t, ok)
+	assert.Equal(t, "0.0.0.0/0", match.Values[0])
+
+	match, ok = trie.Longest(net.ParseIP("2603:1030:1::1"))
+	require.True(t, ok)
+	assert.Equal(t, 48, match.Bits)
+	match, ok = trie.Longest(net.ParseIP("2603:1030:2::1"))
+	require.True(t, ok)
+	assert.Equal(t, "2603:1030::/32", match.Values[0])
+	_, ok = trie.Longest(net.ParseIP("2001:db8::1"))
+	assert.False(t, ok, "IPv4 networks do not match IPv6 addresses")
+	assert.Nil(t, trie.Matches(nil))
+}
+
+func TestCIDRTrieMatchesLinearScan(t *testing.T) {
+	trie := newCIDRTrie()
+	networks := []*net.IPNet{}
+	for i := 0; i < 200; i++ {
+		cidr := fmt.Sprintf("%d.%d.%d.0/%d", 10+i%7, i%13, i%29, 8+i%17)
+		_, network, err := net.ParseCIDR(cidr)
+		require.Nil(t, err)
+		trie.Insert(network, network.String())
+		networks = append(networks, network)
+	}
+	for i := 0; i < 500; i++ {
+		ip := net.IPv4(byte(10+i%7), byte(i%13), byte(i%31), byte(i))
+		longest, expected := -1, ""
+		for _, network := range networks {
+			bits, _ := network.Mask.Size()
+			if network.Contains(ip) && bits > longest {
+				longest, expected = bits, network.String()
+			}
+		}
+		match, ok := trie.Longest(ip)
+		if longest == -1 {
+			assert.False(t, ok, ip.String())
+			continue
+		}
+		require.True(t, ok, ip.String())
+		assert.Equal(t, longest, match.Bits, ip.String())
+		assert.Equal(t, expected, match.Values[0], ip.String())
+	}
+}
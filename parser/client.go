@@ -10,9 +10,18 @@ import (
 )
 
 const (
-	MAX_CONCURRENCY   = 1
-	DestinationFile   = "file"
-	DestinationSyslog = "syslog"
+	MAX_CONCURRENCY         = 1
+	DestinationFile         = "file"
+	DestinationSyslog       = "syslog"
+	DestinationSplunk       = "splunk"
+	DestinationLogAnalytics = "loganalytics"
+	DestinationKafka        = "kafka"
+	DestinationLumberjack   = "lumberjack"
+	DestinationGelf         = "gelf"
+	DestinationLoki         = "loki"
+	DestinationIpfix        = "ipfix"
+	DestinationParquet      = "parquet"
+	DestinationStream       = "stream"
 )
 
 var (
@@ -51,11 +60,6 @@ func NewAzureClient(accountName, accountKey, containerName, dataPath string) (Az
 	return azureClient, nil
 }
 
-func (client *FileClient) Initialize(dataPath string) error {
-	client.DataPath = dataPath
-	return nil
-}
-
 func (client *AzureClient) GetBlobsByPrefix(prefix string) ([]storage.Blob, error) {
 	params := storage.ListBlobsParameters{
 		Prefix: prefix,
@@ -84,13 +88,31 @@ func (client *AzureClient) ProcessBlobsAfter(afterTime time.Time, parserClient N
 	return client.RunJob(jobName)
 }
 
+// RegisterJob registers job under its name. Destinations register their jobs
+// concurrently.
 func (client *AzureClient) RegisterJob(job *Job) error {
+	client.processMutex.Lock()
+	defer client.processMutex.Unlock()
 	client.RegisteredJobs[job.Name] = job
 	return nil
 }
 
+// registeredJobs returns a copy of RegisteredJobs, which destinations register
+// into while it is read.
+func (client *AzureClient) registeredJobs() map[string]*Job {
+	client.processMutex.Lock()
+	defer client.processMutex.Unlock()
+	jobs := make(map[string]*Job, len(client.RegisteredJobs))
+	for name, job := range client.RegisteredJobs {
+		jobs[name] = job
+	}
+	return jobs
+}
+
 func (client *AzureClient) RunJob(jobName string) error {
+	client.processMutex.Lock()
 	job, ok := client.RegisteredJobs[jobName]
+	client.processMutex.Unlock()
 	if !ok {
 		return fmt.Errorf("no existing job with %s", jobName)
 	}else{
@@ -2,11 +2,24 @@ package parser
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"time"
 	"github.com/Azure/azure-sdk-for-go/storage"
 )
 
+// Log families group events by the Azure log they were extracted from.
+// Destinations use them to pick per-family settings such as Splunk sourcetypes.
+const (
+	LogFamilyNsgFlow       = "nsg_flow"
+	LogFamilyNsgEvent      = "nsg_event"
+	LogFamilyAppGwAccess   = "appgw_access"
+	LogFamilyAppGwFirewall = "appgw_firewall"
+)
+
 type AzureEventRecord interface {
 	IsInitialized() bool
 	InitRecord()
@@ -82,6 +95,87 @@ type NsgParserClient interface {
 	ProcessAzureLogFile(AzureLogFile, chan AzureLogFile) error
 }
 
+// EventSender delivers the events extracted from a single AzureLogFile.
+// SendEvents must only return nil once the destination has accepted every
+// event, or the events held back have been saved to disk, as the blob range
+// is checkpointed straight after.
+type EventSender interface {
+	SendEvents(logFile AzureLogFile, events []*CEFEvent) error
+}
+
+// processLogFile loads the unprocessed range of logFile, hands the resulting
+// events to sender and, if delivery succeeded, checkpoints the range and
+// reports logFile on resultsChan.
+func processLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile, sender EventSender) error {
+	blobRange := logFile.getUnprocessedBlobRange()
+	err := logFile.LoadBlobRange(blobRange)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	records := logFile.GetAzureEventLog().GetRecords()
+	events := []*CEFEvent{}
+	for _, record := range records {
+		cefEvents, _ := record.GetCEFList(GetCEFEventListOptions{StartTime: logFile.GetLastProcessedRecord()})
+		events = append(events, cefEvents...)
+	}
+
+	logCount := len(events)
+	if logCount > 0 {
+		err = sender.SendEvents(logFile, events)
+		if err != nil {
+			logFile.Logger().WithField("type", fmt.Sprintf("%T", sender)).Errorf("delivery failed: %s", err)
+			return err
+		}
+		logFile.SetLastProcessedTimeStamp(events[logCount-1].Time.Unix())
+	}
+
+	logFile.SetLastProcessed(time.Now())
+	logFile.SetLastRecordCount(len(records))
+	if len(records) > 0 {
+		logFile.SetLastProcessedRecord(records[len(records)-1].GetTime())
+	}
+	logFile.SetLastProcessedRange(blobRange)
+
+	processedFlowCount.Inc(int64(logCount))
+
+	resultsChan <- logFile
+	return nil
+}
+
+// lookupFamilySetting returns settings[family] if set, otherwise fallback.
+func lookupFamilySetting(settings map[string]string, family, fallback string) string {
+	if value, ok := settings[family]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newTLSConfig builds a client TLS config. caFile replaces the system roots and
+// certFile/keyFile add a client certificate. Empty paths are ignored.
+func newTLSConfig(caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
 // Parses Blob.Name (Path) or Resource ID for NSG Name
 func getLoggedResourceName(name string) (string, error) {
 	nameTokens := LoggedResourceFileRegExp.FindStringSubmatch(name)
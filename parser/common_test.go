@@ -80,7 +80,7 @@ var recordErrorTests = map[string][]struct {
   }
 }`),
 			errorCount:        1,
-			firstErrorMessage: "unexpected # tokens in tuple 1497038813,10.193.160.4,40.85.232.72,46010. expected 8",
+			firstErrorMessage: "unexpected # tokens in tuple 1497038813,10.193.160.4,40.85.232.72,46010. expected 8 or 13",
 		},
 		{
 			record: []byte(`{
@@ -173,3 +173,24 @@ func loadTestLogFile(name string, t *testing.T) AzureLogFile {
 	}
 	return &logFile
 }
+
+func eventsFromLogFile(logFile AzureLogFile) []*CEFEvent {
+	events := []*CEFEvent{}
+	for _, record := range logFile.GetAzureEventLog().GetRecords() {
+		cefEvents, _ := record.GetCEFList(GetCEFEventListOptions{})
+		events = append(events, cefEvents...)
+	}
+	return events
+}
+
+func loadTestEvents(name string, t *testing.T) []*CEFEvent {
+	return eventsFromLogFile(loadTestLogFile(name, t))
+}
+
+func loadTestAppGwEvents(t *testing.T) []*CEFEvent {
+	return eventsFromLogFile(loadTestAppGwLogFile("app_gateway_access.json", t))
+}
+
+func loadTestAppGwFirewallEvents(t *testing.T) []*CEFEvent {
+	return eventsFromLogFile(loadTestAppGwFirewallLogFile("app_gateway_firewall_log.json", t))
+}
@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ReadEvents reads the events of a local log file, optionally gzipped. The
+// file is either a blob downloaded from an insights-logs container, with its
+// records converted as they would be when processed from Azure, or newline
+// delimited events written by the file destination in the json format.
+func ReadEvents(r io.Reader) ([]*CEFEvent, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = bufio.NewReader(gzipReader)
+	}
+	payload, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := struct {
+		Records []struct {
+			Category string `json:"category"`
+		} `json:"records"`
+	}{}
+	if json.Unmarshal(payload, &blob) == nil && blob.Records != nil {
+		if len(blob.Records) == 0 {
+			return []*CEFEvent{}, nil
+		}
+		var eventLog AzureEventLog
+		switch blob.Records[0].Category {
+		case "ApplicationGatewayAccessLog":
+			eventLog = &AzureAppGwAccessLog{}
+		case "ApplicationGatewayFirewallLog":
+			eventLog = &AzureAppGwFirewallAccessLog{}
+		default:
+			eventLog = &AzureNsgEventLog{}
+		}
+		err = json.Unmarshal(payload, eventLog)
+		if err != nil {
+			return nil, fmt.Errorf("error reading log records: %s", err)
+		}
+		events := []*CEFEvent{}
+		for _, record := range eventLog.GetRecords() {
+			recordEvents, errs := record.GetCEFList(GetCEFEventListOptions{})
+			if len(errs) > 0 {
+				return nil, fmt.Errorf("error converting log record: %s", errs[0])
+			}
+			events = append(events, recordEvents...)
+		}
+		return events, nil
+	}
+
+	events := []*CEFEvent{}
+	for i, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		event := &CEFEvent{}
+		err = json.Unmarshal(line, event)
+		if err != nil {
+			return nil, fmt.Errorf("line %d is neither a log blob nor a json event: %s", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadEvents(t *testing.T) {
+	for name, expected := range map[string][]*CEFEvent{
+		"nsg_flow_events.json":          loadTestEvents("nsg_flow_events.json", t),
+		"app_gateway_access.json":       loadTestAppGwEvents(t),
+		"app_gateway_firewall_log.json": loadTestAppGwFirewallEvents(t),
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(testDataPath, name))
+		require.Nil(t, err)
+		events, err := ReadEvents(bytes.NewReader(data))
+		require.Nil(t, err, name)
+		require.Equal(t, len(expected), len(events), name)
+		assert.Equal(t, expected[0].Extension, events[0].Extension, name)
+	}
+}
+
+func TestReadEventsNdjson(t *testing.T) {
+	expected := loadTestEvents("nsg_flow_events_v2.json", t)
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	for _, event := range expected {
+		line, err := json.Marshal(event)
+		require.Nil(t, err)
+		gzipWriter.Write(append(line, '\n'))
+	}
+	require.Nil(t, gzipWriter.Close())
+
+	events, err := ReadEvents(buffer)
+	require.Nil(t, err)
+	require.Equal(t, len(expected), len(events))
+	assert.Equal(t, expected[5].Extension, events[5].Extension)
+	assert.True(t, expected[5].Time.Equal(events[5].Time))
+
+	_, err = ReadEvents(strings.NewReader("{\"time\":\"2017\"}\nnot json\n"))
+	assert.NotNil(t, err)
+	events, err = ReadEvents(strings.NewReader("{\"records\":[]}"))
+	require.Nil(t, err)
+	assert.Empty(t, events)
+}
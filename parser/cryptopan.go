@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// cryptoPAn is the prefix-preserving anonymization of Crypto-PAn: two values
+// sharing their first n bits are anonymized to values sharing their first n
+// bits. The key is an AES-128 key followed by the 16 byte secret the pad is
+// encrypted from. IPv4 addresses anonymized over 32 bits match the reference
+// implementation.
+type cryptoPAn struct {
+	block cipher.Block
+	pad   [16]byte
+}
+
+func newCryptoPAn(key []byte) (*cryptoPAn, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("crypto-pan keys are 32 bytes, not %d", len(key))
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	anonymizer := &cryptoPAn{block: block}
+	block.Encrypt(anonymizer.pad[:], key[16:])
+	return anonymizer, nil
+}
+
+// derive returns an anonymizer with the same key and another pad, to
+// anonymize values of another kind independently.
+func (anonymizer *cryptoPAn) derive() *cryptoPAn {
+	derived := &cryptoPAn{block: anonymizer.block}
+	anonymizer.block.Encrypt(derived.pad[:], anonymizer.pad[:])
+	return derived
+}
+
+// anonymize anonymizes the first bits of value, keeping the first keep bits.
+func (anonymizer *cryptoPAn) anonymize(value [16]byte, keep, bits int) [16]byte {
+	result := value
+	for i := keep; i < bits; i++ {
+		if anonymizer.flip(value, i) {
+			result[i/8] ^= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// deanonymize reverses anonymize, recovering the original bits in order.
+func (anonymizer *cryptoPAn) deanonymize(value [16]byte, keep, bits int) [16]byte {
+	result := value
+	for i := keep; i < bits; i++ {
+		if anonymizer.flip(result, i) {
+			result[i/8] ^= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// flip returns the pseudorandom bit of position i, which depends only on the
+// first i bits of value.
+func (anonymizer *cryptoPAn) flip(value [16]byte, i int) bool {
+	input := anonymizer.pad
+	for j := 0; j < i/8; j++ {
+		input[j] = value[j]
+	}
+	if i%8 != 0 {
+		mask := byte(0xff << (8 - uint(i%8)))
+		input[i/8] = value[i/8]&mask | input[i/8]&^mask
+	}
+	var output [16]byte
+	anonymizer.block.Encrypt(output[:], input[:])
+	return output[0]&0x80 != 0
+}
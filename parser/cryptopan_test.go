@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+// testCryptoPAnKey is the key of the sample of the reference implementation.
+var testCryptoPAnKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+	216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+
+func anonymizeIPv4(anonymizer *cryptoPAn, address string) string {
+	var value [16]byte
+	copy(value[:], net.ParseIP(address).To4())
+	anonymized := anonymizer.anonymize(value, 0, 32)
+	return net.IP(anonymized[:4]).String()
+}
+
+func TestCryptoPAnReferenceSample(t *testing.T) {
+	anonymizer, err := newCryptoPAn(testCryptoPAnKey)
+	require.Nil(t, err)
+	for original, anonymized := range map[string]string{
+		"128.11.68.132":   "135.242.180.132",
+		"129.118.74.4":    "134.136.186.123",
+		"130.132.252.244": "133.68.164.234",
+		"141.223.7.43":    "141.167.8.160",
+		"141.233.145.108": "141.129.237.235",
+		"152.163.225.39":  "151.140.114.167",
+		"156.29.3.236":    "147.225.12.42",
+		"165.247.96.84":   "162.9.99.234",
+		"166.107.77.190":  "160.132.178.185",
+		"192.102.249.13":  "252.138.62.131",
+	} {
+		assert.Equal(t, anonymized, anonymizeIPv4(anonymizer, original), original)
+	}
+	_, err = newCryptoPAn(testCryptoPAnKey[:16])
+	assert.NotNil(t, err)
+}
+
+func TestCryptoPAnPrefixPreserving(t *testing.T) {
+	anonymizer, err := newCryptoPAn(testCryptoPAnKey)
+	require.Nil(t, err)
+	a := net.ParseIP("2001:db8:1:2::10").To16()
+	b := net.ParseIP("2001:db8:1:3::10").To16()
+	var valueA, valueB [16]byte
+	copy(valueA[:], a)
+	copy(valueB[:], b)
+	anonymizedA := anonymizer.anonymize(valueA, 16, 128)
+	anonymizedB := anonymizer.anonymize(valueB, 16, 128)
+	assert.Equal(t, 63, commonBits(anonymizedA, anonymizedB, 128))
+	assert.Equal(t, valueA[:2], anonymizedA[:2], "kept bits are unchanged")
+	assert.NotEqual(t, valueA, anonymizedA)
+	assert.Equal(t, valueA, anonymizer.deanonymize(anonymizedA, 16, 128))
+	assert.Equal(t, valueB, anonymizer.deanonymize(anonymizedB, 16, 128))
+	assert.NotEqual(t, anonymizedA, anonymizer.derive().anonymize(valueA, 16, 128))
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"io"
+	"regexp"
+)
+
+var destinationNameRegExp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Destination is one named output of the process command. Each destination
+// runs as its own job, so it keeps its own ProcessStatus and a slow or failing
+// destination neither holds back nor duplicates delivery to the others.
+//
+// Families, when set, limits the log families sent to the destination,
+// Enrichers add fields to the events, Filters drops unwanted events, Routes
+// selects the events routed to it and Pseudonymizer replaces their addresses,
+// in that order, so filters and routes can match enriched fields and real
+// addresses. Events not sent count as delivered.
+type Destination struct {
+	Name      string
+	Type      string
+	Families  []string
+	Enrichers []EventEnricher
+	Filters   *FilterChain
+	Routes    *RouteTable
+	// Pseudonymizer is applied to the events of the destination only, as
+	// every destination parses its own events.
+	Pseudonymizer *Pseudonymizer
+	Sender        EventSender
+
+	families    map[string]bool
+	sentCount   metrics.Counter
+	failedCount metrics.Counter
+}
+
+// NewDestination validates a destination and registers its metrics.
+func NewDestination(name, destinationType string, families []string, sender EventSender) (*Destination, error) {
+	if !destinationNameRegExp.MatchString(name) {
+		return nil, fmt.Errorf("invalid destination name %q. use letters, digits, - and _", name)
+	}
+	destination := &Destination{
+		Name:        name,
+		Type:        destinationType,
+		Families:    families,
+		Sender:      sender,
+		families:    map[string]bool{},
+		sentCount:   metrics.GetOrRegisterCounter(fmt.Sprintf("destination_%s_events", name), nil),
+		failedCount: metrics.GetOrRegisterCounter(fmt.Sprintf("destination_%s_failures", name), nil),
+	}
+	for _, family := range families {
+		if !isLogFamily(family) {
+			return nil, fmt.Errorf("destination %s has unknown log family %q", name, family)
+		}
+		destination.families[family] = true
+	}
+	return destination, nil
+}
+
+func (destination *Destination) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, destination)
+}
+
+// SendEvents enriches the events of the destination's families and sends
+// those that pass its filters and are routed to it, pseudonymized when configured.
+func (destination *Destination) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if len(destination.families) > 0 {
+		selected := make([]*CEFEvent, 0, len(events))
+		for _, event := range events {
+			if destination.families[event.LogFamily()] {
+				selected = append(selected, event)
+			}
+		}
+		events = selected
+	}
+	for _, enricher := range destination.Enrichers {
+		for _, event := range events {
+			enricher.Enrich(event)
+		}
+	}
+	if destination.Filters != nil {
+		events = destination.Filters.Filter(events)
+	}
+	if destination.Routes != nil {
+		events = destination.Routes.Select(destination.Name, events)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if destination.Pseudonymizer != nil {
+		for _, event := range events {
+			destination.Pseudonymizer.Pseudonymize(event)
+		}
+	}
+	err := destination.Sender.SendEvents(logFile, events)
+	if err != nil {
+		destination.failedCount.Inc(1)
+		return fmt.Errorf("destination %s: %s", destination.Name, err)
+	}
+	destination.sentCount.Inc(int64(len(events)))
+	return nil
+}
+
+// Close closes the sender if it holds connections or open files.
+func (destination *Destination) Close() error {
+	if closer, ok := destination.Sender.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
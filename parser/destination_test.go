@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type recordingSender struct {
+	events []*CEFEvent
+	err    error
+}
+
+func (sender *recordingSender) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if sender.err != nil {
+		return sender.err
+	}
+	sender.events = append(sender.events, events...)
+	return nil
+}
+
+func TestNewDestination(t *testing.T) {
+	_, err := NewDestination("siem/1", DestinationSyslog, nil, &recordingSender{})
+	assert.Error(t, err)
+	_, err = NewDestination("siem", DestinationSyslog, []string{"nsg_flows"}, &recordingSender{})
+	assert.Error(t, err)
+	destination, err := NewDestination("siem-1", DestinationSyslog, []string{LogFamilyNsgFlow}, &recordingSender{})
+	require.Nil(t, err)
+	assert.Equal(t, "siem-1", destination.Name)
+}
+
+func TestDestinationFamilies(t *testing.T) {
+	events := append(loadTestEvents("nsg_flow_events.json", t), loadTestAppGwFirewallEvents(t)...)
+	flowCount := len(loadTestEvents("nsg_flow_events.json", t))
+
+	all := &recordingSender{}
+	destination, err := NewDestination("test_all", DestinationFile, nil, all)
+	require.Nil(t, err)
+	require.Nil(t, destination.SendEvents(nil, events))
+	assert.Len(t, all.events, len(events))
+	assert.Equal(t, int64(len(events)), destination.sentCount.Count())
+
+	firewall := &recordingSender{}
+	destination, err = NewDestination("test_firewall", DestinationFile, []string{LogFamilyAppGwFirewall}, firewall)
+	require.Nil(t, err)
+	require.Nil(t, destination.SendEvents(nil, events))
+	assert.Len(t, firewall.events, len(events)-flowCount)
+	for _, event := range firewall.events {
+		assert.Equal(t, LogFamilyAppGwFirewall, event.LogFamily())
+	}
+
+	// Nothing is sent when no event is of the destination's families.
+	access := &recordingSender{err: fmt.Errorf("unreachable")}
+	destination, err = NewDestination("test_access", DestinationFile, []string{LogFamilyAppGwAccess}, access)
+	require.Nil(t, err)
+	assert.Nil(t, destination.SendEvents(nil, events))
+}
+
+func TestDestinationFailure(t *testing.T) {
+	sender := &recordingSender{err: fmt.Errorf("connection refused")}
+	destination, err := NewDestination("test_failure", DestinationFile, nil, sender)
+	require.Nil(t, err)
+	err = destination.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "destination test_failure")
+	assert.Equal(t, int64(1), destination.failedCount.Count())
+	assert.Equal(t, int64(0), destination.sentCount.Count())
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// EventEnricher adds fields to events before they are filtered, routed and
+// sent. Enrichers may be shared by destinations and must be safe for
+// concurrent use.
+type EventEnricher interface {
+	Enrich(event *CEFEvent)
+}
+
+// reservedNetworks are the loopback, link local, multicast and documentation
+// ranges, which like privateNetworks are not looked up in public data.
+var reservedNetworks = mustParseCIDRs("0.0.0.0/8", "127.0.0.0/8", "169.254.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+	"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "::/128", "::1/128", "fe80::/10", "ff00::/8", "2001:db8::/32")
+
+// isPublicIP reports whether ip is a valid address outside the private and
+// reserved ranges.
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchedFile is the state of a file loaded by an enricher, to reload it when
+// it changes.
+type watchedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (watchedFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return watchedFile{}, err
+	}
+	return watchedFile{path: path, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// changed reports whether the file was modified since it was loaded. A file
+// that cannot be read is reported unchanged, so the loaded data is kept.
+func (file watchedFile) changed() bool {
+	current, err := statFile(file.path)
+	return err == nil && (!current.modTime.Equal(file.modTime) || current.size != file.size)
+}
@@ -5,5 +5,9 @@ import (
 )
 
 var (
+	// ErrSkipEvent is returned by formatters for events they have no
+	// rendering for. Destinations drop those events.
+	ErrSkipEvent = fmt.Errorf("event has no rendering in this format")
+
 	errResourceIdName = fmt.Errorf("expected resourceId with name type /SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSGNAME-NSG")
 )
@@ -93,7 +93,7 @@ func (record *AzureNsgEventRecord) convertNetworkSecurityGroupFlowEventsToCEF(op
 	var errors []error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Errorf("Recovered in convertNetworkSecurityGroupFlowEventsToCEF", r)
+			log.Errorf("Recovered in convertNetworkSecurityGroupFlowEventsToCEF: %v", r)
 		}
 	}()
 	if record.Time.After(options.StartTime) {
@@ -113,8 +113,8 @@ func (record *AzureNsgEventRecord) convertNetworkSecurityGroupFlowEventsToCEF(op
 
 					//Tuple-Specific properties below here.
 					tuples := strings.Split(tuple, ",")
-					if len(tuples) != 8 {
-						errors = append(errors, fmt.Errorf("unexpected # tokens in tuple %s. expected 8", flowTuple))
+					if len(tuples) != 8 && len(tuples) != 13 {
+						errors = append(errors, fmt.Errorf("unexpected # tokens in tuple %s. expected 8 or 13", flowTuple))
 						continue
 					}
 
@@ -155,6 +155,21 @@ func (record *AzureNsgEventRecord) convertNetworkSecurityGroupFlowEventsToCEF(op
 						event.Extension["categoryOutcome"] = "Unknown"
 					}
 
+					// Version 2 tuples add the flow state and packet/byte counters.
+					// Counters are empty for the B(egin) state.
+					if len(tuples) == 13 {
+						event.Extension["cs5"] = flowStateMap[tuples[8]]
+						event.Extension["cs5label"] = "Flow State"
+						if tuples[9] != "" {
+							event.Extension["cn1"] = tuples[9]
+							event.Extension["cn1label"] = "Packets Sent"
+							event.Extension["out"] = tuples[10]
+							event.Extension["cn2"] = tuples[11]
+							event.Extension["cn2label"] = "Packets Received"
+							event.Extension["in"] = tuples[12]
+						}
+					}
+
 					events = append(events, &event)
 				}
 			}
@@ -92,3 +92,24 @@ func BenchmarkConvertToCEF(b *testing.B) {
 		}
 	}
 }
+
+func TestConvertV2FlowTuples(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	assert.Equal(t, 6, len(events))
+
+	begin := events[0]
+	assert.Equal(t, "Deny", begin.Extension["categoryOutcome"])
+	assert.Equal(t, "Begin", begin.Extension["cs5"])
+	assert.Equal(t, "UDP", begin.Extension["proto"])
+	_, hasPackets := begin.Extension["cn1"]
+	assert.False(t, hasPackets, "begin tuples carry no counters")
+
+	end := events[3]
+	assert.Equal(t, "End", end.Extension["cs5"])
+	assert.Equal(t, "1", end.Extension["cn1"])
+	assert.Equal(t, "66", end.Extension["out"])
+	assert.Equal(t, "1", end.Extension["cn2"])
+	assert.Equal(t, "66", end.Extension["in"])
+
+	assert.Equal(t, "2001:db8::4", events[5].Extension["src"])
+}
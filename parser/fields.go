@@ -0,0 +1,187 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventFields returns every decoded field of an event by name, as text.
+//
+// All events carry time, category, operationName, logFamily, severity and
+// their raw CEF extension keys, which includes anything added by enrichment.
+// Flow events add the NsgFlowLog fields and other events add their record
+// properties, with nested properties joined by a dot, as in details.message.
+func EventFields(event *CEFEvent) map[string]string {
+	fields := map[string]string{}
+	for key, value := range event.Extension {
+		fields[key] = value
+	}
+	fields["time"] = event.Time.UTC().Format(time.RFC3339)
+	fields["category"], fields["operationName"] = azureCategory(event)
+	fields["logFamily"] = event.LogFamily()
+	fields["severity"] = strconv.Itoa(event.Severity)
+
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		flowLog, err := NewNsgFlowLog(event)
+		if err != nil {
+			break
+		}
+		data, _ := json.Marshal(flowLog)
+		properties := map[string]interface{}{}
+		json.Unmarshal(data, &properties)
+		delete(properties, "time")
+		addFieldValues(fields, "", properties)
+	case LogFamilyNsgEvent:
+		fields["subscriptionId"] = event.Extension["cs3"]
+		fields["resourceGroup"] = event.Extension["cs4"]
+		fields["nsgName"] = event.Extension["cs2"]
+	case LogFamilyAppGwAccess, LogFamilyAppGwFirewall:
+		fields["subscriptionId"] = event.Extension["cs3"]
+		fields["resourceGroup"] = event.Extension["cs4"]
+		fields["appGatewayName"] = event.Extension["cs2"]
+	}
+	if event.LogFamily() != LogFamilyNsgFlow {
+		properties, err := recordProperties(event)
+		if err == nil {
+			addFieldValues(fields, "", properties)
+		}
+	}
+	return fields
+}
+
+// cefFields are the CEF extension keys set by the parsers and aggregators.
+var cefFields = []string{
+	"act", "categoryOutcome", "cn1", "cn1label", "cn2", "cn2label", "cnt",
+	"cs1", "cs1label", "cs2", "cs2label", "cs3", "cs3label", "cs4", "cs4label",
+	"cs5", "cs5label", "cs6", "cs6label", "deviceDirection", "deviceExternalId",
+	"dmac", "dpt", "dst", "end", "in", "msg", "out", "proto", "request",
+	"requestMethod", "smac", "spt", "src", "start",
+}
+
+// recordFields are the record properties Azure documents for each log family
+// other than nsg_flow, with nested properties joined by a dot.
+var recordFields = map[string][]string{
+	LogFamilyNsgEvent: {
+		"vnetResourceGuid", "subnetPrefix", "macAddress", "primaryIPv4Address",
+		"ruleName", "direction", "priority", "type", "matchedConnections",
+		"conditions.None", "conditions.protocols", "conditions.sourcePortRange",
+		"conditions.destinationPortRange", "conditions.sourceIP", "conditions.destinationIP",
+	},
+	LogFamilyAppGwAccess: {
+		"instanceId", "clientIP", "clientPort", "httpMethod", "requestUri",
+		"requestQuery", "userAgent", "httpStatus", "httpVersion", "receivedBytes",
+		"sentBytes", "timeTaken", "sslEnabled", "sslCipher", "sslProtocol",
+		"sslClientVerify", "sslClientCertificateFingerprint", "sslClientCertificateIssuerName",
+		"host", "originalHost", "originalRequestUriWithArgs", "serverRouted",
+		"serverStatus", "serverResponseLatency", "transactionId", "WAFEvaluationTime",
+		"WAFMode", "clientResponseTime", "listenerName", "ruleName", "backendPoolName",
+		"backendSettingName", "error_info", "noOfConnectionRequests", "upstreamSourcePort",
+	},
+	LogFamilyAppGwFirewall: {
+		"instanceId", "clientIp", "clientPort", "requestUri", "ruleSetType",
+		"ruleSetVersion", "ruleId", "ruleGroup", "message", "action", "site",
+		"engine", "hostname", "transactionId", "policyId", "policyScope",
+		"policyScopeName", "details.message", "details.data", "details.file",
+		"details.line",
+	},
+}
+
+// enrichmentFields returns the extension keys set by the enrichers.
+func enrichmentFields() []string {
+	fields := []string{
+		"shost", "dhost", "slat", "slong", "dlat", "dlong", TrafficTypeKey, PseudonymKeyKey,
+		ThreatFeedKey, ThreatIndicatorKey, ThreatNetworkKey, ThreatMatchKey, ThreatDescriptionKey,
+	}
+	for _, prefix := range []string{"source", "destination"} {
+		for _, key := range []string{
+			GeoCountryCodeKey, GeoCountryKey, GeoCityKey, GeoASNKey, GeoASOrganizationKey,
+			AssetNICKey, AssetSubnetKey, AssetVNetKey, AssetApplicationKey, AssetOwnerKey,
+			NetworkKey, ServiceTagKey,
+		} {
+			fields = append(fields, prefix+key)
+		}
+	}
+	return fields
+}
+
+// KnownFields returns every name EventFields can return for events of
+// family, including the keys set by enrichment.
+func KnownFields(family string) map[string]bool {
+	known := map[string]bool{"time": true, "category": true, "operationName": true, "logFamily": true, "severity": true}
+	for _, field := range cefFields {
+		known[field] = true
+	}
+	for _, field := range enrichmentFields() {
+		known[field] = true
+	}
+	switch family {
+	case LogFamilyNsgFlow:
+		flowLog := reflect.TypeOf(NsgFlowLog{})
+		for i := 0; i < flowLog.NumField(); i++ {
+			known[strings.Split(flowLog.Field(i).Tag.Get("json"), ",")[0]] = true
+		}
+	case LogFamilyNsgEvent:
+		known["subscriptionId"], known["resourceGroup"], known["nsgName"] = true, true, true
+	case LogFamilyAppGwAccess, LogFamilyAppGwFirewall:
+		known["subscriptionId"], known["resourceGroup"], known["appGatewayName"] = true, true, true
+	}
+	for _, field := range recordFields[family] {
+		known[field] = true
+	}
+	return known
+}
+
+// appGwOperationNames maps Application Gateway categories to the operation
+// name of their records.
+var appGwOperationNames = map[string]string{
+	"ApplicationGatewayAccessLog":   "ApplicationGatewayAccess",
+	"ApplicationGatewayFirewallLog": "ApplicationGatewayFirewall",
+}
+
+// azureCategory returns the Azure log category and operation name of an
+// event. Application Gateway events are named after the gateway and use the
+// category as their event class.
+func azureCategory(event *CEFEvent) (category, operationName string) {
+	if operationName, ok := appGwOperationNames[event.DeviceEventClassId]; ok {
+		return event.DeviceEventClassId, operationName
+	}
+	return event.Name, event.DeviceEventClassId
+}
+
+func addFieldValues(fields map[string]string, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		switch value := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			addFieldValues(fields, prefix+key+".", value)
+		case string:
+			fields[prefix+key] = value
+		case float64:
+			fields[prefix+key] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			fields[prefix+key] = strconv.FormatBool(value)
+		default:
+			data, _ := json.Marshal(value)
+			fields[prefix+key] = string(data)
+		}
+	}
+}
+
+// recordProperties returns the properties of the Azure record, which are
+// carried as JSON in the msg extension.
+func recordProperties(event *CEFEvent) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	if event.Extension["msg"] == "" {
+		return properties, nil
+	}
+	err := json.Unmarshal([]byte(event.Extension["msg"]), &properties)
+	if err != nil {
+		return nil, fmt.Errorf("error reading record properties: %s", err)
+	}
+	return properties, nil
+}
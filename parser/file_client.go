@@ -1,59 +1,449 @@
 package parser
 
 import (
-	"encoding/json"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
+	"io"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
+const (
+	fileDefaultPartition      = "category={{.Family}}/nsg={{.Resource}}/date={{.Date}}/hour={{.Hour}}"
+	fileDefaultRotateSize     = 128
+	fileDefaultRotateInterval = 300
+	fileTempSuffix            = ".tmp"
+	filePartialSuffix         = ".partial"
+	fileCorruptSuffix         = ".corrupt"
+	fileGzipSuffix            = ".gz"
+	fileMaxRotateCheck        = 10 * time.Second
+)
+
+var (
+	fileExtensions = map[string]string{
+		FormatJSON:     "ndjson",
+		FormatFlat:     "ndjson",
+		FormatCEF:      "cef",
+		FormatCSV:      "csv",
+		FormatTSV:      "tsv",
+		FormatProtobuf: "pb",
+		FormatECS:      "ndjson",
+		FormatOCSF:     "ndjson",
+		FormatZeek:     "log",
+		FormatZeekJSON: "ndjson",
+		FormatEVE:      "ndjson",
+		FormatTemplate: "txt",
+	}
+	filePartitionValueRegExp = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// FileConfig holds the settings for the file destination.
+//
+// Partition is a text/template evaluated per event with .Family, .Resource,
+// .Subscription, .ResourceGroup, .Date and .Hour, the last two taken from the
+// event time in UTC. RotateSize is in megabytes and RotateInterval in seconds.
+// Columns sets the csv and tsv columns and Templates the template files per
+// log family. Extension names the files of the template format.
+type FileConfig struct {
+	DataPath       string              `mapstructure:"data_path"`
+	Path           string              `mapstructure:"file_path"`
+	Format         string              `mapstructure:"file_format"`
+	Partition      string              `mapstructure:"file_partition"`
+	RotateSize     int                 `mapstructure:"file_rotate_size"`
+	RotateInterval int                 `mapstructure:"file_rotate_interval"`
+	Gzip           bool                `mapstructure:"file_gzip"`
+	Columns        map[string][]string `mapstructure:"csv_columns"`
+	Templates      map[string]string   `mapstructure:"template_files"`
+	Extension      string              `mapstructure:"file_extension"`
+}
+
+// FileClient writes events as newline delimited records into partitioned
+// directories under Path.
+//
+// Each partition has one open file, written as a hidden temp file and renamed
+// into place once it reaches RotateSize or RotateInterval, so loaders that
+// skip dot files never see a partial file. SendEvents syncs the open files
+// before the blob range is checkpointed and Initialize publishes temp files
+// left behind by a previous run, so no checkpointed event is lost.
 type FileClient struct {
-	DataPath string
+	config            FileConfig
+	formatter         EventFormatter
+	extension         string
+	convert           func(source, destination string) error
+	partitionTemplate *template.Template
+	files             map[string]*partitionFile
+	sequence          int
+	mutex             sync.Mutex
+	done              chan struct{}
+	initialized       bool
 }
 
-func (client FileClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
-	var fileName string
-	blobRange := logFile.getUnprocessedBlobRange()
-	err := logFile.LoadBlobRange(blobRange)
+type partitionFile struct {
+	dir    string
+	name   string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+type filePartitionData struct {
+	Family        string
+	Resource      string
+	Subscription  string
+	ResourceGroup string
+	Date          string
+	Hour          string
+}
+
+func (client *FileClient) Initialize(config FileConfig) error {
+	if config.Path == "" {
+		config.Path = filepath.Join(config.DataPath, "output")
+	}
+	config.Format = strings.ToLower(config.Format)
+	if config.Format == "" {
+		config.Format = FormatJSON
+	}
+	formatter, err := NewEventFormatterWithOptions(config.Format, FormatOptions{Columns: config.Columns, Templates: config.Templates})
 	if err != nil {
-		log.Error(err)
 		return err
 	}
+	if config.Partition == "" {
+		config.Partition = fileDefaultPartition
+	}
+	if _, ok := formatter.(HeaderFormatter); ok && !strings.Contains(config.Partition, ".Family") {
+		return fmt.Errorf("file_partition must include {{.Family}} for the %s format", config.Format)
+	}
+	partitionTemplate, err := template.New("filePartition").Parse(config.Partition)
+	if err != nil {
+		return fmt.Errorf("invalid file_partition template: %s", err)
+	}
+	if strings.ContainsAny(config.Extension, `/\`) {
+		return fmt.Errorf("invalid file_extension %q", config.Extension)
+	}
+	if config.RotateSize <= 0 {
+		config.RotateSize = fileDefaultRotateSize
+	}
+	if config.RotateInterval <= 0 {
+		config.RotateInterval = fileDefaultRotateInterval
+	}
+	err = os.MkdirAll(config.Path, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating file_path %s: %s", config.Path, err)
+	}
 
-	events := []*CEFEvent{}
-	for _, record := range logFile.GetAzureEventLog().GetRecords() {
-		cefEvents, _ := record.GetCEFList(GetCEFEventListOptions{StartTime: logFile.GetLastProcessedRecord()})
-		events = append(events, cefEvents...)
+	client.config = config
+	client.formatter = formatter
+	if client.extension == "" {
+		client.extension = fileExtensions[config.Format]
+		if config.Format == FormatTemplate && config.Extension != "" {
+			client.extension = strings.TrimPrefix(config.Extension, ".")
+		}
 	}
+	if config.Gzip {
+		client.convert = gzipFile
+	}
+	client.partitionTemplate = partitionTemplate
+	client.files = map[string]*partitionFile{}
+	err = client.recover()
+	if err != nil {
+		return err
+	}
+	client.done = make(chan struct{})
+	go client.rotateLoop()
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"path":      config.Path,
+		"format":    config.Format,
+		"partition": config.Partition,
+		"gzip":      config.Gzip,
+	}).Info("initialized file client")
+	return nil
+}
+
+func (client *FileClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents appends events to their partition files and syncs them to disk.
+func (client *FileClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized file client")
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	rotateSize := int64(client.config.RotateSize) * 1024 * 1024
+	for _, event := range events {
+		line, err := client.formatter.Format(event)
+		if err == ErrSkipEvent {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dir, err := client.partition(event)
+		if err != nil {
+			return err
+		}
+		file, err := client.open(dir, event.LogFamily())
+		if err != nil {
+			return err
+		}
+		n, err := file.file.Write(FrameRecord(client.formatter, line))
+		file.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %s", file.tempPath(), err)
+		}
+		if file.size >= rotateSize {
+			err = client.rotate(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, file := range client.files {
+		err := file.file.Sync()
+		if err != nil {
+			return fmt.Errorf("error syncing %s: %s", file.tempPath(), err)
+		}
+	}
+	return client.rotateExpired()
+}
 
-	logCount := len(events)
-	if logCount == 0 {
-		logFile.Logger().Info("0 CEF Events extracted.")
+// Close publishes every open file.
+func (client *FileClient) Close() error {
+	if !client.initialized {
 		return nil
 	}
-	startTimeStamp := events[0].Time.Unix()
-	endTimeStamp := events[logCount-1].Time.Unix()
-	bm := LoggedResourceFileRegExp.FindStringSubmatch(logFile.GetBlob().Name)
-	if len(bm) == 7 {
-		fileName = fmt.Sprintf("nsgLog-%s-%s%s%s%s%s", bm[1], bm[2], bm[3], bm[4], bm[5], bm[6])
-	} else {
-		return fmt.Errorf("error in Blob.Name, expected 7 tokens. Got %d. Name: %s", len(bm), logFile.GetBlob().Name)
+	close(client.done)
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for dir := range client.files {
+		err := client.rotate(dir)
+		if err != nil {
+			return err
+		}
 	}
-	fileName = fmt.Sprintf("%s-%d-%d.json", fileName, startTimeStamp, endTimeStamp)
-	outJson, err := json.Marshal(events)
+	client.initialized = false
+	return nil
+}
+
+func (client *FileClient) rotateLoop() {
+	interval := time.Duration(client.config.RotateInterval) * time.Second
+	if interval > fileMaxRotateCheck {
+		interval = fileMaxRotateCheck
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-ticker.C:
+			client.mutex.Lock()
+			err := client.rotateExpired()
+			client.mutex.Unlock()
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+func (client *FileClient) rotateExpired() error {
+	maxAge := time.Duration(client.config.RotateInterval) * time.Second
+	for dir, file := range client.files {
+		if time.Since(file.opened) >= maxAge {
+			err := client.rotate(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (client *FileClient) partition(event *CEFEvent) (string, error) {
+	eventTime := event.Time.UTC()
+	var partition bytes.Buffer
+	err := client.partitionTemplate.Execute(&partition, filePartitionData{
+		Family:        filePartitionValue(event.LogFamily()),
+		Resource:      filePartitionValue(event.Extension["cs2"]),
+		Subscription:  filePartitionValue(event.Extension["cs3"]),
+		ResourceGroup: filePartitionValue(event.Extension["cs4"]),
+		Date:          eventTime.Format("2006-01-02"),
+		Hour:          eventTime.Format("15"),
+	})
 	if err != nil {
-		return fmt.Errorf("error marshalling to json %s", err)
+		return "", fmt.Errorf("error rendering file partition: %s", err)
 	}
-	path := filepath.Join(client.DataPath, fileName)
-	err = ioutil.WriteFile(path, outJson, 0666)
+	return filepath.Join(client.config.Path, filepath.FromSlash(partition.String())), nil
+}
 
-	logFile.SetLastProcessed(time.Now())
-	logFile.SetLastRecordCount(len(logFile.GetAzureEventLog().GetRecords()))
-	logFile.SetLastProcessedRecord(logFile.GetAzureEventLog().GetRecords()[logFile.GetLastRecordCount()-1].GetTime())
-	logFile.SetLastProcessedRange(blobRange)
+// filePartitionValue keeps template values from adding path separators.
+func filePartitionValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return filePartitionValueRegExp.ReplaceAllString(value, "_")
+}
+
+// open returns the open file of a partition, creating it if needed. New files
+// of formats with a header start with the header of family.
+func (client *FileClient) open(dir, family string) (*partitionFile, error) {
+	if file, ok := client.files[dir]; ok {
+		return file, nil
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating partition %s: %s", dir, err)
+	}
+	now := time.Now()
+	client.sequence++
+	file := &partitionFile{
+		dir:    dir,
+		name:   fmt.Sprintf("nsgLog-%d-%d.%s", now.UnixNano(), client.sequence, client.extension),
+		opened: now,
+	}
+	file.file, err = os.OpenFile(file.tempPath(), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %s", file.tempPath(), err)
+	}
+	client.files[dir] = file
+	if formatter, ok := client.formatter.(HeaderFormatter); ok {
+		header, err := formatter.Header(family)
+		if err != nil {
+			return nil, err
+		}
+		n, err := file.file.Write(append(header, '\n'))
+		file.size += int64(n)
+		if err != nil {
+			return nil, fmt.Errorf("error writing %s: %s", file.tempPath(), err)
+		}
+	}
+	return file, nil
+}
+
+func (client *FileClient) rotate(dir string) error {
+	file := client.files[dir]
+	delete(client.files, dir)
+	err := file.file.Close()
+	if err != nil {
+		return fmt.Errorf("error closing %s: %s", file.tempPath(), err)
+	}
+	return client.publish(file.tempPath(), filepath.Join(file.dir, file.name))
+}
 
-	resultsChan <- logFile
+// recover publishes temp files left in Path by a previous run. Partial
+// conversions are removed since their source is still present, and so are
+// empty temp files. A temp file that cannot be published is moved aside with
+// a .corrupt suffix so it does not stop every later start.
+func (client *FileClient) recover() error {
+	tempFiles := []string{}
+	err := filepath.Walk(client.config.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, ".") {
+			return nil
+		}
+		if strings.HasSuffix(name, filePartialSuffix) || strings.HasSuffix(name, fileTempSuffix) && info.Size() == 0 {
+			return os.Remove(path)
+		}
+		if !strings.HasSuffix(name, fileTempSuffix) {
+			return nil
+		}
+		tempFiles = append(tempFiles, path)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error recovering files in %s: %s", client.config.Path, err)
+	}
+	for _, tempPath := range tempFiles {
+		dir, name := filepath.Split(tempPath)
+		finalPath := filepath.Join(dir, strings.TrimSuffix(strings.TrimPrefix(name, "."), fileTempSuffix))
+		log.WithField("file", finalPath).Info("publishing file left by previous run")
+		err = client.publish(tempPath, finalPath)
+		if err != nil {
+			corruptPath := tempPath + fileCorruptSuffix
+			log.WithField("file", corruptPath).Errorf("moving aside file left by previous run: %s", err)
+			if renameErr := os.Rename(tempPath, corruptPath); renameErr != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+func (file *partitionFile) tempPath() string {
+	return tempFilePath(filepath.Join(file.dir, file.name))
+}
+
+func tempFilePath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+fileTempSuffix)
+}
+
+// publish moves tempPath to finalPath, passing it through the conversion
+// first if there is one.
+func (client *FileClient) publish(tempPath, finalPath string) error {
+	if client.convert == nil {
+		err := os.Rename(tempPath, finalPath)
+		if err != nil {
+			return fmt.Errorf("error publishing %s: %s", finalPath, err)
+		}
+		return nil
+	}
+
+	if client.config.Gzip {
+		finalPath += fileGzipSuffix
+	}
+	if _, err := os.Stat(finalPath); err == nil {
+		// Converted on a previous run, which stopped before removing the source.
+		return os.Remove(tempPath)
+	}
+	dir, name := filepath.Split(finalPath)
+	partialPath := filepath.Join(dir, "."+name+filePartialSuffix)
+	err := client.convert(tempPath, partialPath)
+	if err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("error converting %s: %s", tempPath, err)
+	}
+	err = os.Rename(partialPath, finalPath)
+	if err != nil {
+		return fmt.Errorf("error publishing %s: %s", finalPath, err)
+	}
+	return os.Remove(tempPath)
+}
+
+func gzipFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return out.Sync()
+}
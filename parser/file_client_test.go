@@ -0,0 +1,287 @@
+package parser
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/dimitertodorov/nsg-parser/nsgpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publishedFiles returns the visible files under dir, keyed by path relative
+// to dir.
+func publishedFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		require.Nil(t, err)
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			relative, _ := filepath.Rel(dir, path)
+			files[filepath.ToSlash(relative)] = path
+		}
+		return nil
+	})
+	require.Nil(t, err)
+	return files
+}
+
+func readFileLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(path, fileGzipSuffix) {
+		reader, err = gzip.NewReader(file)
+		require.Nil(t, err)
+	}
+	lines := []string{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 65536), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.Nil(t, scanner.Err())
+	return lines
+}
+
+func newTestFileClient(t *testing.T, config FileConfig) (*FileClient, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-file")
+	require.Nil(t, err)
+	config.Path = dir
+	client := &FileClient{}
+	require.Nil(t, client.Initialize(config))
+	return client, dir
+}
+
+func TestFileClientPartitions(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{Format: FormatFlat})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	appGwEvents := loadTestAppGwEvents(t)
+	require.Nil(t, client.SendEvents(nil, append(events, appGwEvents...)))
+	assert.Empty(t, publishedFiles(t, dir), "open files must stay hidden")
+	require.Nil(t, client.Close())
+
+	files := publishedFiles(t, dir)
+	flowLines := 0
+	for name, path := range files {
+		assert.True(t, strings.HasSuffix(name, ".ndjson"), name)
+		lines := readFileLines(t, path)
+		if strings.HasPrefix(name, "category=nsg_flow/nsg=NSGNAME-NSG/date=2017-06-09/") {
+			flowLines += len(lines)
+			flowLog := NsgFlowLog{}
+			require.Nil(t, json.Unmarshal([]byte(lines[0]), &flowLog))
+			assert.Equal(t, "SUBID", flowLog.SubscriptionID)
+		} else {
+			assert.True(t, strings.HasPrefix(name, "category=appgw_access/"), name)
+		}
+	}
+	assert.Equal(t, len(events), flowLines)
+}
+
+func TestFileClientRotateSize(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{RotateSize: 1, Partition: "all"})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.Close())
+
+	files := publishedFiles(t, dir)
+	require.True(t, len(files) > 1, "expected files to rotate at 1MB")
+	total := 0
+	for _, path := range files {
+		info, err := os.Stat(path)
+		require.Nil(t, err)
+		assert.True(t, info.Size() < 1024*1024+4096)
+		lines := readFileLines(t, path)
+		event := CEFEvent{}
+		require.Nil(t, json.Unmarshal([]byte(lines[0]), &event))
+		assert.Equal(t, "NSGNAME-NSG", event.Extension["cs2"])
+		total += len(lines)
+	}
+	assert.Equal(t, len(events), total)
+}
+
+func TestFileClientRotateInterval(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{RotateInterval: 1, Partition: "all"})
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	require.Nil(t, client.SendEvents(nil, loadTestEvents("nsg_flow_events_v2.json", t)))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(publishedFiles(t, dir)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	for _, path := range files {
+		assert.Equal(t, 6, len(readFileLines(t, path)))
+	}
+}
+
+func TestFileClientGzipRecovery(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{Gzip: true, Format: FormatCEF, Partition: "all"})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	// Simulate a crash: the synced temp file is left behind along with a
+	// partial compressed file.
+	close(client.done)
+	for _, file := range client.files {
+		file.file.Close()
+		partialPath := filepath.Join(file.dir, "."+file.name+fileGzipSuffix+filePartialSuffix)
+		require.Nil(t, ioutil.WriteFile(partialPath, []byte{1}, 0644))
+	}
+	assert.Empty(t, publishedFiles(t, dir))
+
+	recovered := &FileClient{}
+	require.Nil(t, recovered.Initialize(FileConfig{Path: dir, Gzip: true, Format: FormatCEF, Partition: "all"}))
+	defer recovered.Close()
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	for name, path := range files {
+		assert.True(t, strings.HasSuffix(name, ".cef.gz"), name)
+		lines := readFileLines(t, path)
+		require.Equal(t, len(events), len(lines))
+		assert.True(t, strings.HasPrefix(lines[0], "CEF:0|Microsoft|Azure NSG|"), lines[0])
+	}
+	hidden, err := filepath.Glob(filepath.Join(dir, "all", ".*"))
+	require.Nil(t, err)
+	assert.Empty(t, hidden)
+}
+
+func TestFileClientCSV(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{
+		Format:    FormatCSV,
+		Partition: "{{.Family}}",
+		Columns:   map[string][]string{LogFamilyNsgFlow: {"time", "sourceIp", "destinationPort"}},
+	})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.SendEvents(nil, loadTestAppGwEvents(t)))
+	require.Nil(t, client.Close())
+
+	files := publishedFiles(t, dir)
+	require.Equal(t, 2, len(files))
+	for name, path := range files {
+		assert.True(t, strings.HasSuffix(name, ".csv"), name)
+		lines := readFileLines(t, path)
+		if strings.HasPrefix(name, LogFamilyNsgFlow+"/") {
+			require.Equal(t, len(events)+1, len(lines))
+			assert.Equal(t, "time,sourceIp,destinationPort", lines[0])
+			assert.Equal(t, "2017-06-09T20:06:53Z,10.193.160.4,443", lines[1])
+		} else {
+			assert.Equal(t, strings.Join(DefaultColumns[LogFamilyAppGwAccess], ","), lines[0])
+		}
+	}
+}
+
+func TestFileClientProtobuf(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{Format: FormatProtobuf, Partition: "all", Gzip: true})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.Close())
+
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	for name, path := range files {
+		assert.True(t, strings.HasSuffix(name, ".pb.gz"), name)
+		file, err := os.Open(path)
+		require.Nil(t, err)
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		require.Nil(t, err)
+		decoded, err := nsgpb.DecodeAll(reader)
+		require.Nil(t, err)
+		require.Equal(t, len(events), len(decoded))
+		assert.Equal(t, "10.193.160.4", decoded[0].GetFlow().SourceIp)
+	}
+}
+
+func TestFileClientSchemaNames(t *testing.T) {
+	for _, format := range []string{FormatECS, FormatOCSF} {
+		client, dir := newTestFileClient(t, FileConfig{Format: format, Partition: "all"})
+		require.Nil(t, client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)))
+		require.Nil(t, client.Close())
+
+		files := publishedFiles(t, dir)
+		require.Equal(t, 1, len(files), format)
+		for name := range files {
+			assert.Regexp(t, `^all/nsgLog-\d+-\d+\.ndjson$`, name, format)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileClientInitializeErrors(t *testing.T) {
+	client := &FileClient{}
+	assert.Error(t, client.Initialize(FileConfig{Path: os.TempDir(), Format: "xml"}))
+	assert.Error(t, client.Initialize(FileConfig{Path: os.TempDir(), Partition: "{{.Nope"}))
+	assert.Error(t, client.Initialize(FileConfig{Path: os.TempDir(), Format: FormatCSV, Partition: "all"}))
+	assert.Error(t, client.Initialize(FileConfig{Path: os.TempDir(), Extension: "../log"}))
+	assert.Error(t, client.SendEvents(nil, nil))
+}
+
+func TestFileClientZeek(t *testing.T) {
+	client, dir := newTestFileClient(t, FileConfig{Format: FormatZeek, Partition: "{{.Family}}"})
+	defer os.RemoveAll(dir)
+	events := append(loadTestEvents("nsg_flow_events_v2.json", t), loadTestAppGwEvents(t)...)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.Close())
+
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files), "only flow tuples are written")
+	for name, path := range files {
+		assert.Regexp(t, `^nsg_flow/nsgLog-\d+-\d+\.log$`, name)
+		lines := readFileLines(t, path)
+		assert.Equal(t, "#path\tconn", lines[4])
+		assert.Equal(t, 7+6, len(lines))
+	}
+}
+
+func TestFileClientZeekJSONNames(t *testing.T) {
+	for _, format := range []string{FormatZeekJSON, FormatEVE} {
+		client, dir := newTestFileClient(t, FileConfig{Format: format, Partition: "all"})
+		require.Nil(t, client.SendEvents(nil, loadTestEvents("nsg_flow_events_v2.json", t)))
+		require.Nil(t, client.Close())
+
+		files := publishedFiles(t, dir)
+		require.Equal(t, 1, len(files), format)
+		for name := range files {
+			assert.Regexp(t, `^all/nsgLog-\d+-\d+\.ndjson$`, name, format)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileClientTemplateNames(t *testing.T) {
+	files, templateDir := writeTestTemplates(t, map[string]string{LogFamilyNsgFlow: `{{.Fields.sourceIp}}`})
+	defer os.RemoveAll(templateDir)
+
+	for extension, pattern := range map[string]string{"": `\.txt$`, ".log": `\.log$`} {
+		client, dir := newTestFileClient(t, FileConfig{Format: FormatTemplate, Partition: "all", Templates: files, Extension: extension})
+		require.Nil(t, client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)))
+		require.Nil(t, client.Close())
+
+		published := publishedFiles(t, dir)
+		require.Equal(t, 1, len(published))
+		for name := range published {
+			assert.Regexp(t, `^all/nsgLog-\d+-\d+`+pattern, name)
+		}
+		os.RemoveAll(dir)
+	}
+}
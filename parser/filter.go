@@ -0,0 +1,389 @@
+package parser
+
+import (
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FilterInclude keeps the events a filter matches.
+	FilterInclude = "include"
+	// FilterExclude drops the events a filter matches.
+	FilterExclude = "exclude"
+)
+
+var filterDirections = map[string]string{"inbound": "I", "outbound": "O", "in": "I", "out": "O"}
+
+// Filter matches events on their addresses, ports, protocol, direction,
+// action, rule, NSG and traffic type, and on any field of EventFields. An
+// event matches when it matches one value of every criteria set.
+//
+// Rules, NSGs and field values are globs using * and ?, ignoring case, or
+// regular expressions when enclosed in slashes, as in /^UserRule_.*$/.
+type Filter struct {
+	Name string `mapstructure:"name"`
+	// Mode is include or exclude.
+	Mode             string   `mapstructure:"mode"`
+	SourceCIDRs      []string `mapstructure:"source_cidrs"`
+	DestinationCIDRs []string `mapstructure:"destination_cidrs"`
+	// Ports are single ports or ranges, as in 1024-65535.
+	SourcePorts      []string `mapstructure:"source_ports"`
+	DestinationPorts []string `mapstructure:"destination_ports"`
+	// Protocols are tcp or udp.
+	Protocols []string `mapstructure:"protocols"`
+	// Directions are inbound or outbound.
+	Directions []string `mapstructure:"directions"`
+	// Actions are the actions of routes, see Route.
+	Actions []string `mapstructure:"actions"`
+	Rules   []string `mapstructure:"rules"`
+	Nsgs    []string `mapstructure:"nsgs"`
+	// TrafficTypes are the traffic types of TrafficClassifier.
+	TrafficTypes []string `mapstructure:"traffic_types"`
+	// ThreatFeeds are the names of the ThreatIntel feeds, which may use
+	// path.Match globs. "*" matches events matching any feed.
+	ThreatFeeds []string            `mapstructure:"threat_feeds"`
+	Fields      map[string][]string `mapstructure:"fields"`
+
+	sourceNetworks      []*net.IPNet
+	destinationNetworks []*net.IPNet
+	sourcePorts         []portRange
+	destinationPorts    []portRange
+	directions          map[string]bool
+	rules               []*regexp.Regexp
+	nsgs                []*regexp.Regexp
+	fields              map[string][]*regexp.Regexp
+	drops               metrics.Counter
+}
+
+// FilterChain applies filters in order. The first filter matching an event
+// decides whether it is kept. Events matching no filter are kept, unless the
+// default is exclude.
+type FilterChain struct {
+	filters        []*Filter
+	defaultExclude bool
+	defaultDrops   metrics.Counter
+}
+
+type portRange struct {
+	low, high int
+}
+
+// NewFilterChain validates filters and registers their drop counters for the
+// destination. defaultMode is include, exclude or empty for include.
+func NewFilterChain(destination string, filters []Filter, defaultMode string) (*FilterChain, error) {
+	chain := &FilterChain{
+		defaultDrops: metrics.GetOrRegisterCounter(fmt.Sprintf("filter_%s_default_drops", destination), nil),
+	}
+	switch defaultMode {
+	case "", FilterInclude:
+	case FilterExclude:
+		chain.defaultExclude = true
+	default:
+		return nil, fmt.Errorf("filter_default must be include or exclude")
+	}
+	names := map[string]bool{}
+	for i := range filters {
+		filter := filters[i]
+		if !destinationNameRegExp.MatchString(filter.Name) {
+			return nil, fmt.Errorf("invalid filter name %q. use letters, digits, - and _", filter.Name)
+		}
+		if names[filter.Name] || filter.Name == "default" {
+			return nil, fmt.Errorf("filter name %s is used twice", filter.Name)
+		}
+		names[filter.Name] = true
+		err := filter.compile()
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %s", filter.Name, err)
+		}
+		filter.drops = metrics.GetOrRegisterCounter(fmt.Sprintf("filter_%s_%s_drops", destination, filter.Name), nil)
+		chain.filters = append(chain.filters, &filter)
+	}
+	return chain, nil
+}
+
+func (filter *Filter) compile() error {
+	if filter.Mode != FilterInclude && filter.Mode != FilterExclude {
+		return fmt.Errorf("mode must be include or exclude")
+	}
+	if len(filter.SourceCIDRs)+len(filter.DestinationCIDRs)+len(filter.SourcePorts)+len(filter.DestinationPorts)+
+		len(filter.Protocols)+len(filter.Directions)+len(filter.Actions)+len(filter.Rules)+len(filter.Nsgs)+len(filter.TrafficTypes)+len(filter.ThreatFeeds)+len(filter.Fields) == 0 {
+		return fmt.Errorf("no criteria")
+	}
+	var err error
+	if filter.sourceNetworks, err = parseCIDRs(filter.SourceCIDRs); err != nil {
+		return err
+	}
+	if filter.destinationNetworks, err = parseCIDRs(filter.DestinationCIDRs); err != nil {
+		return err
+	}
+	if filter.sourcePorts, err = parsePortRanges(filter.SourcePorts); err != nil {
+		return err
+	}
+	if filter.destinationPorts, err = parsePortRanges(filter.DestinationPorts); err != nil {
+		return err
+	}
+	for _, protocol := range filter.Protocols {
+		if !strings.EqualFold(protocol, "tcp") && !strings.EqualFold(protocol, "udp") {
+			return fmt.Errorf("unknown protocol %q", protocol)
+		}
+	}
+	filter.directions = map[string]bool{}
+	for _, direction := range filter.Directions {
+		flow, ok := filterDirections[strings.ToLower(direction)]
+		if !ok {
+			return fmt.Errorf("unknown direction %q", direction)
+		}
+		filter.directions[flow] = true
+	}
+	for _, action := range filter.Actions {
+		if !routeActions[strings.ToLower(action)] {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+	for _, trafficType := range filter.TrafficTypes {
+		if !trafficTypes[strings.ToLower(trafficType)] {
+			return fmt.Errorf("unknown traffic type %q", trafficType)
+		}
+	}
+	for _, pattern := range filter.ThreatFeeds {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid threat feed pattern %q", pattern)
+		}
+	}
+	if filter.rules, err = compilePatterns(filter.Rules); err != nil {
+		return err
+	}
+	if filter.nsgs, err = compilePatterns(filter.Nsgs); err != nil {
+		return err
+	}
+	filter.fields = map[string][]*regexp.Regexp{}
+	for field, values := range filter.Fields {
+		if len(values) == 0 {
+			return fmt.Errorf("field %s has no values", field)
+		}
+		if filter.fields[strings.ToLower(field)], err = compilePatterns(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter returns the events kept by the chain.
+func (chain *FilterChain) Filter(events []*CEFEvent) []*CEFEvent {
+	kept := make([]*CEFEvent, 0, len(events))
+	for _, event := range events {
+		if chain.Keep(event) {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// Keep reports whether the chain keeps event and counts the drop if not.
+func (chain *FilterChain) Keep(event *CEFEvent) bool {
+	for _, filter := range chain.filters {
+		if !filter.Match(event) {
+			continue
+		}
+		if filter.Mode == FilterExclude {
+			filter.drops.Inc(1)
+			return false
+		}
+		return true
+	}
+	if chain.defaultExclude {
+		chain.defaultDrops.Inc(1)
+		return false
+	}
+	return true
+}
+
+// Match reports whether event matches every criteria set of the filter.
+// Events without a value for a criteria, such as Application Gateway events
+// for ports, do not match it.
+func (filter *Filter) Match(event *CEFEvent) bool {
+	extension := event.Extension
+	if len(filter.sourceNetworks) > 0 && !containsIP(filter.sourceNetworks, extension["src"]) {
+		return false
+	}
+	if len(filter.destinationNetworks) > 0 && !containsIP(filter.destinationNetworks, extension["dst"]) {
+		return false
+	}
+	if len(filter.sourcePorts) > 0 && !containsPort(filter.sourcePorts, extension["spt"]) {
+		return false
+	}
+	if len(filter.destinationPorts) > 0 && !containsPort(filter.destinationPorts, extension["dpt"]) {
+		return false
+	}
+	if len(filter.Protocols) > 0 && (extension["proto"] == "" || !containsFold(filter.Protocols, extension["proto"])) {
+		return false
+	}
+	if len(filter.directions) > 0 && !filter.directions[eventDirection(event)] {
+		return false
+	}
+	if len(filter.Actions) > 0 {
+		found := false
+		for _, action := range eventActions(event) {
+			if containsFold(filter.Actions, action) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.rules) > 0 && !matchAny(filter.rules, eventRule(event)) {
+		return false
+	}
+	if len(filter.nsgs) > 0 {
+		family := event.LogFamily()
+		if family != LogFamilyNsgFlow && family != LogFamilyNsgEvent || !matchAny(filter.nsgs, extension["cs2"]) {
+			return false
+		}
+	}
+	if len(filter.TrafficTypes) > 0 && !containsFold(filter.TrafficTypes, extension[TrafficTypeKey]) {
+		return false
+	}
+	if len(filter.ThreatFeeds) > 0 && !matchThreatFeeds(filter.ThreatFeeds, event) {
+		return false
+	}
+	if len(filter.fields) > 0 {
+		fields := map[string]string{}
+		for field, value := range EventFields(event) {
+			fields[strings.ToLower(field)] = value
+		}
+		for field, patterns := range filter.fields {
+			value, ok := fields[field]
+			if !ok || !matchAny(patterns, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// eventDirection returns I or O for flows and NSG events.
+func eventDirection(event *CEFEvent) string {
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		switch event.Extension["deviceDirection"] {
+		case "0":
+			return "I"
+		case "1":
+			return "O"
+		}
+	case LogFamilyNsgEvent:
+		properties, err := recordProperties(event)
+		if err == nil {
+			return filterDirections[strings.ToLower(propertyText(properties, "direction"))]
+		}
+	}
+	return ""
+}
+
+// eventRule returns the NSG rule of flows and NSG events, and the rule id of
+// Application Gateway firewall events.
+func eventRule(event *CEFEvent) string {
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		return event.Extension["cs1"]
+	case LogFamilyNsgEvent, LogFamilyAppGwFirewall:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return ""
+		}
+		if event.LogFamily() == LogFamilyNsgEvent {
+			return propertyText(properties, "ruleName")
+		}
+		return propertyText(properties, "ruleId")
+	}
+	return ""
+}
+
+// compilePatterns compiles globs, and regular expressions enclosed in
+// slashes.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		expression := "(?i)^" + strings.Replace(strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1), `\?`, ".", -1) + "$"
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expression = pattern[1 : len(pattern)-1]
+		}
+		var err error
+		compiled[i], err = regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	return compiled, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		networks[i] = network
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePortRanges(ports []string) ([]portRange, error) {
+	ranges := make([]portRange, len(ports))
+	for i, port := range ports {
+		bounds := strings.SplitN(port, "-", 2)
+		low, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		high := low
+		if err == nil && len(bounds) == 2 {
+			high, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		}
+		if err != nil || low < 0 || high > 65535 || low > high {
+			return nil, fmt.Errorf("invalid port range %q", port)
+		}
+		ranges[i] = portRange{low, high}
+	}
+	return ranges, nil
+}
+
+func containsPort(ranges []portRange, value string) bool {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return false
+	}
+	for _, portRange := range ranges {
+		if port >= portRange.low && port <= portRange.high {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package parser
+
+import (
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewFilterChain(t *testing.T) {
+	invalid := []Filter{
+		{Name: "east west", Mode: FilterExclude, Protocols: []string{"tcp"}},
+		{Name: "east_west", Mode: "drop", Protocols: []string{"tcp"}},
+		{Name: "east_west", Mode: FilterExclude},
+		{Name: "east_west", Mode: FilterExclude, SourceCIDRs: []string{"10.0.0.0/33"}},
+		{Name: "east_west", Mode: FilterExclude, DestinationPorts: []string{"8080-80"}},
+		{Name: "east_west", Mode: FilterExclude, SourcePorts: []string{"65536"}},
+		{Name: "east_west", Mode: FilterExclude, Protocols: []string{"icmp"}},
+		{Name: "east_west", Mode: FilterExclude, Directions: []string{"sideways"}},
+		{Name: "east_west", Mode: FilterExclude, Actions: []string{"drop"}},
+		{Name: "east_west", Mode: FilterExclude, Rules: []string{"/[/"}},
+		{Name: "east_west", Mode: FilterExclude, Fields: map[string][]string{"httpStatus": nil}},
+	}
+	for _, filter := range invalid {
+		_, err := NewFilterChain("test", []Filter{filter}, "")
+		assert.Error(t, err, "%+v", filter)
+	}
+	_, err := NewFilterChain("test", nil, "drop")
+	assert.Error(t, err)
+	_, err = NewFilterChain("test", []Filter{{Name: "a", Mode: FilterInclude, Protocols: []string{"tcp"}},
+		{Name: "a", Mode: FilterExclude, Protocols: []string{"udp"}}}, "")
+	assert.Error(t, err)
+}
+
+func TestFilterMatchFlow(t *testing.T) {
+	// 10.193.160.4:46010 to 40.85.232.72:443, TCP, outbound, allowed.
+	flow := loadTestEvents("nsg_flow_events.json", t)[0]
+	matches := []Filter{
+		{SourceCIDRs: []string{"10.0.0.0/8"}, DestinationCIDRs: []string{"40.85.0.0/16", "fd00::/8"}},
+		{SourcePorts: []string{"1024-65535"}, DestinationPorts: []string{"80", "443"}},
+		{Protocols: []string{"TCP"}, Directions: []string{"outbound"}, Actions: []string{"allow"}},
+		{Nsgs: []string{"nsgname-*"}},
+		{Rules: []string{flow.Extension["cs1"]}},
+		{Rules: []string{"/^" + flow.Extension["cs1"][:4] + "/"}},
+		{Fields: map[string][]string{"subscriptionid": {"SUBID"}}},
+	}
+	misses := []Filter{
+		{DestinationCIDRs: []string{"10.0.0.0/8"}},
+		{DestinationPorts: []string{"1-442"}},
+		{Protocols: []string{"udp"}},
+		{Directions: []string{"in"}},
+		{Actions: []string{"deny"}},
+		{Nsgs: []string{"other-nsg"}},
+		{Rules: []string{"/^NoSuchRule$/"}},
+		{Fields: map[string][]string{"httpStatus": {"*"}}},
+	}
+	for _, filter := range matches {
+		filter.Name, filter.Mode = "test", FilterExclude
+		require.Nil(t, filter.compile())
+		assert.True(t, filter.Match(flow), "%+v", filter)
+	}
+	for _, filter := range misses {
+		filter.Name, filter.Mode = "test", FilterExclude
+		require.Nil(t, filter.compile())
+		assert.False(t, filter.Match(flow), "%+v", filter)
+	}
+}
+
+func TestFilterMatchAppGw(t *testing.T) {
+	firewall := loadTestAppGwFirewallEvents(t)[0]
+	access := loadTestAppGwEvents(t)[0]
+	filter := Filter{Name: "test", Mode: FilterExclude, Actions: []string{"detected"}, Fields: map[string][]string{"ruleSetType": {"owasp"}}}
+	require.Nil(t, filter.compile())
+	assert.True(t, filter.Match(firewall))
+	assert.False(t, filter.Match(access))
+
+	status := EventFields(access)["httpStatus"]
+	require.NotEmpty(t, status)
+	filter = Filter{Name: "test", Mode: FilterExclude, Fields: map[string][]string{"httpStatus": {status[:1] + "*"}}}
+	require.Nil(t, filter.compile())
+	assert.True(t, filter.Match(access))
+	filter = Filter{Name: "test", Mode: FilterExclude, Fields: map[string][]string{"httpStatus": {"/^9/"}}}
+	require.Nil(t, filter.compile())
+	assert.False(t, filter.Match(access))
+
+	// AppGW events have no NSG, destination port or protocol.
+	for _, filter := range []Filter{{Nsgs: []string{"*"}}, {DestinationPorts: []string{"0-65535"}}, {Protocols: []string{"tcp"}}} {
+		filter.Name, filter.Mode = "test", FilterExclude
+		require.Nil(t, filter.compile())
+		assert.False(t, filter.Match(firewall))
+	}
+}
+
+func TestFilterChain(t *testing.T) {
+	flows := loadTestEvents("nsg_flow_events_v2.json", t)
+	firewall := loadTestAppGwFirewallEvents(t)
+	events := append(append([]*CEFEvent{}, flows...), firewall...)
+
+	chain, err := NewFilterChain("test", []Filter{
+		{Name: "test_keep_denies", Mode: FilterInclude, Actions: []string{"deny"}},
+		{Name: "test_drop_flows", Mode: FilterExclude, Protocols: []string{"tcp", "udp"}},
+	}, "")
+	require.Nil(t, err)
+	drops := chain.filters[1].drops.Count()
+	kept := chain.Filter(events)
+	denies := 0
+	for _, event := range flows {
+		if event.Extension["categoryOutcome"] == "Deny" {
+			denies++
+		}
+	}
+	assert.Len(t, kept, denies+len(firewall))
+	assert.Equal(t, int64(len(flows)-denies), chain.filters[1].drops.Count()-drops)
+
+	chain, err = NewFilterChain("test", []Filter{{Name: "test_waf", Mode: FilterInclude, Fields: map[string][]string{"action": {"Detected"}}}}, FilterExclude)
+	require.Nil(t, err)
+	defaultDrops := chain.defaultDrops.Count()
+	assert.Len(t, chain.Filter(events), len(firewall))
+	assert.Equal(t, int64(len(flows)), chain.defaultDrops.Count()-defaultDrops)
+}
+
+func TestFilterChainCountersPerDestination(t *testing.T) {
+	filters := []Filter{{Name: "denies", Mode: FilterInclude, Actions: []string{"deny"}}}
+	siem, err := NewFilterChain("siem", filters, FilterExclude)
+	require.Nil(t, err)
+	archive, err := NewFilterChain("archive", filters, FilterExclude)
+	require.Nil(t, err)
+
+	siemDrops := metrics.GetOrRegisterCounter("filter_siem_default_drops", nil)
+	before := archive.defaultDrops.Count()
+	siem.Filter(loadTestEvents("nsg_flow_events.json", t))
+	assert.True(t, siemDrops.Count() > 0)
+	assert.Equal(t, before, archive.defaultDrops.Count(), "each destination counts its own drops")
+	assert.NotNil(t, metrics.DefaultRegistry.Get("filter_archive_denies_drops"))
+}
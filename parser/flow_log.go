@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const nsgResourceIDFormat = "/SUBSCRIPTIONS/%s/RESOURCEGROUPS/%s/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/%s"
+
+// NsgFlowLog is the flat, typed record for a single NSG flow tuple.
+// Field names and types are stable; the version 2 fields are omitted for
+// version 1 tuples and the counters for tuples that do not carry them.
+type NsgFlowLog struct {
+	Time            int64  `json:"time"`
+	SystemID        string `json:"systemId"`
+	Category        string `json:"category"`
+	ResourceID      string `json:"resourceId"`
+	OperationName   string `json:"operationName"`
+	SubscriptionID  string `json:"subscriptionId"`
+	ResourceGroup   string `json:"resourceGroup"`
+	NsgName         string `json:"nsgName"`
+	Rule            string `json:"rule"`
+	Mac             string `json:"mac"`
+	SourceIP        string `json:"sourceIp"`
+	DestinationIP   string `json:"destinationIp"`
+	SourcePort      int    `json:"sourcePort"`
+	DestinationPort int    `json:"destinationPort"`
+	Protocol        string `json:"protocol"`
+	TrafficFlow     string `json:"trafficFlow"`
+	Traffic         string `json:"traffic"`
+	Version         int    `json:"version"`
+	FlowState       string `json:"flowState,omitempty"`
+	PacketsSent     *int64 `json:"packetsSourceToDestination,omitempty"`
+	BytesSent       *int64 `json:"bytesSourceToDestination,omitempty"`
+	PacketsReceived *int64 `json:"packetsDestinationToSource,omitempty"`
+	BytesReceived   *int64 `json:"bytesDestinationToSource,omitempty"`
+	// LastSeen and TupleCount are set on aggregated flows, see
+	// FlowAggregator.
+	LastSeen   int64 `json:"lastSeen,omitempty"`
+	TupleCount int64 `json:"tupleCount,omitempty"`
+	// The traffic labels are set on classified flows, see
+	// TrafficClassifier.
+	TrafficType           string `json:"trafficType,omitempty"`
+	SourceNetwork         string `json:"sourceNetwork,omitempty"`
+	DestinationNetwork    string `json:"destinationNetwork,omitempty"`
+	SourceServiceTag      string `json:"sourceServiceTag,omitempty"`
+	DestinationServiceTag string `json:"destinationServiceTag,omitempty"`
+}
+
+// NewNsgFlowLog flattens an nsg_flow event back into the tuple fields it was
+// parsed from.
+func NewNsgFlowLog(event *CEFEvent) (*NsgFlowLog, error) {
+	if event.LogFamily() != LogFamilyNsgFlow {
+		return nil, fmt.Errorf("cannot create flow log from %s event", event.LogFamily())
+	}
+	extension := event.Extension
+	flowLog := &NsgFlowLog{
+		Time:           event.Time.Unix(),
+		SystemID:       extension["deviceExternalId"],
+		Category:       event.Name,
+		ResourceID:     fmt.Sprintf(nsgResourceIDFormat, extension["cs3"], extension["cs4"], extension["cs2"]),
+		OperationName:  event.DeviceEventClassId,
+		SubscriptionID: extension["cs3"],
+		ResourceGroup:  extension["cs4"],
+		NsgName:        extension["cs2"],
+		Rule:           extension["cs1"],
+		SourceIP:       extension["src"],
+		DestinationIP:  extension["dst"],
+		Protocol:       tupleValue(protocolMap, extension["proto"]),
+		Traffic:        tupleValue(cefOutcomeMap, extension["categoryOutcome"]),
+		Version:        1,
+
+		TrafficType:           extension[TrafficTypeKey],
+		SourceNetwork:         extension["source"+NetworkKey],
+		DestinationNetwork:    extension["destination"+NetworkKey],
+		SourceServiceTag:      extension["source"+ServiceTagKey],
+		DestinationServiceTag: extension["destination"+ServiceTagKey],
+	}
+	flowLog.SourcePort, _ = strconv.Atoi(extension["spt"])
+	flowLog.DestinationPort, _ = strconv.Atoi(extension["dpt"])
+
+	switch extension["deviceDirection"] {
+	case "0":
+		flowLog.TrafficFlow = "I"
+		flowLog.Mac = extension["dmac"]
+	case "1":
+		flowLog.TrafficFlow = "O"
+		flowLog.Mac = extension["smac"]
+	}
+
+	if state, ok := extension["cs5"]; ok {
+		flowLog.Version = 2
+		flowLog.FlowState = tupleValue(flowStateMap, state)
+	}
+	if extension["cn1"] != "" {
+		flowLog.PacketsSent = parseCounter(extension["cn1"])
+		flowLog.BytesSent = parseCounter(extension["out"])
+		flowLog.PacketsReceived = parseCounter(extension["cn2"])
+		flowLog.BytesReceived = parseCounter(extension["in"])
+	}
+	if extension["cnt"] != "" {
+		flowLog.TupleCount, _ = strconv.ParseInt(extension["cnt"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(extension["end"], 10, 64)
+		flowLog.LastSeen = lastSeen / 1000
+	}
+	return flowLog, nil
+}
+
+// tupleValue returns the single letter tuple code that maps to value.
+func tupleValue(codes map[string]string, value string) string {
+	for code, mapped := range codes {
+		if mapped == value {
+			return code
+		}
+	}
+	return ""
+}
+
+func parseCounter(value string) *int64 {
+	counter, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &counter
+}
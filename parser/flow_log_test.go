@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewNsgFlowLog(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events.json", t)
+	flowLog, err := NewNsgFlowLog(events[0])
+	require.Nil(t, err)
+	assert.Equal(t, int64(1497038813), flowLog.Time)
+	assert.Equal(t, "fe485b0f-4e32-4dc2-ad20-ba20243985d3", flowLog.SystemID)
+	assert.Equal(t, "NetworkSecurityGroupFlowEvent", flowLog.Category)
+	assert.Equal(t, "NetworkSecurityGroupFlowEvents", flowLog.OperationName)
+	assert.Equal(t, "/SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSGNAME-NSG", flowLog.ResourceID)
+	assert.Equal(t, "SUBID", flowLog.SubscriptionID)
+	assert.Equal(t, "RGNAME", flowLog.ResourceGroup)
+	assert.Equal(t, "NSGNAME-NSG", flowLog.NsgName)
+	assert.Equal(t, "DefaultRule_AllowVnetOutBound", flowLog.Rule)
+	assert.Equal(t, "00:0D:3A:F3:38:54", flowLog.Mac)
+	assert.Equal(t, "10.193.160.4", flowLog.SourceIP)
+	assert.Equal(t, "40.85.232.72", flowLog.DestinationIP)
+	assert.Equal(t, 46010, flowLog.SourcePort)
+	assert.Equal(t, 443, flowLog.DestinationPort)
+	assert.Equal(t, "T", flowLog.Protocol)
+	assert.Equal(t, "O", flowLog.TrafficFlow)
+	assert.Equal(t, "A", flowLog.Traffic)
+	assert.Equal(t, 1, flowLog.Version)
+	assert.Equal(t, "", flowLog.FlowState)
+	assert.Nil(t, flowLog.PacketsSent)
+
+	_, err = NewNsgFlowLog(loadTestAppGwEvents(t)[0])
+	assert.Error(t, err)
+}
+
+func TestNewNsgFlowLogV2(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+
+	denied, err := NewNsgFlowLog(events[0])
+	require.Nil(t, err)
+	assert.Equal(t, 2, denied.Version)
+	assert.Equal(t, "B", denied.FlowState)
+	assert.Equal(t, "U", denied.Protocol)
+	assert.Equal(t, "I", denied.TrafficFlow)
+	assert.Equal(t, "D", denied.Traffic)
+	assert.Nil(t, denied.BytesSent)
+
+	ipv6, err := NewNsgFlowLog(events[5])
+	require.Nil(t, err)
+	assert.Equal(t, "E", ipv6.FlowState)
+	require.NotNil(t, ipv6.PacketsSent)
+	assert.Equal(t, int64(52), *ipv6.PacketsSent)
+	assert.Equal(t, int64(29952), *ipv6.BytesSent)
+	assert.Equal(t, int64(47), *ipv6.PacketsReceived)
+	assert.Equal(t, int64(27072), *ipv6.BytesReceived)
+}
+
+func TestFlatFormatter(t *testing.T) {
+	formatter, err := NewEventFormatter(FormatFlat)
+	require.Nil(t, err)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	out, err := formatter.Format(events[3])
+	require.Nil(t, err)
+	record := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(out, &record))
+	assert.Equal(t, float64(59932), record["sourcePort"])
+	assert.Equal(t, float64(66), record["bytesSourceToDestination"])
+	assert.Equal(t, "E", record["flowState"])
+	assert.NotContains(t, record, "extension")
+
+	out, err = formatter.Format(events[0])
+	require.Nil(t, err)
+	assert.NotContains(t, string(out), "packetsSourceToDestination")
+
+	appGwEvent := loadTestAppGwEvents(t)[0]
+	out, err = formatter.Format(appGwEvent)
+	require.Nil(t, err)
+	expected, _ := json.Marshal(appGwEvent)
+	assert.Equal(t, expected, out)
+}
@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+	FormatFlat = "flat"
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+	// FormatProtobuf renders nsgpb.Event messages, see nsgpb/nsg.proto.
+	FormatProtobuf = "protobuf"
+	// FormatECS and FormatOCSF render json documents of the ecs and ocsf
+	// schemas, see NewSchemaDocument.
+	FormatECS  = SchemaECS
+	FormatOCSF = SchemaOCSF
+)
+
+// DefaultColumns are the delimited columns of each log family when none are
+// configured.
+var DefaultColumns = map[string][]string{
+	LogFamilyNsgFlow: {"time", "nsgName", "rule", "sourceIp", "sourcePort", "destinationIp", "destinationPort",
+		"protocol", "trafficFlow", "traffic", "flowState", "bytesSourceToDestination", "bytesDestinationToSource"},
+	LogFamilyNsgEvent:      {"time", "category", "operationName", "subscriptionId", "resourceGroup", "nsgName"},
+	LogFamilyAppGwAccess:   {"time", "appGatewayName", "clientIP", "httpMethod", "requestUri", "httpStatus", "sentBytes", "timeTaken"},
+	LogFamilyAppGwFirewall: {"time", "appGatewayName", "clientIp", "requestUri", "ruleId", "action", "message"},
+}
+
+// EventFormatter renders a single event as the payload of a message.
+type EventFormatter interface {
+	Format(event *CEFEvent) ([]byte, error)
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(event *CEFEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+type cefFormatter struct{}
+
+func (cefFormatter) Format(event *CEFEvent) ([]byte, error) {
+	text, err := event.CEFText()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(text), nil
+}
+
+// flatFormatter renders nsg_flow events as NsgFlowLog records. Other log
+// families have no flat schema and are rendered as json.
+type flatFormatter struct{}
+
+func (flatFormatter) Format(event *CEFEvent) ([]byte, error) {
+	if event.LogFamily() != LogFamilyNsgFlow {
+		return json.Marshal(event)
+	}
+	flowLog, err := NewNsgFlowLog(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(flowLog)
+}
+
+// RecordFramer is implemented by formatters whose records are not lines of
+// text, and frame records themselves when written to a stream.
+type RecordFramer interface {
+	Frame(record []byte) []byte
+}
+
+// FrameRecord returns record as written to a file or stream: framed by the
+// formatter if it is a RecordFramer and newline terminated otherwise.
+func FrameRecord(formatter EventFormatter, record []byte) []byte {
+	if framer, ok := formatter.(RecordFramer); ok {
+		return framer.Frame(record)
+	}
+	return append(record, '\n')
+}
+
+// HeaderFormatter is implemented by formatters whose output starts with a
+// header line, which depends on the log family.
+type HeaderFormatter interface {
+	EventFormatter
+	Header(family string) ([]byte, error)
+}
+
+// DelimitedFormatter renders events as CSV or TSV records with an ordered
+// column list per log family. Fields are named as in EventFields, and missing
+// fields are left empty. Values are quoted as in RFC 4180.
+type DelimitedFormatter struct {
+	delimiter rune
+	columns   map[string][]string
+}
+
+// NewDelimitedFormatter returns a csv or tsv formatter. columns override
+// DefaultColumns per log family and must be KnownFields of that family.
+func NewDelimitedFormatter(format string, columns map[string][]string) (*DelimitedFormatter, error) {
+	formatter := &DelimitedFormatter{columns: map[string][]string{}}
+	switch strings.ToLower(format) {
+	case FormatCSV:
+		formatter.delimiter = ','
+	case FormatTSV:
+		formatter.delimiter = '\t'
+	default:
+		return nil, fmt.Errorf("unsupported delimited format %q. expected %s or %s", format, FormatCSV, FormatTSV)
+	}
+	for family, familyColumns := range DefaultColumns {
+		formatter.columns[family] = familyColumns
+	}
+	for family, familyColumns := range columns {
+		if _, ok := DefaultColumns[family]; !ok {
+			return nil, fmt.Errorf("columns configured for unknown log family %q", family)
+		}
+		if len(familyColumns) == 0 {
+			return nil, fmt.Errorf("no columns configured for %s", family)
+		}
+		known := KnownFields(family)
+		for _, column := range familyColumns {
+			if !known[column] {
+				return nil, fmt.Errorf("unknown column %q configured for %s", column, family)
+			}
+		}
+		formatter.columns[family] = familyColumns
+	}
+	return formatter, nil
+}
+
+// Columns returns the columns of family.
+func (formatter *DelimitedFormatter) Columns(family string) []string {
+	return formatter.columns[family]
+}
+
+func (formatter *DelimitedFormatter) Header(family string) ([]byte, error) {
+	columns, ok := formatter.columns[family]
+	if !ok {
+		return nil, fmt.Errorf("no columns for log family %q", family)
+	}
+	return formatter.record(columns)
+}
+
+func (formatter *DelimitedFormatter) Format(event *CEFEvent) ([]byte, error) {
+	columns, ok := formatter.columns[event.LogFamily()]
+	if !ok {
+		return nil, fmt.Errorf("no columns for log family %q", event.LogFamily())
+	}
+	fields := EventFields(event)
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fields[column]
+	}
+	return formatter.record(values)
+}
+
+// record renders a single record without the line terminator.
+func (formatter *DelimitedFormatter) record(values []string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	writer.Comma = formatter.delimiter
+	writer.Write(values)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// ParseColumns parses family=column,column lists as given on the command line.
+func ParseColumns(settings []string) (map[string][]string, error) {
+	columns := map[string][]string{}
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid column list %q. expected family=column,column", setting)
+		}
+		for _, column := range strings.Split(parts[1], ",") {
+			columns[parts[0]] = append(columns[parts[0]], strings.TrimSpace(column))
+		}
+	}
+	return columns, nil
+}
+
+var formatNames = []string{FormatJSON, FormatCEF, FormatFlat, FormatCSV, FormatTSV, FormatProtobuf, FormatECS, FormatOCSF, FormatZeek, FormatZeekJSON, FormatEVE, FormatTemplate}
+
+// FormatOptions configures the formats that need more than a name.
+type FormatOptions struct {
+	// Columns are the csv and tsv columns per log family.
+	Columns map[string][]string
+	// Templates are the template files per log family of the template format.
+	Templates map[string]string
+}
+
+// NewEventFormatter returns the formatter registered under name. An empty
+// name selects json.
+func NewEventFormatter(name string) (EventFormatter, error) {
+	return NewEventFormatterWithOptions(name, FormatOptions{})
+}
+
+// NewEventFormatterWithOptions is NewEventFormatter with the columns and
+// templates of the formats using them. Other formats ignore options.
+func NewEventFormatterWithOptions(name string, options FormatOptions) (EventFormatter, error) {
+	switch strings.ToLower(name) {
+	case "", FormatJSON:
+		return jsonFormatter{}, nil
+	case FormatCEF:
+		return cefFormatter{}, nil
+	case FormatFlat:
+		return flatFormatter{}, nil
+	case FormatCSV, FormatTSV:
+		return NewDelimitedFormatter(name, options.Columns)
+	case FormatProtobuf:
+		return protobufFormatter{}, nil
+	case FormatECS, FormatOCSF:
+		return schemaFormatter{schema: strings.ToLower(name)}, nil
+	case FormatZeek:
+		return zeekFormatter{}, nil
+	case FormatZeekJSON:
+		return zeekJSONFormatter{}, nil
+	case FormatEVE:
+		return eveFormatter{}, nil
+	case FormatTemplate:
+		return NewTemplateFormatter(options.Templates)
+	default:
+		return nil, fmt.Errorf("unsupported format %q. expected one of %s", name, strings.Join(formatNames, ", "))
+	}
+}
@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"encoding/csv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEventFields(t *testing.T) {
+	fields := EventFields(loadTestEvents("nsg_flow_events.json", t)[0])
+	assert.Equal(t, "2017-06-09T20:06:53Z", fields["time"])
+	assert.Equal(t, "nsg_flow", fields["logFamily"])
+	assert.Equal(t, "10.193.160.4", fields["sourceIp"])
+	assert.Equal(t, "46010", fields["sourcePort"])
+	assert.Equal(t, "NSGNAME-NSG", fields["nsgName"])
+	assert.Equal(t, "10.193.160.4", fields["src"], "extension keys are fields")
+
+	fields = EventFields(loadTestEvents("nsg_flow_events_v2.json", t)[5])
+	assert.Equal(t, "52", fields["packetsSourceToDestination"])
+
+	fields = EventFields(loadTestAppGwFirewallEvents(t)[0])
+	assert.Equal(t, "960015", fields["ruleId"])
+	assert.Equal(t, "Warning. Operator EQ matched 0 at REQUEST_HEADERS.", fields["details.message"])
+	assert.NotEmpty(t, fields["appGatewayName"])
+
+	fields = EventFields(loadTestAppGwEvents(t)[0])
+	assert.NotEmpty(t, fields["httpStatus"])
+	assert.NotContains(t, fields["sentBytes"], "e+")
+}
+
+func TestKnownFields(t *testing.T) {
+	geoip, geoipDir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(geoipDir)
+	classifier, classifierDir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(classifierDir)
+
+	events := append(loadTestEvents("nsg_flow_events.json", t), loadTestEvents("nsg_flow_events_v2.json", t)...)
+	events = append(append(events, loadTestAppGwEvents(t)...), loadTestAppGwFirewallEvents(t)...)
+	events = append(events, geoIPTestEvent(t, testGeoIPLinkoping))
+	for _, event := range events {
+		geoip.Enrich(event)
+		classifier.Enrich(event)
+		known := KnownFields(event.LogFamily())
+		for field := range EventFields(event) {
+			assert.True(t, known[field], "%s field %s", event.LogFamily(), field)
+		}
+	}
+	assert.True(t, KnownFields(LogFamilyNsgFlow)["destinationCountryCode"])
+	assert.True(t, KnownFields(LogFamilyNsgEvent)["conditions.sourceIP"])
+	assert.False(t, KnownFields(LogFamilyNsgFlow)["clientIP"])
+}
+
+func TestDelimitedFormatter(t *testing.T) {
+	formatter, err := NewDelimitedFormatter(FormatCSV, map[string][]string{
+		LogFamilyNsgFlow: {"time", "sourceIp", "rule", "threatDescription"},
+	})
+	require.Nil(t, err)
+	header, err := formatter.Header(LogFamilyNsgFlow)
+	require.Nil(t, err)
+	assert.Equal(t, "time,sourceIp,rule,threatDescription", string(header))
+	assert.Equal(t, DefaultColumns[LogFamilyAppGwAccess], formatter.Columns(LogFamilyAppGwAccess))
+
+	event := loadTestEvents("nsg_flow_events.json", t)[0]
+	event.Extension["cs1"] = "Rule \"A\", with comma"
+	event.Extension[ThreatDescriptionKey] = "line\nbreak"
+	line, err := formatter.Format(event)
+	require.Nil(t, err)
+	assert.Equal(t, "2017-06-09T20:06:53Z,10.193.160.4,\"Rule \"\"A\"\", with comma\",\"line\nbreak\"", string(line))
+	records, err := csv.NewReader(strings.NewReader(string(line))).ReadAll()
+	require.Nil(t, err)
+	assert.Equal(t, [][]string{{"2017-06-09T20:06:53Z", "10.193.160.4", "Rule \"A\", with comma", "line\nbreak"}}, records)
+
+	tsv, err := NewEventFormatter(FormatTSV)
+	require.Nil(t, err)
+	line, err = tsv.Format(event)
+	require.Nil(t, err)
+	assert.Len(t, strings.Split(string(line), "\t"), len(DefaultColumns[LogFamilyNsgFlow]))
+
+	_, err = NewDelimitedFormatter(FormatCSV, map[string][]string{"nsg_flows": {"time"}})
+	assert.NotNil(t, err)
+	_, err = NewDelimitedFormatter(FormatCSV, map[string][]string{LogFamilyNsgFlow: {}})
+	assert.NotNil(t, err)
+	_, err = NewDelimitedFormatter(FormatCSV, map[string][]string{LogFamilyNsgFlow: {"time", "sourceIP"}})
+	assert.NotNil(t, err, "column names are case sensitive")
+	_, err = NewDelimitedFormatter(FormatCSV, map[string][]string{LogFamilyAppGwFirewall: {"time", "details.message"}})
+	assert.Nil(t, err)
+	_, err = NewEventFormatter("xml")
+	assert.NotNil(t, err)
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns([]string{"nsg_flow=time, sourceIp", "appgw_access=clientIP"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		LogFamilyNsgFlow:     {"time", "sourceIp"},
+		LogFamilyAppGwAccess: {"clientIP"},
+	}, columns)
+	_, err = ParseColumns([]string{"nsg_flow"})
+	assert.NotNil(t, err)
+}
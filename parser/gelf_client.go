@@ -0,0 +1,337 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GelfProtocolUDP = "udp"
+	GelfProtocolTCP = "tcp"
+
+	GelfCompressionGzip = "gzip"
+	GelfCompressionZlib = "zlib"
+	GelfCompressionNone = "none"
+
+	gelfVersion          = "1.1"
+	gelfDefaultHost      = "nsg-parser"
+	gelfDefaultChunkSize = 1420
+	gelfMinChunkSize     = 512
+	gelfMaxChunks        = 128
+	gelfChunkHeaderSize  = 12
+)
+
+var (
+	gelfChunkMagic    = []byte{0x1e, 0x0f}
+	gelfFieldRegExp   = regexp.MustCompile(`[^\w.\-]+`)
+	gelfSkippedFields = map[string]bool{"msg": true, "cs2": true}
+	gelfNumericFields = regexp.MustCompile(`^(spt|dpt|cn[0-9]|in|out|cnt|deviceDirection|start|end)$`)
+)
+
+// GelfConfig holds the settings for the Graylog GELF destination.
+// UDP messages are compressed and chunked. TCP messages are null byte delimited
+// and cannot be compressed.
+type GelfConfig struct {
+	Address            string `mapstructure:"gelf_address"`
+	Protocol           string `mapstructure:"gelf_protocol"`
+	Compression        string `mapstructure:"gelf_compression"`
+	ChunkSize          int    `mapstructure:"gelf_chunk_size"`
+	Host               string `mapstructure:"gelf_host"`
+	TLS                bool   `mapstructure:"gelf_tls"`
+	TLSCAFile          string `mapstructure:"gelf_tls_ca_file"`
+	TLSCertFile        string `mapstructure:"gelf_tls_cert_file"`
+	TLSKeyFile         string `mapstructure:"gelf_tls_key_file"`
+	InsecureSkipVerify bool   `mapstructure:"gelf_tls_insecure_skip_verify"`
+}
+
+// GelfClient sends events as GELF 1.1 messages. NSG and Application Gateway
+// fields become _ prefixed additional fields.
+type GelfClient struct {
+	config      GelfConfig
+	tlsConfig   *tls.Config
+	conn        net.Conn
+	initialized bool
+}
+
+func (client *GelfClient) Initialize(config GelfConfig) error {
+	if config.Address == "" {
+		return fmt.Errorf("gelf_address is required for the gelf destination")
+	}
+	config.Protocol = strings.ToLower(config.Protocol)
+	if config.Protocol == "" {
+		config.Protocol = GelfProtocolUDP
+	}
+	if config.Protocol != GelfProtocolUDP && config.Protocol != GelfProtocolTCP {
+		return fmt.Errorf("unsupported gelf_protocol %q. expected udp or tcp", config.Protocol)
+	}
+	config.Compression = strings.ToLower(config.Compression)
+	switch config.Compression {
+	case "":
+		config.Compression = GelfCompressionGzip
+	case GelfCompressionGzip, GelfCompressionZlib, GelfCompressionNone:
+	default:
+		return fmt.Errorf("unsupported gelf_compression %q. expected gzip, zlib or none", config.Compression)
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = gelfDefaultChunkSize
+	}
+	if config.ChunkSize < gelfMinChunkSize {
+		return fmt.Errorf("gelf_chunk_size must be at least %d", gelfMinChunkSize)
+	}
+	if config.TLS {
+		if config.Protocol != GelfProtocolTCP {
+			return fmt.Errorf("gelf_tls requires gelf_protocol tcp")
+		}
+		tlsConfig, err := newTLSConfig(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.InsecureSkipVerify)
+		if err != nil {
+			return err
+		}
+		client.tlsConfig = tlsConfig
+	}
+
+	client.config = config
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"address":  config.Address,
+		"protocol": config.Protocol,
+	}).Info("initialized gelf client")
+	return nil
+}
+
+func (client *GelfClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents writes every event to the connection. UDP delivery is best
+// effort, so with UDP a nil error only means the datagrams were sent.
+func (client *GelfClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized gelf client")
+	}
+	if client.conn == nil {
+		err := client.connect()
+		if err != nil {
+			return err
+		}
+	}
+	for _, event := range events {
+		message, err := json.Marshal(client.newGelfMessage(event))
+		if err != nil {
+			return fmt.Errorf("error marshalling to json %s", err)
+		}
+		if client.config.Protocol == GelfProtocolTCP {
+			err = client.writeTCP(message)
+		} else {
+			err = client.writeUDP(message)
+		}
+		if err != nil {
+			client.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection, if any.
+func (client *GelfClient) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	err := client.conn.Close()
+	client.conn = nil
+	return err
+}
+
+func (client *GelfClient) connect() error {
+	var conn net.Conn
+	var err error
+	if client.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", client.config.Address, client.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout(client.config.Protocol, client.config.Address, 30*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to gelf %s: %s", client.config.Address, err)
+	}
+	client.conn = conn
+	return nil
+}
+
+func (client *GelfClient) writeTCP(message []byte) error {
+	client.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := client.conn.Write(append(message, 0))
+	return err
+}
+
+func (client *GelfClient) writeUDP(message []byte) error {
+	payload, err := gelfCompress(message, client.config.Compression)
+	if err != nil {
+		return err
+	}
+	chunks, err := gelfChunks(payload, client.config.ChunkSize)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err = client.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gelfCompress(message []byte, compression string) ([]byte, error) {
+	var compressed bytes.Buffer
+	switch compression {
+	case GelfCompressionGzip:
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(message)
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case GelfCompressionZlib:
+		writer := zlib.NewWriter(&compressed)
+		writer.Write(message)
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return message, nil
+	}
+	return compressed.Bytes(), nil
+}
+
+// gelfChunks splits payload into GELF chunks of at most chunkSize bytes.
+// Payloads that fit in one datagram are returned unchunked.
+func gelfChunks(payload []byte, chunkSize int) ([][]byte, error) {
+	if len(payload) <= chunkSize {
+		return [][]byte{payload}, nil
+	}
+	dataSize := chunkSize - gelfChunkHeaderSize
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("gelf message of %d bytes needs %d chunks. the maximum is %d", len(payload), count, gelfMaxChunks)
+	}
+	messageID := make([]byte, 8)
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-i*dataSize)
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, messageID...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*dataSize:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (client *GelfClient) newGelfMessage(event *CEFEvent) map[string]interface{} {
+	host := client.config.Host
+	if host == "" {
+		host = event.Extension["cs2"]
+	}
+	if host == "" {
+		host = gelfDefaultHost
+	}
+	message := map[string]interface{}{
+		"version":         gelfVersion,
+		"host":            host,
+		"short_message":   gelfShortMessage(event),
+		"timestamp":       float64(event.Time.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":           gelfLevel(event.Severity),
+		"_log_family":     event.LogFamily(),
+		"_event_class_id": event.DeviceEventClassId,
+		"_name":           event.Name,
+		"_severity":       event.Severity,
+	}
+	if event.DeviceProduct != nil {
+		message["_device_product"] = *event.DeviceProduct
+	}
+	if event.Extension["cs2"] != "" {
+		message["_resource"] = event.Extension["cs2"]
+	}
+	if event.Extension["msg"] != "" {
+		message["full_message"] = event.Extension["msg"]
+	}
+	for key, value := range event.Extension {
+		if value == "" || gelfSkippedFields[key] || strings.HasSuffix(key, "label") {
+			continue
+		}
+		name := key
+		if label := event.Extension[key+"label"]; label != "" {
+			name = strings.ToLower(strings.Trim(gelfFieldRegExp.ReplaceAllString(label, "_"), "_"))
+		}
+		var fieldValue interface{} = value
+		if gelfNumericFields.MatchString(key) {
+			if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+				fieldValue = number
+			}
+		}
+		message["_"+name] = fieldValue
+	}
+	return message
+}
+
+// gelfShortMessage summarises a flow as "outcome proto src:spt > dst:dpt rule",
+// falling back to the event name for events without endpoints.
+func gelfShortMessage(event *CEFEvent) string {
+	extension := event.Extension
+	if extension["src"] == "" {
+		return event.Name
+	}
+	parts := []string{}
+	for _, key := range []string{"categoryOutcome", "act", "proto"} {
+		if extension[key] != "" {
+			parts = append(parts, extension[key])
+		}
+	}
+	parts = append(parts, gelfEndpoint(extension["src"], extension["spt"]))
+	if extension["dst"] != "" {
+		parts = append(parts, ">", gelfEndpoint(extension["dst"], extension["dpt"]))
+	}
+	if extension["cs1"] != "" {
+		parts = append(parts, extension["cs1"])
+	}
+	return strings.Join(parts, " ")
+}
+
+func gelfEndpoint(address, port string) string {
+	if port == "" {
+		return address
+	}
+	return net.JoinHostPort(address, port)
+}
+
+// gelfLevel maps CEF severity (0-10) to a syslog level.
+func gelfLevel(severity int) int {
+	switch {
+	case severity >= 9:
+		return 2
+	case severity >= 7:
+		return 3
+	case severity >= 4:
+		return 4
+	default:
+		return 6
+	}
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// readGelfUDP reads datagrams from conn and reassembles chunked messages
+// until count messages have been received.
+func readGelfUDP(t *testing.T, conn net.PacketConn, count int) []map[string]interface{} {
+	messages := []map[string]interface{}{}
+	partial := map[string][][]byte{}
+	buf := make([]byte, 65536)
+	for len(messages) < count {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err, "timed out waiting for gelf datagrams")
+		datagram := append([]byte{}, buf[:n]...)
+
+		payload := datagram
+		if bytes.HasPrefix(datagram, gelfChunkMagic) {
+			id := string(datagram[2:10])
+			seq, total := int(datagram[10]), int(datagram[11])
+			if partial[id] == nil {
+				partial[id] = make([][]byte, total)
+			}
+			partial[id][seq] = datagram[gelfChunkHeaderSize:]
+			complete := true
+			for _, chunk := range partial[id] {
+				complete = complete && chunk != nil
+			}
+			if !complete {
+				continue
+			}
+			payload = bytes.Join(partial[id], nil)
+			delete(partial, id)
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		require.Nil(t, err)
+		decompressed, err := ioutil.ReadAll(reader)
+		require.Nil(t, err)
+		message := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal(decompressed, &message))
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestGelfUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	client := &GelfClient{}
+	err = client.Initialize(GelfConfig{Address: conn.LocalAddr().String(), ChunkSize: gelfMinChunkSize})
+	require.Nil(t, err)
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)[:20]
+	appGwEvents := loadTestAppGwEvents(t)[:2]
+	go func() {
+		assert.Nil(t, client.SendEvents(nil, append(events, appGwEvents...)))
+	}()
+	messages := readGelfUDP(t, conn, len(events)+len(appGwEvents))
+
+	first := messages[0]
+	assert.Equal(t, "1.1", first["version"])
+	assert.Equal(t, "NSGNAME-NSG", first["host"])
+	assert.Equal(t, float64(events[0].Time.Unix()), first["timestamp"])
+	assert.Equal(t, LogFamilyNsgFlow, first["_log_family"])
+	assert.Equal(t, "DefaultRule_AllowVnetOutBound", first["_rule_name"])
+	assert.Equal(t, events[0].Extension["src"], first["_src"])
+	assert.Equal(t, "SUBID", first["_subscription_id"])
+	assert.IsType(t, float64(0), first["_dpt"])
+	assert.Contains(t, first["short_message"], "Allow TCP")
+
+	// Application Gateway records carry the raw record, which is large enough to be chunked.
+	appGw := messages[len(messages)-1]
+	assert.Equal(t, LogFamilyAppGwAccess, appGw["_log_family"])
+	assert.NotEmpty(t, appGw["full_message"])
+}
+
+func TestGelfTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	received := make(chan []byte, 100)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			message, err := reader.ReadBytes(0)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- message[:len(message)-1]
+		}
+	}()
+
+	client := &GelfClient{}
+	err = client.Initialize(GelfConfig{Address: listener.Addr().String(), Protocol: GelfProtocolTCP, Host: "collector"})
+	require.Nil(t, err)
+
+	events := loadTestEvents("nsg_flow_events.json", t)[:10]
+	require.Nil(t, client.SendEvents(nil, events))
+	client.Close()
+
+	count := 0
+	for message := range received {
+		decoded := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal(message, &decoded), "tcp messages must be uncompressed json")
+		assert.Equal(t, "collector", decoded["host"])
+		count++
+	}
+	assert.Equal(t, len(events), count)
+}
+
+func TestGelfChunkLimit(t *testing.T) {
+	_, err := gelfChunks(make([]byte, gelfMaxChunks*gelfMinChunkSize), gelfMinChunkSize)
+	assert.Error(t, err)
+
+	chunks, err := gelfChunks(make([]byte, 3000), 1000)
+	require.Nil(t, err)
+	assert.Equal(t, 4, len(chunks))
+	assert.Equal(t, byte(3), chunks[3][10])
+	assert.Equal(t, byte(4), chunks[3][11])
+}
+
+func TestGelfInvalidConfig(t *testing.T) {
+	client := &GelfClient{}
+	assert.Error(t, client.Initialize(GelfConfig{}))
+	assert.Error(t, client.Initialize(GelfConfig{Address: "localhost:12201", Protocol: "http"}))
+	assert.Error(t, client.Initialize(GelfConfig{Address: "localhost:12201", Compression: "snappy"}))
+	assert.Error(t, client.Initialize(GelfConfig{Address: "localhost:12201", TLS: true}))
+	assert.Error(t, client.Initialize(GelfConfig{Address: "localhost:12201", ChunkSize: 100}))
+}
@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/mmdb"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Extension keys set by GeoIPEnricher, after the source and destination
+// prefix. Coordinates use the CEF keys slat, slong, dlat and dlong.
+const (
+	GeoCountryCodeKey    = "CountryCode"
+	GeoCountryKey        = "Country"
+	GeoCityKey           = "City"
+	GeoASNKey            = "Asn"
+	GeoASOrganizationKey = "AsOrganization"
+)
+
+// GeoIPConfig configures a GeoIPEnricher. City databases also have the
+// country, so CityDatabase may be a country database.
+type GeoIPConfig struct {
+	CityDatabase string `mapstructure:"geoip_city_database"`
+	ASNDatabase  string `mapstructure:"geoip_asn_database"`
+	// CacheSize is the number of addresses cached.
+	CacheSize int `mapstructure:"geoip_cache_size"`
+	// ReloadInterval is how often, in seconds, the database files are
+	// checked for changes.
+	ReloadInterval int `mapstructure:"geoip_reload_interval"`
+}
+
+// GeoIPEnricher adds the location and autonomous system of public source and
+// destination addresses from MaxMind DB files, such as GeoLite2 City and ASN.
+// A database is reloaded when its file changes, so it can be replaced by
+// geoipupdate while running.
+type GeoIPEnricher struct {
+	config    GeoIPConfig
+	cache     *lruCache
+	mutex     sync.RWMutex
+	city      *geoIPDatabase
+	asn       *geoIPDatabase
+	lastCheck time.Time
+}
+
+type geoIPDatabase struct {
+	file   watchedFile
+	reader *mmdb.Reader
+}
+
+// geoIPResult is the data of an address. Empty values are not set.
+type geoIPResult struct {
+	countryCode  string
+	country      string
+	city         string
+	latitude     string
+	longitude    string
+	asn          string
+	organization string
+}
+
+// NewGeoIPEnricher opens the configured databases.
+func NewGeoIPEnricher(config GeoIPConfig) (*GeoIPEnricher, error) {
+	if config.CityDatabase == "" && config.ASNDatabase == "" {
+		return nil, fmt.Errorf("geoip_city_database or geoip_asn_database is required")
+	}
+	if config.CacheSize <= 0 {
+		config.CacheSize = 10000
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = 60
+	}
+	enricher := &GeoIPEnricher{config: config, cache: newLRUCache(config.CacheSize), lastCheck: time.Now()}
+	var err error
+	if config.CityDatabase != "" {
+		enricher.city, err = openGeoIPDatabase(config.CityDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.ASNDatabase != "" {
+		enricher.asn, err = openGeoIPDatabase(config.ASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return enricher, nil
+}
+
+func openGeoIPDatabase(path string) (*geoIPDatabase, error) {
+	file, err := statFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening geoip database: %s", err)
+	}
+	reader, err := mmdb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening geoip database %s: %s", path, err)
+	}
+	log.WithFields(log.Fields{"path": path, "type": reader.Metadata.DatabaseType,
+		"build": time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC()}).Info("loaded geoip database")
+	return &geoIPDatabase{file: file, reader: reader}, nil
+}
+
+// Enrich adds the data of the public src and dst addresses of event.
+func (enricher *GeoIPEnricher) Enrich(event *CEFEvent) {
+	enricher.reloadChanged()
+	enricher.enrichAddress(event, event.Extension["src"], "source", "slat", "slong")
+	enricher.enrichAddress(event, event.Extension["dst"], "destination", "dlat", "dlong")
+}
+
+func (enricher *GeoIPEnricher) enrichAddress(event *CEFEvent, address string, prefix, latitudeKey, longitudeKey string) {
+	ip := net.ParseIP(address)
+	if !isPublicIP(ip) {
+		return
+	}
+	result := enricher.lookup(ip)
+	setExtension(event, prefix+GeoCountryCodeKey, result.countryCode)
+	setExtension(event, prefix+GeoCountryKey, result.country)
+	setExtension(event, prefix+GeoCityKey, result.city)
+	setExtension(event, latitudeKey, result.latitude)
+	setExtension(event, longitudeKey, result.longitude)
+	setExtension(event, prefix+GeoASNKey, result.asn)
+	setExtension(event, prefix+GeoASOrganizationKey, result.organization)
+}
+
+func (enricher *GeoIPEnricher) lookup(ip net.IP) geoIPResult {
+	key := ip.String()
+	if cached, ok := enricher.cache.Get(key); ok {
+		return cached.(geoIPResult)
+	}
+	enricher.mutex.RLock()
+	city, asn := enricher.city, enricher.asn
+	enricher.mutex.RUnlock()
+
+	result := geoIPResult{}
+	if city != nil {
+		value, _, err := city.reader.Lookup(ip)
+		if err != nil {
+			log.WithField("ip", key).Debugf("geoip lookup failed: %s", err)
+		}
+		if record, ok := value.(map[string]interface{}); ok {
+			result.countryCode = mmdbString(record, "country", "iso_code")
+			result.country = mmdbString(record, "country", "names", "en")
+			result.city = mmdbString(record, "city", "names", "en")
+			result.latitude = mmdbString(record, "location", "latitude")
+			result.longitude = mmdbString(record, "location", "longitude")
+		}
+	}
+	if asn != nil {
+		value, _, err := asn.reader.Lookup(ip)
+		if err != nil {
+			log.WithField("ip", key).Debugf("geoip lookup failed: %s", err)
+		}
+		if record, ok := value.(map[string]interface{}); ok {
+			result.asn = mmdbString(record, "autonomous_system_number")
+			result.organization = mmdbString(record, "autonomous_system_organization")
+		}
+	}
+	enricher.cache.Add(key, result)
+	return result
+}
+
+// reloadChanged reopens databases whose file changed, at most once per
+// reload interval. A database that fails to open is kept as it was.
+func (enricher *GeoIPEnricher) reloadChanged() {
+	enricher.mutex.RLock()
+	due := time.Since(enricher.lastCheck) >= time.Duration(enricher.config.ReloadInterval)*time.Second
+	enricher.mutex.RUnlock()
+	if !due {
+		return
+	}
+	enricher.mutex.Lock()
+	defer enricher.mutex.Unlock()
+	if time.Since(enricher.lastCheck) < time.Duration(enricher.config.ReloadInterval)*time.Second {
+		return
+	}
+	enricher.lastCheck = time.Now()
+	reloaded := false
+	for _, database := range []**geoIPDatabase{&enricher.city, &enricher.asn} {
+		if *database == nil || !(*database).file.changed() {
+			continue
+		}
+		opened, err := openGeoIPDatabase((*database).file.path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		*database = opened
+		reloaded = true
+	}
+	if reloaded {
+		enricher.cache.Purge()
+	}
+}
+
+// mmdbString returns the value at path in record as text, or "".
+func mmdbString(record map[string]interface{}, path ...string) string {
+	var value interface{} = record
+	for _, key := range path {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = values[key]
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	default:
+		return ""
+	}
+}
+
+// setExtension sets a non empty value.
+func setExtension(event *CEFEvent, key, value string) {
+	if value != "" {
+		event.Extension[key] = value
+	}
+}
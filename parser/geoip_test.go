@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The GeoIP2-City-Test, GeoLite2-Country-Test and GeoLite2-ASN-Test
+// databases in testdata are MaxMind's own test databases. The fixture
+// addresses are Azure addresses they do not hold, so the tests use theirs.
+const (
+	// testGeoIPLinkoping is in Linköping, SE, in AS 29518.
+	testGeoIPLinkoping = "89.160.20.112"
+	// testGeoIPPhilippines has a country and location only.
+	testGeoIPPhilippines = "202.196.224.1"
+)
+
+// copyTestDatabase copies a database from testdata to path.
+func copyTestDatabase(t *testing.T, name, path string) {
+	data, err := ioutil.ReadFile(filepath.Join(testDataPath, name))
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(path, data, 0644))
+}
+
+// newTestGeoIPEnricher returns an enricher of copies of the test databases,
+// which may be replaced to test reloads.
+func newTestGeoIPEnricher(t *testing.T) (*GeoIPEnricher, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-geoip")
+	require.Nil(t, err)
+	copyTestDatabase(t, "GeoIP2-City-Test.mmdb", filepath.Join(dir, "city.mmdb"))
+	copyTestDatabase(t, "GeoLite2-ASN-Test.mmdb", filepath.Join(dir, "asn.mmdb"))
+	enricher, err := NewGeoIPEnricher(GeoIPConfig{
+		CityDatabase: filepath.Join(dir, "city.mmdb"),
+		ASNDatabase:  filepath.Join(dir, "asn.mmdb"),
+	})
+	require.Nil(t, err)
+	return enricher, dir
+}
+
+// geoIPTestEvent returns an outbound tuple from a private address to
+// destination.
+func geoIPTestEvent(t *testing.T, destination string) *CEFEvent {
+	return sessionTestEvents(t, "1542110377,10.5.16.4,"+destination+",44931,443,T,O,A,B,,,,")[0]
+}
+
+func TestGeoIPEnricherFlow(t *testing.T) {
+	enricher, dir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(dir)
+
+	event := geoIPTestEvent(t, testGeoIPLinkoping)
+	enricher.Enrich(event)
+	extension := event.Extension
+	assert.Equal(t, "SE", extension["destinationCountryCode"])
+	assert.Equal(t, "Sweden", extension["destinationCountry"])
+	assert.Equal(t, "Linköping", extension["destinationCity"])
+	assert.Equal(t, "58.4167", extension["dlat"])
+	assert.Equal(t, "15.6167", extension["dlong"])
+	assert.Equal(t, "29518", extension["destinationAsn"])
+	assert.Equal(t, "Bredband2 AB", extension["destinationAsOrganization"])
+
+	// Private addresses are not looked up.
+	assert.Equal(t, "10.5.16.4", extension["src"])
+	for _, key := range []string{"sourceCountryCode", "sourceCity", "slat", "slong", "sourceAsn"} {
+		assert.NotContains(t, extension, key)
+	}
+
+	ecs, err := NewECSDocument(event)
+	require.Nil(t, err)
+	assert.Equal(t, "SE", schemaValue(t, ecs, "destination", "geo", "country_iso_code"))
+	assert.Equal(t, "Linköping", schemaValue(t, ecs, "destination", "geo", "city_name"))
+	assert.Equal(t, 58.4167, schemaValue(t, ecs, "destination", "geo", "location", "lat"))
+	assert.Equal(t, float64(29518), schemaValue(t, ecs, "destination", "as", "number"))
+	assert.Equal(t, "Bredband2 AB", schemaValue(t, ecs, "destination", "as", "organization", "name"))
+	assert.Nil(t, schemaValue(t, ecs, "source", "geo"))
+
+	ocsf, err := NewOCSFEvent(event)
+	require.Nil(t, err)
+	assert.Equal(t, "SE", schemaValue(t, ocsf, "dst_endpoint", "location", "country"))
+	assert.Equal(t, 15.6167, schemaValue(t, ocsf, "dst_endpoint", "location", "long"))
+	assert.Equal(t, float64(29518), schemaValue(t, ocsf, "dst_endpoint", "autonomous_system", "number"))
+
+	// Inbound from London, to an address only in the ASN database.
+	event = sessionTestEvents(t, "1542110377,81.2.69.160,1.0.0.1,44931,443,T,I,A,B,,,,")[0]
+	enricher.Enrich(event)
+	assert.Equal(t, "GB", event.Extension["sourceCountryCode"])
+	assert.Equal(t, "London", event.Extension["sourceCity"])
+	assert.Equal(t, "-0.0931", event.Extension["slong"])
+	assert.Equal(t, "15169", event.Extension["destinationAsn"])
+	assert.Equal(t, "Google Inc.", event.Extension["destinationAsOrganization"])
+	assert.NotContains(t, event.Extension, "destinationCountryCode")
+}
+
+func TestGeoIPEnricherAppGw(t *testing.T) {
+	enricher, dir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(dir)
+
+	events := loadTestAppGwFirewallEvents(t)
+	for _, event := range events {
+		event.Extension["src"] = testGeoIPLinkoping
+		enricher.Enrich(event)
+	}
+	assert.Equal(t, "Linköping", events[0].Extension["sourceCity"])
+	assert.Equal(t, "29518", events[0].Extension["sourceAsn"])
+	assert.Equal(t, "Linköping", EventFields(events[0])["sourceCity"])
+	// Every event has the same client, looked up once.
+	assert.Equal(t, 1, enricher.cache.Len())
+}
+
+func TestGeoIPEnricherPartialRecords(t *testing.T) {
+	enricher, dir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(dir)
+
+	event := geoIPTestEvent(t, testGeoIPPhilippines)
+	enricher.Enrich(event)
+	// No city and no autonomous system.
+	assert.Equal(t, "PH", event.Extension["destinationCountryCode"])
+	assert.NotContains(t, event.Extension, "destinationCity")
+	assert.NotContains(t, event.Extension, "destinationAsn")
+	assert.Equal(t, "122", event.Extension["dlong"])
+}
+
+func TestGeoIPEnricherReload(t *testing.T) {
+	enricher, dir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(dir)
+
+	event := geoIPTestEvent(t, testGeoIPLinkoping)
+	enricher.Enrich(event)
+	assert.Equal(t, "Linköping", event.Extension["destinationCity"])
+
+	// A country database has no cities.
+	copyTestDatabase(t, "GeoLite2-Country-Test.mmdb", filepath.Join(dir, "city.mmdb"))
+	// The file is not checked again before the reload interval.
+	event = geoIPTestEvent(t, testGeoIPLinkoping)
+	enricher.Enrich(event)
+	assert.Equal(t, "Linköping", event.Extension["destinationCity"])
+
+	enricher.lastCheck = time.Now().Add(-time.Hour)
+	event = geoIPTestEvent(t, testGeoIPLinkoping)
+	enricher.Enrich(event)
+	assert.Equal(t, "SE", event.Extension["destinationCountryCode"])
+	assert.NotContains(t, event.Extension, "destinationCity")
+	assert.Equal(t, "29518", event.Extension["destinationAsn"])
+
+	// A broken file keeps the loaded database.
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "city.mmdb"), []byte("not a database"), 0644))
+	enricher.lastCheck = time.Now().Add(-time.Hour)
+	event = geoIPTestEvent(t, testGeoIPLinkoping)
+	enricher.Enrich(event)
+	assert.Equal(t, "SE", event.Extension["destinationCountryCode"])
+}
+
+func TestNewGeoIPEnricherErrors(t *testing.T) {
+	_, err := NewGeoIPEnricher(GeoIPConfig{})
+	assert.NotNil(t, err)
+	_, err = NewGeoIPEnricher(GeoIPConfig{CityDatabase: "missing.mmdb"})
+	assert.NotNil(t, err)
+}
+
+func TestDestinationEnrichers(t *testing.T) {
+	enricher, dir := newTestGeoIPEnricher(t)
+	defer os.RemoveAll(dir)
+
+	sender := &recordingSender{}
+	destination, err := NewDestination("geoip", "file", nil, sender)
+	require.Nil(t, err)
+	destination.Enrichers = []EventEnricher{enricher}
+	destination.Filters, err = NewFilterChain("geoip", []Filter{
+		{Name: "sweden", Mode: "exclude", Fields: map[string][]string{"destinationcountrycode": {"SE"}}},
+	}, "")
+	require.Nil(t, err)
+
+	events := []*CEFEvent{geoIPTestEvent(t, testGeoIPLinkoping), geoIPTestEvent(t, testGeoIPPhilippines)}
+	require.Nil(t, destination.SendEvents(nil, events))
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, "PH", sender.events[0].Extension["destinationCountryCode"])
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+	cache.Add("c", 3)
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "least recently used entry is dropped")
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 2, cache.Len())
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+}
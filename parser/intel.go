@@ -0,0 +1,367 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extension keys set by ThreatIntel on events matching an indicator.
+const (
+	ThreatFeedKey        = "threatFeed"
+	ThreatIndicatorKey   = "threatIndicatorId"
+	ThreatNetworkKey     = "threatNetwork"
+	ThreatMatchKey       = "threatMatch"
+	ThreatDescriptionKey = "threatDescription"
+)
+
+// stixAddressPattern finds the address comparisons of STIX patterns.
+var stixAddressPattern = regexp.MustCompile(`(?i)ipv[46]-addr:value\s*(?:=|ISSUBSET)\s*'([^']+)'`)
+
+// stixConjunctions are pattern operators requiring more than one address to
+// match, which a single address cannot.
+var stixConjunctions = regexp.MustCompile(`(?i)\s(AND|FOLLOWEDBY)\s`)
+
+// ThreatFeed is a local indicator file. Files ending in .csv have a header
+// row with an ip, cidr, indicator or value column and optional id and
+// description columns. Files ending in .json are STIX 2 bundles. Other files
+// list an address or CIDR per line, with # comments.
+type ThreatFeed struct {
+	Name string `mapstructure:"name"`
+	Path string `mapstructure:"path"`
+
+	file       watchedFile
+	indicators []*threatIndicator
+	matches    metrics.Counter
+}
+
+// ThreatConfig configures ThreatIntel.
+type ThreatConfig struct {
+	Feeds []ThreatFeed `mapstructure:"threat_feeds"`
+	// Severity is the CEF severity matching events are raised to.
+	Severity int `mapstructure:"threat_severity"`
+	// ReloadInterval is how often, in seconds, feeds are checked for
+	// changes.
+	ReloadInterval int `mapstructure:"threat_reload_interval"`
+}
+
+// threatIndicator is an address or network of a feed.
+type threatIndicator struct {
+	feed        string
+	id          string
+	network     string
+	description string
+}
+
+// ThreatIntel matches the source and destination of events against the
+// indicators of local feeds. Matching events are raised to the configured
+// severity and get the feed, indicator id, network and description of the
+// narrowest matching indicator, the source checked first. Feeds are reloaded
+// when their file changes.
+type ThreatIntel struct {
+	config    ThreatConfig
+	mutex     sync.RWMutex
+	feeds     []*ThreatFeed
+	trie      *cidrTrie
+	lastCheck time.Time
+}
+
+// NewThreatIntel loads the configured feeds.
+func NewThreatIntel(config ThreatConfig) (*ThreatIntel, error) {
+	if len(config.Feeds) == 0 {
+		return nil, fmt.Errorf("no threat_feeds")
+	}
+	if config.Severity <= 0 || config.Severity > 10 {
+		config.Severity = 8
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = 60
+	}
+	intel := &ThreatIntel{config: config}
+	names := map[string]bool{}
+	for i := range config.Feeds {
+		feed := config.Feeds[i]
+		if !destinationNameRegExp.MatchString(feed.Name) {
+			return nil, fmt.Errorf("invalid threat feed name %q. use letters, digits, - and _", feed.Name)
+		}
+		if names[feed.Name] {
+			return nil, fmt.Errorf("threat feed name %s is used twice", feed.Name)
+		}
+		names[feed.Name] = true
+		err := feed.load()
+		if err != nil {
+			return nil, err
+		}
+		feed.matches = metrics.GetOrRegisterCounter(fmt.Sprintf("threat_%s_matches", feed.Name), nil)
+		intel.feeds = append(intel.feeds, &feed)
+	}
+	intel.trie = intel.build()
+	intel.lastCheck = time.Now()
+	return intel, nil
+}
+
+// build returns the trie of the indicators of every feed.
+func (intel *ThreatIntel) build() *cidrTrie {
+	trie := newCIDRTrie()
+	for _, feed := range intel.feeds {
+		for _, indicator := range feed.indicators {
+			_, network, _ := net.ParseCIDR(indicator.network)
+			trie.Insert(network, indicator)
+		}
+	}
+	return trie
+}
+
+// Enrich marks event when its source or destination matches an indicator.
+func (intel *ThreatIntel) Enrich(event *CEFEvent) {
+	intel.reloadChanged()
+	intel.mutex.RLock()
+	trie := intel.trie
+	intel.mutex.RUnlock()
+	for _, endpoint := range []struct{ key, name string }{{"src", "source"}, {"dst", "destination"}} {
+		ip := net.ParseIP(event.Extension[endpoint.key])
+		if ip == nil {
+			continue
+		}
+		match, ok := trie.Longest(ip)
+		if !ok {
+			continue
+		}
+		indicator := match.Values[0].(*threatIndicator)
+		event.Extension[ThreatFeedKey] = indicator.feed
+		event.Extension[ThreatIndicatorKey] = indicator.id
+		event.Extension[ThreatNetworkKey] = indicator.network
+		event.Extension[ThreatMatchKey] = endpoint.name
+		setExtension(event, ThreatDescriptionKey, indicator.description)
+		if event.Severity < intel.config.Severity {
+			event.Severity = intel.config.Severity
+		}
+		intel.feedCounter(indicator.feed).Inc(1)
+		return
+	}
+}
+
+func (intel *ThreatIntel) feedCounter(name string) metrics.Counter {
+	for _, feed := range intel.feeds {
+		if feed.Name == name {
+			return feed.matches
+		}
+	}
+	return metrics.NilCounter{}
+}
+
+// reloadChanged reloads the feeds whose file changed, at most once per reload
+// interval. A feed that fails to load keeps its indicators.
+func (intel *ThreatIntel) reloadChanged() {
+	intel.mutex.RLock()
+	due := time.Since(intel.lastCheck) >= time.Duration(intel.config.ReloadInterval)*time.Second
+	intel.mutex.RUnlock()
+	if !due {
+		return
+	}
+	intel.mutex.Lock()
+	defer intel.mutex.Unlock()
+	if time.Since(intel.lastCheck) < time.Duration(intel.config.ReloadInterval)*time.Second {
+		return
+	}
+	intel.lastCheck = time.Now()
+	reloaded := false
+	for _, feed := range intel.feeds {
+		if !feed.file.changed() {
+			continue
+		}
+		err := feed.load()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		reloaded = true
+	}
+	if reloaded {
+		intel.trie = intel.build()
+	}
+}
+
+// load reads the indicators of the feed, keeping the loaded ones on error.
+func (feed *ThreatFeed) load() error {
+	file, err := statFile(feed.Path)
+	if err != nil {
+		return fmt.Errorf("error opening threat feed %s: %s", feed.Name, err)
+	}
+	data, err := ioutil.ReadFile(feed.Path)
+	if err != nil {
+		return fmt.Errorf("error reading threat feed %s: %s", feed.Name, err)
+	}
+	var indicators []*threatIndicator
+	switch strings.ToLower(filepath.Ext(feed.Path)) {
+	case ".csv":
+		indicators, err = readCSVIndicators(data)
+	case ".json":
+		indicators, err = readSTIXIndicators(data)
+	default:
+		indicators, err = readListIndicators(data)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading threat feed %s: %s", feed.Name, err)
+	}
+	for _, indicator := range indicators {
+		indicator.feed = feed.Name
+	}
+	log.WithFields(log.Fields{"feed": feed.Name, "path": feed.Path, "indicators": len(indicators)}).Info("loaded threat feed")
+	feed.file, feed.indicators = file, indicators
+	return nil
+}
+
+// newThreatIndicator returns the indicator of an address or CIDR, with the
+// network as id when id is empty.
+func newThreatIndicator(value, id, description string) (*threatIndicator, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", value)
+		}
+		if ip.To4() != nil {
+			value += "/32"
+		} else {
+			value += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q", value)
+	}
+	if id == "" {
+		id = network.String()
+	}
+	return &threatIndicator{id: id, network: network.String(), description: description}, nil
+}
+
+func readListIndicators(data []byte) ([]*threatIndicator, error) {
+	indicators := []*threatIndicator{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		indicator, err := newThreatIndicator(fields[0], "", "")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		indicators = append(indicators, indicator)
+	}
+	return indicators, scanner.Err()
+}
+
+func readCSVIndicators(data []byte) ([]*threatIndicator, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header row")
+	}
+	columns := map[string]int{}
+	for i, column := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	valueColumn := -1
+	for _, name := range []string{"ip", "cidr", "indicator", "value"} {
+		if i, ok := columns[name]; ok {
+			valueColumn = i
+			break
+		}
+	}
+	if valueColumn == -1 {
+		return nil, fmt.Errorf("no ip, cidr, indicator or value column")
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	indicators := []*threatIndicator{}
+	for line, record := range records[1:] {
+		if valueColumn >= len(record) || strings.TrimSpace(record[valueColumn]) == "" {
+			continue
+		}
+		indicator, err := newThreatIndicator(record[valueColumn], column(record, "id"), column(record, "description"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s", line+2, err)
+		}
+		indicators = append(indicators, indicator)
+	}
+	return indicators, nil
+}
+
+// readSTIXIndicators reads the ipv4-addr and ipv6-addr indicators of a STIX 2
+// bundle. Revoked and expired indicators, and patterns that need more than
+// one observation, are skipped.
+func readSTIXIndicators(data []byte) ([]*threatIndicator, error) {
+	bundle := struct {
+		Type    string `json:"type"`
+		Objects []struct {
+			Type        string `json:"type"`
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Pattern     string `json:"pattern"`
+			PatternType string `json:"pattern_type"`
+			ValidUntil  string `json:"valid_until"`
+			Revoked     bool   `json:"revoked"`
+		} `json:"objects"`
+	}{}
+	err := json.Unmarshal(data, &bundle)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Type != "bundle" {
+		return nil, fmt.Errorf("not a STIX bundle")
+	}
+	indicators := []*threatIndicator{}
+	for _, object := range bundle.Objects {
+		if object.Type != "indicator" || object.Revoked || object.PatternType != "" && object.PatternType != "stix" {
+			continue
+		}
+		if validUntil, err := time.Parse(time.RFC3339, object.ValidUntil); err == nil && validUntil.Before(time.Now()) {
+			continue
+		}
+		if stixConjunctions.MatchString(object.Pattern) {
+			continue
+		}
+		description := object.Name
+		if description == "" {
+			description = object.Description
+		}
+		for _, match := range stixAddressPattern.FindAllStringSubmatch(object.Pattern, -1) {
+			indicator, err := newThreatIndicator(match[1], object.ID, description)
+			if err != nil {
+				return nil, fmt.Errorf("indicator %s: %s", object.ID, err)
+			}
+			indicators = append(indicators, indicator)
+		}
+	}
+	return indicators, nil
+}
@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testThreatList = `# blocklist
+94.102.49.0/24 scanners
+185.220.101.7
+2001:db8:dead::/48
+`
+
+const testThreatCSV = `id,ip,description
+IOC-1,45.155.205.233,Exfiltration endpoint
+IOC-2,52.237.25.0/24,Phishing kit
+`
+
+const testThreatSTIX = `{"type": "bundle", "id": "bundle--5d0092c5-5f74-4287-9642-33f4c354e56d", "objects": [
+{"type": "indicator", "spec_version": "2.1", "id": "indicator--8e2e2d2b-17d4-4cbf-938f-98ee46b3cd3f",
+ "name": "Tor exit node", "pattern": "[ipv4-addr:value = '94.102.49.190']", "pattern_type": "stix", "valid_from": "2018-01-01T00:00:00Z"},
+{"type": "indicator", "spec_version": "2.1", "id": "indicator--2f6f4bd5-8c7b-4e2a-8c5d-6a1e0a4b7c11",
+ "name": "Revoked", "pattern": "[ipv4-addr:value = '10.5.16.4']", "pattern_type": "stix", "revoked": true},
+{"type": "indicator", "spec_version": "2.1", "id": "indicator--c5f4b3a2-1d0e-4f9a-8b7c-6d5e4f3a2b1c",
+ "name": "Expired", "pattern": "[ipv4-addr:value ISSUBSET '10.0.0.0/8']", "pattern_type": "stix", "valid_until": "2019-01-01T00:00:00Z"},
+{"type": "indicator", "spec_version": "2.1", "id": "indicator--a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d",
+ "name": "Needs both", "pattern": "[ipv4-addr:value = '10.193.160.4'] AND [ipv4-addr:value = '8.8.8.8']", "pattern_type": "stix"},
+{"type": "malware", "id": "malware--31b940d4-6f7f-459a-80ea-9c1f17b5891b", "name": "Poison Ivy"}
+]}`
+
+func writeTestThreatFeeds(t *testing.T) (string, ThreatConfig) {
+	dir, err := ioutil.TempDir("", "nsg-parser-intel")
+	require.Nil(t, err)
+	config := ThreatConfig{}
+	for name, content := range map[string]string{"blocklist.txt": testThreatList, "partner.csv": testThreatCSV, "cti.json": testThreatSTIX} {
+		path := filepath.Join(dir, name)
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	config.Feeds = []ThreatFeed{
+		{Name: "blocklist", Path: filepath.Join(dir, "blocklist.txt")},
+		{Name: "partner", Path: filepath.Join(dir, "partner.csv")},
+		{Name: "cti", Path: filepath.Join(dir, "cti.json")},
+	}
+	return dir, config
+}
+
+func TestThreatIntel(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+	intel, err := NewThreatIntel(config)
+	require.Nil(t, err)
+	assert.Equal(t, 6, intel.trie.Len())
+
+	// The narrowest indicator wins, so the STIX address over the list network.
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,")[0]
+	intel.Enrich(event)
+	assert.Equal(t, "cti", event.Extension[ThreatFeedKey])
+	assert.Equal(t, "indicator--8e2e2d2b-17d4-4cbf-938f-98ee46b3cd3f", event.Extension[ThreatIndicatorKey])
+	assert.Equal(t, "94.102.49.190/32", event.Extension[ThreatNetworkKey])
+	assert.Equal(t, "destination", event.Extension[ThreatMatchKey])
+	assert.Equal(t, "Tor exit node", event.Extension[ThreatDescriptionKey])
+	assert.Equal(t, 8, event.Severity)
+
+	event = sessionTestEvents(t, "1542110377,94.102.49.1,10.5.16.4,44931,443,T,I,D,B,,,,")[0]
+	intel.Enrich(event)
+	assert.Equal(t, "blocklist", event.Extension[ThreatFeedKey])
+	assert.Equal(t, "94.102.49.0/24", event.Extension[ThreatIndicatorKey])
+	assert.Equal(t, "source", event.Extension[ThreatMatchKey])
+	assert.NotContains(t, event.Extension, ThreatDescriptionKey)
+
+	event = sessionTestEvents(t, "1542110377,10.5.16.4,2001:db8:dead:1::5,44931,443,T,O,A,B,,,,")[0]
+	intel.Enrich(event)
+	assert.Equal(t, "2001:db8:dead::/48", event.Extension[ThreatNetworkKey])
+
+	// Revoked, expired and conjunctive STIX indicators are not loaded.
+	events := loadTestEvents("nsg_flow_events.json", t)
+	intel.Enrich(events[0])
+	assert.NotContains(t, events[0].Extension, ThreatFeedKey)
+	assert.Equal(t, 0, events[0].Severity)
+
+	events = loadTestAppGwFirewallEvents(t)
+	severity := events[0].Severity
+	intel.Enrich(events[0])
+	assert.Equal(t, "partner", events[0].Extension[ThreatFeedKey])
+	assert.Equal(t, "IOC-2", events[0].Extension[ThreatIndicatorKey])
+	assert.Equal(t, "Phishing kit", events[0].Extension[ThreatDescriptionKey])
+	if severity < 8 {
+		severity = 8
+	}
+	assert.Equal(t, severity, events[0].Severity)
+}
+
+func TestThreatIntelReload(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+	config.Severity = 10
+	intel, err := NewThreatIntel(config)
+	require.Nil(t, err)
+
+	path := filepath.Join(dir, "blocklist.txt")
+	require.Nil(t, ioutil.WriteFile(path, []byte("10.193.160.0/24\n"), 0644))
+	intel.lastCheck = time.Now().Add(-time.Hour)
+	events := loadTestEvents("nsg_flow_events.json", t)
+	intel.Enrich(events[0])
+	assert.Equal(t, "blocklist", events[0].Extension[ThreatFeedKey])
+	assert.Equal(t, 10, events[0].Severity)
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,185.220.101.7,44931,443,T,O,A,B,,,,")[0]
+	intel.Enrich(event)
+	assert.NotContains(t, event.Extension, ThreatFeedKey)
+
+	// A broken feed keeps its indicators.
+	require.Nil(t, ioutil.WriteFile(path, []byte("10.193.160.0/33\n"), 0644))
+	intel.lastCheck = time.Now().Add(-time.Hour)
+	events = loadTestEvents("nsg_flow_events.json", t)
+	intel.Enrich(events[0])
+	assert.Equal(t, "blocklist", events[0].Extension[ThreatFeedKey])
+}
+
+func TestThreatIntelSharedLock(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+	intel, err := NewThreatIntel(config)
+	require.Nil(t, err)
+
+	// Before the reload interval, Enrich only needs the read lock, so
+	// destinations enrich concurrently.
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,")[0]
+	intel.mutex.RLock()
+	defer intel.mutex.RUnlock()
+	done := make(chan struct{})
+	go func() {
+		intel.Enrich(event)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Enrich waited for the write lock")
+	}
+}
+
+func TestNewThreatIntelErrors(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+
+	_, err := NewThreatIntel(ThreatConfig{})
+	assert.NotNil(t, err)
+	_, err = NewThreatIntel(ThreatConfig{Feeds: []ThreatFeed{{Name: "missing", Path: filepath.Join(dir, "missing.txt")}}})
+	assert.NotNil(t, err)
+	_, err = NewThreatIntel(ThreatConfig{Feeds: []ThreatFeed{config.Feeds[0], config.Feeds[0]}})
+	assert.NotNil(t, err)
+	_, err = NewThreatIntel(ThreatConfig{Feeds: []ThreatFeed{{Name: "bad name", Path: config.Feeds[0].Path}}})
+	assert.NotNil(t, err)
+
+	for name, content := range map[string]string{
+		"bad.txt":    "not-an-address\n",
+		"bad.csv":    "id,name\n1,x\n",
+		"bad.json":   `{"type": "indicator"}`,
+		"badip.json": `{"type": "bundle", "objects": [{"type": "indicator", "id": "indicator--1", "pattern": "[ipv4-addr:value = '300.1.1.1']"}]}`,
+	} {
+		path := filepath.Join(dir, name)
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err = NewThreatIntel(ThreatConfig{Feeds: []ThreatFeed{{Name: "bad", Path: path}}})
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestThreatFeedsFiltersAndRoutes(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+	intel, err := NewThreatIntel(config)
+	require.Nil(t, err)
+	threat := sessionTestEvents(t, "1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,")[0]
+	clean := sessionTestEvents(t, "1542110377,10.5.16.4,8.8.8.8,44931,53,U,O,A,B,,,,")[0]
+	intel.Enrich(threat)
+	intel.Enrich(clean)
+
+	table, err := NewRouteTable([]Route{{Name: "threats", ThreatFeeds: []string{"*"}, Destinations: []string{"soc"}}},
+		[]string{"archive"}, []string{"soc", "archive"})
+	require.Nil(t, err)
+	assert.Equal(t, []*CEFEvent{threat}, table.Select("soc", []*CEFEvent{threat, clean}))
+	assert.Equal(t, []*CEFEvent{clean}, table.Select("archive", []*CEFEvent{threat, clean}))
+	_, err = NewRouteTable([]Route{{Name: "bad", ThreatFeeds: []string{"["}, Destinations: []string{"soc"}}}, nil, []string{"soc"})
+	assert.NotNil(t, err)
+
+	chain, err := NewFilterChain("test", []Filter{{Name: "cti_only", Mode: FilterInclude, ThreatFeeds: []string{"CTI"}}}, FilterExclude)
+	require.Nil(t, err)
+	assert.True(t, chain.Keep(threat))
+	assert.False(t, chain.Keep(clean))
+	_, err = NewFilterChain("test", []Filter{{Name: "bad", Mode: FilterInclude, ThreatFeeds: []string{"["}}}, "")
+	assert.NotNil(t, err)
+}
+
+func TestThreatIntelSchemas(t *testing.T) {
+	dir, config := writeTestThreatFeeds(t)
+	defer os.RemoveAll(dir)
+	intel, err := NewThreatIntel(config)
+	require.Nil(t, err)
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,")[0]
+	intel.Enrich(event)
+
+	text, err := event.CEFText()
+	require.Nil(t, err)
+	assert.Contains(t, text, "threatFeed=cti")
+
+	ecs, err := NewECSDocument(event)
+	require.Nil(t, err)
+	enrichments := schemaValue(t, ecs, "threat", "enrichments").([]interface{})
+	require.Equal(t, 1, len(enrichments))
+	enrichment := enrichments[0].(map[string]interface{})
+	assert.Equal(t, "94.102.49.190", enrichment["indicator"].(map[string]interface{})["ip"])
+	assert.Equal(t, "cti", enrichment["indicator"].(map[string]interface{})["provider"])
+	assert.Equal(t, "destination.ip", enrichment["matched"].(map[string]interface{})["field"])
+	assert.Equal(t, float64(8), schemaValue(t, ecs, "event", "severity"))
+
+	ocsf, err := NewOCSFEvent(event)
+	require.Nil(t, err)
+	assert.Equal(t, float64(4), schemaValue(t, ocsf, "severity_id"))
+	assert.Equal(t, "High", schemaValue(t, ocsf, "severity"))
+	enrichments = schemaValue(t, ocsf, "enrichments").([]interface{})
+	require.Equal(t, 1, len(enrichments))
+	assert.Equal(t, "dst_endpoint.ip", enrichments[0].(map[string]interface{})["name"])
+
+	clean := loadTestEvents("nsg_flow_events.json", t)[0]
+	ecs, err = NewECSDocument(clean)
+	require.Nil(t, err)
+	assert.NotContains(t, ecs, "threat")
+	ocsf, err = NewOCSFEvent(clean)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), schemaValue(t, ocsf, "severity_id"))
+}
@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extension keys set by AssetInventory, after the source and destination
+// prefix. The VM name is set in the CEF keys shost and dhost.
+const (
+	AssetNICKey         = "Nic"
+	AssetSubnetKey      = "Subnet"
+	AssetVNetKey        = "Vnet"
+	AssetApplicationKey = "Application"
+	AssetOwnerKey       = "Owner"
+)
+
+// Asset is a network interface of the inventory.
+type Asset struct {
+	IPs         []string
+	MAC         string
+	VM          string
+	NIC         string
+	Subnet      string
+	VNet        string
+	Application string
+	Owner       string
+}
+
+// assetColumns maps the columns and keys of inventory files to asset fields.
+var assetColumns = map[string]string{
+	"ip":               "ip",
+	"ips":              "ip",
+	"ipaddress":        "ip",
+	"privateipaddress": "ip",
+	"mac":              "mac",
+	"macaddress":       "mac",
+	"vm":               "vm",
+	"vmname":           "vm",
+	"virtualmachine":   "vm",
+	"nic":              "nic",
+	"nicname":          "nic",
+	"networkinterface": "nic",
+	"subnet":           "subnet",
+	"vnet":             "vnet",
+	"virtualnetwork":   "vnet",
+	"application":      "application",
+	"app":              "application",
+	"owner":            "owner",
+}
+
+// AssetInventory adds the VM, NIC, subnet, VNet, application and owner of
+// the source and destination of events from an inventory file. Addresses are
+// matched by the MAC address of flows first, as private ranges may overlap
+// between VNets, then by IP. The file is reloaded when it changes.
+type AssetInventory struct {
+	ReloadInterval time.Duration
+
+	mutex     sync.RWMutex
+	file      watchedFile
+	byIP      map[string]*Asset
+	byMAC     map[string]*Asset
+	lastCheck time.Time
+}
+
+// NewAssetInventory loads the csv or json inventory at path.
+func NewAssetInventory(path string, reloadInterval time.Duration) (*AssetInventory, error) {
+	if reloadInterval <= 0 {
+		reloadInterval = time.Minute
+	}
+	inventory := &AssetInventory{ReloadInterval: reloadInterval}
+	err := inventory.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+func (inventory *AssetInventory) load(path string) error {
+	file, err := statFile(path)
+	if err != nil {
+		return fmt.Errorf("error opening inventory: %s", err)
+	}
+	assets, err := ReadAssets(path)
+	if err != nil {
+		return err
+	}
+	byIP, byMAC := map[string]*Asset{}, map[string]*Asset{}
+	duplicates := 0
+	for _, asset := range assets {
+		for _, address := range asset.IPs {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return fmt.Errorf("invalid address %q in inventory %s", address, path)
+			}
+			if other, ok := byIP[ip.String()]; ok && other.NIC != asset.NIC {
+				duplicates++
+			}
+			byIP[ip.String()] = asset
+		}
+		if asset.MAC != "" {
+			byMAC[macKey(asset.MAC)] = asset
+		}
+	}
+	if duplicates > 0 {
+		log.WithFields(log.Fields{"path": path, "duplicates": duplicates}).
+			Warn("inventory has addresses of more than one nic. the last is used when the mac is not known")
+	}
+	log.WithFields(log.Fields{"path": path, "assets": len(assets)}).Info("loaded inventory")
+
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	inventory.file, inventory.byIP, inventory.byMAC, inventory.lastCheck = file, byIP, byMAC, time.Now()
+	return nil
+}
+
+// Enrich adds the assets of the source and destination of event.
+func (inventory *AssetInventory) Enrich(event *CEFEvent) {
+	inventory.reloadChanged()
+	extension := event.Extension
+	inventory.enrichEndpoint(event, inventory.Lookup(extension["src"], extension["smac"]), "source", "shost")
+	inventory.enrichEndpoint(event, inventory.Lookup(extension["dst"], extension["dmac"]), "destination", "dhost")
+}
+
+func (inventory *AssetInventory) enrichEndpoint(event *CEFEvent, asset *Asset, prefix, hostKey string) {
+	if asset == nil {
+		return
+	}
+	setExtension(event, hostKey, asset.VM)
+	setExtension(event, prefix+AssetNICKey, asset.NIC)
+	setExtension(event, prefix+AssetSubnetKey, asset.Subnet)
+	setExtension(event, prefix+AssetVNetKey, asset.VNet)
+	setExtension(event, prefix+AssetApplicationKey, asset.Application)
+	setExtension(event, prefix+AssetOwnerKey, asset.Owner)
+}
+
+// Lookup returns the asset with mac or, when mac is empty or unknown, with
+// address, or nil.
+func (inventory *AssetInventory) Lookup(address, mac string) *Asset {
+	inventory.mutex.RLock()
+	defer inventory.mutex.RUnlock()
+	if mac != "" {
+		if asset, ok := inventory.byMAC[macKey(mac)]; ok {
+			return asset
+		}
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return inventory.byIP[ip.String()]
+	}
+	return nil
+}
+
+// reloadChanged reloads the inventory when its file changed, at most once
+// per reload interval. An inventory that fails to load is kept as it was.
+func (inventory *AssetInventory) reloadChanged() {
+	inventory.mutex.Lock()
+	if time.Since(inventory.lastCheck) < inventory.ReloadInterval {
+		inventory.mutex.Unlock()
+		return
+	}
+	inventory.lastCheck = time.Now()
+	file := inventory.file
+	inventory.mutex.Unlock()
+	if !file.changed() {
+		return
+	}
+	err := inventory.load(file.path)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// ReadAssets reads an inventory file. Files ending in .csv have a header
+// row. Other files are a json array of objects, or an object with the array
+// in data, as printed by az graph query. Columns and keys are matched without
+// case, and ip holds one or more addresses separated by spaces, commas or
+// semicolons, or a json array.
+func ReadAssets(path string) ([]*Asset, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory: %s", err)
+	}
+	var rows []map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		rows, err = readAssetCSV(data)
+	} else {
+		rows, err = readAssetJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory %s: %s", path, err)
+	}
+	assets := make([]*Asset, 0, len(rows))
+	for _, row := range rows {
+		asset := &Asset{}
+		for key, value := range row {
+			field, ok := assetColumns[strings.ToLower(strings.Replace(key, "_", "", -1))]
+			if !ok {
+				continue
+			}
+			if field == "ip" {
+				asset.IPs = append(asset.IPs, assetAddresses(value)...)
+				continue
+			}
+			text, _ := value.(string)
+			text = strings.TrimSpace(text)
+			switch field {
+			case "mac":
+				asset.MAC = text
+			case "vm":
+				asset.VM = text
+			case "nic":
+				asset.NIC = text
+			case "subnet":
+				asset.Subnet = text
+			case "vnet":
+				asset.VNet = text
+			case "application":
+				asset.Application = text
+			case "owner":
+				asset.Owner = text
+			}
+		}
+		if len(asset.IPs) > 0 || asset.MAC != "" {
+			assets = append(assets, asset)
+		}
+	}
+	return assets, nil
+}
+
+func readAssetCSV(data []byte) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header row")
+	}
+	rows := []map[string]interface{}{}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := map[string]interface{}{}
+		for i, value := range record {
+			if i < len(header) {
+				row[strings.TrimSpace(header[i])] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readAssetJSON(data []byte) ([]map[string]interface{}, error) {
+	rows := []map[string]interface{}{}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		wrapped := struct {
+			Data []map[string]interface{} `json:"data"`
+		}{}
+		err := json.Unmarshal(data, &wrapped)
+		return wrapped.Data, err
+	}
+	err := json.Unmarshal(data, &rows)
+	return rows, err
+}
+
+func assetAddresses(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == ',' || r == ';'
+		})
+	case []interface{}:
+		addresses := []string{}
+		for _, item := range value {
+			addresses = append(addresses, assetAddresses(item)...)
+		}
+		return addresses
+	default:
+		return nil
+	}
+}
+
+// macKey normalizes 00:0D:3A:F8:78:56, 00-0D-3A-F8-78-56 and 000D3AF87856.
+func macKey(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testInventoryCSV = `vm,nic,ip,mac,subnet,vnet,application,owner
+web-vm-03,web-vm-03-nic,10.5.16.4,00-0D-3A-F8-78-56,web,prod-vnet,shop,web-team@example.com
+sql-01,sql-01-nic,10.5.17.20;10.5.17.21,00-0D-3A-11-22-33,data,prod-vnet,shop,dba@example.com
+`
+
+// testInventoryJSON is the output of the az graph query in the README.
+const testInventoryJSON = `{"count":2,"data":[
+{"nic":"web-vm-03-nic","mac":"00-0D-3A-F8-78-56","ip":"10.5.16.4","vm":"web-vm-03","subnet":"web","vnet":"prod-vnet","application":"shop","owner":"web-team@example.com"},
+{"nic":"sql-01-nic","mac":"00-0D-3A-11-22-33","ip":["10.5.17.20","10.5.17.21"],"vm":"sql-01","subnet":"data","vnet":"prod-vnet","application":"shop","owner":"dba@example.com"}
+],"skip_token":null,"total_records":2}`
+
+func writeTestInventory(t *testing.T, name, data string) (string, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-inventory")
+	require.Nil(t, err)
+	path := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(path, []byte(data), 0644))
+	return dir, path
+}
+
+func TestAssetInventory(t *testing.T) {
+	for name, data := range map[string]string{"inventory.csv": testInventoryCSV, "inventory.json": testInventoryJSON} {
+		dir, path := writeTestInventory(t, name, data)
+		inventory, err := NewAssetInventory(path, time.Minute)
+		require.Nil(t, err, name)
+
+		event := sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.21,44931,1433,T,O,A,B,,,,")[0]
+		assert.Equal(t, "00:0D:3A:F8:78:56", event.Extension["smac"])
+		inventory.Enrich(event)
+		extension := event.Extension
+		assert.Equal(t, "web-vm-03", extension["shost"], name)
+		assert.Equal(t, "web-vm-03-nic", extension["sourceNic"], name)
+		assert.Equal(t, "web", extension["sourceSubnet"], name)
+		assert.Equal(t, "prod-vnet", extension["sourceVnet"], name)
+		assert.Equal(t, "shop", extension["sourceApplication"], name)
+		assert.Equal(t, "web-team@example.com", extension["sourceOwner"], name)
+		assert.Equal(t, "sql-01", extension["dhost"], name)
+		assert.Equal(t, "data", extension["destinationSubnet"], name)
+		assert.Equal(t, "dba@example.com", extension["destinationOwner"], name)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAssetInventoryMatchesMACFirst(t *testing.T) {
+	// The address of web-vm-03 is also used in another VNet.
+	dir, path := writeTestInventory(t, "inventory.csv", testInventoryCSV+
+		"dev-vm-01,dev-vm-01-nic,10.5.16.4,00-0D-3A-44-55-66,web,dev-vnet,shop-dev,dev@example.com\n")
+	defer os.RemoveAll(dir)
+	inventory, err := NewAssetInventory(path, time.Minute)
+	require.Nil(t, err)
+
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0]
+	inventory.Enrich(event)
+	assert.Equal(t, "web-vm-03", event.Extension["shost"])
+	assert.Equal(t, "dev-vm-01", inventory.Lookup("10.5.16.4", "").VM)
+	assert.Nil(t, inventory.Lookup("10.5.18.1", "00:0D:3A:00:00:00"))
+}
+
+func TestAssetInventoryReload(t *testing.T) {
+	dir, path := writeTestInventory(t, "inventory.csv", testInventoryCSV)
+	defer os.RemoveAll(dir)
+	inventory, err := NewAssetInventory(path, time.Minute)
+	require.Nil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("vm,ip\nweb-vm-04,10.5.16.4\n"), 0644))
+	inventory.Enrich(sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0])
+	assert.Equal(t, "web-vm-03", inventory.Lookup("10.5.16.4", "").VM, "not checked before the reload interval")
+
+	inventory.lastCheck = time.Now().Add(-time.Hour)
+	inventory.Enrich(sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0])
+	assert.Equal(t, "web-vm-04", inventory.Lookup("10.5.16.4", "").VM)
+	assert.Nil(t, inventory.Lookup("10.5.17.20", ""))
+
+	// A broken inventory keeps the loaded assets.
+	require.Nil(t, ioutil.WriteFile(path, []byte("vm,ip\nweb-vm-05,not-an-ip\n"), 0644))
+	inventory.lastCheck = time.Now().Add(-time.Hour)
+	inventory.Enrich(sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0])
+	assert.Equal(t, "web-vm-04", inventory.Lookup("10.5.16.4", "").VM)
+}
+
+func TestAssetInventorySchemas(t *testing.T) {
+	dir, path := writeTestInventory(t, "inventory.csv", testInventoryCSV)
+	defer os.RemoveAll(dir)
+	inventory, err := NewAssetInventory(path, time.Minute)
+	require.Nil(t, err)
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0]
+	inventory.Enrich(event)
+
+	ecs, err := NewECSDocument(event)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"web-vm-03", "sql-01"}, schemaValue(t, ecs, "related", "hosts"))
+	assert.Equal(t, "sql-01-nic", schemaValue(t, ecs, "azure", "destination", "nic"))
+	assert.Equal(t, "web-team@example.com", schemaValue(t, ecs, "azure", "source", "owner"))
+
+	ocsf, err := NewOCSFEvent(event)
+	require.Nil(t, err)
+	assert.Equal(t, "web-vm-03", schemaValue(t, ocsf, "src_endpoint", "name"))
+	assert.Equal(t, "prod-vnet", schemaValue(t, ocsf, "dst_endpoint", "vpc_uid"))
+	assert.Equal(t, "dba@example.com", schemaValue(t, ocsf, "dst_endpoint", "owner", "name"))
+
+	text, err := event.CEFText()
+	require.Nil(t, err)
+	assert.Contains(t, text, "shost=web-vm-03")
+	assert.Contains(t, text, "dhost=sql-01")
+}
+
+func TestReadAssetsErrors(t *testing.T) {
+	_, err := ReadAssets("missing.csv")
+	assert.NotNil(t, err)
+	dir, path := writeTestInventory(t, "inventory.json", "[{")
+	defer os.RemoveAll(dir)
+	_, err = ReadAssets(path)
+	assert.NotNil(t, err)
+	_, err = NewAssetInventory(path, time.Minute)
+	assert.NotNil(t, err)
+}
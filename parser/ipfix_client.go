@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/ipfix"
+	log "github.com/sirupsen/logrus"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ipfixTemplateIPv4         uint16 = 256
+	ipfixTemplateIPv4Counters uint16 = 257
+	ipfixTemplateIPv6         uint16 = 258
+	ipfixTemplateIPv6Counters uint16 = 259
+
+	ipfixFirewallEventCreated uint8 = 1
+	ipfixFirewallEventDeleted uint8 = 2
+	ipfixFirewallEventDenied  uint8 = 3
+	ipfixFirewallEventUpdated uint8 = 5
+)
+
+var (
+	ipfixProtocols = map[string]uint8{
+		"TCP": 6,
+		"UDP": 17,
+	}
+	ipfixCounterFields = []ipfix.Field{
+		{ID: ipfix.PacketDeltaCount, Length: 8},
+		{ID: ipfix.OctetDeltaCount, Length: 8},
+		{ID: ipfix.PostPacketDeltaCount, Length: 8},
+		{ID: ipfix.PostOctetDeltaCount, Length: 8},
+	}
+	ipfixTemplates = []ipfix.Template{
+		{ID: ipfixTemplateIPv4, Fields: ipfixFlowFields(ipfix.SourceIPv4Address, ipfix.DestinationIPv4Address, 4)},
+		{ID: ipfixTemplateIPv4Counters, Fields: append(ipfixFlowFields(ipfix.SourceIPv4Address, ipfix.DestinationIPv4Address, 4), ipfixCounterFields...)},
+		{ID: ipfixTemplateIPv6, Fields: ipfixFlowFields(ipfix.SourceIPv6Address, ipfix.DestinationIPv6Address, 16)},
+		{ID: ipfixTemplateIPv6Counters, Fields: append(ipfixFlowFields(ipfix.SourceIPv6Address, ipfix.DestinationIPv6Address, 16), ipfixCounterFields...)},
+	}
+)
+
+func ipfixFlowFields(source, destination, addressLength uint16) []ipfix.Field {
+	return []ipfix.Field{
+		{ID: source, Length: addressLength},
+		{ID: destination, Length: addressLength},
+		{ID: ipfix.SourceTransportPort, Length: 2},
+		{ID: ipfix.DestinationTransportPort, Length: 2},
+		{ID: ipfix.ProtocolIdentifier, Length: 1},
+		{ID: ipfix.FlowDirection, Length: 1},
+		{ID: ipfix.FirewallEvent, Length: 1},
+		{ID: ipfix.FlowStartMilliseconds, Length: 8},
+	}
+}
+
+// IpfixConfig holds the settings for the IPFIX / NetFlow v9 destination.
+// Domains maps NSG names to observation domain IDs. NSGs without an entry get
+// a stable ID hashed from their name.
+type IpfixConfig struct {
+	Collector       string            `mapstructure:"ipfix_collector"`
+	Version         int               `mapstructure:"ipfix_version"`
+	MTU             int               `mapstructure:"ipfix_mtu"`
+	TemplateRefresh int               `mapstructure:"ipfix_template_refresh"`
+	Domains         map[string]uint32 `mapstructure:"ipfix_domains"`
+}
+
+// IpfixClient exports NSG flow tuples as IPFIX or NetFlow v9 data records over
+// UDP. Other log families are skipped.
+type IpfixClient struct {
+	config      IpfixConfig
+	conn        net.Conn
+	exporter    *ipfix.Exporter
+	domains     map[string]uint32
+	initialized bool
+}
+
+func (client *IpfixClient) Initialize(config IpfixConfig) error {
+	if config.Collector == "" {
+		return fmt.Errorf("ipfix_collector is required for the ipfix destination")
+	}
+	if config.TemplateRefresh <= 0 {
+		config.TemplateRefresh = int(ipfix.DefaultTemplateRefresh / time.Second)
+	}
+	conn, err := net.Dial("udp", config.Collector)
+	if err != nil {
+		return fmt.Errorf("error connecting to ipfix collector %s: %s", config.Collector, err)
+	}
+	exporter, err := ipfix.NewExporter(conn, ipfix.Config{
+		Version:         config.Version,
+		MTU:             config.MTU,
+		TemplateRefresh: time.Duration(config.TemplateRefresh) * time.Second,
+		Templates:       ipfixTemplates,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client.config = config
+	client.conn = conn
+	client.exporter = exporter
+	client.domains = map[string]uint32{}
+	for name, domain := range config.Domains {
+		client.domains[strings.ToUpper(name)] = domain
+	}
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"collector": config.Collector,
+		"version":   config.Version,
+	}).Info("initialized ipfix client")
+	return nil
+}
+
+func (client *IpfixClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents exports flow events grouped by NSG, one observation domain each.
+func (client *IpfixClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized ipfix client")
+	}
+	records := map[uint32][]ipfix.Record{}
+	domains := []uint32{}
+	skipped := 0
+	for _, event := range events {
+		if event.LogFamily() != LogFamilyNsgFlow {
+			skipped++
+			continue
+		}
+		record, err := newIpfixRecord(event)
+		if err != nil {
+			return err
+		}
+		domain := client.domain(event.Extension["cs2"])
+		if _, ok := records[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		records[domain] = append(records[domain], record)
+	}
+	if skipped > 0 {
+		log.Debugf("ipfix skipped %d events that are not nsg flows", skipped)
+	}
+	for _, domain := range domains {
+		err := client.exporter.Export(domain, records[domain])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the UDP socket.
+func (client *IpfixClient) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	return client.conn.Close()
+}
+
+func (client *IpfixClient) domain(nsgName string) uint32 {
+	name := strings.ToUpper(nsgName)
+	if domain, ok := client.domains[name]; ok {
+		return domain
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	domain := hash.Sum32()
+	client.domains[name] = domain
+	return domain
+}
+
+func newIpfixRecord(event *CEFEvent) (ipfix.Record, error) {
+	extension := event.Extension
+	source := net.ParseIP(extension["src"])
+	destination := net.ParseIP(extension["dst"])
+	if source == nil || destination == nil {
+		return ipfix.Record{}, fmt.Errorf("invalid flow addresses %q and %q", extension["src"], extension["dst"])
+	}
+	hasCounters := extension["cn1"] != ""
+
+	record := ipfix.Record{}
+	var data []byte
+	if source.To4() != nil && destination.To4() != nil {
+		record.Template = ipfixTemplateIPv4
+		data = append(data, source.To4()...)
+		data = append(data, destination.To4()...)
+	} else {
+		record.Template = ipfixTemplateIPv6
+		data = append(data, source.To16()...)
+		data = append(data, destination.To16()...)
+	}
+	if hasCounters {
+		record.Template++
+	}
+
+	data = appendIpfixUint(data, extension["spt"], 2)
+	data = appendIpfixUint(data, extension["dpt"], 2)
+	data = append(data, ipfixProtocols[extension["proto"]])
+	direction, _ := strconv.Atoi(extension["deviceDirection"])
+	data = append(data, uint8(direction))
+	data = append(data, ipfixFirewallEvent(event))
+	data = appendUint64(data, uint64(event.Time.UnixNano()/int64(time.Millisecond)))
+	if hasCounters {
+		for _, key := range []string{"cn1", "out", "cn2", "in"} {
+			data = appendIpfixUint(data, extension[key], 8)
+		}
+	}
+	record.Data = data
+	return record, nil
+}
+
+// ipfixFirewallEvent maps the flow decision and, for version 2 tuples, the
+// flow state to the firewallEvent information element.
+func ipfixFirewallEvent(event *CEFEvent) uint8 {
+	if event.Extension["categoryOutcome"] == "Deny" {
+		return ipfixFirewallEventDenied
+	}
+	switch event.Extension["cs5"] {
+	case "Continuing":
+		return ipfixFirewallEventUpdated
+	case "End":
+		return ipfixFirewallEventDeleted
+	default:
+		return ipfixFirewallEventCreated
+	}
+}
+
+func appendIpfixUint(data []byte, value string, length int) []byte {
+	number, _ := strconv.ParseUint(value, 10, length*8)
+	if length == 2 {
+		return append(data, byte(number>>8), byte(number))
+	}
+	return appendUint64(data, number)
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], value)
+	return append(data, buf[:]...)
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"github.com/dimitertodorov/nsg-parser/ipfix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+// collectIpfix decodes messages from conn until count data records arrive.
+func collectIpfix(t *testing.T, conn net.PacketConn, count int) []*ipfix.Message {
+	decoder := ipfix.NewDecoder()
+	messages := []*ipfix.Message{}
+	records := 0
+	buf := make([]byte, 65536)
+	for records < count {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err, "timed out waiting for ipfix messages")
+		message, err := decoder.Decode(buf[:n])
+		require.Nil(t, err)
+		records += len(message.Records)
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func newTestIpfixClient(t *testing.T, config IpfixConfig) (*IpfixClient, net.PacketConn) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	config.Collector = conn.LocalAddr().String()
+	client := &IpfixClient{}
+	require.Nil(t, client.Initialize(config))
+	return client, conn
+}
+
+func TestIpfixExport(t *testing.T) {
+	client, conn := newTestIpfixClient(t, IpfixConfig{Domains: map[string]uint32{"nsgname-nsg": 42}})
+	defer conn.Close()
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)[:200]
+	v2Events := loadTestEvents("nsg_flow_events_v2.json", t)
+	go func() {
+		assert.Nil(t, client.SendEvents(nil, append(append(events, v2Events...), loadTestAppGwEvents(t)...)))
+	}()
+	messages := collectIpfix(t, conn, len(events)+len(v2Events))
+
+	records := map[uint32][]ipfix.DataRecord{}
+	for _, message := range messages {
+		assert.Equal(t, ipfix.VersionIPFIX, message.Version)
+		records[message.Domain] = append(records[message.Domain], message.Records...)
+	}
+	require.Equal(t, len(events), len(records[42]))
+	assert.Equal(t, 2, len(records), "expected one observation domain per nsg")
+
+	first := records[42][0]
+	assert.Equal(t, ipfixTemplateIPv4, first.Template)
+	assert.Equal(t, net.ParseIP(events[0].Extension["src"]).To4(), net.IP(first.Values[ipfix.SourceIPv4Address]))
+	assert.Equal(t, uint64(443), first.Uint(ipfix.DestinationTransportPort))
+	assert.Equal(t, uint64(6), first.Uint(ipfix.ProtocolIdentifier))
+	assert.Equal(t, uint64(1), first.Uint(ipfix.FlowDirection))
+	assert.Equal(t, uint64(ipfixFirewallEventCreated), first.Uint(ipfix.FirewallEvent))
+	assert.Equal(t, uint64(events[0].Time.Unix()*1000), first.Uint(ipfix.FlowStartMilliseconds))
+
+	v2Domain := client.domain("NSGNAME-V2-NSG")
+	v2Records := records[v2Domain]
+	require.Equal(t, len(v2Events), len(v2Records))
+	assert.Equal(t, uint64(ipfixFirewallEventDenied), v2Records[0].Uint(ipfix.FirewallEvent))
+	assert.Equal(t, uint64(0), v2Records[0].Uint(ipfix.FlowDirection))
+
+	ended := v2Records[3]
+	assert.Equal(t, ipfixTemplateIPv4Counters, ended.Template)
+	assert.Equal(t, uint64(ipfixFirewallEventDeleted), ended.Uint(ipfix.FirewallEvent))
+	assert.Equal(t, uint64(1), ended.Uint(ipfix.PacketDeltaCount))
+	assert.Equal(t, uint64(66), ended.Uint(ipfix.OctetDeltaCount))
+
+	ipv6 := v2Records[5]
+	assert.Equal(t, ipfixTemplateIPv6Counters, ipv6.Template)
+	assert.Equal(t, net.ParseIP("2001:db8::4"), net.IP(ipv6.Values[ipfix.SourceIPv6Address]))
+	assert.Equal(t, uint64(27072), ipv6.Uint(ipfix.PostOctetDeltaCount))
+}
+
+func TestIpfixNetFlow9(t *testing.T) {
+	client, conn := newTestIpfixClient(t, IpfixConfig{Version: ipfix.VersionNetFlow9, MTU: 512})
+	defer conn.Close()
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)[:50]
+	go func() {
+		assert.Nil(t, client.SendEvents(nil, events))
+	}()
+	messages := collectIpfix(t, conn, len(events))
+	assert.True(t, len(messages) > 1)
+	for i, message := range messages {
+		assert.Equal(t, ipfix.VersionNetFlow9, message.Version)
+		assert.Equal(t, uint32(i), message.Sequence)
+	}
+}
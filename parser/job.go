@@ -23,7 +23,7 @@ type Job struct {
 	DoneChan      chan bool            `json:"-"`
 	LogFiles      []AzureLogFile   `json:"-"`
 	Tasks         []*pool.Task         `json:"-"`
-	TaskPool      pool.Pool            `json:"-"`
+	TaskPool      *pool.Pool           `json:"-"`
 	StartTime     time.Time
 	EndTime       time.Time
 	processMutex  *sync.Mutex `json:"-"`
@@ -118,7 +118,7 @@ func (job *Job) Run() {
 	}()
 	go job.logFileSink()
 	taskPool := pool.NewPool(job.Tasks, 1)
-	job.TaskPool = *taskPool
+	job.TaskPool = taskPool
 	job.TaskPool.Run()
 	for _, task := range job.TaskPool.Tasks {
 		if task.Err != nil {
@@ -4,6 +4,8 @@ import (
 	"testing"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
 )
 
 type AppGwFirewallMockClient struct{}
@@ -36,7 +38,12 @@ func TestAppGwFirewallJobRun(t *testing.T) {
 			logFile := loadTestAppGwFirewallLogFile(tt.testFile, t)
 			fileName := logFile.GetAzureEventLog().GetRecords()[0].getSourceFileName()
 			processStatus := ProcessStatus{fileName: createProcessStatusFromLogfile(logFile)}
-			job, err := NewJob(&JobOptions{}, processStatus, &AzureClient{}, client)
+			dataPath, err := ioutil.TempDir("", "nsg-parser-job")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataPath)
+			job, err := NewJob(&JobOptions{DataPath: dataPath}, processStatus, &AzureClient{}, client)
 			if err != nil {
 				t.Fatalf("got error creating job %s", err)
 			}
@@ -2,6 +2,8 @@ package parser
 
 import (
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
 	"testing"
 	"fmt"
 )
@@ -36,7 +38,12 @@ func TestJobRun(t *testing.T) {
 			logFile := loadTestLogFile(tt.testFile, t)
 			fileName := logFile.GetAzureEventLog().GetRecords()[0].getSourceFileName()
 			processStatus := ProcessStatus{fileName: createProcessStatusFromLogfile(logFile)}
-			job, err := NewJob(&JobOptions{}, processStatus, &AzureClient{}, client)
+			dataPath, err := ioutil.TempDir("", "nsg-parser-job")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataPath)
+			job, err := NewJob(&JobOptions{DataPath: dataPath}, processStatus, &AzureClient{}, client)
 			if err != nil {
 				t.Fatalf("got error creating job %s", err)
 			}
@@ -0,0 +1,319 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+	"github.com/xdg/scram"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	KafkaKeyNone  = "none"
+	KafkaKeyNsg   = "nsg"
+	KafkaKeyTuple = "tuple"
+
+	kafkaDefaultTopic    = "nsg-parser"
+	kafkaDefaultClientID = "nsg-parser"
+	kafkaMaxTopicLength  = 249
+)
+
+var kafkaTopicRegExp = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// KafkaConfig holds the settings for the Kafka destination.
+//
+// Topic is a text/template evaluated per event with .Family, .Resource,
+// .Subscription and .ResourceGroup, so "nsg-{{.Family}}" gives a topic per log
+// family and "nsg-{{.Resource}}" a topic per NSG or Application Gateway.
+type KafkaConfig struct {
+	Brokers            []string          `mapstructure:"kafka_brokers"`
+	Topic              string            `mapstructure:"kafka_topic"`
+	Key                string            `mapstructure:"kafka_key"`
+	Format             string            `mapstructure:"kafka_format"`
+	Compression        string            `mapstructure:"kafka_compression"`
+	RequiredAcks       string            `mapstructure:"kafka_required_acks"`
+	Timeout            int               `mapstructure:"kafka_timeout"`
+	ClientID           string            `mapstructure:"kafka_client_id"`
+	TLS                bool              `mapstructure:"kafka_tls"`
+	TLSCAFile          string            `mapstructure:"kafka_tls_ca_file"`
+	TLSCertFile        string            `mapstructure:"kafka_tls_cert_file"`
+	TLSKeyFile         string            `mapstructure:"kafka_tls_key_file"`
+	InsecureSkipVerify bool              `mapstructure:"kafka_tls_insecure_skip_verify"`
+	SASLMechanism      string            `mapstructure:"kafka_sasl_mechanism"`
+	SASLUsername       string            `mapstructure:"kafka_sasl_username"`
+	SASLPassword       string            `mapstructure:"kafka_sasl_password"`
+	Templates          map[string]string `mapstructure:"template_files"`
+}
+
+// KafkaClient produces one message per event with a sarama SyncProducer.
+// SendEvents returns once the configured acks level is satisfied, so the
+// checkpoint only advances after the brokers have accepted the messages.
+type KafkaClient struct {
+	config        KafkaConfig
+	producer      sarama.SyncProducer
+	formatter     EventFormatter
+	topicTemplate *template.Template
+	initialized   bool
+}
+
+type kafkaTopicData struct {
+	Family        string
+	Resource      string
+	Subscription  string
+	ResourceGroup string
+}
+
+func (client *KafkaClient) Initialize(config KafkaConfig) error {
+	if len(config.Brokers) == 0 {
+		return fmt.Errorf("kafka_brokers is required for the kafka destination")
+	}
+	if config.Topic == "" {
+		config.Topic = kafkaDefaultTopic
+	}
+	topicTemplate, err := template.New("kafkaTopic").Parse(config.Topic)
+	if err != nil {
+		return fmt.Errorf("invalid kafka_topic template: %s", err)
+	}
+	switch config.Key {
+	case "":
+		config.Key = KafkaKeyNsg
+	case KafkaKeyNone, KafkaKeyNsg, KafkaKeyTuple:
+	default:
+		return fmt.Errorf("unsupported kafka_key %q. expected none, nsg or tuple", config.Key)
+	}
+	formatter, err := NewEventFormatterWithOptions(config.Format, FormatOptions{Templates: config.Templates})
+	if err != nil {
+		return err
+	}
+	producerConfig, err := newKafkaProducerConfig(config)
+	if err != nil {
+		return err
+	}
+	producer, err := sarama.NewSyncProducer(config.Brokers, producerConfig)
+	if err != nil {
+		return fmt.Errorf("error connecting to kafka_brokers: %s", err)
+	}
+
+	client.config = config
+	client.producer = producer
+	client.formatter = formatter
+	client.topicTemplate = topicTemplate
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"brokers": strings.Join(config.Brokers, ","),
+		"topic":   config.Topic,
+		"acks":    producerConfig.Producer.RequiredAcks,
+	}).Info("initialized kafka client")
+	return nil
+}
+
+func (client *KafkaClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+func (client *KafkaClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized kafka client")
+	}
+	messages := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, event := range events {
+		message, err := client.newMessage(event)
+		if err == ErrSkipEvent {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return client.producer.SendMessages(messages)
+}
+
+// Close closes the connections to the brokers.
+func (client *KafkaClient) Close() error {
+	if client.producer == nil {
+		return nil
+	}
+	return client.producer.Close()
+}
+
+func (client *KafkaClient) newMessage(event *CEFEvent) (*sarama.ProducerMessage, error) {
+	value, err := client.formatter.Format(event)
+	if err == ErrSkipEvent {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("event_format_error %s", err)
+	}
+	topic, err := client.topic(event)
+	if err != nil {
+		return nil, err
+	}
+	message := &sarama.ProducerMessage{
+		Topic:     topic,
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: event.Time,
+	}
+	if key := client.key(event); key != nil {
+		message.Key = sarama.ByteEncoder(key)
+	}
+	return message, nil
+}
+
+func (client *KafkaClient) topic(event *CEFEvent) (string, error) {
+	var topic bytes.Buffer
+	err := client.topicTemplate.Execute(&topic, kafkaTopicData{
+		Family:        event.LogFamily(),
+		Resource:      event.Extension["cs2"],
+		Subscription:  event.Extension["cs3"],
+		ResourceGroup: event.Extension["cs4"],
+	})
+	if err != nil {
+		return "", fmt.Errorf("error rendering kafka topic: %s", err)
+	}
+	return sanitizeKafkaTopic(topic.String()), nil
+}
+
+// key returns the message key used for partitioning. tuple keys hash the NSG
+// and 5-tuple so every record of a flow lands on the same partition. Events
+// without a tuple fall back to the resource name.
+func (client *KafkaClient) key(event *CEFEvent) []byte {
+	switch client.config.Key {
+	case KafkaKeyNone:
+		return nil
+	case KafkaKeyTuple:
+		if event.Extension["src"] != "" && event.Extension["dst"] != "" {
+			hash := fnv.New64a()
+			fmt.Fprintf(hash, "%s|%s|%s|%s|%s|%s", event.Extension["cs2"],
+				event.Extension["src"], event.Extension["dst"],
+				event.Extension["spt"], event.Extension["dpt"], event.Extension["proto"])
+			return []byte(fmt.Sprintf("%016x", hash.Sum64()))
+		}
+	}
+	if event.Extension["cs2"] == "" {
+		return nil
+	}
+	return []byte(event.Extension["cs2"])
+}
+
+func sanitizeKafkaTopic(topic string) string {
+	topic = kafkaTopicRegExp.ReplaceAllString(topic, "_")
+	if topic == "" || topic == "." || topic == ".." {
+		return kafkaDefaultTopic
+	}
+	if len(topic) > kafkaMaxTopicLength {
+		topic = topic[:kafkaMaxTopicLength]
+	}
+	return topic
+}
+
+// newKafkaProducerConfig translates the settings into a sarama configuration
+// for a SyncProducer.
+func newKafkaProducerConfig(config KafkaConfig) (*sarama.Config, error) {
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V1_0_0_0
+	producerConfig.ClientID = config.ClientID
+	if producerConfig.ClientID == "" {
+		producerConfig.ClientID = kafkaDefaultClientID
+	}
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.Timeout = 30 * time.Second
+	if config.Timeout > 0 {
+		producerConfig.Producer.Timeout = time.Duration(config.Timeout) * time.Second
+	}
+	producerConfig.Net.DialTimeout = producerConfig.Producer.Timeout
+	producerConfig.Net.ReadTimeout = producerConfig.Producer.Timeout
+	producerConfig.Net.WriteTimeout = producerConfig.Producer.Timeout
+
+	switch config.Compression {
+	case "", "none":
+		producerConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		producerConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		producerConfig.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		producerConfig.Producer.Compression = sarama.CompressionLZ4
+	default:
+		return nil, fmt.Errorf("unsupported kafka_compression %q. expected none, gzip, snappy or lz4", config.Compression)
+	}
+	switch config.RequiredAcks {
+	case "none", "0":
+		producerConfig.Producer.RequiredAcks = sarama.NoResponse
+	case "", "leader", "1":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	case "all", "-1":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, fmt.Errorf("unsupported kafka_required_acks %q. expected none, leader or all", config.RequiredAcks)
+	}
+
+	if config.TLS {
+		tlsConfig, err := newTLSConfig(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		producerConfig.Net.TLS.Enable = true
+		producerConfig.Net.TLS.Config = tlsConfig
+	}
+	if config.SASLMechanism != "" {
+		producerConfig.Net.SASL.Enable = true
+		producerConfig.Net.SASL.User = config.SASLUsername
+		producerConfig.Net.SASL.Password = config.SASLPassword
+		switch strings.ToUpper(config.SASLMechanism) {
+		case sarama.SASLTypePlaintext:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hash: scram.HashGeneratorFcn(sha256.New)}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hash: scram.HashGeneratorFcn(sha512.New)}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported kafka_sasl_mechanism %q. expected PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", config.SASLMechanism)
+		}
+	}
+	err := producerConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka settings: %s", err)
+	}
+	return producerConfig, nil
+}
+
+// kafkaSCRAMClient runs the SCRAM exchange of sarama with xdg/scram.
+type kafkaSCRAMClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (client *kafkaSCRAMClient) Begin(userName, password, authzID string) error {
+	scramClient, err := client.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	client.conversation = scramClient.NewConversation()
+	return nil
+}
+
+func (client *kafkaSCRAMClient) Step(challenge string) (string, error) {
+	return client.conversation.Step(challenge)
+}
+
+func (client *kafkaSCRAMClient) Done() bool {
+	return client.conversation.Done()
+}
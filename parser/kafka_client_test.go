@@ -0,0 +1,159 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// newTestKafkaBroker starts a sarama MockBroker leading partitions of every
+// topic.
+func newTestKafkaBroker(t *testing.T, partitions int32, topics ...string) (*sarama.MockBroker, *sarama.MockProduceResponse) {
+	broker := sarama.NewMockBroker(t, 1)
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	for _, topic := range topics {
+		for partition := int32(0); partition < partitions; partition++ {
+			metadata.SetLeader(topic, partition, broker.BrokerID())
+		}
+	}
+	produce := sarama.NewMockProduceResponse(t).SetVersion(3)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"ProduceRequest":  produce,
+	})
+	return broker, produce
+}
+
+func newTestKafkaClient(t *testing.T, broker *sarama.MockBroker, config KafkaConfig) *KafkaClient {
+	config.Brokers = []string{broker.Addr()}
+	client := &KafkaClient{}
+	err := client.Initialize(config)
+	require.Nil(t, err, "error initializing kafka client")
+	return client
+}
+
+func kafkaProduceRequests(broker *sarama.MockBroker) int {
+	requests := 0
+	for _, exchange := range broker.History() {
+		if _, ok := exchange.Request.(*sarama.ProduceRequest); ok {
+			requests++
+		}
+	}
+	return requests
+}
+
+func TestKafkaSendEvents(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "snappy", "lz4"} {
+		broker, _ := newTestKafkaBroker(t, 6, "nsg-nsg_flow", "nsg-appgw_access")
+		client := newTestKafkaClient(t, broker, KafkaConfig{
+			Topic:        "nsg-{{.Family}}",
+			RequiredAcks: "all",
+			Compression:  compression,
+		})
+
+		events := append(loadTestEvents("nsg_flow_events.json", t), loadTestAppGwEvents(t)...)
+		err := client.SendEvents(nil, events)
+		assert.Nil(t, err, "unexpected error sending %s events", compression)
+		assert.True(t, kafkaProduceRequests(broker) > 0, "expected %s produce requests", compression)
+		client.Close()
+		broker.Close()
+	}
+}
+
+func TestKafkaMessages(t *testing.T) {
+	broker, _ := newTestKafkaBroker(t, 6, "nsg-nsg_flow")
+	defer broker.Close()
+	client := newTestKafkaClient(t, broker, KafkaConfig{Topic: "nsg-{{.Family}}"})
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	message, err := client.newMessage(events[0])
+	require.Nil(t, err)
+	assert.Equal(t, "nsg-nsg_flow", message.Topic)
+	assert.Equal(t, events[0].Time, message.Timestamp)
+
+	// The default key keeps every NSG on a single partition.
+	key, err := message.Key.Encode()
+	require.Nil(t, err)
+	assert.Equal(t, "NSGNAME-NSG", string(key))
+
+	value, err := message.Value.Encode()
+	require.Nil(t, err)
+	decoded := CEFEvent{}
+	err = json.Unmarshal(value, &decoded)
+	require.Nil(t, err)
+	assert.Equal(t, "DefaultRule_AllowVnetOutBound", decoded.Extension["cs1"])
+}
+
+func TestKafkaTupleKeyAndCEF(t *testing.T) {
+	broker, _ := newTestKafkaBroker(t, 6, "flows-NSGNAME-NSG")
+	defer broker.Close()
+	client := newTestKafkaClient(t, broker, KafkaConfig{
+		Topic:  "flows-{{.Resource}}",
+		Key:    KafkaKeyTuple,
+		Format: FormatCEF,
+	})
+	defer client.Close()
+
+	partitioner := sarama.NewHashPartitioner("flows-NSGNAME-NSG")
+	partitions := map[int32]bool{}
+	for _, event := range loadTestEvents("nsg_flow_events.json", t) {
+		message, err := client.newMessage(event)
+		require.Nil(t, err)
+		value, err := message.Value.Encode()
+		require.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(value), "CEF:0|Microsoft|Azure NSG|"))
+		partition, err := partitioner.Partition(message, 6)
+		require.Nil(t, err)
+		partitions[partition] = true
+	}
+	assert.True(t, len(partitions) > 1, "expected tuple keys to spread partitions")
+
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t))
+	assert.Nil(t, err, "unexpected error sending events")
+}
+
+func TestKafkaProduceErrorBlocksCheckpoint(t *testing.T) {
+	broker, produce := newTestKafkaBroker(t, 1, "nsg-parser")
+	defer broker.Close()
+	produce.SetError("nsg-parser", 0, sarama.ErrMessageSizeTooLarge)
+
+	client := newTestKafkaClient(t, broker, KafkaConfig{})
+	defer client.Close()
+
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:10])
+	assert.Error(t, err)
+}
+
+func TestKafkaInvalidConfig(t *testing.T) {
+	client := &KafkaClient{}
+	assert.Error(t, client.Initialize(KafkaConfig{}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, Key: "random"}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, Format: "xml"}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, RequiredAcks: "some"}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, Compression: "brotli"}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, SASLMechanism: "GSSAPI", SASLUsername: "a", SASLPassword: "b"}))
+	assert.Error(t, client.Initialize(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "{{.Family"}))
+}
+
+func TestKafkaSCRAMClient(t *testing.T) {
+	config, err := newKafkaProducerConfig(KafkaConfig{SASLMechanism: "scram-sha-512", SASLUsername: "user", SASLPassword: "pencil"})
+	require.Nil(t, err)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+
+	scramClient := config.Net.SASL.SCRAMClientGeneratorFunc()
+	require.Nil(t, scramClient.Begin("user", "pencil", ""))
+	first, err := scramClient.Step("")
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(first, "n,,n=user,r="))
+	assert.False(t, scramClient.Done())
+}
+
+func TestSanitizeKafkaTopic(t *testing.T) {
+	assert.Equal(t, "nsg_flow-RG_NAME", sanitizeKafkaTopic("nsg_flow-RG NAME"))
+	assert.Equal(t, kafkaDefaultTopic, sanitizeKafkaTopic(""))
+	assert.Equal(t, kafkaMaxTopicLength, len(sanitizeKafkaTopic(strings.Repeat("a", 300))))
+}
@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logAnalyticsAPIVersion        = "2016-04-01"
+	logAnalyticsResource          = "/api/logs"
+	logAnalyticsTimeField         = "EventTime"
+	logAnalyticsDefaultMaxPayload = 30 * 1024 * 1024
+)
+
+var (
+	logAnalyticsDefaultLogTypes = map[string]string{
+		LogFamilyNsgFlow:       "NsgFlow",
+		LogFamilyNsgEvent:      "NsgEvent",
+		LogFamilyAppGwAccess:   "AppGwAccess",
+		LogFamilyAppGwFirewall: "AppGwFirewall",
+	}
+	logAnalyticsFieldRegExp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+	logAnalyticsCustomLabel = regexp.MustCompile(`^(c[sn][0-9])$`)
+)
+
+// LogAnalyticsConfig holds the settings for the Log Analytics Data Collector API destination.
+// LogTypes maps log families to custom table names. Log Analytics appends _CL to them.
+type LogAnalyticsConfig struct {
+	WorkspaceID string            `mapstructure:"loganalytics_workspace_id"`
+	SharedKey   string            `mapstructure:"loganalytics_shared_key"`
+	LogTypes    map[string]string `mapstructure:"loganalytics_log_types"`
+	Endpoint    string            `mapstructure:"loganalytics_endpoint"`
+	MaxPayload  int               `mapstructure:"loganalytics_max_payload"`
+}
+
+// LogAnalyticsClient sends events to a Log Analytics (Microsoft Sentinel)
+// workspace as custom log tables using the HTTP Data Collector API.
+type LogAnalyticsClient struct {
+	config      LogAnalyticsConfig
+	sharedKey   []byte
+	httpClient  *http.Client
+	initialized bool
+}
+
+func (client *LogAnalyticsClient) Initialize(config LogAnalyticsConfig) error {
+	if config.WorkspaceID == "" || config.SharedKey == "" {
+		return fmt.Errorf("loganalytics_workspace_id and loganalytics_shared_key are required for the loganalytics destination")
+	}
+	sharedKey, err := base64.StdEncoding.DecodeString(config.SharedKey)
+	if err != nil {
+		return fmt.Errorf("loganalytics_shared_key must be base64 encoded: %s", err)
+	}
+	for family, logType := range config.LogTypes {
+		if !isValidLogType(logType) {
+			return fmt.Errorf("invalid log type %q for %s. only letters, numbers and _ are allowed", logType, family)
+		}
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://%s.ods.opinsights.azure.com", config.WorkspaceID)
+	}
+	config.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	if config.MaxPayload <= 0 {
+		config.MaxPayload = logAnalyticsDefaultMaxPayload
+	}
+
+	client.config = config
+	client.sharedKey = sharedKey
+	client.httpClient = &http.Client{Timeout: 60 * time.Second}
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"workspace": config.WorkspaceID,
+		"endpoint":  config.Endpoint,
+	}).Info("initialized log analytics client")
+	return nil
+}
+
+func (client *LogAnalyticsClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents groups events by log family and posts each group to its Log-Type,
+// splitting payloads so none exceeds MaxPayload bytes.
+func (client *LogAnalyticsClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized log analytics client")
+	}
+	families := []string{}
+	byFamily := map[string][]*CEFEvent{}
+	for _, event := range events {
+		family := event.LogFamily()
+		if _, ok := byFamily[family]; !ok {
+			families = append(families, family)
+		}
+		byFamily[family] = append(byFamily[family], event)
+	}
+
+	for _, family := range families {
+		logType := lookupFamilySetting(client.config.LogTypes, family, logAnalyticsDefaultLogTypes[family])
+		if logType == "" {
+			logType = "NsgParser"
+		}
+		err := client.sendFamily(logType, byFamily[family])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *LogAnalyticsClient) sendFamily(logType string, events []*CEFEvent) error {
+	var payload bytes.Buffer
+	count := 0
+	for _, event := range events {
+		record, err := json.Marshal(flattenCEFEvent(event))
+		if err != nil {
+			return fmt.Errorf("error marshalling to json %s", err)
+		}
+		if len(record)+2 > client.config.MaxPayload {
+			return fmt.Errorf("single record of %d bytes exceeds the %d byte payload limit", len(record), client.config.MaxPayload)
+		}
+		// +2 accounts for the separator and the closing bracket.
+		if count > 0 && payload.Len()+len(record)+2 > client.config.MaxPayload {
+			payload.WriteByte(']')
+			err = client.post(logType, payload.Bytes())
+			if err != nil {
+				return err
+			}
+			payload.Reset()
+			count = 0
+		}
+		if count == 0 {
+			payload.WriteByte('[')
+		} else {
+			payload.WriteByte(',')
+		}
+		payload.Write(record)
+		count++
+	}
+	if count > 0 {
+		payload.WriteByte(']')
+		return client.post(logType, payload.Bytes())
+	}
+	return nil
+}
+
+func (client *LogAnalyticsClient) post(logType string, payload []byte) error {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	url := fmt.Sprintf("%s%s?api-version=%s", client.config.Endpoint, logAnalyticsResource, logAnalyticsAPIVersion)
+	request, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Log-Type", logType)
+	request.Header.Set("x-ms-date", date)
+	request.Header.Set("time-generated-field", logAnalyticsTimeField)
+	request.Header.Set("Authorization", client.authorization(date, len(payload)))
+
+	resp, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("log analytics returned %d for %s: %s", resp.StatusCode, logType, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// authorization builds the SharedKey header for a Data Collector API request.
+func (client *LogAnalyticsClient) authorization(date string, contentLength int) string {
+	return fmt.Sprintf("SharedKey %s:%s", client.config.WorkspaceID,
+		logAnalyticsSignature(client.sharedKey, date, contentLength))
+}
+
+// logAnalyticsSignature returns the base64 HMAC-SHA256 of the Data Collector string to sign.
+func logAnalyticsSignature(sharedKey []byte, date string, contentLength int) string {
+	stringToSign := "POST\n" + strconv.Itoa(contentLength) + "\napplication/json\nx-ms-date:" + date + "\n" + logAnalyticsResource
+	mac := hmac.New(sha256.New, sharedKey)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isValidLogType(logType string) bool {
+	return logType != "" && len(logType) <= 100 && !logAnalyticsFieldRegExp.MatchString(logType)
+}
+
+// flattenCEFEvent renders event as a flat record suitable for a custom log table.
+// Custom CEF fields (cs1..cs6, cn1..cn3) are named after their label when one is set.
+func flattenCEFEvent(event *CEFEvent) map[string]interface{} {
+	record := map[string]interface{}{
+		logAnalyticsTimeField: event.Time.UTC().Format(time.RFC3339Nano),
+		"LogFamily":           event.LogFamily(),
+		"DeviceEventClassId":  event.DeviceEventClassId,
+		"Name":                event.Name,
+		"Severity":            event.Severity,
+	}
+	if event.DeviceProduct != nil {
+		record["DeviceProduct"] = *event.DeviceProduct
+	}
+	for key, value := range event.Extension {
+		if strings.HasSuffix(key, "label") && logAnalyticsCustomLabel.MatchString(strings.TrimSuffix(key, "label")) {
+			continue
+		}
+		name := key
+		if label := event.Extension[key+"label"]; label != "" && logAnalyticsCustomLabel.MatchString(key) {
+			name = logAnalyticsFieldRegExp.ReplaceAllString(strings.Title(label), "")
+		}
+		record[name] = value
+	}
+	return record
+}
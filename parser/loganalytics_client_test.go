@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testLogAnalyticsKey = base64.StdEncoding.EncodeToString([]byte("not-a-real-shared-key"))
+
+// mockLogAnalytics stands in for the Data Collector API and rejects any
+// request whose SharedKey signature does not verify. It signs the request as
+// documented for the API, independently of logAnalyticsSignature.
+type mockLogAnalytics struct {
+	mutex    sync.Mutex
+	requests map[string]int
+	records  map[string][]map[string]interface{}
+}
+
+func (mock *mockLogAnalytics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	key, _ := base64.StdEncoding.DecodeString(testLogAnalyticsKey)
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s\nx-ms-date:%s\n%s", r.Method, r.ContentLength, r.Header.Get("Content-Type"), r.Header.Get("x-ms-date"), r.URL.Path)
+	expected := "SharedKey test-workspace:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if r.ContentLength != int64(len(body)) || r.Header.Get("Authorization") != expected {
+		http.Error(w, "InvalidAuthorization", http.StatusForbidden)
+		return
+	}
+	if _, err := time.Parse(http.TimeFormat, r.Header.Get("x-ms-date")); err != nil {
+		http.Error(w, "InvalidDate", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path != logAnalyticsResource || r.URL.Query().Get("api-version") != logAnalyticsAPIVersion {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("time-generated-field") != logAnalyticsTimeField {
+		http.Error(w, "MissingTimeField", http.StatusBadRequest)
+		return
+	}
+
+	records := []map[string]interface{}{}
+	if err := json.Unmarshal(body, &records); err != nil {
+		http.Error(w, "InvalidDataFormat", http.StatusBadRequest)
+		return
+	}
+	logType := r.Header.Get("Log-Type")
+	mock.requests[logType]++
+	mock.records[logType] = append(mock.records[logType], records...)
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestLogAnalyticsClient(t *testing.T, server *httptest.Server, maxPayload int, key string) *LogAnalyticsClient {
+	client := &LogAnalyticsClient{}
+	err := client.Initialize(LogAnalyticsConfig{
+		WorkspaceID: "test-workspace",
+		SharedKey:   key,
+		Endpoint:    server.URL,
+		MaxPayload:  maxPayload,
+		LogTypes:    map[string]string{LogFamilyAppGwAccess: "WafAccess"},
+	})
+	require.Nil(t, err, "error initializing log analytics client")
+	return client
+}
+
+func TestLogAnalyticsSendEvents(t *testing.T) {
+	mock := &mockLogAnalytics{requests: map[string]int{}, records: map[string][]map[string]interface{}{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLogAnalyticsClient(t, server, 0, testLogAnalyticsKey)
+	flowEvents := loadTestEvents("nsg_flow_events.json", t)
+	events := append(flowEvents, loadTestAppGwEvents(t)...)
+
+	err := client.SendEvents(nil, events)
+	assert.Nil(t, err, "unexpected error sending events")
+	assert.Equal(t, 1, mock.requests["NsgFlow"])
+	assert.Equal(t, len(flowEvents), len(mock.records["NsgFlow"]))
+	assert.Equal(t, 1, mock.requests["WafAccess"])
+
+	first := mock.records["NsgFlow"][0]
+	assert.Equal(t, flowEvents[0].Time.UTC().Format(time.RFC3339Nano), first[logAnalyticsTimeField])
+	assert.Equal(t, flowEvents[0].Extension["src"], first["src"])
+	assert.Equal(t, "NSGNAME-NSG", first["AzureNSG"])
+	assert.Equal(t, "DefaultRule_AllowVnetOutBound", first["RuleName"])
+	assert.Nil(t, first["cs1label"])
+}
+
+func TestLogAnalyticsChunking(t *testing.T) {
+	mock := &mockLogAnalytics{requests: map[string]int{}, records: map[string][]map[string]interface{}{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLogAnalyticsClient(t, server, 64*1024, testLogAnalyticsKey)
+	events := loadTestEvents("nsg_flow_events.json", t)
+
+	err := client.SendEvents(nil, events)
+	assert.Nil(t, err, "unexpected error sending events")
+	assert.True(t, mock.requests["NsgFlow"] > 1, "expected payload to be split")
+	assert.Equal(t, len(events), len(mock.records["NsgFlow"]))
+}
+
+func TestLogAnalyticsBadSignature(t *testing.T) {
+	mock := &mockLogAnalytics{requests: map[string]int{}, records: map[string][]map[string]interface{}{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	wrongKey := base64.StdEncoding.EncodeToString([]byte("wrong-key"))
+	client := newTestLogAnalyticsClient(t, server, 0, wrongKey)
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:1])
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "403"))
+}
+
+func TestLogAnalyticsInvalidLogType(t *testing.T) {
+	client := &LogAnalyticsClient{}
+	err := client.Initialize(LogAnalyticsConfig{
+		WorkspaceID: "test-workspace",
+		SharedKey:   testLogAnalyticsKey,
+		LogTypes:    map[string]string{LogFamilyNsgFlow: "nsg-flow"},
+	})
+	assert.Error(t, err)
+}
+
+// TestLogAnalyticsSignature checks the signature against a known answer
+// computed outside this package.
+func TestLogAnalyticsSignature(t *testing.T) {
+	signature := logAnalyticsSignature([]byte("not-a-real-shared-key"), "Sun, 18 Nov 2018 12:00:00 GMT", 1024)
+	assert.Equal(t, "oJqKTk1AvdYfWUaMBwXeip/DxfdLpGUVr5rMfqcqRN0=", signature)
+}
@@ -0,0 +1,261 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	lokiPushPath          = "/loki/api/v1/push"
+	lokiDefaultBatchSize  = 1000
+	lokiDefaultMaxRetries = 5
+	lokiDefaultBackoff    = time.Second
+	lokiMaxBackoff        = time.Minute
+)
+
+// LokiConfig holds the settings for the Grafana Loki destination.
+// Labels are added to every stream alongside the log_family, resource,
+// subscription and action labels derived from each event.
+type LokiConfig struct {
+	URL                string            `mapstructure:"loki_url"`
+	TenantID           string            `mapstructure:"loki_tenant_id"`
+	Username           string            `mapstructure:"loki_username"`
+	Password           string            `mapstructure:"loki_password"`
+	Labels             map[string]string `mapstructure:"loki_labels"`
+	Format             string            `mapstructure:"loki_format"`
+	BatchSize          int               `mapstructure:"loki_batch_size"`
+	Gzip               bool              `mapstructure:"loki_gzip"`
+	MaxRetries         int               `mapstructure:"loki_max_retries"`
+	InsecureSkipVerify bool              `mapstructure:"loki_insecure_skip_verify"`
+	Templates          map[string]string `mapstructure:"template_files"`
+}
+
+// LokiClient pushes events to Loki using the JSON push API. Lines are sorted
+// by time before they are split into batches, so each stream is pushed in order.
+type LokiClient struct {
+	config       LokiConfig
+	formatter    EventFormatter
+	httpClient   *http.Client
+	retryBackoff time.Duration
+	initialized  bool
+}
+
+type lokiPushRequest struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (client *LokiClient) Initialize(config LokiConfig) error {
+	if config.URL == "" {
+		return fmt.Errorf("loki_url is required for the loki destination")
+	}
+	formatter, err := NewEventFormatterWithOptions(config.Format, FormatOptions{Templates: config.Templates})
+	if err != nil {
+		return err
+	}
+	if _, ok := formatter.(RecordFramer); ok {
+		return fmt.Errorf("loki_format %s is binary. loki log lines must be text", config.Format)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = lokiDefaultBatchSize
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = lokiDefaultMaxRetries
+	}
+	config.URL = strings.TrimSuffix(strings.TrimRight(config.URL, "/"), lokiPushPath)
+
+	client.config = config
+	client.formatter = formatter
+	client.httpClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+		},
+	}
+	client.retryBackoff = lokiDefaultBackoff
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"url":    config.URL,
+		"tenant": config.TenantID,
+	}).Info("initialized loki client")
+	return nil
+}
+
+func (client *LokiClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+func (client *LokiClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized loki client")
+	}
+	// Flow tuples are grouped by rule, so events are not in time order.
+	sorted := make([]*CEFEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	for start := 0; start < len(sorted); start += client.config.BatchSize {
+		end := start + client.config.BatchSize
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		request, err := client.newPushRequest(sorted[start:end])
+		if err != nil {
+			return err
+		}
+		if len(request.Streams) == 0 {
+			continue
+		}
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("error marshalling to json %s", err)
+		}
+		err = client.push(payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *LokiClient) newPushRequest(events []*CEFEvent) (lokiPushRequest, error) {
+	request := lokiPushRequest{}
+	streams := map[string]*lokiStream{}
+	for _, event := range events {
+		line, err := client.formatter.Format(event)
+		if err == ErrSkipEvent {
+			continue
+		}
+		if err != nil {
+			return request, fmt.Errorf("event_format_error %s", err)
+		}
+		labels := client.labels(event)
+		key := lokiStreamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			request.Streams = append(request.Streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(event.Time.UnixNano(), 10), string(line)})
+	}
+	return request, nil
+}
+
+// labels returns the stream labels for event. Only low cardinality values
+// are used. Addresses and ports stay in the line.
+func (client *LokiClient) labels(event *CEFEvent) map[string]string {
+	labels := map[string]string{}
+	for name, value := range client.config.Labels {
+		labels[name] = value
+	}
+	labels["log_family"] = event.LogFamily()
+	if resource := event.Extension["cs2"]; resource != "" {
+		labels["resource"] = resource
+	}
+	if subscription := event.Extension["cs3"]; subscription != "" {
+		labels["subscription"] = subscription
+	}
+	action := event.Extension["categoryOutcome"]
+	if action == "" {
+		action = event.Extension["act"]
+	}
+	if action != "" {
+		labels["action"] = action
+	}
+	return labels
+}
+
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&key, "%s=%q,", name, labels[name])
+	}
+	return key.String()
+}
+
+// push posts payload, retrying on 429 and 5xx responses with exponential
+// backoff. A Retry-After header from Loki takes precedence.
+func (client *LokiClient) push(payload []byte) error {
+	body := payload
+	if client.config.Gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(payload)
+		err := writer.Close()
+		if err != nil {
+			return err
+		}
+		body = compressed.Bytes()
+	}
+
+	backoff := client.retryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= client.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Warnf("loki push failed, retrying in %s: %s", backoff, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > lokiMaxBackoff {
+				backoff = lokiMaxBackoff
+			}
+		}
+
+		request, err := http.NewRequest("POST", client.config.URL+lokiPushPath, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if client.config.Gzip {
+			request.Header.Set("Content-Encoding", "gzip")
+		}
+		if client.config.TenantID != "" {
+			request.Header.Set("X-Scope-OrgID", client.config.TenantID)
+		}
+		if client.config.Username != "" {
+			request.SetBasicAuth(client.config.Username, client.config.Password)
+		}
+
+		resp, err := client.httpClient.Do(request)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		responseBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		lastErr = fmt.Errorf("loki returned %d: %s", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			backoff = time.Duration(seconds) * time.Second
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockLoki accepts pushes and rejects any stream whose lines go back in time.
+type mockLoki struct {
+	mutex     sync.Mutex
+	failures  []int
+	requests  int
+	streams   map[string][][2]string
+	labels    map[string]map[string]string
+	tenants   []string
+	lastTimes map[string]int64
+}
+
+func newMockLoki(failures ...int) *mockLoki {
+	return &mockLoki{
+		failures:  failures,
+		streams:   map[string][][2]string{},
+		labels:    map[string]map[string]string{},
+		lastTimes: map[string]int64{},
+	}
+}
+
+func (mock *mockLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.requests++
+	if len(mock.failures) > 0 {
+		status := mock.failures[0]
+		mock.failures = mock.failures[1:]
+		http.Error(w, "try again", status)
+		return
+	}
+	if r.URL.Path != lokiPushPath {
+		http.NotFound(w, r)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = reader
+	}
+	request := lokiPushRequest{}
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mock.tenants = append(mock.tenants, r.Header.Get("X-Scope-OrgID"))
+	for _, stream := range request.Streams {
+		key := lokiStreamKey(stream.Stream)
+		for _, value := range stream.Values {
+			ts, _ := strconv.ParseInt(value[0], 10, 64)
+			if ts < mock.lastTimes[key] {
+				http.Error(w, "entry out of order", http.StatusBadRequest)
+				return
+			}
+			mock.lastTimes[key] = ts
+		}
+		mock.labels[key] = stream.Stream
+		mock.streams[key] = append(mock.streams[key], stream.Values...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestLokiClient(t *testing.T, server *httptest.Server, config LokiConfig) *LokiClient {
+	config.URL = server.URL
+	client := &LokiClient{}
+	require.Nil(t, client.Initialize(config))
+	client.retryBackoff = time.Millisecond
+	return client
+}
+
+func TestLokiSendEvents(t *testing.T) {
+	mock := newMockLoki()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLokiClient(t, server, LokiConfig{
+		TenantID:  "network",
+		Labels:    map[string]string{"job": "nsg-parser"},
+		BatchSize: 1000,
+		Gzip:      true,
+	})
+	events := loadTestEvents("nsg_flow_events.json", t)
+	appGwEvents := loadTestAppGwEvents(t)
+	err := client.SendEvents(nil, append(events, appGwEvents...))
+	require.Nil(t, err, "unexpected error sending events")
+
+	assert.Equal(t, 5, mock.requests)
+	assert.Equal(t, "network", mock.tenants[0])
+
+	total := 0
+	flowStreams := 0
+	for key, values := range mock.streams {
+		total += len(values)
+		labels := mock.labels[key]
+		assert.Equal(t, "nsg-parser", labels["job"])
+		assert.NotContains(t, labels, "src", "addresses must not be labels")
+		if labels["log_family"] == LogFamilyNsgFlow {
+			flowStreams++
+			assert.Equal(t, "NSGNAME-NSG", labels["resource"])
+			assert.Equal(t, "SUBID", labels["subscription"])
+			assert.Contains(t, []string{"Allow", "Deny"}, labels["action"])
+		}
+	}
+	assert.Equal(t, len(events)+len(appGwEvents), total)
+	assert.True(t, flowStreams > 0)
+
+	line := CEFEvent{}
+	for key, values := range mock.streams {
+		if mock.labels[key]["log_family"] == LogFamilyNsgFlow {
+			require.Nil(t, json.Unmarshal([]byte(values[0][1]), &line))
+			ts, _ := strconv.ParseInt(values[0][0], 10, 64)
+			assert.Equal(t, line.Time.UnixNano(), ts)
+			break
+		}
+	}
+}
+
+func TestLokiRetry(t *testing.T) {
+	mock := newMockLoki(http.StatusTooManyRequests, http.StatusBadGateway)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLokiClient(t, server, LokiConfig{Format: FormatCEF})
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:10])
+	assert.Nil(t, err)
+	assert.Equal(t, 3, mock.requests)
+	for _, values := range mock.streams {
+		assert.True(t, strings.HasPrefix(values[0][1], "CEF:0|"))
+	}
+}
+
+func TestLokiNoRetryOnClientError(t *testing.T) {
+	mock := newMockLoki(http.StatusBadRequest)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLokiClient(t, server, LokiConfig{})
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:10])
+	assert.Error(t, err)
+	assert.Equal(t, 1, mock.requests)
+}
+
+func TestLokiGivesUp(t *testing.T) {
+	mock := newMockLoki(500, 500, 500)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestLokiClient(t, server, LokiConfig{MaxRetries: 2})
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:10])
+	assert.Error(t, err)
+	assert.Equal(t, 3, mock.requests)
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/lumberjack"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const lumberjackBeatName = "nsg-parser"
+
+// LumberjackConfig holds the settings for the Lumberjack v2 (Logstash beats input) destination.
+// Hosts are tried in order, moving to the next host when a connection fails.
+// Schema, when set to ecs or ocsf, sends schema documents instead of events.
+type LumberjackConfig struct {
+	Hosts              []string `mapstructure:"lumberjack_hosts"`
+	Schema             string   `mapstructure:"lumberjack_schema"`
+	WindowSize         int      `mapstructure:"lumberjack_window_size"`
+	CompressionLevel   int      `mapstructure:"lumberjack_compression_level"`
+	Timeout            int      `mapstructure:"lumberjack_timeout"`
+	TLS                bool     `mapstructure:"lumberjack_tls"`
+	TLSCAFile          string   `mapstructure:"lumberjack_tls_ca_file"`
+	TLSCertFile        string   `mapstructure:"lumberjack_tls_cert_file"`
+	TLSKeyFile         string   `mapstructure:"lumberjack_tls_key_file"`
+	InsecureSkipVerify bool     `mapstructure:"lumberjack_tls_insecure_skip_verify"`
+}
+
+// LumberjackClient sends events to a Logstash beats input. Only events acked
+// by Logstash count as delivered. When a connection fails part way through,
+// delivery resumes on the next host from the last acked event.
+type LumberjackClient struct {
+	config      LumberjackConfig
+	connConfig  lumberjack.Config
+	conn        *lumberjack.Client
+	nextHost    int
+	initialized bool
+}
+
+type lumberjackMetadata struct {
+	Beat string `json:"beat"`
+	Type string `json:"type"`
+}
+
+type lumberjackDocument struct {
+	Timestamp string             `json:"@timestamp"`
+	Metadata  lumberjackMetadata `json:"@metadata"`
+	LogFamily string             `json:"log_family"`
+	*CEFEvent
+}
+
+func (client *LumberjackClient) Initialize(config LumberjackConfig) error {
+	if len(config.Hosts) == 0 {
+		return fmt.Errorf("lumberjack_hosts is required for the lumberjack destination")
+	}
+	if config.CompressionLevel < 0 || config.CompressionLevel > 9 {
+		return fmt.Errorf("lumberjack_compression_level must be between 0 and 9")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = int(lumberjack.DefaultTimeout / time.Second)
+	}
+	if config.Schema != "" && config.Schema != SchemaECS && config.Schema != SchemaOCSF {
+		return fmt.Errorf("lumberjack_schema must be %s or %s", SchemaECS, SchemaOCSF)
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLS {
+		var err error
+		tlsConfig, err = newTLSConfig(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.InsecureSkipVerify)
+		if err != nil {
+			return err
+		}
+	}
+
+	client.config = config
+	client.connConfig = lumberjack.Config{
+		WindowSize:       config.WindowSize,
+		CompressionLevel: config.CompressionLevel,
+		Timeout:          time.Duration(config.Timeout) * time.Second,
+		TLS:              tlsConfig,
+	}
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"hosts":  strings.Join(config.Hosts, ","),
+		"tls":    config.TLS,
+		"schema": config.Schema,
+	}).Info("initialized lumberjack client")
+	return nil
+}
+
+func (client *LumberjackClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents tries each host at most once and only returns nil once every
+// event has been acked.
+func (client *LumberjackClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized lumberjack client")
+	}
+	documents := make([][]byte, 0, len(events))
+	for _, event := range events {
+		document, err := client.marshalDocument(event)
+		if err != nil {
+			return fmt.Errorf("error marshalling to json %s", err)
+		}
+		documents = append(documents, document)
+	}
+
+	sent := 0
+	var lastErr error
+	for attempt := 0; attempt < len(client.config.Hosts); attempt++ {
+		if client.conn == nil {
+			host := client.config.Hosts[client.nextHost%len(client.config.Hosts)]
+			client.nextHost++
+			conn, err := lumberjack.Dial(host, client.connConfig)
+			if err != nil {
+				log.WithField("host", host).Warnf("lumberjack connection failed: %s", err)
+				lastErr = err
+				continue
+			}
+			client.conn = conn
+		}
+
+		acked, err := client.conn.Send(documents[sent:])
+		sent += acked
+		if err == nil {
+			return nil
+		}
+		log.Warnf("lumberjack send failed after %d of %d events: %s", sent, len(documents), err)
+		lastErr = err
+		client.Close()
+	}
+	return fmt.Errorf("lumberjack delivery failed. %d of %d events acked: %s", sent, len(documents), lastErr)
+}
+
+// Close closes the current connection, if any.
+func (client *LumberjackClient) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	err := client.conn.Close()
+	client.conn = nil
+	return err
+}
+
+// marshalDocument renders an event as a beats document, mapped to the
+// configured schema if any.
+func (client *LumberjackClient) marshalDocument(event *CEFEvent) ([]byte, error) {
+	if client.config.Schema == "" {
+		return json.Marshal(newLumberjackDocument(event))
+	}
+	document, err := NewSchemaDocument(client.config.Schema, event)
+	if err != nil {
+		return nil, err
+	}
+	document["@timestamp"] = event.Time.UTC().Format("2006-01-02T15:04:05.000Z")
+	document["@metadata"] = lumberjackMetadata{Beat: lumberjackBeatName, Type: event.LogFamily()}
+	return json.Marshal(document)
+}
+
+func newLumberjackDocument(event *CEFEvent) lumberjackDocument {
+	family := event.LogFamily()
+	return lumberjackDocument{
+		Timestamp: event.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Metadata:  lumberjackMetadata{Beat: lumberjackBeatName, Type: family},
+		LogFamily: family,
+		CEFEvent:  event,
+	}
+}
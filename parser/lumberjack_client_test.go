@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"github.com/dimitertodorov/nsg-parser/internal/lumberjacktest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func newTestLumberjackClient(t *testing.T, config LumberjackConfig) *LumberjackClient {
+	client := &LumberjackClient{}
+	err := client.Initialize(config)
+	require.Nil(t, err, "error initializing lumberjack client")
+	return client
+}
+
+func TestLumberjackSendEvents(t *testing.T) {
+	server, err := lumberjacktest.NewServer(nil)
+	require.Nil(t, err)
+	defer server.Close()
+
+	// Grab a free port with nothing listening to exercise failover.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	deadHost := listener.Addr().String()
+	listener.Close()
+
+	client := newTestLumberjackClient(t, LumberjackConfig{
+		Hosts:            []string{deadHost, server.Addr()},
+		WindowSize:       500,
+		CompressionLevel: 3,
+	})
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	err = client.SendEvents(nil, events)
+	assert.Nil(t, err, "unexpected error sending events")
+
+	documents := server.Documents()
+	require.Equal(t, len(events), len(documents))
+	assert.Equal(t, 9, server.Windows())
+	assert.Equal(t, LogFamilyNsgFlow, documents[0]["log_family"])
+	assert.Equal(t, events[0].Time.UTC().Format("2006-01-02T15:04:05.000Z"), documents[0]["@timestamp"])
+	assert.Equal(t, "nsg-parser", documents[0]["@metadata"].(map[string]interface{})["beat"])
+	assert.Equal(t, "NSGNAME-NSG", documents[0]["extension"].(map[string]interface{})["cs2"])
+}
+
+func TestLumberjackUnacked(t *testing.T) {
+	server, err := lumberjacktest.NewServer(nil)
+	require.Nil(t, err)
+	defer server.Close()
+	server.WithholdAcks = true
+
+	client := newTestLumberjackClient(t, LumberjackConfig{Hosts: []string{server.Addr()}, Timeout: 1})
+	defer client.Close()
+
+	err = client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:5])
+	assert.Error(t, err, "unacked events must not be reported as delivered")
+}
+
+func TestLumberjackInvalidConfig(t *testing.T) {
+	client := &LumberjackClient{}
+	assert.Error(t, client.Initialize(LumberjackConfig{}))
+	assert.Error(t, client.Initialize(LumberjackConfig{Hosts: []string{"localhost:5044"}, CompressionLevel: 10}))
+	assert.Error(t, client.Initialize(LumberjackConfig{Hosts: []string{"localhost:5044"}, TLS: true, TLSCAFile: "missing.pem"}))
+}
+
+func TestLumberjackSchema(t *testing.T) {
+	server, err := lumberjacktest.NewServer(nil)
+	require.Nil(t, err)
+	defer server.Close()
+
+	client := newTestLumberjackClient(t, LumberjackConfig{Hosts: []string{server.Addr()}, Schema: SchemaECS})
+	defer client.Close()
+
+	events := loadTestEvents("nsg_flow_events.json", t)[:2]
+	require.Nil(t, client.SendEvents(nil, events))
+
+	documents := server.Documents()
+	require.Equal(t, 2, len(documents))
+	assert.Equal(t, events[0].Time.UTC().Format("2006-01-02T15:04:05.000Z"), documents[0]["@timestamp"])
+	assert.Equal(t, LogFamilyNsgFlow, documents[0]["@metadata"].(map[string]interface{})["type"])
+	assert.Equal(t, "10.193.160.4", documents[0]["source"].(map[string]interface{})["ip"])
+	assert.Nil(t, documents[0]["extension"])
+
+	assert.Error(t, client.Initialize(LumberjackConfig{Hosts: []string{server.Addr()}, Schema: "cim"}))
+}
@@ -0,0 +1,517 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/DataDog/zstd"
+	goparquet "github.com/fraugster/parquet-go"
+	format "github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	parquetDefaultPartition    = "category={{.Family}}/date={{.Date}}/hour={{.Hour}}"
+	parquetDefaultCompression  = "snappy"
+	parquetDefaultRowGroupSize = 100000
+	parquetMaxLineSize         = 16 * 1024 * 1024
+)
+
+// ParquetConfig holds the settings for the Parquet destination. Partition
+// takes the same template as file_partition but must include {{.Family}}, as
+// each file holds a single table. RowGroupSize is counted in rows.
+type ParquetConfig struct {
+	DataPath       string `mapstructure:"data_path"`
+	Path           string `mapstructure:"parquet_path"`
+	Partition      string `mapstructure:"parquet_partition"`
+	Compression    string `mapstructure:"parquet_compression"`
+	RowGroupSize   int    `mapstructure:"parquet_row_group_size"`
+	RotateSize     int    `mapstructure:"parquet_rotate_size"`
+	RotateInterval int    `mapstructure:"parquet_rotate_interval"`
+}
+
+// ParquetClient writes NSG flow tuples and Application Gateway access and
+// firewall records to typed Parquet tables.
+//
+// Events are journaled as NDJSON by a FileClient, which takes care of
+// partitioning, rotation and crash recovery. When a journal rotates it is
+// converted to a Parquet file that is renamed into place once complete.
+type ParquetClient struct {
+	config      ParquetConfig
+	files       FileClient
+	codec       format.CompressionCodec
+	initialized bool
+}
+
+// parquetZstd compresses pages with zstd, which parquet-go leaves to its
+// users to register.
+type parquetZstd struct{}
+
+func (parquetZstd) CompressBlock(block []byte) ([]byte, error) {
+	return zstd.Compress(nil, block)
+}
+
+func (parquetZstd) DecompressBlock(block []byte) ([]byte, error) {
+	return zstd.Decompress(nil, block)
+}
+
+func init() {
+	goparquet.RegisterBlockCompressor(format.CompressionCodec_ZSTD, parquetZstd{})
+}
+
+// parquetTable maps the events of one log family to rows of a schema. Rows
+// hold a value or nil for each column, in schema order.
+type parquetTable struct {
+	schema *parquetschema.SchemaDefinition
+	row    func(event *CEFEvent) ([]interface{}, error)
+}
+
+var parquetTables = map[string]parquetTable{
+	LogFamilyNsgFlow: {
+		schema: mustParseParquetSchema(`message nsg_flow {
+	required int64 time (TIMESTAMP(MILLIS, true));
+	optional binary systemId (STRING);
+	optional binary category (STRING);
+	optional binary resourceId (STRING);
+	optional binary operationName (STRING);
+	optional binary subscriptionId (STRING);
+	optional binary resourceGroup (STRING);
+	optional binary nsgName (STRING);
+	optional binary rule (STRING);
+	optional binary mac (STRING);
+	optional binary sourceIp (STRING);
+	optional binary destinationIp (STRING);
+	optional int32 sourcePort;
+	optional int32 destinationPort;
+	optional binary protocol (STRING);
+	optional binary trafficFlow (STRING);
+	optional binary traffic (STRING);
+	required int32 version;
+	optional binary flowState (STRING);
+	optional int64 packetsSourceToDestination;
+	optional int64 bytesSourceToDestination;
+	optional int64 packetsDestinationToSource;
+	optional int64 bytesDestinationToSource;
+	optional binary trafficType (STRING);
+	optional binary sourceNetwork (STRING);
+	optional binary destinationNetwork (STRING);
+	optional binary sourceServiceTag (STRING);
+	optional binary destinationServiceTag (STRING);
+}`),
+		row: nsgFlowParquetRow,
+	},
+	LogFamilyAppGwAccess: {
+		schema: mustParseParquetSchema(`message appgw_access {
+	required int64 time (TIMESTAMP(MILLIS, true));
+	optional binary category (STRING);
+	optional binary operationName (STRING);
+	optional binary subscriptionId (STRING);
+	optional binary resourceGroup (STRING);
+	optional binary appGatewayName (STRING);
+	optional binary instanceId (STRING);
+	optional binary clientIp (STRING);
+	optional int32 clientPort;
+	optional binary httpMethod (STRING);
+	optional binary requestUri (STRING);
+	optional binary requestQuery (STRING);
+	optional binary userAgent (STRING);
+	optional int32 httpStatus;
+	optional binary httpVersion (STRING);
+	optional int64 receivedBytes;
+	optional int64 sentBytes;
+	optional int64 timeTaken;
+	optional binary sslEnabled (STRING);
+	optional binary trafficType (STRING);
+	optional binary sourceNetwork (STRING);
+	optional binary sourceServiceTag (STRING);
+}`),
+		row: appGwAccessParquetRow,
+	},
+	LogFamilyAppGwFirewall: {
+		schema: mustParseParquetSchema(`message appgw_firewall {
+	required int64 time (TIMESTAMP(MILLIS, true));
+	optional binary category (STRING);
+	optional binary operationName (STRING);
+	optional binary subscriptionId (STRING);
+	optional binary resourceGroup (STRING);
+	optional binary appGatewayName (STRING);
+	optional binary instanceId (STRING);
+	optional binary clientIp (STRING);
+	optional int32 clientPort;
+	optional binary requestUri (STRING);
+	optional binary ruleSetType (STRING);
+	optional binary ruleSetVersion (STRING);
+	optional binary ruleId (STRING);
+	optional binary message (STRING);
+	optional binary action (STRING);
+	optional binary site (STRING);
+	optional binary detailsMessage (STRING);
+	optional binary detailsData (STRING);
+	optional binary detailsFile (STRING);
+	optional binary detailsLine (STRING);
+	optional binary trafficType (STRING);
+	optional binary sourceNetwork (STRING);
+	optional binary sourceServiceTag (STRING);
+}`),
+		row: appGwFirewallParquetRow,
+	},
+}
+
+// mustParseParquetSchema parses a table schema, panicking when it is invalid
+// like regexp.MustCompile.
+func mustParseParquetSchema(text string) *parquetschema.SchemaDefinition {
+	schema, err := parquetschema.ParseSchemaDefinition(text)
+	if err != nil {
+		panic(fmt.Sprintf("invalid parquet schema: %s", err))
+	}
+	return schema
+}
+
+// parseParquetCodec parses none, snappy or zstd.
+func parseParquetCodec(name string) (format.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "none", "uncompressed":
+		return format.CompressionCodec_UNCOMPRESSED, nil
+	case "snappy":
+		return format.CompressionCodec_SNAPPY, nil
+	case "zstd":
+		return format.CompressionCodec_ZSTD, nil
+	}
+	return format.CompressionCodec_UNCOMPRESSED, fmt.Errorf("unsupported parquet_compression %q. expected none, snappy or zstd", name)
+}
+
+func (client *ParquetClient) Initialize(config ParquetConfig) error {
+	if config.Path == "" {
+		config.Path = filepath.Join(config.DataPath, "parquet")
+	}
+	if config.Partition == "" {
+		config.Partition = parquetDefaultPartition
+	}
+	if !strings.Contains(config.Partition, ".Family") {
+		return fmt.Errorf("parquet_partition must include {{.Family}}")
+	}
+	if config.Compression == "" {
+		config.Compression = parquetDefaultCompression
+	}
+	codec, err := parseParquetCodec(config.Compression)
+	if err != nil {
+		return err
+	}
+	if config.RowGroupSize <= 0 {
+		config.RowGroupSize = parquetDefaultRowGroupSize
+	}
+
+	client.config = config
+	client.codec = codec
+	client.files = FileClient{extension: "parquet", convert: client.convert}
+	err = client.files.Initialize(FileConfig{
+		Path:           config.Path,
+		Format:         FormatJSON,
+		Partition:      config.Partition,
+		RotateSize:     config.RotateSize,
+		RotateInterval: config.RotateInterval,
+	})
+	if err != nil {
+		return err
+	}
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"path":        config.Path,
+		"compression": config.Compression,
+	}).Info("initialized parquet client")
+	return nil
+}
+
+func (client *ParquetClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents journals the events that have a Parquet table.
+func (client *ParquetClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized parquet client")
+	}
+	tabled := make([]*CEFEvent, 0, len(events))
+	for _, event := range events {
+		if _, ok := parquetTables[event.LogFamily()]; ok {
+			tabled = append(tabled, event)
+		}
+	}
+	if skipped := len(events) - len(tabled); skipped > 0 {
+		log.Debugf("parquet skipped %d events without a table", skipped)
+	}
+	if len(tabled) == 0 {
+		return nil
+	}
+	return client.files.SendEvents(logFile, tabled)
+}
+
+// Close converts every open journal.
+func (client *ParquetClient) Close() error {
+	return client.files.Close()
+}
+
+// convert writes the journaled events in source to a Parquet file, starting a
+// row group every RowGroupSize rows. An incomplete last line, left by a crash
+// while journaling, is skipped.
+func (client *ParquetClient) convert(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var writer *goparquet.FileWriter
+	var table parquetTable
+	rows := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), parquetMaxLineSize)
+	for scanner.Scan() {
+		event := &CEFEvent{}
+		err = json.Unmarshal(scanner.Bytes(), event)
+		if err != nil {
+			if !scanner.Scan() && scanner.Err() == nil {
+				// A crash while writing leaves the last line incomplete.
+				log.WithField("file", source).Warnf("skipping incomplete last journal line: %s", err)
+				break
+			}
+			return fmt.Errorf("error reading journaled event: %s", err)
+		}
+		if writer == nil {
+			table = parquetTables[event.LogFamily()]
+			if table.schema == nil {
+				return fmt.Errorf("no parquet table for %s events", event.LogFamily())
+			}
+			writer = goparquet.NewFileWriter(out,
+				goparquet.WithSchemaDefinition(table.schema),
+				goparquet.WithCompressionCodec(client.codec),
+				goparquet.WithCreator("nsg-parser"),
+				goparquet.WithMetaData(map[string]string{"nsg-parser.log_family": event.LogFamily()}))
+		}
+		row, err := table.row(event)
+		if err != nil {
+			return err
+		}
+		data, err := parquetData(table.schema, row)
+		if err != nil {
+			return err
+		}
+		err = writer.AddData(data)
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%client.config.RowGroupSize == 0 {
+			err = writer.FlushRowGroup()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if writer == nil {
+		return fmt.Errorf("no events in %s", source)
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// parquetData converts a row to the column values parquet-go writes. Nil
+// values are left out, strings become bytes, times become milliseconds and
+// ints take the width of their column.
+func parquetData(schema *parquetschema.SchemaDefinition, row []interface{}) (map[string]interface{}, error) {
+	columns := schema.RootColumn.Children
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("parquet row has %d values for %d columns", len(row), len(columns))
+	}
+	data := make(map[string]interface{}, len(row))
+	for i, value := range row {
+		element := columns[i].SchemaElement
+		switch typed := value.(type) {
+		case nil:
+			continue
+		case string:
+			value = []byte(typed)
+		case time.Time:
+			value = typed.UnixNano() / int64(time.Millisecond)
+		case int:
+			if element.GetType() == format.Type_INT64 {
+				value = int64(typed)
+			} else if typed < -1<<31 || typed >= 1<<31 {
+				return nil, fmt.Errorf("value %d out of range for parquet column %s", typed, element.Name)
+			} else {
+				value = int32(typed)
+			}
+		}
+		data[element.Name] = value
+	}
+	return data, nil
+}
+
+func nsgFlowParquetRow(event *CEFEvent) ([]interface{}, error) {
+	flowLog, err := NewNsgFlowLog(event)
+	if err != nil {
+		return nil, err
+	}
+	row := []interface{}{
+		event.Time,
+		optionalString(flowLog.SystemID),
+		optionalString(flowLog.Category),
+		optionalString(flowLog.ResourceID),
+		optionalString(flowLog.OperationName),
+		optionalString(flowLog.SubscriptionID),
+		optionalString(flowLog.ResourceGroup),
+		optionalString(flowLog.NsgName),
+		optionalString(flowLog.Rule),
+		optionalString(flowLog.Mac),
+		optionalString(flowLog.SourceIP),
+		optionalString(flowLog.DestinationIP),
+		optionalInt(event.Extension["spt"]),
+		optionalInt(event.Extension["dpt"]),
+		optionalString(flowLog.Protocol),
+		optionalString(flowLog.TrafficFlow),
+		optionalString(flowLog.Traffic),
+		flowLog.Version,
+		optionalString(flowLog.FlowState),
+	}
+	for _, counter := range []*int64{flowLog.PacketsSent, flowLog.BytesSent, flowLog.PacketsReceived, flowLog.BytesReceived} {
+		if counter == nil {
+			row = append(row, nil)
+		} else {
+			row = append(row, *counter)
+		}
+	}
+	row = append(row, optionalString(flowLog.TrafficType), optionalString(flowLog.SourceNetwork),
+		optionalString(flowLog.DestinationNetwork), optionalString(flowLog.SourceServiceTag), optionalString(flowLog.DestinationServiceTag))
+	return row, nil
+}
+
+func appGwAccessParquetRow(event *CEFEvent) ([]interface{}, error) {
+	properties, err := recordProperties(event)
+	if err != nil {
+		return nil, err
+	}
+	category, operationName := azureCategory(event)
+	return []interface{}{
+		event.Time,
+		optionalString(category),
+		optionalString(operationName),
+		optionalString(event.Extension["cs3"]),
+		optionalString(event.Extension["cs4"]),
+		optionalString(event.Extension["cs2"]),
+		propertyString(properties, "instanceId"),
+		propertyString(properties, "clientIP"),
+		propertyInt32(properties, "clientPort"),
+		propertyString(properties, "httpMethod"),
+		propertyString(properties, "requestUri"),
+		propertyString(properties, "requestQuery"),
+		propertyString(properties, "userAgent"),
+		propertyInt32(properties, "httpStatus"),
+		propertyString(properties, "httpVersion"),
+		propertyInt64(properties, "receivedBytes"),
+		propertyInt64(properties, "sentBytes"),
+		propertyInt64(properties, "timeTaken"),
+		propertyString(properties, "sslEnabled"),
+		optionalString(event.Extension[TrafficTypeKey]),
+		optionalString(event.Extension["source"+NetworkKey]),
+		optionalString(event.Extension["source"+ServiceTagKey]),
+	}, nil
+}
+
+func appGwFirewallParquetRow(event *CEFEvent) ([]interface{}, error) {
+	properties, err := recordProperties(event)
+	if err != nil {
+		return nil, err
+	}
+	details, _ := properties["details"].(map[string]interface{})
+	category, operationName := azureCategory(event)
+	return []interface{}{
+		event.Time,
+		optionalString(category),
+		optionalString(operationName),
+		optionalString(event.Extension["cs3"]),
+		optionalString(event.Extension["cs4"]),
+		optionalString(event.Extension["cs2"]),
+		propertyString(properties, "instanceId"),
+		propertyString(properties, "clientIp"),
+		propertyInt32(properties, "clientPort"),
+		propertyString(properties, "requestUri"),
+		propertyString(properties, "ruleSetType"),
+		propertyString(properties, "ruleSetVersion"),
+		propertyString(properties, "ruleId"),
+		propertyString(properties, "message"),
+		propertyString(properties, "action"),
+		propertyString(properties, "site"),
+		propertyString(details, "message"),
+		propertyString(details, "data"),
+		propertyString(details, "file"),
+		propertyString(details, "line"),
+		optionalString(event.Extension[TrafficTypeKey]),
+		optionalString(event.Extension["source"+NetworkKey]),
+		optionalString(event.Extension["source"+ServiceTagKey]),
+	}, nil
+}
+
+func optionalString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func optionalInt(value string) interface{} {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return number
+}
+
+func propertyString(properties map[string]interface{}, key string) interface{} {
+	switch value := properties[key].(type) {
+	case nil:
+		return nil
+	case string:
+		return optionalString(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func propertyInt64(properties map[string]interface{}, key string) interface{} {
+	switch value := properties[key].(type) {
+	case float64:
+		return int64(value)
+	case string:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return number
+		}
+	}
+	return nil
+}
+
+func propertyInt32(properties map[string]interface{}, key string) interface{} {
+	number, ok := propertyInt64(properties, key).(int64)
+	if !ok || number < -1<<31 || number >= 1<<31 {
+		return nil
+	}
+	return int32(number)
+}
@@ -0,0 +1,227 @@
+package parser
+
+import (
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parquetTestFile is the content of a Parquet file. Strings are read back
+// from bytes and null values are left out of rows.
+type parquetTestFile struct {
+	Schema    string
+	Metadata  map[string]string
+	CreatedBy string
+	RowGroups int
+	Rows      []map[string]interface{}
+}
+
+func readParquetFile(t *testing.T, path string) *parquetTestFile {
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+	metadata, err := goparquet.ReadFileMetaData(file, true)
+	require.Nil(t, err)
+	reader, err := goparquet.NewFileReaderWithMetaData(file, metadata)
+	require.Nil(t, err)
+
+	parquetFile := &parquetTestFile{
+		Schema:    reader.GetSchemaDefinition().String(),
+		Metadata:  reader.MetaData(),
+		CreatedBy: metadata.GetCreatedBy(),
+		RowGroups: reader.RowGroupCount(),
+	}
+	for {
+		row, err := reader.NextRow()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		for column, value := range row {
+			if text, ok := value.([]byte); ok {
+				row[column] = string(text)
+			}
+		}
+		parquetFile.Rows = append(parquetFile.Rows, row)
+	}
+	assert.Equal(t, reader.NumRows(), int64(len(parquetFile.Rows)))
+	return parquetFile
+}
+
+// parquetColumns returns the values of row as a map keyed by column name.
+func parquetColumns(file *parquetTestFile, row int) map[string]interface{} {
+	return file.Rows[row]
+}
+
+func newTestParquetClient(t *testing.T, config ParquetConfig) (*ParquetClient, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-parquet")
+	require.Nil(t, err)
+	config.Path = dir
+	client := &ParquetClient{}
+	require.Nil(t, client.Initialize(config))
+	return client, dir
+}
+
+func TestParquetClientTables(t *testing.T) {
+	client, dir := newTestParquetClient(t, ParquetConfig{Compression: "zstd", RowGroupSize: 1000})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	v2Events := loadTestEvents("nsg_flow_events_v2.json", t)
+	accessEvents := loadTestAppGwEvents(t)
+	firewallEvents := loadTestAppGwFirewallEvents(t)
+	all := append(append(append(append([]*CEFEvent{}, events...), v2Events...), accessEvents...), firewallEvents...)
+	require.Nil(t, client.SendEvents(nil, all))
+	assert.Empty(t, publishedFiles(t, dir), "open files must stay hidden")
+	require.Nil(t, client.Close())
+
+	rows := map[string]int{}
+	for name, path := range publishedFiles(t, dir) {
+		assert.True(t, strings.HasSuffix(name, ".parquet"), name)
+		file := readParquetFile(t, path)
+		family := strings.TrimPrefix(strings.SplitN(name, "/", 2)[0], "category=")
+		assert.Equal(t, family, file.Metadata["nsg-parser.log_family"])
+		assert.Equal(t, "nsg-parser", file.CreatedBy)
+		assert.Equal(t, parquetTables[family].schema.String(), file.Schema)
+		rows[family] += len(file.Rows)
+
+		first := parquetColumns(file, 0)
+		switch family {
+		case LogFamilyNsgFlow:
+			if first["nsgName"] == "NSGNAME-NSG" && first["sourceIp"] == "10.193.160.4" && first["sourcePort"] == int32(46010) {
+				assert.Equal(t, int64(1497038813000), first["time"])
+				assert.Equal(t, "40.85.232.72", first["destinationIp"])
+				assert.Equal(t, int32(443), first["destinationPort"])
+				assert.Equal(t, "T", first["protocol"])
+				assert.Equal(t, "O", first["trafficFlow"])
+				assert.Equal(t, "A", first["traffic"])
+				assert.Equal(t, int32(1), first["version"])
+				assert.Nil(t, first["bytesSourceToDestination"])
+			}
+			if first["nsgName"] == "NSGNAME-V2-NSG" {
+				assert.Equal(t, int32(2), first["version"])
+				for row := range file.Rows {
+					columns := parquetColumns(file, row)
+					if columns["flowState"] == "E" && columns["packetsSourceToDestination"] == int64(52) {
+						assert.Equal(t, int64(27072), columns["bytesDestinationToSource"])
+					}
+					if columns["flowState"] == "B" {
+						assert.Nil(t, columns["bytesSourceToDestination"])
+					}
+				}
+			}
+		case LogFamilyAppGwAccess:
+			assert.NotNil(t, first["clientIp"])
+			assert.IsType(t, int32(0), first["httpStatus"])
+			assert.IsType(t, int64(0), first["sentBytes"])
+		case LogFamilyAppGwFirewall:
+			assert.NotNil(t, first["ruleId"])
+			assert.NotNil(t, first["action"])
+		default:
+			t.Errorf("unexpected partition %s", name)
+		}
+	}
+	assert.Equal(t, map[string]int{
+		LogFamilyNsgFlow:       len(events) + len(v2Events),
+		LogFamilyAppGwAccess:   len(accessEvents),
+		LogFamilyAppGwFirewall: len(firewallEvents),
+	}, rows)
+}
+
+func TestParquetClientRowGroups(t *testing.T) {
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	for _, compression := range []string{"none", "snappy", "zstd"} {
+		client, dir := newTestParquetClient(t, ParquetConfig{Compression: compression, RowGroupSize: 10, Partition: "{{.Family}}"})
+		require.Nil(t, client.SendEvents(nil, events))
+		require.Nil(t, client.Close())
+
+		files := publishedFiles(t, dir)
+		require.Equal(t, 1, len(files), compression)
+		for _, path := range files {
+			file := readParquetFile(t, path)
+			assert.Equal(t, len(events), len(file.Rows), compression)
+			assert.Equal(t, (len(events)+9)/10, file.RowGroups, compression)
+			assert.Equal(t, "NSGNAME-V2-NSG", file.Rows[0]["nsgName"], compression)
+			assert.IsType(t, int64(0), file.Rows[0]["time"], compression)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParquetClientRecovery(t *testing.T) {
+	client, dir := newTestParquetClient(t, ParquetConfig{Partition: "{{.Family}}"})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	// Simulate a crash while converting the journal.
+	close(client.files.done)
+	for _, file := range client.files.files {
+		file.file.Close()
+		partialPath := filepath.Join(file.dir, "."+file.name+filePartialSuffix)
+		require.Nil(t, ioutil.WriteFile(partialPath, []byte("PAR1"), 0644))
+	}
+	assert.Empty(t, publishedFiles(t, dir))
+
+	recovered := &ParquetClient{}
+	require.Nil(t, recovered.Initialize(ParquetConfig{Path: dir, Partition: "{{.Family}}"}))
+	defer recovered.Close()
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	for name, path := range files {
+		assert.True(t, strings.HasPrefix(name, LogFamilyNsgFlow+"/"), name)
+		assert.Equal(t, len(events), len(readParquetFile(t, path).Rows))
+	}
+	hidden, err := filepath.Glob(filepath.Join(dir, LogFamilyNsgFlow, ".*"))
+	require.Nil(t, err)
+	assert.Empty(t, hidden)
+}
+
+func TestParquetClientTruncatedJournal(t *testing.T) {
+	client, dir := newTestParquetClient(t, ParquetConfig{Partition: "{{.Family}}"})
+	defer os.RemoveAll(dir)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	// Simulate a crash in the middle of journaling an event.
+	close(client.files.done)
+	for _, file := range client.files.files {
+		file.file.Close()
+		journal, err := os.OpenFile(file.tempPath(), os.O_APPEND|os.O_WRONLY, 0644)
+		require.Nil(t, err)
+		_, err = journal.WriteString(`{"time":"2018-11-13T11:59:37Z","ext`)
+		require.Nil(t, err)
+		journal.Close()
+	}
+	// A journal without a single complete event cannot be converted.
+	brokenPath := filepath.Join(dir, LogFamilyAppGwAccess, ".broken.parquet"+fileTempSuffix)
+	require.Nil(t, os.MkdirAll(filepath.Dir(brokenPath), 0755))
+	require.Nil(t, ioutil.WriteFile(brokenPath, []byte(`{"time":`), 0644))
+
+	recovered := &ParquetClient{}
+	require.Nil(t, recovered.Initialize(ParquetConfig{Path: dir, Partition: "{{.Family}}"}))
+	defer recovered.Close()
+	files := publishedFiles(t, dir)
+	require.Equal(t, 1, len(files))
+	for name, path := range files {
+		assert.True(t, strings.HasPrefix(name, LogFamilyNsgFlow+"/"), name)
+		assert.Equal(t, len(events), len(readParquetFile(t, path).Rows))
+	}
+	_, err := os.Stat(brokenPath + fileCorruptSuffix)
+	assert.Nil(t, err, "expected the broken journal to be moved aside")
+	_, err = os.Stat(brokenPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParquetClientInitializeErrors(t *testing.T) {
+	client := &ParquetClient{}
+	assert.Error(t, client.Initialize(ParquetConfig{Path: os.TempDir(), Partition: "date={{.Date}}"}))
+	assert.Error(t, client.Initialize(ParquetConfig{Path: os.TempDir(), Compression: "lz4"}))
+	assert.Error(t, client.SendEvents(nil, nil))
+}
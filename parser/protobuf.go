@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/dimitertodorov/nsg-parser/nsgpb"
+	"github.com/golang/protobuf/proto"
+	"strconv"
+)
+
+var (
+	protoProtocols  = map[string]nsgpb.Protocol{"T": nsgpb.Protocol_TCP, "U": nsgpb.Protocol_UDP}
+	protoDirections = map[string]nsgpb.Direction{"I": nsgpb.Direction_INBOUND, "O": nsgpb.Direction_OUTBOUND}
+	protoDecisions  = map[string]nsgpb.Decision{"A": nsgpb.Decision_ALLOWED, "D": nsgpb.Decision_DENIED}
+	protoFlowStates = map[string]nsgpb.FlowState{"B": nsgpb.FlowState_BEGIN, "C": nsgpb.FlowState_CONTINUING, "E": nsgpb.FlowState_END}
+	// NSG event logs spell out the direction.
+	protoEventDirections = map[string]nsgpb.Direction{"In": nsgpb.Direction_INBOUND, "Out": nsgpb.Direction_OUTBOUND}
+)
+
+// NewProtoEvent converts an event to its nsgpb.Event. Events of an unknown
+// log family carry no record.
+func NewProtoEvent(event *CEFEvent) (*nsgpb.Event, error) {
+	category, operationName := azureCategory(event)
+	protoEvent := &nsgpb.Event{
+		TimeMs:         event.Time.UnixNano() / 1e6,
+		Category:       category,
+		OperationName:  operationName,
+		SubscriptionId: event.Extension["cs3"],
+		ResourceGroup:  event.Extension["cs4"],
+		ResourceName:   event.Extension["cs2"],
+		Extension:      event.Extension,
+	}
+
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		flowLog, err := NewNsgFlowLog(event)
+		if err != nil {
+			return nil, err
+		}
+		flow := &nsgpb.FlowTuple{
+			SystemId:        flowLog.SystemID,
+			Rule:            flowLog.Rule,
+			Mac:             flowLog.Mac,
+			SourceIp:        flowLog.SourceIP,
+			DestinationIp:   flowLog.DestinationIP,
+			SourcePort:      uint32(flowLog.SourcePort),
+			DestinationPort: uint32(flowLog.DestinationPort),
+			Protocol:        protoProtocols[flowLog.Protocol],
+			Direction:       protoDirections[flowLog.TrafficFlow],
+			Decision:        protoDecisions[flowLog.Traffic],
+			Version:         uint32(flowLog.Version),
+			FlowState:       protoFlowStates[flowLog.FlowState],
+		}
+		if flowLog.PacketsSent != nil {
+			flow.PacketsSourceToDestination = uint64(*flowLog.PacketsSent)
+		}
+		if flowLog.BytesSent != nil {
+			flow.BytesSourceToDestination = uint64(*flowLog.BytesSent)
+		}
+		if flowLog.PacketsReceived != nil {
+			flow.PacketsDestinationToSource = uint64(*flowLog.PacketsReceived)
+		}
+		if flowLog.BytesReceived != nil {
+			flow.BytesDestinationToSource = uint64(*flowLog.BytesReceived)
+		}
+		protoEvent.Record = &nsgpb.Event_Flow{Flow: flow}
+	case LogFamilyNsgEvent:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		nsgEvent := &nsgpb.NsgEvent{
+			SystemId:           event.Extension["deviceExternalId"],
+			VnetResourceGuid:   propertyText(properties, "vnetResourceGuid"),
+			SubnetPrefix:       propertyText(properties, "subnetPrefix"),
+			MacAddress:         propertyText(properties, "macAddress"),
+			PrimaryIpv4Address: propertyText(properties, "primaryIPv4Address"),
+			RuleName:           propertyText(properties, "ruleName"),
+			Direction:          protoEventDirections[propertyText(properties, "direction")],
+			Priority:           uint32(protoUint(properties, "priority")),
+			Type:               propertyText(properties, "type"),
+		}
+		if conditions, ok := properties["conditions"].(map[string]interface{}); ok {
+			nsgEvent.Conditions = map[string]string{}
+			for key := range conditions {
+				nsgEvent.Conditions[key] = propertyText(conditions, key)
+			}
+		}
+		protoEvent.Record = &nsgpb.Event_NsgEvent{NsgEvent: nsgEvent}
+	case LogFamilyAppGwAccess:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		protoEvent.Record = &nsgpb.Event_AppgwAccess{AppgwAccess: &nsgpb.AppGwAccess{
+			InstanceId:    propertyText(properties, "instanceId"),
+			ClientIp:      propertyText(properties, "clientIP"),
+			ClientPort:    uint32(protoUint(properties, "clientPort")),
+			HttpMethod:    propertyText(properties, "httpMethod"),
+			RequestUri:    propertyText(properties, "requestUri"),
+			RequestQuery:  propertyText(properties, "requestQuery"),
+			UserAgent:     propertyText(properties, "userAgent"),
+			HttpStatus:    uint32(protoUint(properties, "httpStatus")),
+			HttpVersion:   propertyText(properties, "httpVersion"),
+			ReceivedBytes: protoUint(properties, "receivedBytes"),
+			SentBytes:     protoUint(properties, "sentBytes"),
+			TimeTaken:     protoUint(properties, "timeTaken"),
+			SslEnabled:    propertyText(properties, "sslEnabled") == "on",
+		}}
+	case LogFamilyAppGwFirewall:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		firewall := &nsgpb.AppGwFirewall{
+			InstanceId:     propertyText(properties, "instanceId"),
+			ClientIp:       propertyText(properties, "clientIp"),
+			ClientPort:     uint32(protoUint(properties, "clientPort")),
+			RequestUri:     propertyText(properties, "requestUri"),
+			RuleSetType:    propertyText(properties, "ruleSetType"),
+			RuleSetVersion: propertyText(properties, "ruleSetVersion"),
+			RuleId:         propertyText(properties, "ruleId"),
+			Message:        propertyText(properties, "message"),
+			Action:         propertyText(properties, "action"),
+			Site:           propertyText(properties, "site"),
+		}
+		if details, ok := properties["details"].(map[string]interface{}); ok {
+			firewall.Details = &nsgpb.AppGwFirewall_Details{
+				Message: propertyText(details, "message"),
+				Data:    propertyText(details, "data"),
+				File:    propertyText(details, "file"),
+				Line:    propertyText(details, "line"),
+			}
+		}
+		protoEvent.Record = &nsgpb.Event_AppgwFirewall{AppgwFirewall: firewall}
+	}
+	return protoEvent, nil
+}
+
+// propertyText returns a property as text. Numbers are formatted without an
+// exponent.
+func propertyText(properties map[string]interface{}, key string) string {
+	switch value := properties[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// protoUint returns a numeric or numeric string property, or 0.
+func protoUint(properties map[string]interface{}, key string) uint64 {
+	switch value := properties[key].(type) {
+	case float64:
+		if value > 0 {
+			return uint64(value)
+		}
+	case string:
+		number, err := strconv.ParseUint(value, 10, 64)
+		if err == nil {
+			return number
+		}
+	}
+	return 0
+}
+
+// protobufFormatter renders events as serialized nsgpb.Event messages.
+// Streams frame each message with its varint size.
+type protobufFormatter struct{}
+
+func (protobufFormatter) Format(event *CEFEvent) ([]byte, error) {
+	protoEvent, err := NewProtoEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(protoEvent)
+}
+
+func (protobufFormatter) Frame(record []byte) []byte {
+	return nsgpb.AppendDelimited(nil, record)
+}
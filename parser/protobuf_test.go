@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dimitertodorov/nsg-parser/nsgpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestNewProtoEventFlow(t *testing.T) {
+	event, err := NewProtoEvent(loadTestEvents("nsg_flow_events.json", t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, int64(1497038813000), event.TimeMs)
+	assert.Equal(t, "NSGNAME-NSG", event.ResourceName)
+	assert.Equal(t, "SUBID", event.SubscriptionId)
+	assert.Equal(t, "10.193.160.4", event.Extension["src"])
+	flow := event.GetFlow()
+	require.NotNil(t, flow)
+	assert.Equal(t, "10.193.160.4", flow.SourceIp)
+	assert.Equal(t, "40.85.232.72", flow.DestinationIp)
+	assert.Equal(t, uint32(46010), flow.SourcePort)
+	assert.Equal(t, uint32(443), flow.DestinationPort)
+	assert.Equal(t, nsgpb.Protocol_TCP, flow.Protocol)
+	assert.Equal(t, nsgpb.Direction_OUTBOUND, flow.Direction)
+	assert.Equal(t, nsgpb.Decision_ALLOWED, flow.Decision)
+	assert.Equal(t, uint32(1), flow.Version)
+	assert.Equal(t, nsgpb.FlowState_FLOW_STATE_UNKNOWN, flow.FlowState)
+
+	event, err = NewProtoEvent(loadTestEvents("nsg_flow_events_v2.json", t)[5])
+	require.Nil(t, err)
+	flow = event.GetFlow()
+	assert.Equal(t, nsgpb.FlowState_END, flow.FlowState)
+	assert.Equal(t, uint64(52), flow.PacketsSourceToDestination)
+	assert.Equal(t, uint64(27072), flow.BytesDestinationToSource)
+}
+
+func TestNewProtoEventAppGw(t *testing.T) {
+	event, err := NewProtoEvent(loadTestAppGwEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, "ApplicationGatewayAccessLog", event.Category)
+	assert.Equal(t, "ApplicationGatewayAccess", event.OperationName)
+	access := event.GetAppgwAccess()
+	require.NotNil(t, access)
+	assert.NotEmpty(t, access.ClientIp)
+	assert.NotZero(t, access.HttpStatus)
+	assert.True(t, access.SslEnabled)
+
+	event, err = NewProtoEvent(loadTestAppGwFirewallEvents(t)[0])
+	require.Nil(t, err)
+	firewall := event.GetAppgwFirewall()
+	require.NotNil(t, firewall)
+	assert.Equal(t, "960015", firewall.RuleId)
+	assert.Equal(t, "52.237.25.113", firewall.ClientIp)
+	assert.Equal(t, "Warning. Operator EQ matched 0 at REQUEST_HEADERS.", firewall.Details.Message)
+}
+
+func TestNewProtoEventNsgEvent(t *testing.T) {
+	properties, err := json.Marshal(map[string]interface{}{
+		"ruleName":   "UserRule_its-vnet-any",
+		"direction":  "In",
+		"priority":   120,
+		"type":       "allow",
+		"conditions": map[string]interface{}{"destinationIP": "0.0.0.0/0"},
+	})
+	require.Nil(t, err)
+	cefEvent := NewAzureCEFEvent()
+	cefEvent.DeviceEventClassId = "NetworkSecurityGroupEvents"
+	cefEvent.Extension["msg"] = string(properties)
+
+	event, err := NewProtoEvent(&cefEvent)
+	require.Nil(t, err)
+	nsgEvent := event.GetNsgEvent()
+	require.NotNil(t, nsgEvent)
+	assert.Equal(t, "UserRule_its-vnet-any", nsgEvent.RuleName)
+	assert.Equal(t, nsgpb.Direction_INBOUND, nsgEvent.Direction)
+	assert.Equal(t, uint32(120), nsgEvent.Priority)
+	assert.Equal(t, map[string]string{"destinationIP": "0.0.0.0/0"}, nsgEvent.Conditions)
+}
+
+func TestStreamClientStdout(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	client := &StreamClient{stdout: stdout}
+	require.Nil(t, client.Initialize(StreamConfig{}))
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.SendEvents(nil, loadTestAppGwFirewallEvents(t)[:2]))
+	require.Nil(t, client.Close())
+
+	decoded, err := nsgpb.DecodeAll(stdout)
+	require.Nil(t, err)
+	require.Len(t, decoded, len(events)+2)
+	assert.Equal(t, "2001:db8::4", decoded[5].GetFlow().SourceIp)
+	assert.NotNil(t, decoded[6].GetAppgwFirewall())
+}
+
+func TestStreamClientTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	received := make(chan []*nsgpb.Event)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		events, _ := nsgpb.DecodeAll(conn)
+		received <- events
+	}()
+
+	client := &StreamClient{}
+	require.Nil(t, client.Initialize(StreamConfig{Address: listener.Addr().String()}))
+	events := loadTestEvents("nsg_flow_events.json", t)
+	require.Nil(t, client.SendEvents(nil, events))
+	require.Nil(t, client.Close())
+
+	decoded := <-received
+	require.Len(t, decoded, len(events))
+	assert.Equal(t, "NSGNAME-NSG", decoded[0].ResourceName)
+}
+
+func TestStreamClientText(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	client := &StreamClient{stdout: stdout}
+	require.Nil(t, client.Initialize(StreamConfig{Format: FormatCEF}))
+	require.Nil(t, client.SendEvents(nil, loadTestEvents("nsg_flow_events_v2.json", t)))
+	assert.Equal(t, 6, bytes.Count(stdout.Bytes(), []byte("\n")))
+
+	assert.NotNil(t, (&StreamClient{}).SendEvents(nil, nil))
+	assert.NotNil(t, (&StreamClient{}).Initialize(StreamConfig{Format: "xml"}))
+	assert.NotNil(t, (&LokiClient{}).Initialize(LokiConfig{URL: "http://localhost:3100", Format: FormatProtobuf}))
+}
@@ -0,0 +1,279 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PseudonymKeyKey is the extension naming the key events were pseudonymized
+// with.
+const PseudonymKeyKey = "pseudonymKey"
+
+const pseudonymCacheSize = 10000
+
+// addressPattern finds the MACs, IPv4 and IPv6 addresses and networks of
+// extension values, including those of the record properties in msg.
+var addressPattern = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?:[:-][0-9a-f]{2}){5}\b|` +
+	`\b\d{1,3}(?:\.\d{1,3}){3}(?:/\d{1,3})?\b|[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}(?:/\d{1,3})?`)
+
+// pseudonymKeyPattern finds the key named in a pseudonymized CEF or json
+// event.
+var pseudonymKeyPattern = regexp.MustCompile(PseudonymKeyKey + `"?\s*[=:]\s*"?([A-Za-z0-9_-]+)`)
+
+// PseudonymKey is a Crypto-PAn key. Key is 64 hex digits, or KeyFile a file
+// holding them.
+type PseudonymKey struct {
+	ID      string `mapstructure:"id"`
+	Key     string `mapstructure:"key"`
+	KeyFile string `mapstructure:"key_file"`
+	// From is the RFC 3339 time of the first events pseudonymized with the
+	// key. Keys without From apply from the beginning.
+	From string `mapstructure:"from"`
+}
+
+// PseudonymConfig configures a Pseudonymizer.
+type PseudonymConfig struct {
+	CIDRs []string       `mapstructure:"pseudonymize_cidrs"`
+	MACs  bool           `mapstructure:"pseudonymize_macs"`
+	Keys  []PseudonymKey `mapstructure:"pseudonym_keys"`
+}
+
+// Enabled reports whether anything is configured to be pseudonymized.
+func (config PseudonymConfig) Enabled() bool {
+	return len(config.CIDRs) > 0 || config.MACs
+}
+
+// pseudonymKey is a loaded PseudonymKey, with a derived anonymizer for MACs.
+type pseudonymKey struct {
+	id   string
+	from time.Time
+	ip   *cryptoPAn
+	mac  *cryptoPAn
+}
+
+// Pseudonymizer replaces the addresses in the configured CIDRs, and MACs,
+// of every extension of events with prefix-preserving Crypto-PAn pseudonyms.
+// The prefix of the widest configured CIDR containing an address is kept and
+// the remaining bits pseudonymized, so pseudonyms stay in the CIDR, do not
+// collide with addresses outside it and keep its subnet structure. Networks,
+// as in subnet prefixes, become the network of their pseudonymized address.
+//
+// Events are pseudonymized with the key of the latest From not after their
+// time, named in their pseudonymKey extension, so keys are rotated by adding
+// a key. Reverse restores the addresses with the key.
+type Pseudonymizer struct {
+	config PseudonymConfig
+	cidrs  *cidrTrie
+	keys   []*pseudonymKey
+	cache  *lruCache
+}
+
+// NewPseudonymizer parses the CIDRs and loads the keys.
+func NewPseudonymizer(config PseudonymConfig) (*Pseudonymizer, error) {
+	if !config.Enabled() {
+		return nil, fmt.Errorf("no pseudonymize_cidrs and pseudonymize_macs not set")
+	}
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("no pseudonym_keys")
+	}
+	pseudonymizer := &Pseudonymizer{config: config, cidrs: newCIDRTrie(), cache: newLRUCache(pseudonymCacheSize)}
+	networks, err := parseCIDRs(config.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+	for _, network := range networks {
+		pseudonymizer.cidrs.Insert(network, network.String())
+	}
+	ids := map[string]bool{}
+	for _, config := range config.Keys {
+		if !destinationNameRegExp.MatchString(config.ID) {
+			return nil, fmt.Errorf("invalid pseudonym key id %q. use letters, digits, - and _", config.ID)
+		}
+		if ids[config.ID] {
+			return nil, fmt.Errorf("pseudonym key id %s is used twice", config.ID)
+		}
+		ids[config.ID] = true
+		key, err := loadPseudonymKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("pseudonym key %s: %s", config.ID, err)
+		}
+		pseudonymizer.keys = append(pseudonymizer.keys, key)
+	}
+	sort.SliceStable(pseudonymizer.keys, func(i, j int) bool {
+		return pseudonymizer.keys[i].from.Before(pseudonymizer.keys[j].from)
+	})
+	return pseudonymizer, nil
+}
+
+func loadPseudonymKey(config PseudonymKey) (*pseudonymKey, error) {
+	text := config.Key
+	if config.KeyFile != "" {
+		data, err := ioutil.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	secret, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(secret) != 32 {
+		return nil, fmt.Errorf("key must be 64 hex digits")
+	}
+	anonymizer, err := newCryptoPAn(secret)
+	if err != nil {
+		return nil, err
+	}
+	key := &pseudonymKey{id: config.ID, ip: anonymizer, mac: anonymizer.derive()}
+	if config.From != "" {
+		key.from, err = time.Parse(time.RFC3339, config.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q", config.From)
+		}
+	}
+	return key, nil
+}
+
+// Pseudonymize replaces the addresses of event with their pseudonyms.
+func (pseudonymizer *Pseudonymizer) Pseudonymize(event *CEFEvent) {
+	key := pseudonymizer.eventKey(event.Time)
+	for name, value := range event.Extension {
+		event.Extension[name] = pseudonymizer.rewrite(value, key, false)
+	}
+	event.Extension[PseudonymKeyKey] = key.id
+}
+
+// Reverse restores the addresses of pseudonymized text, such as an event
+// written by a destination, with the key keyID. Without keyID the key named
+// in text, or else the latest key, is used.
+func (pseudonymizer *Pseudonymizer) Reverse(text, keyID string) (string, error) {
+	if keyID == "" {
+		if match := pseudonymKeyPattern.FindStringSubmatch(text); match != nil {
+			keyID = match[1]
+		}
+	}
+	key := pseudonymizer.keys[len(pseudonymizer.keys)-1]
+	if keyID != "" {
+		key = nil
+		for _, candidate := range pseudonymizer.keys {
+			if candidate.id == keyID {
+				key = candidate
+			}
+		}
+		if key == nil {
+			return "", fmt.Errorf("unknown pseudonym key %s", keyID)
+		}
+	}
+	return pseudonymizer.rewrite(text, key, true), nil
+}
+
+// eventKey returns the key of events at time.
+func (pseudonymizer *Pseudonymizer) eventKey(time time.Time) *pseudonymKey {
+	key := pseudonymizer.keys[0]
+	for _, candidate := range pseudonymizer.keys[1:] {
+		if candidate.from.After(time) {
+			break
+		}
+		key = candidate
+	}
+	return key
+}
+
+func (pseudonymizer *Pseudonymizer) rewrite(text string, key *pseudonymKey, reverse bool) string {
+	if !strings.ContainsAny(text, ".:-") {
+		return text
+	}
+	return addressPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if reverse {
+			return pseudonymizer.token(token, key, true)
+		}
+		cacheKey := key.id + "|" + token
+		if cached, ok := pseudonymizer.cache.Get(cacheKey); ok {
+			return cached.(string)
+		}
+		pseudonym := pseudonymizer.token(token, key, false)
+		pseudonymizer.cache.Add(cacheKey, pseudonym)
+		return pseudonym
+	})
+}
+
+func (pseudonymizer *Pseudonymizer) token(token string, key *pseudonymKey, reverse bool) string {
+	if !strings.Contains(token, ".") && len(token) == 17 && (token[2] == ':' || token[2] == '-') {
+		if net.ParseIP(token) == nil {
+			return pseudonymizer.mac(token, key, reverse)
+		}
+	}
+	return pseudonymizer.address(token, key, reverse)
+}
+
+// address pseudonymizes an address or network in a configured CIDR.
+func (pseudonymizer *Pseudonymizer) address(token string, key *pseudonymKey, reverse bool) string {
+	address, suffix := token, ""
+	if i := strings.IndexByte(token, '/'); i >= 0 {
+		address, suffix = token[:i], token[i:]
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return token
+	}
+	matches := pseudonymizer.cidrs.Matches(ip)
+	if len(matches) == 0 {
+		return token
+	}
+	var value [16]byte
+	bits := 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		copy(value[:], ipv4)
+		bits = 32
+	} else {
+		copy(value[:], ip.To16())
+	}
+	if reverse {
+		value = key.ip.deanonymize(value, matches[0].Bits, bits)
+	} else {
+		value = key.ip.anonymize(value, matches[0].Bits, bits)
+	}
+	if suffix != "" {
+		length, err := strconv.Atoi(suffix[1:])
+		if err != nil || length > bits {
+			return token
+		}
+		value = maskKey(value, length)
+	}
+	if bits == 32 {
+		return net.IP(value[:4]).String() + suffix
+	}
+	return net.IP(value[:]).String() + suffix
+}
+
+// mac pseudonymizes a MAC, keeping its separator and case.
+func (pseudonymizer *Pseudonymizer) mac(token string, key *pseudonymKey, reverse bool) string {
+	if !pseudonymizer.config.MACs {
+		return token
+	}
+	hardware, err := net.ParseMAC(token)
+	if err != nil {
+		return token
+	}
+	var value [16]byte
+	copy(value[:], hardware)
+	if reverse {
+		value = key.mac.deanonymize(value, 0, 48)
+	} else {
+		value = key.mac.anonymize(value, 0, 48)
+	}
+	octets := make([]string, 6)
+	for i := range octets {
+		octets[i] = hex.EncodeToString(value[i : i+1])
+	}
+	mac := strings.Join(octets, token[2:3])
+	if token == strings.ToUpper(token) {
+		mac = strings.ToUpper(mac)
+	}
+	return mac
+}
@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testPseudonymKey        = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPseudonymRotatedKey = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+func newTestPseudonymizer(t *testing.T) *Pseudonymizer {
+	pseudonymizer, err := NewPseudonymizer(PseudonymConfig{
+		CIDRs: []string{"10.0.0.0/8", "10.193.0.0/16", "fd00::/8"},
+		MACs:  true,
+		Keys: []PseudonymKey{
+			{ID: "k2", Key: testPseudonymRotatedKey, From: "2018-11-01T00:00:00Z"},
+			{ID: "k1", Key: testPseudonymKey},
+		},
+	})
+	require.Nil(t, err)
+	return pseudonymizer
+}
+
+func TestPseudonymizer(t *testing.T) {
+	pseudonymizer := newTestPseudonymizer(t)
+	events := loadTestEvents("nsg_flow_events.json", t)
+	original := map[string]string{}
+	for key, value := range events[0].Extension {
+		original[key] = value
+	}
+	pseudonymizer.Pseudonymize(events[0])
+	extension := events[0].Extension
+
+	assert.Equal(t, "k1", extension[PseudonymKeyKey])
+	assert.NotEqual(t, original["src"], extension["src"])
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	assert.True(t, network.Contains(net.ParseIP(extension["src"])), "pseudonyms stay in the widest CIDR")
+	assert.Equal(t, original["dst"], extension["dst"], "addresses outside the CIDRs are kept")
+	assert.NotEqual(t, original["smac"], extension["smac"])
+	assert.Regexp(t, `^[0-9A-F]{2}(:[0-9A-F]{2}){5}$`, extension["smac"])
+
+	text, err := events[0].CEFText()
+	require.Nil(t, err)
+	restored, err := pseudonymizer.Reverse(text, "")
+	require.Nil(t, err)
+	assert.Contains(t, restored, "src="+original["src"])
+	assert.Contains(t, restored, "smac="+original["smac"])
+	for _, value := range []string{extension["src"], extension["smac"]} {
+		reversed, err := pseudonymizer.Reverse(value, "k1")
+		require.Nil(t, err)
+		assert.Contains(t, []string{original["src"], original["smac"]}, reversed)
+	}
+	_, err = pseudonymizer.Reverse(extension["src"], "k3")
+	assert.NotNil(t, err)
+}
+
+func TestPseudonymizerPrefixes(t *testing.T) {
+	pseudonymizer := newTestPseudonymizer(t)
+	events := sessionTestEvents(t,
+		"1542110377,10.5.16.4,10.5.16.200,44931,443,T,O,A,B,,,,",
+		"1542110377,fd00:1:2:3::4,fd00:1:2:4::4,44931,443,T,O,A,B,,,,")
+	for _, event := range events {
+		pseudonymizer.Pseudonymize(event)
+		assert.Equal(t, "k2", event.Extension[PseudonymKeyKey], "events after From take the rotated key")
+	}
+	source, destination := net.ParseIP(events[0].Extension["src"]), net.ParseIP(events[0].Extension["dst"])
+	assert.Equal(t, source.To4()[:3], destination.To4()[:3], "addresses of a /24 share a pseudonymized /24")
+	assert.NotEqual(t, source, destination)
+
+	source, destination = net.ParseIP(events[1].Extension["src"]), net.ParseIP(events[1].Extension["dst"])
+	assert.Equal(t, byte(0xfd), source[0])
+	assert.Equal(t, source[:6], destination[:6])
+	assert.NotEqual(t, source[:8], destination[:8])
+	reversed, err := pseudonymizer.Reverse(events[1].Extension["src"], "k2")
+	require.Nil(t, err)
+	assert.Equal(t, "fd00:1:2:3::4", reversed)
+
+	// Networks become the network of their pseudonymized address.
+	text := "subnetPrefix 10.144.0.32/28 primaryIPv4Address 10.144.0.37 macAddress 00-0d-3a-a3-17-17 destination 0.0.0.0/0"
+	key := pseudonymizer.keys[0]
+	pseudonymized := pseudonymizer.rewrite(text, key, false)
+	fields := strings.Fields(pseudonymized)
+	_, subnet, err := net.ParseCIDR(fields[1])
+	require.Nil(t, err)
+	assert.Equal(t, fields[1], subnet.String())
+	assert.True(t, subnet.Contains(net.ParseIP(fields[3])))
+	assert.Regexp(t, `^[0-9a-f]{2}(-[0-9a-f]{2}){5}$`, fields[5])
+	assert.Equal(t, "0.0.0.0/0", fields[7])
+	assert.Equal(t, text, pseudonymizer.rewrite(pseudonymized, key, true))
+}
+
+func TestPseudonymizerAppGw(t *testing.T) {
+	pseudonymizer, err := NewPseudonymizer(PseudonymConfig{CIDRs: []string{"52.237.0.0/16"}, Keys: []PseudonymKey{{ID: "k1", Key: testPseudonymKey}}})
+	require.Nil(t, err)
+	event := loadTestAppGwFirewallEvents(t)[0]
+	pseudonymizer.Pseudonymize(event)
+	assert.NotEqual(t, "52.237.25.113", event.Extension["src"])
+	assert.NotContains(t, event.Extension["msg"], "52.237.25.113")
+	properties, err := recordProperties(event)
+	require.Nil(t, err)
+	assert.Equal(t, event.Extension["src"], properties["clientIp"])
+}
+
+func TestNewPseudonymizerErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsg-parser-pseudonym")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "k1.key")
+	require.Nil(t, ioutil.WriteFile(keyFile, []byte(testPseudonymKey+"\n"), 0600))
+	_, err = NewPseudonymizer(PseudonymConfig{MACs: true, Keys: []PseudonymKey{{ID: "k1", KeyFile: keyFile}}})
+	assert.Nil(t, err)
+
+	for _, config := range []PseudonymConfig{
+		{Keys: []PseudonymKey{{ID: "k1", Key: testPseudonymKey}}},
+		{MACs: true},
+		{CIDRs: []string{"10.0.0.0"}, Keys: []PseudonymKey{{ID: "k1", Key: testPseudonymKey}}},
+		{MACs: true, Keys: []PseudonymKey{{ID: "k1", Key: "0123"}}},
+		{MACs: true, Keys: []PseudonymKey{{ID: "k1", KeyFile: filepath.Join(dir, "missing.key")}}},
+		{MACs: true, Keys: []PseudonymKey{{ID: "k 1", Key: testPseudonymKey}}},
+		{MACs: true, Keys: []PseudonymKey{{ID: "k1", Key: testPseudonymKey}, {ID: "k1", Key: testPseudonymKey}}},
+		{MACs: true, Keys: []PseudonymKey{{ID: "k1", Key: testPseudonymKey, From: "2018-11-01"}}},
+	} {
+		_, err = NewPseudonymizer(config)
+		assert.NotNil(t, err, "%+v", config)
+	}
+}
+
+func TestDestinationPseudonymizer(t *testing.T) {
+	sender := &recordingSender{}
+	destination, err := NewDestination("mssp", DestinationSyslog, nil, sender)
+	require.Nil(t, err)
+	destination.Pseudonymizer = newTestPseudonymizer(t)
+	destination.Filters, err = NewFilterChain("mssp", []Filter{{Name: "host", Mode: FilterInclude, SourceCIDRs: []string{"10.193.160.4/32"}}}, FilterExclude)
+	require.Nil(t, err)
+
+	require.Nil(t, destination.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)))
+	require.NotEmpty(t, sender.events, "filters match the real addresses")
+	for _, event := range sender.events {
+		assert.NotEqual(t, "10.193.160.4", event.Extension["src"])
+		assert.Equal(t, "k1", event.Extension[PseudonymKeyKey])
+	}
+
+	ecs, err := NewECSDocument(sender.events[0])
+	require.Nil(t, err)
+	assert.Equal(t, "k1", schemaValue(t, ecs, "azure", "pseudonym_key"))
+	assert.Equal(t, sender.events[0].Extension["src"], schemaValue(t, ecs, "source", "ip"))
+}
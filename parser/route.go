@@ -0,0 +1,278 @@
+package parser
+
+import (
+	"fmt"
+	metrics "github.com/rcrowley/go-metrics"
+	"path"
+	"strings"
+)
+
+// RouteAllDestinations in the destinations of a route sends its events to
+// every destination.
+const RouteAllDestinations = "*"
+
+// routeActions are the values actions can match. allow and deny match flows,
+// NSG events and Application Gateway firewall events, the others match the
+// firewall action as logged.
+var routeActions = map[string]bool{"allow": true, "deny": true, "allowed": true, "blocked": true, "detected": true, "matched": true}
+
+// routeCategories are the Azure log categories that categories can match, next
+// to the log families.
+var routeCategories = map[string]bool{
+	"networksecuritygroupflowevent": true,
+	"networksecuritygroupevent":     true,
+	"applicationgatewayaccesslog":   true,
+	"applicationgatewayfirewalllog": true,
+}
+
+// Route sends the events it matches to Destinations. An event matches when it
+// matches one value of every criteria set. Subscriptions, ResourceGroups and
+// Resources, the NSG or Application Gateway names, match case insensitively
+// and may use path.Match globs.
+type Route struct {
+	Name           string   `mapstructure:"name"`
+	Subscriptions  []string `mapstructure:"subscriptions"`
+	ResourceGroups []string `mapstructure:"resource_groups"`
+	Resources      []string `mapstructure:"resources"`
+	// Categories are log families or Azure log categories.
+	Categories []string `mapstructure:"categories"`
+	// Actions are allow, deny, or an Application Gateway firewall action.
+	Actions []string `mapstructure:"actions"`
+	// TrafficTypes are the traffic types of TrafficClassifier.
+	TrafficTypes []string `mapstructure:"traffic_types"`
+	// ThreatFeeds are the names of the ThreatIntel feeds. "*" matches events
+	// matching any feed.
+	ThreatFeeds  []string `mapstructure:"threat_feeds"`
+	Destinations []string `mapstructure:"destinations"`
+
+	destinations map[string]bool
+	count        metrics.Counter
+}
+
+// RouteTable selects the events of each destination. Events matching no
+// route take the default route. Without a default route they are dropped.
+type RouteTable struct {
+	routes              []*Route
+	defaultDestinations map[string]bool
+	defaultCount        metrics.Counter
+}
+
+// NewRouteTable validates routes and the default route against the names of
+// the configured destinations and registers the route metrics.
+func NewRouteTable(routes []Route, defaultDestinations []string, destinations []string) (*RouteTable, error) {
+	table := &RouteTable{
+		defaultCount: metrics.GetOrRegisterCounter("route_default_events", nil),
+	}
+	names := map[string]bool{}
+	for i := range routes {
+		route := routes[i]
+		if !destinationNameRegExp.MatchString(route.Name) {
+			return nil, fmt.Errorf("invalid route name %q. use letters, digits, - and _", route.Name)
+		}
+		if names[route.Name] || route.Name == "default" {
+			return nil, fmt.Errorf("route name %s is used twice", route.Name)
+		}
+		names[route.Name] = true
+		for _, category := range route.Categories {
+			if !routeCategories[strings.ToLower(category)] && !isLogFamily(category) {
+				return nil, fmt.Errorf("route %s has unknown category %q", route.Name, category)
+			}
+		}
+		for _, action := range route.Actions {
+			if !routeActions[strings.ToLower(action)] {
+				return nil, fmt.Errorf("route %s has unknown action %q", route.Name, action)
+			}
+		}
+		for _, trafficType := range route.TrafficTypes {
+			if !trafficTypes[strings.ToLower(trafficType)] {
+				return nil, fmt.Errorf("route %s has unknown traffic type %q", route.Name, trafficType)
+			}
+		}
+		for _, pattern := range append(append(append(route.Subscriptions, route.ResourceGroups...), route.Resources...), route.ThreatFeeds...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("route %s has invalid pattern %q", route.Name, pattern)
+			}
+		}
+		if len(route.Destinations) == 0 {
+			return nil, fmt.Errorf("route %s has no destinations", route.Name)
+		}
+		var err error
+		route.destinations, err = routeDestinations(route.Destinations, destinations)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", route.Name, err)
+		}
+		route.count = metrics.GetOrRegisterCounter(fmt.Sprintf("route_%s_events", route.Name), nil)
+		table.routes = append(table.routes, &route)
+	}
+	var err error
+	table.defaultDestinations, err = routeDestinations(defaultDestinations, destinations)
+	if err != nil {
+		return nil, fmt.Errorf("default route: %s", err)
+	}
+	return table, nil
+}
+
+// Select returns the events routed to destination. The metric of a route
+// counts the events it sends to each destination, so an event routed to two
+// destinations counts twice.
+func (table *RouteTable) Select(destination string, events []*CEFEvent) []*CEFEvent {
+	selected := make([]*CEFEvent, 0, len(events))
+	for _, event := range events {
+		matched, routed := false, false
+		for _, route := range table.routes {
+			if !route.Match(event) {
+				continue
+			}
+			matched = true
+			if route.destinations[destination] {
+				route.count.Inc(1)
+				routed = true
+			}
+		}
+		if !matched && table.defaultDestinations[destination] {
+			table.defaultCount.Inc(1)
+			routed = true
+		}
+		if routed {
+			selected = append(selected, event)
+		}
+	}
+	return selected
+}
+
+// Match reports whether event matches every criteria set of the route.
+func (route *Route) Match(event *CEFEvent) bool {
+	if !matchPatterns(route.Subscriptions, event.Extension["cs3"]) ||
+		!matchPatterns(route.ResourceGroups, event.Extension["cs4"]) ||
+		!matchPatterns(route.Resources, event.Extension["cs2"]) {
+		return false
+	}
+	if len(route.Categories) > 0 {
+		category, _ := azureCategory(event)
+		if !containsFold(route.Categories, event.LogFamily()) && !containsFold(route.Categories, category) {
+			return false
+		}
+	}
+	if len(route.Actions) > 0 {
+		found := false
+		for _, action := range eventActions(event) {
+			if containsFold(route.Actions, action) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(route.TrafficTypes) > 0 && !containsFold(route.TrafficTypes, event.Extension[TrafficTypeKey]) {
+		return false
+	}
+	if len(route.ThreatFeeds) > 0 && !matchThreatFeeds(route.ThreatFeeds, event) {
+		return false
+	}
+	return true
+}
+
+// eventActions returns the action values an event matches. Denied flows,
+// blocking NSG rules and blocked requests are deny.
+func eventActions(event *CEFEvent) []string {
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		switch event.Extension["categoryOutcome"] {
+		case "Allow":
+			return []string{"allow"}
+		case "Deny":
+			return []string{"deny"}
+		}
+	case LogFamilyNsgEvent:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil
+		}
+		switch strings.ToLower(propertyText(properties, "type")) {
+		case "allow":
+			return []string{"allow"}
+		case "block", "deny":
+			return []string{"deny"}
+		}
+	case LogFamilyAppGwFirewall:
+		action := strings.ToLower(event.Extension["act"])
+		switch action {
+		case "blocked":
+			return []string{action, "deny"}
+		case "allowed":
+			return []string{action, "allow"}
+		case "":
+			return nil
+		default:
+			return []string{action}
+		}
+	}
+	return nil
+}
+
+func routeDestinations(names []string, destinations []string) (map[string]bool, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		if name == RouteAllDestinations {
+			for _, destination := range destinations {
+				selected[destination] = true
+			}
+			continue
+		}
+		if !containsString(destinations, name) {
+			return nil, fmt.Errorf("unknown destination %q", name)
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+// matchPatterns reports whether value matches one of patterns, ignoring case.
+// No patterns match every value.
+func matchPatterns(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchThreatFeeds reports whether event matched an indicator of a feed
+// matching patterns.
+func matchThreatFeeds(patterns []string, event *CEFEvent) bool {
+	feed := event.Extension[ThreatFeedKey]
+	return feed != "" && matchPatterns(patterns, feed)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isLogFamily(family string) bool {
+	switch family {
+	case LogFamilyNsgFlow, LogFamilyNsgEvent, LogFamilyAppGwAccess, LogFamilyAppGwFirewall:
+		return true
+	}
+	return false
+}
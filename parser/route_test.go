@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewRouteTable(t *testing.T) {
+	destinations := []string{"siem", "splunk"}
+	invalid := [][]Route{
+		{{Name: "team x", Destinations: []string{"siem"}}},
+		{{Name: "waf", Destinations: []string{"siem"}}, {Name: "waf", Destinations: []string{"splunk"}}},
+		{{Name: "waf", Categories: []string{"waf"}, Destinations: []string{"siem"}}},
+		{{Name: "denies", Actions: []string{"drop"}, Destinations: []string{"siem"}}},
+		{{Name: "team_x", Subscriptions: []string{"[sub"}, Destinations: []string{"siem"}}},
+		{{Name: "team_x", Subscriptions: []string{"SUBID"}}},
+		{{Name: "team_x", Subscriptions: []string{"SUBID"}, Destinations: []string{"elastic"}}},
+	}
+	for _, routes := range invalid {
+		_, err := NewRouteTable(routes, nil, destinations)
+		assert.Error(t, err, "%+v", routes)
+	}
+	_, err := NewRouteTable(nil, []string{"elastic"}, destinations)
+	assert.Error(t, err)
+	_, err = NewRouteTable([]Route{{Name: "waf", Categories: []string{"ApplicationGatewayFirewallLog", LogFamilyAppGwAccess},
+		Destinations: []string{RouteAllDestinations}}}, []string{"siem"}, destinations)
+	assert.Nil(t, err)
+}
+
+func TestRouteMatch(t *testing.T) {
+	flow := loadTestEvents("nsg_flow_events_v2.json", t)[0]
+	firewall := loadTestAppGwFirewallEvents(t)[0]
+
+	assert.True(t, (&Route{Subscriptions: []string{"subid"}, Resources: []string{"nsgname-*"}}).Match(flow))
+	assert.False(t, (&Route{Subscriptions: []string{"subid"}, ResourceGroups: []string{"other"}}).Match(flow))
+	assert.True(t, (&Route{Categories: []string{"NetworkSecurityGroupFlowEvent"}}).Match(flow))
+	assert.True(t, (&Route{Actions: []string{"deny"}}).Match(flow))
+	assert.False(t, (&Route{Actions: []string{"allow"}}).Match(flow))
+
+	assert.True(t, (&Route{Categories: []string{LogFamilyAppGwFirewall}, Actions: []string{"detected"}}).Match(firewall))
+	assert.False(t, (&Route{Actions: []string{"deny"}}).Match(firewall))
+	assert.False(t, (&Route{Categories: []string{LogFamilyNsgFlow}}).Match(firewall))
+}
+
+func TestRouteTableSelect(t *testing.T) {
+	flows := loadTestEvents("nsg_flow_events_v2.json", t)
+	firewall := loadTestAppGwFirewallEvents(t)
+	events := append(append([]*CEFEvent{}, flows...), firewall...)
+	denies := 0
+	for _, event := range flows {
+		if event.Extension["categoryOutcome"] == "Deny" {
+			denies++
+		}
+	}
+	require.True(t, denies > 0 && denies < len(flows))
+
+	table, err := NewRouteTable([]Route{
+		{Name: "test_waf", Categories: []string{LogFamilyAppGwFirewall}, Destinations: []string{"splunk"}},
+		{Name: "test_denies", Actions: []string{"deny"}, Destinations: []string{RouteAllDestinations}},
+	}, []string{"siem"}, []string{"siem", "splunk", "archive"})
+	require.Nil(t, err)
+	defaultCount := table.defaultCount.Count()
+
+	// Denied flows go everywhere, other flows take the default route.
+	assert.Len(t, table.Select("siem", events), len(flows))
+	assert.Equal(t, int64(len(flows)-denies), table.defaultCount.Count()-defaultCount)
+	assert.Len(t, table.Select("splunk", events), len(firewall)+denies)
+	assert.Len(t, table.Select("archive", events), denies)
+	assert.Equal(t, int64(len(firewall)), table.routes[0].count.Count())
+	assert.Equal(t, int64(3*denies), table.routes[1].count.Count())
+}
+
+func TestDestinationRoutes(t *testing.T) {
+	table, err := NewRouteTable([]Route{{Name: "test_flows", Categories: []string{LogFamilyNsgFlow}, Destinations: []string{"siem"}}},
+		nil, []string{"siem"})
+	require.Nil(t, err)
+	sender := &recordingSender{}
+	destination, err := NewDestination("siem", DestinationSyslog, nil, sender)
+	require.Nil(t, err)
+	destination.Routes = table
+	flows := loadTestEvents("nsg_flow_events.json", t)
+	require.Nil(t, destination.SendEvents(nil, append(append([]*CEFEvent{}, flows...), loadTestAppGwEvents(t)...)))
+	assert.Len(t, sender.events, len(flows))
+}
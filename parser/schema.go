@@ -0,0 +1,545 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SchemaECS maps events to Elastic Common Schema documents.
+	SchemaECS = "ecs"
+	// SchemaOCSF maps events to Open Cybersecurity Schema Framework events.
+	SchemaOCSF = "ocsf"
+
+	ecsVersion  = "8.11.0"
+	ocsfVersion = "1.1.0"
+
+	ocsfClassNetworkActivity = 4001
+	ocsfClassHTTPActivity    = 4002
+)
+
+var (
+	ecsTransports = map[string]string{"T": "tcp", "U": "udp"}
+	ecsDirections = map[string]string{"I": "inbound", "O": "outbound"}
+	ecsActions    = map[string]string{"A": "allowed", "D": "denied"}
+	ecsFlowStates = map[string]string{"B": "start", "E": "end"}
+
+	ocsfProtocolNumbers = map[string]int{"T": 6, "U": 17}
+	ocsfDirectionIDs    = map[string]int{"I": 1, "O": 2}
+	ocsfDirections      = map[string]string{"I": "Inbound", "O": "Outbound"}
+	// Flow states map to the Open, Close and Traffic activities. Version 1
+	// tuples have no state and report traffic.
+	ocsfFlowActivities = map[string]int{"B": 1, "E": 2, "C": 6, "": 6}
+	ocsfHTTPActivities = map[string]int{"CONNECT": 1, "DELETE": 2, "GET": 3, "HEAD": 4, "OPTIONS": 5, "POST": 6, "PUT": 7, "TRACE": 8}
+)
+
+// NewSchemaDocument maps an event to a document of the ecs or ocsf schema.
+func NewSchemaDocument(schema string, event *CEFEvent) (map[string]interface{}, error) {
+	switch strings.ToLower(schema) {
+	case SchemaECS:
+		return NewECSDocument(event)
+	case SchemaOCSF:
+		return NewOCSFEvent(event)
+	default:
+		return nil, fmt.Errorf("unsupported schema %q. expected %s or %s", schema, SchemaECS, SchemaOCSF)
+	}
+}
+
+// NewECSDocument maps an event to an ECS document. NSG flows become network
+// events observed by the NSG, Application Gateway access logs web access
+// events and WAF logs intrusion detection events. Fields without an ECS
+// equivalent are kept under azure.
+func NewECSDocument(event *CEFEvent) (map[string]interface{}, error) {
+	category, operationName := azureCategory(event)
+	document := map[string]interface{}{}
+	setField(document, "@timestamp", event.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+	setField(document, "ecs.version", ecsVersion)
+	setField(document, "event.kind", "event")
+	setField(document, "event.module", "azure")
+	setField(document, "event.dataset", "azure."+event.LogFamily())
+	setField(document, "event.provider", category)
+	setField(document, "cloud.provider", "azure")
+	setField(document, "cloud.account.id", optionalString(event.Extension["cs3"]))
+	setField(document, "azure.resource_group", optionalString(event.Extension["cs4"]))
+	setField(document, "azure.operation_name", optionalString(operationName))
+	setField(document, "observer.vendor", "Microsoft")
+	setField(document, "observer.name", optionalString(event.Extension["cs2"]))
+
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		flowLog, err := NewNsgFlowLog(event)
+		if err != nil {
+			return nil, err
+		}
+		eventTypes := []string{"connection", ecsActions[flowLog.Traffic]}
+		if state, ok := ecsFlowStates[flowLog.FlowState]; ok {
+			eventTypes = append(eventTypes, state)
+		}
+		setField(document, "event.category", []string{"network"})
+		setField(document, "event.type", eventTypes)
+		setField(document, "event.action", ecsActions[flowLog.Traffic])
+		setField(document, "observer.type", "firewall")
+		setField(document, "observer.product", "Network Security Group")
+		setField(document, "rule.name", optionalString(flowLog.Rule))
+		setField(document, "source.ip", flowLog.SourceIP)
+		setField(document, "source.port", flowLog.SourcePort)
+		setField(document, "destination.ip", flowLog.DestinationIP)
+		setField(document, "destination.port", flowLog.DestinationPort)
+		setField(document, "network.transport", optionalString(ecsTransports[flowLog.Protocol]))
+		setField(document, "network.direction", optionalString(ecsDirections[flowLog.TrafficFlow]))
+		setField(document, "network.type", networkType(flowLog.SourceIP))
+		setField(document, "azure.nsg.system_id", optionalString(flowLog.SystemID))
+		setField(document, "azure.nsg.mac", optionalString(flowLog.Mac))
+		setField(document, "azure.nsg.flow_version", flowLog.Version)
+		if flowLog.PacketsSent != nil {
+			setField(document, "source.packets", *flowLog.PacketsSent)
+			setField(document, "source.bytes", *flowLog.BytesSent)
+			setField(document, "destination.packets", *flowLog.PacketsReceived)
+			setField(document, "destination.bytes", *flowLog.BytesReceived)
+			setField(document, "network.packets", *flowLog.PacketsSent+*flowLog.PacketsReceived)
+			setField(document, "network.bytes", *flowLog.BytesSent+*flowLog.BytesReceived)
+		}
+	case LogFamilyNsgEvent:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		setField(document, "event.category", []string{"network"})
+		setField(document, "event.type", []string{"info"})
+		setField(document, "event.action", optionalString(operationName))
+		setField(document, "observer.type", "firewall")
+		setField(document, "observer.product", "Network Security Group")
+		setField(document, "rule.name", propertyString(properties, "ruleName"))
+		setField(document, "azure.nsg.properties", properties)
+	case LogFamilyAppGwAccess:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		outcome := "success"
+		if status, ok := propertyInt64(properties, "httpStatus").(int64); ok && status >= 400 {
+			outcome = "failure"
+		}
+		setField(document, "event.category", []string{"web"})
+		setField(document, "event.type", []string{"access"})
+		setField(document, "event.outcome", outcome)
+		setField(document, "observer.type", "proxy")
+		setField(document, "observer.product", "Application Gateway")
+		setField(document, "source.ip", propertyString(properties, "clientIP"))
+		setField(document, "source.port", propertyInt64(properties, "clientPort"))
+		setField(document, "http.request.method", propertyString(properties, "httpMethod"))
+		setField(document, "http.request.bytes", propertyInt64(properties, "receivedBytes"))
+		setField(document, "http.response.status_code", propertyInt64(properties, "httpStatus"))
+		setField(document, "http.response.bytes", propertyInt64(properties, "sentBytes"))
+		setField(document, "http.version", optionalString(strings.TrimPrefix(propertyText(properties, "httpVersion"), "HTTP/")))
+		setField(document, "url.original", optionalString(requestURL(properties)))
+		setField(document, "url.path", propertyString(properties, "requestUri"))
+		setField(document, "url.query", propertyString(properties, "requestQuery"))
+		setField(document, "user_agent.original", propertyString(properties, "userAgent"))
+		setField(document, "tls.established", propertyText(properties, "sslEnabled") == "on")
+		if timeTaken, ok := propertyInt64(properties, "timeTaken").(int64); ok {
+			setField(document, "event.duration", timeTaken*int64(time.Millisecond))
+		}
+		setField(document, "azure.application_gateway.instance_id", propertyString(properties, "instanceId"))
+	case LogFamilyAppGwFirewall:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		action := strings.ToLower(propertyText(properties, "action"))
+		eventType := "info"
+		if action == "blocked" {
+			eventType = "denied"
+		}
+		details, _ := properties["details"].(map[string]interface{})
+		setField(document, "event.category", []string{"web", "intrusion_detection"})
+		setField(document, "event.type", []string{eventType})
+		setField(document, "event.action", optionalString(action))
+		setField(document, "observer.type", "waf")
+		setField(document, "observer.product", "Application Gateway")
+		setField(document, "source.ip", propertyString(properties, "clientIp"))
+		setField(document, "source.port", propertyInt64(properties, "clientPort"))
+		setField(document, "url.original", propertyString(properties, "requestUri"))
+		setField(document, "rule.id", propertyString(properties, "ruleId"))
+		setField(document, "rule.ruleset", propertyString(properties, "ruleSetType"))
+		setField(document, "rule.version", propertyString(properties, "ruleSetVersion"))
+		setField(document, "rule.description", propertyString(properties, "message"))
+		setField(document, "message", propertyString(details, "message"))
+		setField(document, "azure.application_gateway.instance_id", propertyString(properties, "instanceId"))
+		setField(document, "azure.application_gateway.site", propertyString(properties, "site"))
+		setField(document, "azure.application_gateway.details", details)
+	}
+	setECSGeo(document, "source", event, "source", "slat", "slong")
+	setECSGeo(document, "destination", event, "destination", "dlat", "dlong")
+	setECSAsset(document, "source", event, "source", "shost")
+	setECSAsset(document, "destination", event, "destination", "dhost")
+	setField(document, "azure.traffic_type", optionalString(event.Extension[TrafficTypeKey]))
+	for _, field := range []string{"source", "destination"} {
+		setField(document, "azure."+field+".network", optionalString(event.Extension[field+NetworkKey]))
+		setField(document, "azure."+field+".service_tag", optionalString(event.Extension[field+ServiceTagKey]))
+	}
+	setECSThreat(document, event)
+	setField(document, "azure.pseudonym_key", optionalString(event.Extension[PseudonymKeyKey]))
+	return document, nil
+}
+
+// NewOCSFEvent maps an event to an OCSF event. NSG flows become Network
+// Activity events and Application Gateway access and WAF logs HTTP Activity
+// events. NSG event logs have no matching class and are base events. Fields
+// without an OCSF equivalent are kept under unmapped.
+func NewOCSFEvent(event *CEFEvent) (map[string]interface{}, error) {
+	category, operationName := azureCategory(event)
+	document := map[string]interface{}{}
+	setField(document, "time", event.Time.UnixNano()/int64(time.Millisecond))
+	setField(document, "severity_id", 1)
+	setField(document, "severity", "Informational")
+	setField(document, "metadata.version", ocsfVersion)
+	setField(document, "metadata.log_name", optionalString(category))
+	setField(document, "metadata.product.vendor_name", "Microsoft")
+	setField(document, "cloud.provider", "Azure")
+	setField(document, "cloud.account.uid", optionalString(event.Extension["cs3"]))
+	setField(document, "device.name", optionalString(event.Extension["cs2"]))
+	setField(document, "unmapped.resource_group", optionalString(event.Extension["cs4"]))
+	setField(document, "unmapped.operation_name", optionalString(operationName))
+
+	switch event.LogFamily() {
+	case LogFamilyNsgFlow:
+		flowLog, err := NewNsgFlowLog(event)
+		if err != nil {
+			return nil, err
+		}
+		setOCSFClass(document, ocsfClassNetworkActivity, "Network Activity", ocsfFlowActivities[flowLog.FlowState])
+		setField(document, "metadata.product.name", "Network Security Group")
+		setOCSFAction(document, flowLog.Traffic == "A")
+		setField(document, "firewall_rule.name", optionalString(flowLog.Rule))
+		setField(document, "src_endpoint.ip", flowLog.SourceIP)
+		setField(document, "src_endpoint.port", flowLog.SourcePort)
+		setField(document, "dst_endpoint.ip", flowLog.DestinationIP)
+		setField(document, "dst_endpoint.port", flowLog.DestinationPort)
+		setField(document, "connection_info.protocol_name", optionalString(ecsTransports[flowLog.Protocol]))
+		setField(document, "connection_info.protocol_num", ocsfProtocolNumbers[flowLog.Protocol])
+		setField(document, "connection_info.direction_id", ocsfDirectionIDs[flowLog.TrafficFlow])
+		setField(document, "connection_info.direction", optionalString(ocsfDirections[flowLog.TrafficFlow]))
+		if networkType(flowLog.SourceIP) == "ipv6" {
+			setField(document, "connection_info.protocol_ver_id", 6)
+		} else {
+			setField(document, "connection_info.protocol_ver_id", 4)
+		}
+		setField(document, "device.uid", optionalString(flowLog.SystemID))
+		setField(document, "device.mac", optionalString(flowLog.Mac))
+		if flowLog.PacketsSent != nil {
+			setField(document, "traffic.packets_out", *flowLog.PacketsSent)
+			setField(document, "traffic.bytes_out", *flowLog.BytesSent)
+			setField(document, "traffic.packets_in", *flowLog.PacketsReceived)
+			setField(document, "traffic.bytes_in", *flowLog.BytesReceived)
+			setField(document, "traffic.packets", *flowLog.PacketsSent+*flowLog.PacketsReceived)
+			setField(document, "traffic.bytes", *flowLog.BytesSent+*flowLog.BytesReceived)
+		}
+	case LogFamilyNsgEvent:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		setOCSFClass(document, 0, "Base Event", 0)
+		setField(document, "metadata.product.name", "Network Security Group")
+		setField(document, "unmapped.properties", properties)
+	case LogFamilyAppGwAccess:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		setHTTPActivity(document, properties)
+		setField(document, "src_endpoint.ip", propertyString(properties, "clientIP"))
+		setField(document, "src_endpoint.port", propertyInt64(properties, "clientPort"))
+		setField(document, "http_request.http_method", propertyString(properties, "httpMethod"))
+		setField(document, "http_request.url.url_string", optionalString(requestURL(properties)))
+		setField(document, "http_request.url.path", propertyString(properties, "requestUri"))
+		setField(document, "http_request.url.query_string", propertyString(properties, "requestQuery"))
+		setField(document, "http_request.user_agent", propertyString(properties, "userAgent"))
+		setField(document, "http_request.version", propertyString(properties, "httpVersion"))
+		setField(document, "http_response.code", propertyInt64(properties, "httpStatus"))
+		setField(document, "traffic.bytes_in", propertyInt64(properties, "receivedBytes"))
+		setField(document, "traffic.bytes_out", propertyInt64(properties, "sentBytes"))
+		setField(document, "duration", propertyInt64(properties, "timeTaken"))
+		setField(document, "unmapped.ssl_enabled", propertyText(properties, "sslEnabled") == "on")
+		setField(document, "device.instance_uid", propertyString(properties, "instanceId"))
+	case LogFamilyAppGwFirewall:
+		properties, err := recordProperties(event)
+		if err != nil {
+			return nil, err
+		}
+		setHTTPActivity(document, properties)
+		switch strings.ToLower(propertyText(properties, "action")) {
+		case "blocked":
+			setOCSFAction(document, false)
+		case "detected", "matched":
+			setField(document, "action_id", 1)
+			setField(document, "action", "Allowed")
+			setField(document, "disposition_id", 15)
+			setField(document, "disposition", "Detected")
+		case "allowed":
+			setOCSFAction(document, true)
+		}
+		details, _ := properties["details"].(map[string]interface{})
+		setField(document, "src_endpoint.ip", propertyString(properties, "clientIp"))
+		setField(document, "src_endpoint.port", propertyInt64(properties, "clientPort"))
+		setField(document, "http_request.url.url_string", propertyString(properties, "requestUri"))
+		setField(document, "firewall_rule.uid", propertyString(properties, "ruleId"))
+		setField(document, "firewall_rule.type", propertyString(properties, "ruleSetType"))
+		setField(document, "firewall_rule.version", propertyString(properties, "ruleSetVersion"))
+		setField(document, "firewall_rule.desc", propertyString(properties, "message"))
+		setField(document, "message", propertyString(details, "message"))
+		setField(document, "device.instance_uid", propertyString(properties, "instanceId"))
+		setField(document, "unmapped.site", propertyString(properties, "site"))
+		setField(document, "unmapped.details", details)
+	}
+	setOCSFGeo(document, "src_endpoint", event, "source", "slat", "slong")
+	setOCSFGeo(document, "dst_endpoint", event, "destination", "dlat", "dlong")
+	setOCSFAsset(document, "src_endpoint", event, "source", "shost")
+	setOCSFAsset(document, "dst_endpoint", event, "destination", "dhost")
+	setField(document, "unmapped.traffic_type", optionalString(event.Extension[TrafficTypeKey]))
+	for endpoint, prefix := range map[string]string{"src_endpoint": "source", "dst_endpoint": "destination"} {
+		setField(document, endpoint+".zone", optionalString(event.Extension[prefix+NetworkKey]))
+		setField(document, "unmapped."+prefix+"_service_tag", optionalString(event.Extension[prefix+ServiceTagKey]))
+	}
+	setOCSFThreat(document, event)
+	setField(document, "unmapped.pseudonym_key", optionalString(event.Extension[PseudonymKeyKey]))
+	return document, nil
+}
+
+// setECSGeo sets the geo and as fields of field from the GeoIP extensions
+// with prefix.
+func setECSGeo(document map[string]interface{}, field string, event *CEFEvent, prefix, latitudeKey, longitudeKey string) {
+	extension := event.Extension
+	setField(document, field+".geo.country_iso_code", optionalString(extension[prefix+GeoCountryCodeKey]))
+	setField(document, field+".geo.country_name", optionalString(extension[prefix+GeoCountryKey]))
+	setField(document, field+".geo.city_name", optionalString(extension[prefix+GeoCityKey]))
+	latitude, latitudeErr := strconv.ParseFloat(extension[latitudeKey], 64)
+	longitude, longitudeErr := strconv.ParseFloat(extension[longitudeKey], 64)
+	if latitudeErr == nil && longitudeErr == nil {
+		setField(document, field+".geo.location", map[string]interface{}{"lat": latitude, "lon": longitude})
+	}
+	if asn, err := strconv.ParseInt(extension[prefix+GeoASNKey], 10, 64); err == nil {
+		setField(document, field+".as.number", asn)
+	}
+	setField(document, field+".as.organization.name", optionalString(extension[prefix+GeoASOrganizationKey]))
+}
+
+// setECSAsset sets the inventory fields with prefix under azure.field, with
+// the VM name also in related.hosts.
+func setECSAsset(document map[string]interface{}, field string, event *CEFEvent, prefix, hostKey string) {
+	extension := event.Extension
+	if host := extension[hostKey]; host != "" {
+		related, _ := document["related"].(map[string]interface{})
+		hosts, _ := related["hosts"].([]string)
+		setField(document, "related.hosts", append(hosts, host))
+		setField(document, "azure."+field+".vm_name", host)
+	}
+	setField(document, "azure."+field+".nic", optionalString(extension[prefix+AssetNICKey]))
+	setField(document, "azure."+field+".subnet", optionalString(extension[prefix+AssetSubnetKey]))
+	setField(document, "azure."+field+".vnet", optionalString(extension[prefix+AssetVNetKey]))
+	setField(document, "azure."+field+".application", optionalString(extension[prefix+AssetApplicationKey]))
+	setField(document, "azure."+field+".owner", optionalString(extension[prefix+AssetOwnerKey]))
+}
+
+// setOCSFAsset sets the inventory fields with prefix on endpoint.
+func setOCSFAsset(document map[string]interface{}, endpoint string, event *CEFEvent, prefix, hostKey string) {
+	extension := event.Extension
+	setField(document, endpoint+".name", optionalString(extension[hostKey]))
+	setField(document, endpoint+".interface_name", optionalString(extension[prefix+AssetNICKey]))
+	setField(document, endpoint+".subnet_uid", optionalString(extension[prefix+AssetSubnetKey]))
+	setField(document, endpoint+".vpc_uid", optionalString(extension[prefix+AssetVNetKey]))
+	setField(document, endpoint+".owner.name", optionalString(extension[prefix+AssetOwnerKey]))
+	setField(document, "unmapped."+prefix+"_application", optionalString(extension[prefix+AssetApplicationKey]))
+}
+
+// setOCSFGeo sets the location and autonomous_system of endpoint from the
+// GeoIP extensions with prefix.
+func setOCSFGeo(document map[string]interface{}, endpoint string, event *CEFEvent, prefix, latitudeKey, longitudeKey string) {
+	extension := event.Extension
+	setField(document, endpoint+".location.country", optionalString(extension[prefix+GeoCountryCodeKey]))
+	setField(document, endpoint+".location.city", optionalString(extension[prefix+GeoCityKey]))
+	if latitude, err := strconv.ParseFloat(extension[latitudeKey], 64); err == nil {
+		setField(document, endpoint+".location.lat", latitude)
+	}
+	if longitude, err := strconv.ParseFloat(extension[longitudeKey], 64); err == nil {
+		setField(document, endpoint+".location.long", longitude)
+	}
+	if asn, err := strconv.ParseInt(extension[prefix+GeoASNKey], 10, 64); err == nil {
+		setField(document, endpoint+".autonomous_system.number", asn)
+	}
+	setField(document, endpoint+".autonomous_system.name", optionalString(extension[prefix+GeoASOrganizationKey]))
+}
+
+// threatMatch returns the matched address and its side, source or
+// destination, of events matching a ThreatIntel indicator.
+func threatMatch(event *CEFEvent) (string, string) {
+	if event.Extension[ThreatFeedKey] == "" {
+		return "", ""
+	}
+	if event.Extension[ThreatMatchKey] == "destination" {
+		return event.Extension["dst"], "destination"
+	}
+	return event.Extension["src"], "source"
+}
+
+// setECSThreat sets threat.enrichments and event.severity of events matching
+// a ThreatIntel indicator.
+func setECSThreat(document map[string]interface{}, event *CEFEvent) {
+	address, side := threatMatch(event)
+	if side == "" {
+		return
+	}
+	extension := event.Extension
+	indicator := map[string]interface{}{"ip": address, "type": "ipv4-addr", "provider": extension[ThreatFeedKey]}
+	if networkType(address) == "ipv6" {
+		indicator["type"] = "ipv6-addr"
+	}
+	if description := extension[ThreatDescriptionKey]; description != "" {
+		indicator["description"] = description
+	}
+	setField(document, "threat.enrichments", []interface{}{map[string]interface{}{
+		"indicator": indicator,
+		"matched": map[string]interface{}{
+			"atomic": address,
+			"field":  side + ".ip",
+			"id":     extension[ThreatIndicatorKey],
+			"type":   "indicator_match_rule",
+		},
+	}})
+	setField(document, "event.severity", event.Severity)
+}
+
+// setOCSFThreat sets the enrichments and severity of events matching a
+// ThreatIntel indicator, mapping the CEF severity to severity_id.
+func setOCSFThreat(document map[string]interface{}, event *CEFEvent) {
+	address, side := threatMatch(event)
+	if side == "" {
+		return
+	}
+	extension := event.Extension
+	endpoint := map[string]string{"source": "src_endpoint", "destination": "dst_endpoint"}[side]
+	data := map[string]interface{}{"indicator_id": extension[ThreatIndicatorKey], "network": extension[ThreatNetworkKey]}
+	if description := extension[ThreatDescriptionKey]; description != "" {
+		data["description"] = description
+	}
+	setField(document, "enrichments", []interface{}{map[string]interface{}{
+		"name":     endpoint + ".ip",
+		"value":    address,
+		"type":     "threat_intel",
+		"provider": extension[ThreatFeedKey],
+		"data":     data,
+	}})
+	switch {
+	case event.Severity >= 9:
+		setField(document, "severity_id", 5)
+		setField(document, "severity", "Critical")
+	case event.Severity >= 7:
+		setField(document, "severity_id", 4)
+		setField(document, "severity", "High")
+	case event.Severity >= 4:
+		setField(document, "severity_id", 3)
+		setField(document, "severity", "Medium")
+	default:
+		setField(document, "severity_id", 2)
+		setField(document, "severity", "Low")
+	}
+}
+
+// setOCSFClass sets the class, category and activity of an OCSF event. All
+// classes used are in the Network Activity category.
+func setOCSFClass(document map[string]interface{}, classUID int, className string, activityID int) {
+	categoryUID := classUID / 1000
+	setField(document, "class_uid", classUID)
+	setField(document, "class_name", className)
+	setField(document, "category_uid", categoryUID)
+	if categoryUID == 4 {
+		setField(document, "category_name", "Network Activity")
+	}
+	setField(document, "activity_id", activityID)
+	setField(document, "type_uid", classUID*100+activityID)
+}
+
+func setOCSFAction(document map[string]interface{}, allowed bool) {
+	if allowed {
+		setField(document, "action_id", 1)
+		setField(document, "action", "Allowed")
+		setField(document, "disposition_id", 1)
+		setField(document, "disposition", "Allowed")
+	} else {
+		setField(document, "action_id", 2)
+		setField(document, "action", "Denied")
+		setField(document, "disposition_id", 2)
+		setField(document, "disposition", "Blocked")
+	}
+}
+
+// setHTTPActivity sets the HTTP Activity class, with the activity taken from
+// the request method. WAF logs carry no method and are Other.
+func setHTTPActivity(document map[string]interface{}, properties map[string]interface{}) {
+	activityID, ok := ocsfHTTPActivities[strings.ToUpper(propertyText(properties, "httpMethod"))]
+	if !ok {
+		activityID = 99
+	}
+	setOCSFClass(document, ocsfClassHTTPActivity, "HTTP Activity", activityID)
+	setField(document, "metadata.product.name", "Application Gateway")
+}
+
+// setField sets a dotted path in document, creating the objects on the way.
+// nil values and empty strings are skipped.
+func setField(document map[string]interface{}, path string, value interface{}) {
+	switch typed := value.(type) {
+	case nil:
+		return
+	case string:
+		if typed == "" {
+			return
+		}
+	case map[string]interface{}:
+		if typed == nil {
+			return
+		}
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := document[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			document[key] = child
+		}
+		document = child
+	}
+	document[keys[len(keys)-1]] = value
+}
+
+// requestURL returns the request path of an access log with its query.
+func requestURL(properties map[string]interface{}) string {
+	uri := propertyText(properties, "requestUri")
+	if query := propertyText(properties, "requestQuery"); query != "" {
+		return uri + "?" + query
+	}
+	return uri
+}
+
+func networkType(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// schemaFormatter renders events as ECS or OCSF json documents.
+type schemaFormatter struct {
+	schema string
+}
+
+func (formatter schemaFormatter) Format(event *CEFEvent) ([]byte, error) {
+	document, err := NewSchemaDocument(formatter.schema, event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// schemaValue returns the value at a dotted path of a document after a json
+// round trip, as a consumer would see it.
+func schemaValue(t *testing.T, document map[string]interface{}, path ...string) interface{} {
+	data, err := json.Marshal(document)
+	require.Nil(t, err)
+	var value interface{} = map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(data, &value))
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func TestNewECSDocumentFlow(t *testing.T) {
+	document, err := NewECSDocument(loadTestEvents("nsg_flow_events.json", t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, "2017-06-09T20:06:53.000Z", document["@timestamp"])
+	assert.Equal(t, "10.193.160.4", schemaValue(t, document, "source", "ip"))
+	assert.Equal(t, float64(46010), schemaValue(t, document, "source", "port"))
+	assert.Equal(t, "40.85.232.72", schemaValue(t, document, "destination", "ip"))
+	assert.Equal(t, float64(443), schemaValue(t, document, "destination", "port"))
+	assert.Equal(t, "tcp", schemaValue(t, document, "network", "transport"))
+	assert.Equal(t, "outbound", schemaValue(t, document, "network", "direction"))
+	assert.Equal(t, "allowed", schemaValue(t, document, "event", "action"))
+	assert.Equal(t, "NSGNAME-NSG", schemaValue(t, document, "observer", "name"))
+	assert.NotEmpty(t, schemaValue(t, document, "rule", "name"))
+	assert.Nil(t, schemaValue(t, document, "source", "bytes"))
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	document, err = NewECSDocument(events[5])
+	require.Nil(t, err)
+	assert.Equal(t, "ipv6", schemaValue(t, document, "network", "type"))
+	assert.Equal(t, []interface{}{"connection", "allowed", "end"}, schemaValue(t, document, "event", "type"))
+	assert.Equal(t, float64(52), schemaValue(t, document, "source", "packets"))
+	assert.Equal(t, float64(29952), schemaValue(t, document, "source", "bytes"))
+	assert.Equal(t, float64(29952+27072), schemaValue(t, document, "network", "bytes"))
+}
+
+func TestNewECSDocumentAppGw(t *testing.T) {
+	document, err := NewECSDocument(loadTestAppGwEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, "52.237.25.113", schemaValue(t, document, "source", "ip"))
+	assert.Equal(t, float64(200), schemaValue(t, document, "http", "response", "status_code"))
+	assert.Equal(t, "2.0", schemaValue(t, document, "http", "version"))
+	assert.Contains(t, schemaValue(t, document, "url", "original"), "/?X-AzureApplicationGateway-CACHE-HIT=0")
+	assert.Contains(t, schemaValue(t, document, "user_agent", "original"), "Mozilla/5.0")
+	assert.Equal(t, float64(77000000), schemaValue(t, document, "event", "duration"))
+	assert.Equal(t, "ApplicationGatewayAccessLog", schemaValue(t, document, "event", "provider"))
+
+	document, err = NewECSDocument(loadTestAppGwFirewallEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, "960015", schemaValue(t, document, "rule", "id"))
+	assert.Equal(t, "OWASP", schemaValue(t, document, "rule", "ruleset"))
+	assert.Equal(t, "detected", schemaValue(t, document, "event", "action"))
+	assert.Equal(t, "/", schemaValue(t, document, "url", "original"))
+	assert.Equal(t, "Warning. Operator EQ matched 0 at REQUEST_HEADERS.", document["message"])
+}
+
+func TestNewOCSFEvent(t *testing.T) {
+	document, err := NewOCSFEvent(loadTestEvents("nsg_flow_events.json", t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, int64(1497038813000), document["time"])
+	assert.Equal(t, 4001, document["class_uid"])
+	assert.Equal(t, 6, document["activity_id"])
+	assert.Equal(t, 400106, document["type_uid"])
+	assert.Equal(t, "Allowed", document["action"])
+	assert.Equal(t, "10.193.160.4", schemaValue(t, document, "src_endpoint", "ip"))
+	assert.Equal(t, float64(443), schemaValue(t, document, "dst_endpoint", "port"))
+	assert.Equal(t, float64(6), schemaValue(t, document, "connection_info", "protocol_num"))
+	assert.Equal(t, "Outbound", schemaValue(t, document, "connection_info", "direction"))
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	document, err = NewOCSFEvent(events[0])
+	require.Nil(t, err)
+	assert.Equal(t, 1, document["activity_id"])
+	assert.Equal(t, "Denied", document["action"])
+	assert.Equal(t, float64(17), schemaValue(t, document, "connection_info", "protocol_num"))
+
+	document, err = NewOCSFEvent(loadTestAppGwEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, 4002, document["class_uid"])
+	assert.Equal(t, 3, document["activity_id"])
+	assert.Equal(t, "GET", schemaValue(t, document, "http_request", "http_method"))
+	assert.Equal(t, float64(200), schemaValue(t, document, "http_response", "code"))
+
+	document, err = NewOCSFEvent(loadTestAppGwFirewallEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, 99, document["activity_id"])
+	assert.Equal(t, "Detected", document["disposition"])
+	assert.Equal(t, "960015", schemaValue(t, document, "firewall_rule", "uid"))
+}
+
+func TestSchemaFormatter(t *testing.T) {
+	for _, name := range []string{FormatECS, FormatOCSF} {
+		formatter, err := NewEventFormatter(name)
+		require.Nil(t, err)
+		for _, event := range loadTestEvents("nsg_flow_events_v2.json", t) {
+			line, err := formatter.Format(event)
+			require.Nil(t, err)
+			assert.True(t, json.Valid(line), name)
+		}
+	}
+	_, err := NewSchemaDocument("cim", loadTestEvents("nsg_flow_events.json", t)[0])
+	assert.Error(t, err)
+}
@@ -38,7 +38,7 @@ func loadStatus() (NsgParserStatus, error) {
 		BuildDate:          version.BuildDate,
 		BuildUser:          version.BuildUser,
 		Revision:           version.Revision,
-		Jobs:               httpStatusClient.RegisteredJobs,
+		Jobs:               httpStatusClient.registeredJobs(),
 		ProcessedFlowCount: processedFlowCount.Count(),
 	}
 
@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestGetProcessStatusWhileRegistering(t *testing.T) {
+	client, err := NewAzureClient("account", "c2VjcmV0", "container", "")
+	require.Nil(t, err)
+	httpStatusClient = &client
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				client.RegisterJob(&Job{Name: fmt.Sprintf("job-%d-%d", i, j)})
+			}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		recorder := httptest.NewRecorder()
+		GetProcessStatus(recorder, httptest.NewRequest("GET", "/status", nil))
+		assert.Equal(t, 200, recorder.Code)
+	}
+	wg.Wait()
+
+	status, err := loadStatus()
+	require.Nil(t, err)
+	assert.Equal(t, 200, len(status.Jobs))
+}
@@ -0,0 +1,352 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCountersDelta sums the counters of each tuple, as version 2
+	// counters count the packets and bytes since the previous tuple.
+	SessionCountersDelta = "delta"
+	// SessionCountersCumulative takes the counters of the latest tuple.
+	SessionCountersCumulative = "cumulative"
+)
+
+// Sessionizer joins the Begin, Continuing and End tuples of version 2 flows
+// into one connection record per session, keyed by NIC and 5-tuple. A record
+// is sent when the End tuple is read, carrying the first and last tuple times
+// in start and end, the tuple count in cnt, the total counters and the final
+// flow state. Sessions without an End tuple are sent with their last state
+// once a tuple of the same NSG and NIC later than Timeout after their last
+// tuple is read, as the blobs of NSGs are read independently.
+//
+// Denied tuples are sent as single tuple records and version 1 tuples and
+// other log families unchanged. Open sessions are saved to a state file, so
+// they survive restarts. Tuples earlier than the last tuple of their session,
+// or of the same second with the same state and counters as a tuple already
+// read, were already counted and are ignored, so blob ranges read again after
+// a restart are not counted twice. Completed sessions are kept until they
+// expire for this.
+type Sessionizer struct {
+	Timeout  time.Duration
+	Counters string
+	Sender   EventSender
+
+	path  string
+	mutex sync.Mutex
+	state sessionState
+}
+
+type sessionState struct {
+	// Watermarks are the latest tuple time read of each NSG and NIC.
+	Watermarks map[string]time.Time    `json:"watermarks"`
+	Sessions   map[string]*flowSession `json:"sessions"`
+}
+
+type flowSession struct {
+	Event     CEFEvent  `json:"event"`
+	LastSeen  time.Time `json:"lastSeen"`
+	State     string    `json:"state"`
+	Count     int64     `json:"count"`
+	Counters  []int64   `json:"counters,omitempty"`
+	Completed bool      `json:"completed,omitempty"`
+	// LastTuples identify the tuples read at LastSeen, as tuples of the same
+	// second can only be told apart by their state and counters.
+	LastTuples []string `json:"lastTuples,omitempty"`
+}
+
+// NewSessionizer returns a sessionizer sending to sender, with the open
+// sessions saved in stateFile.
+func NewSessionizer(timeout time.Duration, counters string, stateFile string, sender EventSender) (*Sessionizer, error) {
+	if timeout < time.Minute {
+		return nil, fmt.Errorf("session timeout must be at least a minute")
+	}
+	switch counters {
+	case "":
+		counters = SessionCountersDelta
+	case SessionCountersDelta, SessionCountersCumulative:
+	default:
+		return nil, fmt.Errorf("session counters must be delta or cumulative")
+	}
+	sessionizer := &Sessionizer{
+		Timeout:  timeout,
+		Counters: counters,
+		Sender:   sender,
+		path:     stateFile,
+		state:    sessionState{Watermarks: map[string]time.Time{}, Sessions: map[string]*flowSession{}},
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return sessionizer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading session state: %s", err)
+	}
+	err = json.Unmarshal(data, &sessionizer.state)
+	if err != nil {
+		return nil, fmt.Errorf("error reading session state %s: %s", stateFile, err)
+	}
+	if sessionizer.state.Watermarks == nil {
+		sessionizer.state.Watermarks = map[string]time.Time{}
+	}
+	if sessionizer.state.Sessions == nil {
+		sessionizer.state.Sessions = map[string]*flowSession{}
+	}
+	return sessionizer, nil
+}
+
+// SendEvents adds the tuples of events to their sessions and sends the other
+// events with the sessions completed or expired. The sessions are saved after
+// sending. If sending fails they are left as they were.
+func (sessionizer *Sessionizer) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	sessionizer.mutex.Lock()
+	defer sessionizer.mutex.Unlock()
+
+	changed := map[string]*flowSession{}
+	completed := []*flowSession{}
+	watermarks := map[string]time.Time{}
+	send := []*CEFEvent{}
+	for _, event := range events {
+		state, stateful := event.Extension["cs5"]
+		if event.LogFamily() != LogFamilyNsgFlow || !stateful {
+			send = append(send, event)
+			continue
+		}
+		nic := flowNIC(event)
+		watermark, ok := watermarks[nic]
+		if !ok {
+			watermark = sessionizer.state.Watermarks[nic]
+		}
+		if event.Time.After(watermark) {
+			watermarks[nic] = event.Time
+		}
+		if event.Extension["categoryOutcome"] == "Deny" {
+			session := newFlowSession(event)
+			session.add(event, sessionizer.Counters)
+			completed = append(completed, session)
+			continue
+		}
+		key := sessionKey(event)
+		session, ok := changed[key]
+		if !ok {
+			session = sessionizer.state.Sessions[key].copy()
+		}
+		if session != nil && session.seen(event) {
+			continue
+		}
+		// A Begin tuple of an open session reuses its 5-tuple.
+		if session != nil && !session.Completed && state == flowStateMap["B"] {
+			completed = append(completed, session)
+			session = nil
+		}
+		if session == nil || session.Completed {
+			session = newFlowSession(event)
+		}
+		session.add(event, sessionizer.Counters)
+		changed[key] = session
+		if state == flowStateMap["E"] {
+			session.Completed = true
+			completed = append(completed, session)
+		}
+	}
+
+	// Completed sessions are kept until they expire to ignore their tuples
+	// when read again.
+	closed := []string{}
+	for key, session := range sessionizer.state.Sessions {
+		if _, ok := changed[key]; !ok && sessionizer.expired(session, watermarks) {
+			closed = append(closed, key)
+			if !session.Completed {
+				completed = append(completed, session)
+			}
+		}
+	}
+	for key, session := range changed {
+		if sessionizer.expired(session, watermarks) {
+			closed = append(closed, key)
+			if !session.Completed {
+				completed = append(completed, session)
+			}
+		}
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].Event.Time.Before(completed[j].Event.Time) })
+	for _, session := range completed {
+		send = append(send, session.record())
+	}
+
+	if len(send) > 0 {
+		err := sessionizer.Sender.SendEvents(logFile, send)
+		if err != nil {
+			return err
+		}
+	}
+	for key, session := range changed {
+		sessionizer.state.Sessions[key] = session
+	}
+	for _, key := range closed {
+		delete(sessionizer.state.Sessions, key)
+	}
+	for nic, watermark := range watermarks {
+		sessionizer.state.Watermarks[nic] = watermark
+	}
+	return sessionizer.save()
+}
+
+// expired reports whether a tuple of the NSG and NIC of session later than
+// Timeout after its last tuple was read, in this batch or before.
+func (sessionizer *Sessionizer) expired(session *flowSession, watermarks map[string]time.Time) bool {
+	nic := flowNIC(&session.Event)
+	watermark, ok := watermarks[nic]
+	if !ok {
+		watermark = sessionizer.state.Watermarks[nic]
+	}
+	return session.LastSeen.Before(watermark.Add(-sessionizer.Timeout))
+}
+
+// OpenSessions returns the number of open sessions.
+func (sessionizer *Sessionizer) OpenSessions() int {
+	sessionizer.mutex.Lock()
+	defer sessionizer.mutex.Unlock()
+	open := 0
+	for _, session := range sessionizer.state.Sessions {
+		if !session.Completed {
+			open++
+		}
+	}
+	return open
+}
+
+// Close saves the open sessions and closes the sender. Open sessions are not
+// sent, they are continued by the next run.
+func (sessionizer *Sessionizer) Close() error {
+	sessionizer.mutex.Lock()
+	err := sessionizer.save()
+	sessionizer.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if closer, ok := sessionizer.Sender.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// save replaces the state file, so that a crash leaves the previous state.
+func (sessionizer *Sessionizer) save() error {
+	data, err := json.Marshal(sessionizer.state)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(filepath.Dir(sessionizer.path), "."+filepath.Base(sessionizer.path))
+	err = ioutil.WriteFile(temp, data, 0666)
+	if err != nil {
+		return fmt.Errorf("error saving session state: %s", err)
+	}
+	err = os.Rename(temp, sessionizer.path)
+	if err != nil {
+		return fmt.Errorf("error saving session state: %s", err)
+	}
+	return nil
+}
+
+// sessionKey identifies the session of a tuple by NIC and 5-tuple.
+func sessionKey(event *CEFEvent) string {
+	extension := event.Extension
+	return strings.Join([]string{flowNIC(event), extension["src"], extension["spt"],
+		extension["dst"], extension["dpt"], extension["proto"]}, "|")
+}
+
+func newFlowSession(event *CEFEvent) *flowSession {
+	session := &flowSession{Event: *event}
+	session.Event.Extension = copyExtension(event.Extension)
+	return session
+}
+
+func (session *flowSession) copy() *flowSession {
+	if session == nil {
+		return nil
+	}
+	copied := *session
+	copied.Event.Extension = copyExtension(session.Event.Extension)
+	if session.Counters != nil {
+		copied.Counters = append([]int64{}, session.Counters...)
+	}
+	if session.LastTuples != nil {
+		copied.LastTuples = append([]string{}, session.LastTuples...)
+	}
+	return &copied
+}
+
+// seen returns whether the tuple of event was already added to the session.
+func (session *flowSession) seen(event *CEFEvent) bool {
+	if event.Time.Before(session.LastSeen) {
+		return true
+	}
+	if !event.Time.Equal(session.LastSeen) {
+		return false
+	}
+	tuple := flowTupleID(event)
+	for _, seen := range session.LastTuples {
+		if seen == tuple {
+			return true
+		}
+	}
+	return false
+}
+
+// flowTupleID identifies a tuple within its session and second by its state
+// and counters.
+func flowTupleID(event *CEFEvent) string {
+	values := []string{event.Extension["cs5"]}
+	for _, key := range flowCounterKeys {
+		values = append(values, event.Extension[key])
+	}
+	return strings.Join(values, "|")
+}
+
+func (session *flowSession) add(event *CEFEvent, counters string) {
+	session.Count++
+	if !event.Time.Equal(session.LastSeen) {
+		session.LastTuples = nil
+	}
+	session.LastSeen = event.Time
+	session.LastTuples = append(session.LastTuples, flowTupleID(event))
+	session.State = event.Extension["cs5"]
+	if event.Extension["cn1"] == "" {
+		return
+	}
+	if session.Counters == nil || counters == SessionCountersCumulative {
+		session.Counters = make([]int64, len(flowCounterKeys))
+	}
+	for i, key := range flowCounterKeys {
+		value, _ := strconv.ParseInt(event.Extension[key], 10, 64)
+		session.Counters[i] += value
+	}
+}
+
+// record returns the connection record of a session.
+func (session *flowSession) record() *CEFEvent {
+	event := session.Event
+	event.Extension = copyExtension(session.Event.Extension)
+	event.Extension["end"] = strconv.FormatInt(session.LastSeen.UnixNano()/int64(time.Millisecond), 10)
+	event.Extension["cnt"] = strconv.FormatInt(session.Count, 10)
+	event.Extension["cs5"] = session.State
+	if session.Counters != nil {
+		for i, key := range flowCounterKeys {
+			event.Extension[key] = strconv.FormatInt(session.Counters[i], 10)
+		}
+		event.Extension["cn1label"] = "Packets Sent"
+		event.Extension["cn2label"] = "Packets Received"
+	}
+	return &event
+}
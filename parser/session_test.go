@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionTestEvents reads version 2 flow tuples of one NIC.
+func sessionTestEvents(t *testing.T, tuples ...string) []*CEFEvent {
+	blob := `{"records":[{"time":"2018-11-13T12:00:00.0000000Z","systemId":"a0fca5ce-022c-47b1-9735-89943b42f2fa",` +
+		`"category":"NetworkSecurityGroupFlowEvent","resourceId":"/SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSGNAME-NSG",` +
+		`"operationName":"NetworkSecurityGroupFlowEvents","properties":{"Version":2,"flows":[{"rule":"UserRule_AllowHttps","flows":[{"mac":"000D3AF87856",` +
+		`"flowTuples":["` + strings.Join(tuples, `","`) + `"]}]}]}}]}`
+	events, err := ReadEvents(strings.NewReader(blob))
+	require.Nil(t, err)
+	return events
+}
+
+func newTestSessionizer(t *testing.T, counters string, sender EventSender) (*Sessionizer, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-session")
+	require.Nil(t, err)
+	sessionizer, err := NewSessionizer(10*time.Minute, counters, filepath.Join(dir, "sessions.json"), sender)
+	require.Nil(t, err)
+	return sessionizer, dir
+}
+
+func TestSessionizer(t *testing.T) {
+	sender := &recordingSender{}
+	sessionizer, dir := newTestSessionizer(t, "", sender)
+	defer os.RemoveAll(dir)
+
+	// The session spans two reads and a restart.
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,",
+		"1542110437,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,10,1000,8,4000")))
+	assert.Len(t, sender.events, 0)
+	assert.Equal(t, 1, sessionizer.OpenSessions())
+	require.Nil(t, sessionizer.Close())
+
+	sessionizer, err := NewSessionizer(10*time.Minute, "", filepath.Join(dir, "sessions.json"), sender)
+	require.Nil(t, err)
+	assert.Equal(t, 1, sessionizer.OpenSessions())
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110497,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,5,500,4,2000",
+		"1542110557,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,1,100,1,100")))
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, 0, sessionizer.OpenSessions())
+
+	flowLog, err := NewNsgFlowLog(sender.events[0])
+	require.Nil(t, err)
+	assert.Equal(t, int64(1542110377), flowLog.Time)
+	assert.Equal(t, int64(1542110557), flowLog.LastSeen)
+	assert.Equal(t, int64(4), flowLog.TupleCount)
+	assert.Equal(t, "E", flowLog.FlowState)
+	assert.Equal(t, int64(16), *flowLog.PacketsSent)
+	assert.Equal(t, int64(1600), *flowLog.BytesSent)
+	assert.Equal(t, int64(13), *flowLog.PacketsReceived)
+	assert.Equal(t, int64(6100), *flowLog.BytesReceived)
+
+	// Tuples read again are ignored.
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110497,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,5,500,4,2000",
+		"1542110557,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,1,100,1,100")))
+	assert.Len(t, sender.events, 1)
+}
+
+func TestSessionizerSameSecond(t *testing.T) {
+	sender := &recordingSender{}
+	sessionizer, dir := newTestSessionizer(t, "", sender)
+	defer os.RemoveAll(dir)
+
+	// A short connection begins and ends within the same second.
+	events := sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,",
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,3,300,2,200")
+	require.Nil(t, sessionizer.SendEvents(nil, events))
+	require.Len(t, sender.events, 1)
+	flowLog, err := NewNsgFlowLog(sender.events[0])
+	require.Nil(t, err)
+	assert.Equal(t, "E", flowLog.FlowState)
+	assert.Equal(t, int64(2), flowLog.TupleCount)
+	assert.Equal(t, int64(300), *flowLog.BytesSent)
+	assert.Equal(t, 0, sessionizer.OpenSessions())
+
+	// Both tuples are ignored when read again after a restart.
+	require.Nil(t, sessionizer.Close())
+	sessionizer, err = NewSessionizer(10*time.Minute, "", filepath.Join(dir, "sessions.json"), sender)
+	require.Nil(t, err)
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,",
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,3,300,2,200")))
+	assert.Len(t, sender.events, 1)
+}
+
+func TestSessionizerExpiry(t *testing.T) {
+	sender := &recordingSender{}
+	sessionizer, dir := newTestSessionizer(t, SessionCountersCumulative, sender)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,10,1000,8,4000",
+		"1542110437,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,12,1200,9,4500",
+		"1542110437,10.5.16.4,94.102.49.190,44932,443,T,O,D,B,,,,")))
+	// The denied tuple is sent at once.
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, "Deny", sender.events[0].Extension["categoryOutcome"])
+	assert.Equal(t, "1", sender.events[0].Extension["cnt"])
+
+	// A tuple past the timeout expires the session.
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542111097,10.5.16.4,94.102.49.190,44940,443,T,O,A,B,,,,")))
+	require.Len(t, sender.events, 2)
+	flowLog, err := NewNsgFlowLog(sender.events[1])
+	require.Nil(t, err)
+	assert.Equal(t, 44931, flowLog.SourcePort)
+	assert.Equal(t, "C", flowLog.FlowState)
+	assert.Equal(t, int64(2), flowLog.TupleCount)
+	assert.Equal(t, int64(12), *flowLog.PacketsSent)
+	assert.Equal(t, int64(4500), *flowLog.BytesReceived)
+	assert.Equal(t, 1, sessionizer.OpenSessions())
+}
+
+func TestSessionizerWatermarkPerNSG(t *testing.T) {
+	sender := &recordingSender{}
+	sessionizer, dir := newTestSessionizer(t, "", sender)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,",
+		"1542110437,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,10,1000,8,4000")))
+	// The blob of another NSG is read an hour ahead.
+	other := sessionTestEvents(t, "1542113977,10.6.16.4,94.102.49.190,50000,443,T,O,A,B,,,,")
+	for _, event := range other {
+		event.Extension["cs2"] = "OTHER-NSG"
+	}
+	require.Nil(t, sessionizer.SendEvents(nil, other))
+	assert.Len(t, sender.events, 0, "sessions only expire by tuples of their NSG")
+	assert.Equal(t, 2, sessionizer.OpenSessions())
+
+	require.Nil(t, sessionizer.SendEvents(nil, sessionTestEvents(t,
+		"1542110497,10.5.16.4,94.102.49.190,44931,443,T,O,A,C,5,500,4,2000",
+		"1542110557,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,1,100,1,100")))
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, flowStateMap["E"], sender.events[0].Extension["cs5"])
+	assert.Equal(t, "4", sender.events[0].Extension["cnt"])
+	assert.Equal(t, 1, sessionizer.OpenSessions())
+}
+
+func TestSessionizerPassThrough(t *testing.T) {
+	sender := &recordingSender{}
+	sessionizer, dir := newTestSessionizer(t, "", sender)
+	defer os.RemoveAll(dir)
+
+	events := append(loadTestEvents("nsg_flow_events.json", t), loadTestAppGwEvents(t)...)
+	require.Nil(t, sessionizer.SendEvents(nil, events))
+	assert.Len(t, sender.events, len(events))
+	assert.Equal(t, 0, sessionizer.OpenSessions())
+}
+
+func TestSessionizerRetry(t *testing.T) {
+	sender := &recordingSender{err: fmt.Errorf("connection refused")}
+	sessionizer, dir := newTestSessionizer(t, "", sender)
+	defer os.RemoveAll(dir)
+
+	events := sessionTestEvents(t,
+		"1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,B,,,,",
+		"1542110437,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,10,1000,8,4000")
+	assert.Error(t, sessionizer.SendEvents(nil, events))
+	sender.err = nil
+	require.Nil(t, sessionizer.SendEvents(nil, events))
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, "2", sender.events[0].Extension["cnt"])
+
+	_, err := NewSessionizer(10*time.Minute, "total", filepath.Join(dir, "other.json"), sender)
+	assert.Error(t, err)
+	_, err = NewSessionizer(time.Second, "", filepath.Join(dir, "other.json"), sender)
+	assert.Error(t, err)
+}
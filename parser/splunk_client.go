@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/satori/uuid"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	splunkEventPath       = "/services/collector/event"
+	splunkAckPath         = "/services/collector/ack"
+	splunkDefaultBatch    = 500
+	splunkDefaultTimeout  = 60
+	splunkAckPollInterval = time.Second
+)
+
+var splunkDefaultSourceTypes = map[string]string{
+	LogFamilyNsgFlow:       "azure:nsg:flow",
+	LogFamilyNsgEvent:      "azure:nsg:event",
+	LogFamilyAppGwAccess:   "azure:appgw:access",
+	LogFamilyAppGwFirewall: "azure:appgw:firewall",
+}
+
+// SplunkConfig holds the settings for the Splunk HTTP Event Collector destination.
+// Indexes, SourceTypes and Sources are keyed by log family and fall back to
+// Index, the built-in sourcetypes and Source respectively.
+type SplunkConfig struct {
+	URL                string            `mapstructure:"splunk_url"`
+	Token              string            `mapstructure:"splunk_token"`
+	Index              string            `mapstructure:"splunk_index"`
+	Indexes            map[string]string `mapstructure:"splunk_indexes"`
+	Source             string            `mapstructure:"splunk_source"`
+	Sources            map[string]string `mapstructure:"splunk_sources"`
+	SourceTypes        map[string]string `mapstructure:"splunk_sourcetypes"`
+	Gzip               bool              `mapstructure:"splunk_gzip"`
+	UseAck             bool              `mapstructure:"splunk_ack"`
+	AckTimeout         int               `mapstructure:"splunk_ack_timeout"`
+	BatchSize          int               `mapstructure:"splunk_batch_size"`
+	InsecureSkipVerify bool              `mapstructure:"splunk_insecure_skip_verify"`
+}
+
+// SplunkClient posts batches of events to a Splunk HTTP Event Collector.
+// With indexer acknowledgement enabled, a blob range is only checkpointed
+// once Splunk has acked every batch sent for it.
+type SplunkClient struct {
+	config          SplunkConfig
+	channel         string
+	httpClient      *http.Client
+	ackPollInterval time.Duration
+	initialized     bool
+}
+
+type splunkEvent struct {
+	Time       float64   `json:"time"`
+	Host       string    `json:"host,omitempty"`
+	Index      string    `json:"index,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	SourceType string    `json:"sourcetype,omitempty"`
+	Event      *CEFEvent `json:"event"`
+}
+
+type splunkResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+type splunkAckRequest struct {
+	Acks []int64 `json:"acks"`
+}
+
+type splunkAckResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+func (client *SplunkClient) Initialize(config SplunkConfig) error {
+	if config.URL == "" {
+		return fmt.Errorf("splunk_url is required for the splunk destination")
+	}
+	if config.Token == "" {
+		return fmt.Errorf("splunk_token is required for the splunk destination")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = splunkDefaultBatch
+	}
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = splunkDefaultTimeout
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+
+	client.config = config
+	client.channel = uuid.NewV4().String()
+	client.httpClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+		},
+	}
+	client.ackPollInterval = splunkAckPollInterval
+	client.initialized = true
+
+	log.WithFields(log.Fields{
+		"url":     config.URL,
+		"ack":     config.UseAck,
+		"channel": client.channel,
+	}).Info("initialized splunk client")
+	return nil
+}
+
+func (client *SplunkClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents posts events in batches of BatchSize and, when acknowledgement is
+// enabled, blocks until Splunk has acked all of them or AckTimeout expires.
+func (client *SplunkClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized splunk client")
+	}
+	ackIds := []int64{}
+	for start := 0; start < len(events); start += client.config.BatchSize {
+		end := start + client.config.BatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		ackId, err := client.postBatch(events[start:end])
+		if err != nil {
+			return err
+		}
+		if ackId != nil {
+			ackIds = append(ackIds, *ackId)
+		}
+	}
+	if client.config.UseAck {
+		return client.waitForAcks(ackIds)
+	}
+	return nil
+}
+
+func (client *SplunkClient) newSplunkEvent(event *CEFEvent) splunkEvent {
+	family := event.LogFamily()
+	return splunkEvent{
+		Time:       float64(event.Time.UnixNano()/int64(time.Millisecond)) / 1000,
+		Host:       event.Extension["cs2"],
+		Index:      lookupFamilySetting(client.config.Indexes, family, client.config.Index),
+		Source:     lookupFamilySetting(client.config.Sources, family, client.config.Source),
+		SourceType: lookupFamilySetting(client.config.SourceTypes, family, splunkDefaultSourceTypes[family]),
+		Event:      event,
+	}
+}
+
+func (client *SplunkClient) postBatch(events []*CEFEvent) (*int64, error) {
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	for _, event := range events {
+		err := encoder.Encode(client.newSplunkEvent(event))
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling to json %s", err)
+		}
+	}
+
+	response := splunkResponse{}
+	err := client.post(splunkEventPath, payload.Bytes(), &response)
+	if err != nil {
+		return nil, err
+	}
+	if client.config.UseAck && response.AckID == nil {
+		return nil, fmt.Errorf("splunk did not return an ackId. is indexer acknowledgement enabled for the token?")
+	}
+	return response.AckID, nil
+}
+
+// waitForAcks polls the ack endpoint until every id in ackIds is acknowledged.
+func (client *SplunkClient) waitForAcks(ackIds []int64) error {
+	deadline := time.Now().Add(time.Duration(client.config.AckTimeout) * time.Second)
+	pending := ackIds
+	for len(pending) > 0 {
+		payload, err := json.Marshal(splunkAckRequest{Acks: pending})
+		if err != nil {
+			return err
+		}
+		response := splunkAckResponse{}
+		err = client.post(splunkAckPath, payload, &response)
+		if err != nil {
+			return err
+		}
+
+		stillPending := []int64{}
+		for _, id := range pending {
+			if !response.Acks[strconv.FormatInt(id, 10)] {
+				stillPending = append(stillPending, id)
+			}
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for splunk to ack %d batch(es)", len(pending))
+		}
+		time.Sleep(client.ackPollInterval)
+	}
+	return nil
+}
+
+func (client *SplunkClient) post(path string, payload []byte, response interface{}) error {
+	body := payload
+	if client.config.Gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(payload)
+		err := writer.Close()
+		if err != nil {
+			return err
+		}
+		body = compressed.Bytes()
+	}
+
+	request, err := http.NewRequest("POST", client.config.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Splunk "+client.config.Token)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Splunk-Request-Channel", client.channel)
+	if client.config.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("splunk returned %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(responseBody)))
+	}
+	return json.Unmarshal(responseBody, response)
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockSplunk is a minimal HTTP Event Collector that records received events
+// and acknowledges batches once ackAfter polls have been made.
+type mockSplunk struct {
+	mutex     sync.Mutex
+	events    []splunkEvent
+	nextAckId int64
+	ackAfter  int
+	ackPolls  int
+	channels  map[string]bool
+	encodings map[string]bool
+}
+
+func (mock *mockSplunk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	if r.Header.Get("Authorization") != "Splunk test-token" {
+		http.Error(w, `{"text":"Invalid token","code":4}`, http.StatusForbidden)
+		return
+	}
+	mock.channels[r.Header.Get("X-Splunk-Request-Channel")] = true
+	mock.encodings[r.Header.Get("Content-Encoding")] = true
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+
+	switch r.URL.Path {
+	case splunkEventPath:
+		decoder := json.NewDecoder(body)
+		for decoder.More() {
+			event := splunkEvent{}
+			if err := decoder.Decode(&event); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mock.events = append(mock.events, event)
+		}
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, mock.nextAckId)
+		mock.nextAckId++
+	case splunkAckPath:
+		request := splunkAckRequest{}
+		json.NewDecoder(body).Decode(&request)
+		mock.ackPolls++
+		response := splunkAckResponse{Acks: map[string]bool{}}
+		for _, id := range request.Acks {
+			response.Acks[fmt.Sprintf("%d", id)] = mock.ackAfter >= 0 && mock.ackPolls > mock.ackAfter
+		}
+		json.NewEncoder(w).Encode(response)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newMockSplunk(ackAfter int) *mockSplunk {
+	return &mockSplunk{ackAfter: ackAfter, channels: map[string]bool{}, encodings: map[string]bool{}}
+}
+
+func newTestSplunkClient(t *testing.T, config SplunkConfig) *SplunkClient {
+	client := &SplunkClient{}
+	err := client.Initialize(config)
+	require.Nil(t, err, "error initializing splunk client")
+	client.ackPollInterval = time.Millisecond
+	return client
+}
+
+func TestSplunkSendEvents(t *testing.T) {
+	mock := newMockSplunk(1)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestSplunkClient(t, SplunkConfig{
+		URL:         server.URL,
+		Token:       "test-token",
+		Index:       "azure",
+		SourceTypes: map[string]string{LogFamilyNsgFlow: "custom:flow"},
+		Gzip:        true,
+		UseAck:      true,
+		BatchSize:   1000,
+	})
+
+	events := loadTestEvents("nsg_flow_events.json", t)
+	err := client.SendEvents(nil, events)
+	assert.Nil(t, err, "unexpected error sending events")
+
+	assert.Equal(t, len(events), len(mock.events))
+	assert.Equal(t, int64(5), mock.nextAckId, "expected one request per batch")
+	assert.True(t, mock.ackPolls >= 2, "expected ack endpoint to be polled until acked")
+	assert.Equal(t, 1, len(mock.channels), "all requests should share a channel")
+	assert.True(t, mock.encodings["gzip"])
+
+	first := mock.events[0]
+	assert.Equal(t, "azure", first.Index)
+	assert.Equal(t, "custom:flow", first.SourceType)
+	assert.Equal(t, "NSGNAME-NSG", first.Host)
+	assert.Equal(t, float64(events[0].Time.Unix()), first.Time)
+	assert.Equal(t, events[0].Extension["src"], first.Event.Extension["src"])
+}
+
+func TestSplunkAckTimeout(t *testing.T) {
+	mock := newMockSplunk(-1)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestSplunkClient(t, SplunkConfig{
+		URL:        server.URL,
+		Token:      "test-token",
+		UseAck:     true,
+		AckTimeout: 1,
+	})
+	client.ackPollInterval = 100 * time.Millisecond
+
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:10])
+	assert.EqualError(t, err, "timed out waiting for splunk to ack 1 batch(es)")
+}
+
+func TestSplunkBadToken(t *testing.T) {
+	mock := newMockSplunk(0)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := newTestSplunkClient(t, SplunkConfig{URL: server.URL, Token: "wrong"})
+	err := client.SendEvents(nil, loadTestEvents("nsg_flow_events.json", t)[:1])
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(mock.events))
+}
+
+func TestSplunkDefaultSourceType(t *testing.T) {
+	client := newTestSplunkClient(t, SplunkConfig{URL: "http://127.0.0.1:8088/", Token: "t", Source: "nsg-parser"})
+	events := loadTestEvents("nsg_flow_events.json", t)
+	splunkEvent := client.newSplunkEvent(events[0])
+	assert.Equal(t, "azure:nsg:flow", splunkEvent.SourceType)
+	assert.Equal(t, "nsg-parser", splunkEvent.Source)
+	assert.Equal(t, "", splunkEvent.Index)
+}
@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const streamDefaultTimeout = 30
+
+// StreamConfig holds the settings for the stream destination. An empty
+// Address writes to stdout, otherwise events are sent over TCP. Timeout is in
+// seconds. Columns and Templates configure the csv, tsv and template formats
+// as for the file destination.
+type StreamConfig struct {
+	Address   string              `mapstructure:"stream_address"`
+	Format    string              `mapstructure:"stream_format"`
+	Timeout   int                 `mapstructure:"stream_timeout"`
+	Columns   map[string][]string `mapstructure:"csv_columns"`
+	Templates map[string]string   `mapstructure:"template_files"`
+}
+
+// StreamClient writes framed events to stdout or a TCP connection. With the
+// protobuf format, the default, each event is an nsgpb.Event preceded by its
+// varint size, which nsgpb.Decoder reads back.
+type StreamClient struct {
+	config      StreamConfig
+	formatter   EventFormatter
+	stdout      io.Writer
+	conn        net.Conn
+	writer      *bufio.Writer
+	initialized bool
+}
+
+func (client *StreamClient) Initialize(config StreamConfig) error {
+	if config.Format == "" {
+		config.Format = FormatProtobuf
+	}
+	formatter, err := NewEventFormatterWithOptions(config.Format, FormatOptions{Columns: config.Columns, Templates: config.Templates})
+	if err != nil {
+		return err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = streamDefaultTimeout
+	}
+
+	client.config = config
+	client.formatter = formatter
+	if client.stdout == nil {
+		client.stdout = os.Stdout
+	}
+	client.initialized = true
+
+	target := config.Address
+	if target == "" {
+		target = "stdout"
+	}
+	log.WithFields(log.Fields{
+		"target": target,
+		"format": config.Format,
+	}).Info("initialized stream client")
+	return nil
+}
+
+func (client *StreamClient) ProcessAzureLogFile(logFile AzureLogFile, resultsChan chan AzureLogFile) error {
+	return processLogFile(logFile, resultsChan, client)
+}
+
+// SendEvents writes and flushes every event. A nil error means the events
+// were handed to the operating system, the stream has no acknowledgements.
+func (client *StreamClient) SendEvents(logFile AzureLogFile, events []*CEFEvent) error {
+	if !client.initialized {
+		return fmt.Errorf("uninitialized stream client")
+	}
+	if client.writer == nil {
+		err := client.connect()
+		if err != nil {
+			return err
+		}
+	}
+	if client.conn != nil {
+		client.conn.SetWriteDeadline(time.Now().Add(time.Duration(client.config.Timeout) * time.Second))
+	}
+	for _, event := range events {
+		record, err := client.formatter.Format(event)
+		if err == ErrSkipEvent {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = client.writer.Write(FrameRecord(client.formatter, record))
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("error writing stream: %s", err)
+		}
+	}
+	err := client.writer.Flush()
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("error writing stream: %s", err)
+	}
+	return nil
+}
+
+// Close flushes the stream and closes the connection, if any.
+func (client *StreamClient) Close() error {
+	if client.writer == nil {
+		return nil
+	}
+	err := client.writer.Flush()
+	client.writer = nil
+	if client.conn != nil {
+		closeErr := client.conn.Close()
+		client.conn = nil
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (client *StreamClient) connect() error {
+	if client.config.Address == "" {
+		client.writer = bufio.NewWriter(client.stdout)
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", client.config.Address, time.Duration(client.config.Timeout)*time.Second)
+	if err != nil {
+		return fmt.Errorf("error connecting to stream %s: %s", client.config.Address, err)
+	}
+	client.conn = conn
+	client.writer = bufio.NewWriter(conn)
+	return nil
+}
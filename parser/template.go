@@ -0,0 +1,298 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// FormatTemplate renders events with user templates, see TemplateFormatter.
+const FormatTemplate = "template"
+
+// TemplateData is the data a user template is executed with.
+type TemplateData struct {
+	// Time is the event time in UTC.
+	Time   time.Time
+	Family string
+	// Fields holds every decoded field by name, see EventFields.
+	Fields map[string]string
+	Event  *CEFEvent
+}
+
+// templateFuncs is the function library of user templates.
+var templateFuncs = template.FuncMap{
+	"formatTime": templateFormatTime,
+	"json":       templateJSON,
+	"cefHeader":  cefHeaderEscape,
+	"cefValue":   cefValueEscape,
+	"csv":        csvQuote,
+	"inCIDR":     templateInCIDR,
+	"isPrivate":  templateIsPrivate,
+	"ipVersion":  templateIPVersion,
+	"network":    templateNetwork,
+	"default":    templateDefault,
+	"coalesce":   templateCoalesce,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimSpace":  strings.TrimSpace,
+	"replace":    templateReplace,
+}
+
+// privateNetworks are the RFC 1918, carrier-grade NAT and unique local
+// address ranges.
+var privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// TemplateFormatter renders events with a text/template per log family.
+// Events of a family without a template are skipped. A trailing newline of the
+// output is dropped, as records are newline terminated when written.
+type TemplateFormatter struct {
+	templates map[string]*template.Template
+}
+
+// NewTemplateFormatter parses the template files of each log family and
+// validates them against a sample event of the family. A template that fails
+// on the sample is an error. A template referencing fields the sample does not
+// have, such as fields added by enrichment, is only logged.
+func NewTemplateFormatter(files map[string]string) (*TemplateFormatter, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("template_files must name a template file for at least one log family")
+	}
+	formatter := &TemplateFormatter{templates: map[string]*template.Template{}}
+	for family, path := range files {
+		sample, err := templateSampleEvent(family)
+		if err != nil {
+			return nil, err
+		}
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s template: %s", family, err)
+		}
+		tpl, err := template.New(family).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template %s: %s", family, path, err)
+		}
+		err = tpl.Execute(ioutil.Discard, newTemplateData(sample))
+		if err != nil {
+			return nil, fmt.Errorf("%s template %s fails on a sample event: %s", family, path, err)
+		}
+		strict, _ := tpl.Clone()
+		err = strict.Option("missingkey=error").Execute(ioutil.Discard, newTemplateData(sample))
+		if err != nil {
+			log.WithField("template", path).Warnf("%s template uses fields missing from the sample event: %s", family, err)
+		}
+		formatter.templates[family] = tpl
+	}
+	return formatter, nil
+}
+
+func (formatter *TemplateFormatter) Format(event *CEFEvent) ([]byte, error) {
+	tpl, ok := formatter.templates[event.LogFamily()]
+	if !ok {
+		return nil, ErrSkipEvent
+	}
+	var output bytes.Buffer
+	err := tpl.Execute(&output, newTemplateData(event))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(output.Bytes(), []byte("\n")), nil
+}
+
+func newTemplateData(event *CEFEvent) TemplateData {
+	return TemplateData{
+		Time:   event.Time.UTC(),
+		Family: event.LogFamily(),
+		Fields: EventFields(event),
+		Event:  event,
+	}
+}
+
+// templateSampleRecords are blobs with a single record of a log family, used
+// to validate templates. The flow tuple is a version 2 end tuple, which has
+// every flow field.
+var templateSampleRecords = map[string]string{
+	LogFamilyNsgFlow: `{"records":[{"time":"2018-11-13T12:01:00.6480000Z","systemId":"a0fca5ce-022c-47b1-9735-89943b42f2fa",` +
+		`"category":"NetworkSecurityGroupFlowEvent","resourceId":"/SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSGNAME-NSG",` +
+		`"operationName":"NetworkSecurityGroupFlowEvents","properties":{"Version":2,"flows":[{"rule":"UserRule_AllowHttps","flows":[{"mac":"000D3AF87856",` +
+		`"flowTuples":["1542110377,10.5.16.4,94.102.49.190,44931,443,T,O,A,E,52,29952,47,27072"]}]}]}}]}`,
+	LogFamilyAppGwAccess: `{"records":[{"resourceId":"/SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/APPLICATIONGATEWAYS/APPGW",` +
+		`"operationName":"ApplicationGatewayAccess","time":"2017-07-21T15:12:23Z","category":"ApplicationGatewayAccessLog",` +
+		`"properties":{"instanceId":"ApplicationGatewayRole_IN_1","clientIP":"52.237.25.113","clientPort":1709,"httpMethod":"GET","requestUri":"/",` +
+		`"requestQuery":"SERVER-STATUS=200","userAgent":"Mozilla/5.0","httpStatus":200,"httpVersion":"HTTP/2.0","receivedBytes":258,"sentBytes":1216,` +
+		`"timeTaken":77,"sslEnabled":"on"}}]}`,
+	LogFamilyAppGwFirewall: `{"records":[{"resourceId":"/SUBSCRIPTIONS/SUBID/RESOURCEGROUPS/RGNAME/PROVIDERS/MICROSOFT.NETWORK/APPLICATIONGATEWAYS/APPGW",` +
+		`"operationName":"ApplicationGatewayFirewall","time":"2017-07-21T17:27:20Z","category":"ApplicationGatewayFirewallLog",` +
+		`"properties":{"instanceId":"ApplicationGatewayRole_IN_1","clientIp":"52.237.25.113","clientPort":"0","requestUri":"/","ruleSetType":"OWASP",` +
+		`"ruleSetVersion":"2.2.9","ruleId":"960015","message":"Request Missing an Accept Header","action":"Detected","site":"Global",` +
+		`"details":{"message":"Warning. Operator EQ matched 0 at REQUEST_HEADERS.","data":"","file":"base_rules/modsecurity_crs_21_protocol_anomalies.conf","line":"47"}}}]}`,
+}
+
+// templateSampleEvent returns a sample event of family. NSG event logs are
+// not converted from blobs yet, so their sample is built directly.
+func templateSampleEvent(family string) (*CEFEvent, error) {
+	if family == LogFamilyNsgEvent {
+		event := NewAzureCEFEvent()
+		event.Time = time.Date(2017, 6, 22, 0, 0, 11, 0, time.UTC)
+		event.Name = "NetworkSecurityGroupEvent"
+		event.DeviceEventClassId = "NetworkSecurityGroupEvents"
+		event.Extension["cs2"] = "NSGNAME-NSG"
+		event.Extension["cs3"] = "SUBID"
+		event.Extension["cs4"] = "RGNAME"
+		event.Extension["msg"] = `{"vnetResourceGuid":"{518F41E6-E16C-4D52-A628-ECC84BEC5E35}","subnetPrefix":"10.144.0.32/28",` +
+			`"macAddress":"00-0D-3A-A3-17-17","primaryIPv4Address":"10.144.0.37","ruleName":"UserRule_its-vnet-any","direction":"In",` +
+			`"priority":120,"type":"allow","conditions":{"sourceIP":"10.144.0.0/23","destinationIP":"0.0.0.0/0"}}`
+		return &event, nil
+	}
+	record, ok := templateSampleRecords[family]
+	if !ok {
+		return nil, fmt.Errorf("template configured for unknown log family %q", family)
+	}
+	events, err := ReadEvents(strings.NewReader(record))
+	if err != nil {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+// templateFormatTime formats t in UTC with a Go layout, or as seconds or
+// milliseconds since the epoch with the unix and unixms layouts.
+func templateFormatTime(layout string, t time.Time) string {
+	switch layout {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixms":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		return t.UTC().Format(layout)
+	}
+}
+
+// templateJSON renders value as JSON, a quoted string for strings.
+func templateJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// cefHeaderEscape escapes a CEF header value.
+func cefHeaderEscape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return strings.Replace(value, `|`, `\|`, -1)
+}
+
+// cefValueEscape escapes a CEF extension value.
+func cefValueEscape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `=`, `\=`, -1)
+	value = strings.Replace(value, "\r\n", `\n`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return strings.Replace(value, "\r", `\r`, -1)
+}
+
+// csvQuote quotes a value as in RFC 4180 when it contains a comma, quote or
+// line break.
+func csvQuote(value string) string {
+	if !strings.ContainsAny(value, ",\"\r\n") {
+		return value
+	}
+	return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+}
+
+// templateInCIDR reports whether ip is in one of the comma separated cidrs.
+func templateInCIDR(cidrs string, ip string) (bool, error) {
+	address := net.ParseIP(ip)
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return false, err
+		}
+		if address != nil && network.Contains(address) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func templateIsPrivate(ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateIPVersion returns 4 or 6, or 0 for a value that is not an address.
+func templateIPVersion(ip string) int {
+	address := net.ParseIP(ip)
+	switch {
+	case address == nil:
+		return 0
+	case address.To4() != nil:
+		return 4
+	default:
+		return 6
+	}
+}
+
+// templateNetwork returns the network of ip with a prefix of bits, as in
+// 10.1.2.0/24. Values that are not addresses are returned unchanged.
+func templateNetwork(bits int, ip string) string {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return ip
+	}
+	size := 128
+	if ipv4 := address.To4(); ipv4 != nil {
+		address, size = ipv4, 32
+	}
+	if bits < 0 || bits > size {
+		return ip
+	}
+	network := net.IPNet{IP: address.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}
+	return network.String()
+}
+
+// templateDefault returns value, or fallback when value is empty.
+func templateDefault(fallback string, value string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// templateCoalesce returns the first non empty value.
+func templateCoalesce(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func templateReplace(old, new, value string) string {
+	return strings.Replace(value, old, new, -1)
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
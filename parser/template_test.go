@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTemplates(t *testing.T, templates map[string]string) (map[string]string, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-template")
+	require.Nil(t, err)
+	files := map[string]string{}
+	for family, text := range templates {
+		files[family] = filepath.Join(dir, family+".tmpl")
+		require.Nil(t, ioutil.WriteFile(files[family], []byte(text), 0644))
+	}
+	return files, dir
+}
+
+func TestTemplateFormatter(t *testing.T) {
+	files, dir := writeTestTemplates(t, map[string]string{
+		LogFamilyNsgFlow: `{{.Time | formatTime "unix"}} {{.Fields.nsgName | lower}} {{.Fields.sourceIp | network 24}} ` +
+			`{{if isPrivate .Fields.sourceIp}}private{{end}} {{.Fields.flowState | default "-"}} {{json .Fields.rule}}` + "\n",
+		LogFamilyAppGwFirewall: `{{.Family}},{{index .Fields "details.message" | csv}},{{ipVersion .Fields.clientIp}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	formatter, err := NewEventFormatterWithOptions(FormatTemplate, FormatOptions{Templates: files})
+	require.Nil(t, err)
+
+	event := loadTestEvents("nsg_flow_events.json", t)[0]
+	line, err := formatter.Format(event)
+	require.Nil(t, err)
+	assert.Equal(t, `1497038813 nsgname-nsg 10.193.160.0/24 private - "`+event.Extension["cs1"]+`"`, string(line))
+
+	line, err = formatter.Format(loadTestAppGwFirewallEvents(t)[0])
+	require.Nil(t, err)
+	assert.Equal(t, `appgw_firewall,Warning. Operator EQ matched 0 at REQUEST_HEADERS.,4`, string(line))
+
+	_, err = formatter.Format(loadTestAppGwEvents(t)[0])
+	assert.Equal(t, ErrSkipEvent, err)
+}
+
+func TestTemplateFormatterValidation(t *testing.T) {
+	for name, templates := range map[string]map[string]string{
+		"parse":          {LogFamilyNsgFlow: `{{.Fields.sourceIp`},
+		"unknown family": {"nsg_audit": `{{.Time}}`},
+		"execution":      {LogFamilyAppGwAccess: `{{inCIDR "10.0.0.0/33" .Fields.clientIP}}`},
+		"unknown func":   {LogFamilyNsgEvent: `{{.Fields.ruleName | shout}}`},
+	} {
+		files, dir := writeTestTemplates(t, templates)
+		_, err := NewTemplateFormatter(files)
+		assert.Error(t, err, name)
+		os.RemoveAll(dir)
+	}
+
+	_, err := NewTemplateFormatter(nil)
+	assert.Error(t, err)
+	_, err = NewTemplateFormatter(map[string]string{LogFamilyNsgFlow: "missing.tmpl"})
+	assert.Error(t, err)
+
+	// Fields missing from the sample, such as enrichment fields, only warn.
+	files, dir := writeTestTemplates(t, map[string]string{LogFamilyNsgFlow: `{{.Fields.geoCountry}}`})
+	defer os.RemoveAll(dir)
+	_, err = NewTemplateFormatter(files)
+	assert.Nil(t, err)
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	assert.Equal(t, `a\|b\\c`, cefHeaderEscape(`a|b\c`))
+	assert.Equal(t, `a\=b\nc`, cefValueEscape("a=b\nc"))
+	assert.Equal(t, `"say ""hi"", ok"`, csvQuote(`say "hi", ok`))
+	assert.Equal(t, "plain", csvQuote("plain"))
+	assert.Equal(t, "2001:db8::/32", templateNetwork(32, "2001:db8:1::1"))
+	assert.Equal(t, "n/a", templateNetwork(8, "n/a"))
+	assert.Equal(t, 6, templateIPVersion("2001:db8::1"))
+	assert.True(t, templateIsPrivate("172.20.1.1"))
+	assert.False(t, templateIsPrivate("40.85.232.72"))
+	inside, err := templateInCIDR("192.168.0.0/16, 10.0.0.0/8", "10.1.1.1")
+	assert.Nil(t, err)
+	assert.True(t, inside)
+	assert.Equal(t, "b", templateCoalesce("", "b", "c"))
+}
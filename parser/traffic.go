@@ -0,0 +1,331 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Traffic types set by TrafficClassifier in the trafficType extension.
+const (
+	TrafficIntraVNet = "intra-vnet"
+	TrafficCrossVNet = "cross-vnet"
+	TrafficAzure     = "azure-platform"
+	TrafficOnPrem    = "on-prem"
+	TrafficInternet  = "internet"
+	TrafficPrivate   = "private"
+)
+
+// Network labels of endpoints, set after the source and destination prefix
+// of NetworkKey.
+const (
+	NetworkVNet     = "vnet"
+	NetworkPeered   = "peered"
+	NetworkOnPrem   = "onprem"
+	NetworkPrivate  = "private"
+	NetworkAzure    = "azure"
+	NetworkInternet = "internet"
+)
+
+// Extension keys set by TrafficClassifier. NetworkKey and ServiceTagKey
+// follow the source and destination prefix.
+const (
+	TrafficTypeKey = "trafficType"
+	NetworkKey     = "Network"
+	ServiceTagKey  = "ServiceTag"
+)
+
+// trafficTypes are the values traffic_types of filters and routes can match.
+var trafficTypes = map[string]bool{
+	TrafficIntraVNet: true, TrafficCrossVNet: true, TrafficAzure: true,
+	TrafficOnPrem: true, TrafficInternet: true, TrafficPrivate: true,
+}
+
+const trafficCacheSize = 10000
+
+// TrafficVNet is a VNet of our own and its address spaces.
+type TrafficVNet struct {
+	Name  string   `mapstructure:"name"`
+	CIDRs []string `mapstructure:"cidrs"`
+}
+
+// TrafficConfig configures a TrafficClassifier.
+type TrafficConfig struct {
+	// ServiceTagsFile is the Azure IP Ranges and Service Tags json file.
+	ServiceTagsFile string        `mapstructure:"service_tags_file"`
+	VNets           []TrafficVNet `mapstructure:"traffic_vnets"`
+	PeeredCIDRs     []string      `mapstructure:"traffic_peered_cidrs"`
+	OnPremCIDRs     []string      `mapstructure:"traffic_onprem_cidrs"`
+	// ReloadInterval is how often, in seconds, the service tags file is
+	// checked for changes.
+	ReloadInterval int `mapstructure:"service_tags_reload_interval"`
+}
+
+// Enabled reports whether anything is configured to classify traffic with.
+func (config TrafficConfig) Enabled() bool {
+	return config.ServiceTagsFile != "" || len(config.VNets)+len(config.PeeredCIDRs)+len(config.OnPremCIDRs) > 0
+}
+
+// TrafficClassifier labels the network of the source and destination of
+// events, and the traffic of flows as intra-vnet, cross-vnet, azure-platform,
+// on-prem, internet or private, much as Traffic Analytics does.
+//
+// Endpoints in the configured ranges take their label, the narrowest range
+// winning. Other private addresses are in the VNet set by AssetInventory
+// when known, and private otherwise. Public addresses are azure, with their
+// service tag, when in the service tags file and internet otherwise.
+type TrafficClassifier struct {
+	config    TrafficConfig
+	ranges    *cidrTrie
+	cache     *lruCache
+	mutex     sync.RWMutex
+	tags      *cidrTrie
+	file      watchedFile
+	lastCheck time.Time
+}
+
+// trafficRange is a configured range.
+type trafficRange struct {
+	network string
+	vnet    string
+}
+
+// serviceTag is a service tag of the service tags file.
+type serviceTag struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Region          string   `json:"region"`
+		SystemService   string   `json:"systemService"`
+		AddressPrefixes []string `json:"addressPrefixes"`
+	} `json:"properties"`
+}
+
+// trafficEndpoint is the classification of an address.
+type trafficEndpoint struct {
+	network    string
+	vnet       string
+	serviceTag string
+}
+
+// NewTrafficClassifier parses the configured ranges and loads the service
+// tags file.
+func NewTrafficClassifier(config TrafficConfig) (*TrafficClassifier, error) {
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = 60
+	}
+	classifier := &TrafficClassifier{config: config, ranges: newCIDRTrie(), cache: newLRUCache(trafficCacheSize)}
+	for _, vnet := range config.VNets {
+		if vnet.Name == "" || len(vnet.CIDRs) == 0 {
+			return nil, fmt.Errorf("traffic_vnets need a name and cidrs")
+		}
+		err := classifier.addRanges(vnet.CIDRs, trafficRange{network: NetworkVNet, vnet: vnet.Name})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := classifier.addRanges(config.PeeredCIDRs, trafficRange{network: NetworkPeered})
+	if err != nil {
+		return nil, err
+	}
+	err = classifier.addRanges(config.OnPremCIDRs, trafficRange{network: NetworkOnPrem})
+	if err != nil {
+		return nil, err
+	}
+	if config.ServiceTagsFile != "" {
+		err = classifier.loadServiceTags(config.ServiceTagsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	classifier.lastCheck = time.Now()
+	return classifier, nil
+}
+
+func (classifier *TrafficClassifier) addRanges(cidrs []string, value trafficRange) error {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		classifier.ranges.Insert(network, value)
+	}
+	return nil
+}
+
+// loadServiceTags loads the service tags file as downloaded from the
+// Microsoft Download Center or by az network list-service-tags.
+func (classifier *TrafficClassifier) loadServiceTags(path string) error {
+	file, err := statFile(path)
+	if err != nil {
+		return fmt.Errorf("error opening service tags: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading service tags: %s", err)
+	}
+	document := struct {
+		Values []*serviceTag `json:"values"`
+	}{}
+	err = json.Unmarshal(data, &document)
+	if err != nil {
+		return fmt.Errorf("error reading service tags %s: %s", path, err)
+	}
+	tags := newCIDRTrie()
+	for _, tag := range document.Values {
+		for _, prefix := range tag.Properties.AddressPrefixes {
+			_, network, err := net.ParseCIDR(prefix)
+			if err != nil {
+				return fmt.Errorf("invalid prefix %q of service tag %s", prefix, tag.Name)
+			}
+			tags.Insert(network, tag)
+		}
+	}
+	log.WithFields(log.Fields{"path": path, "tags": len(document.Values), "prefixes": tags.Len()}).Info("loaded service tags")
+
+	classifier.mutex.Lock()
+	defer classifier.mutex.Unlock()
+	classifier.tags, classifier.file = tags, file
+	classifier.cache.Purge()
+	return nil
+}
+
+// Enrich labels the source and destination of event and the traffic type.
+func (classifier *TrafficClassifier) Enrich(event *CEFEvent) {
+	classifier.reloadChanged()
+	extension := event.Extension
+	if extension["src"] == "" {
+		return
+	}
+	source := classifier.endpoint(extension["src"], extension["source"+AssetVNetKey])
+	setTrafficEndpoint(event, "source", source)
+	if extension["dst"] == "" {
+		event.Extension[TrafficTypeKey] = trafficType(source, nil)
+		return
+	}
+	destination := classifier.endpoint(extension["dst"], extension["destination"+AssetVNetKey])
+	setTrafficEndpoint(event, "destination", destination)
+	event.Extension[TrafficTypeKey] = trafficType(source, &destination)
+}
+
+func setTrafficEndpoint(event *CEFEvent, prefix string, endpoint trafficEndpoint) {
+	setExtension(event, prefix+NetworkKey, endpoint.network)
+	setExtension(event, prefix+ServiceTagKey, endpoint.serviceTag)
+	if endpoint.vnet != "" && event.Extension[prefix+AssetVNetKey] == "" {
+		event.Extension[prefix+AssetVNetKey] = endpoint.vnet
+	}
+}
+
+// trafficType classifies a flow by its endpoints. Events without a
+// destination, such as Application Gateway logs, are private rather than
+// intra-vnet when the source is in a VNet.
+func trafficType(source trafficEndpoint, destination *trafficEndpoint) string {
+	networks := map[string]bool{source.network: true}
+	if destination != nil {
+		networks[destination.network] = true
+	}
+	switch {
+	case networks[NetworkOnPrem]:
+		return TrafficOnPrem
+	case networks[NetworkInternet]:
+		return TrafficInternet
+	case networks[NetworkAzure]:
+		return TrafficAzure
+	case destination == nil || networks[NetworkPrivate] || networks[""]:
+		return TrafficPrivate
+	case source.network == NetworkVNet && destination.network == NetworkVNet && strings.EqualFold(source.vnet, destination.vnet):
+		return TrafficIntraVNet
+	default:
+		return TrafficCrossVNet
+	}
+}
+
+// endpoint classifies address. vnet is the VNet of the address set by
+// AssetInventory, if any.
+func (classifier *TrafficClassifier) endpoint(address, vnet string) trafficEndpoint {
+	var endpoint trafficEndpoint
+	if cached, ok := classifier.cache.Get(address); ok {
+		endpoint = cached.(trafficEndpoint)
+	} else {
+		endpoint = classifier.lookup(address)
+		classifier.cache.Add(address, endpoint)
+	}
+	if endpoint.network == NetworkPrivate && vnet != "" {
+		endpoint = trafficEndpoint{network: NetworkVNet, vnet: vnet}
+	}
+	return endpoint
+}
+
+func (classifier *TrafficClassifier) lookup(address string) trafficEndpoint {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return trafficEndpoint{}
+	}
+	if match, ok := classifier.ranges.Longest(ip); ok {
+		configured := match.Values[len(match.Values)-1].(trafficRange)
+		return trafficEndpoint{network: configured.network, vnet: configured.vnet}
+	}
+	if !isPublicIP(ip) {
+		return trafficEndpoint{network: NetworkPrivate}
+	}
+	classifier.mutex.RLock()
+	tags := classifier.tags
+	classifier.mutex.RUnlock()
+	if tags != nil {
+		if tag := bestServiceTag(tags.Matches(ip)); tag != nil {
+			return trafficEndpoint{network: NetworkAzure, serviceTag: tag.Name}
+		}
+	}
+	return trafficEndpoint{network: NetworkInternet}
+}
+
+// bestServiceTag returns the most specific tag of matches. Tags of a service,
+// such as Storage.CanadaCentral, win over AzureCloud tags and regional tags
+// over global ones, then the narrowest prefix wins.
+func bestServiceTag(matches []cidrMatch) *serviceTag {
+	var best *serviceTag
+	bestRank := -1
+	for _, match := range matches {
+		for _, value := range match.Values {
+			tag := value.(*serviceTag)
+			rank := match.Bits
+			if tag.Properties.Region != "" {
+				rank += 1000
+			}
+			if tag.Properties.SystemService != "" {
+				rank += 2000
+			}
+			if rank > bestRank {
+				best, bestRank = tag, rank
+			}
+		}
+	}
+	return best
+}
+
+// reloadChanged reloads the service tags when the file changed, at most once
+// per reload interval. Tags that fail to load are kept as they were.
+func (classifier *TrafficClassifier) reloadChanged() {
+	if classifier.config.ServiceTagsFile == "" {
+		return
+	}
+	classifier.mutex.Lock()
+	if time.Since(classifier.lastCheck) < time.Duration(classifier.config.ReloadInterval)*time.Second {
+		classifier.mutex.Unlock()
+		return
+	}
+	classifier.lastCheck = time.Now()
+	file := classifier.file
+	classifier.mutex.Unlock()
+	if !file.changed() {
+		return
+	}
+	err := classifier.loadServiceTags(file.path)
+	if err != nil {
+		log.Error(err)
+	}
+}
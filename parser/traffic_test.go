@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testServiceTags is an excerpt of the Azure IP Ranges and Service Tags file.
+const testServiceTags = `{"changeNumber": 250, "cloud": "Public", "values": [
+{"name": "AzureCloud", "id": "AzureCloud", "properties": {"changeNumber": 80, "region": "", "regionId": 0, "platform": "Azure",
+  "systemService": "", "addressPrefixes": ["40.64.0.0/10", "52.224.0.0/11", "2603:1030::/32"], "networkFeatures": ["API", "NSG"]}},
+{"name": "AzureCloud.canadacentral", "id": "AzureCloud.canadacentral", "properties": {"region": "canadacentral", "regionId": 9,
+  "systemService": "", "addressPrefixes": ["40.85.192.0/18", "52.237.0.0/18"]}},
+{"name": "Storage", "id": "Storage", "properties": {"region": "", "systemService": "AzureStorage", "addressPrefixes": ["40.85.232.0/24"]}},
+{"name": "Storage.CanadaCentral", "id": "Storage.CanadaCentral", "properties": {"region": "canadacentral",
+  "systemService": "AzureStorage", "addressPrefixes": ["40.85.232.0/24"]}},
+{"name": "AzureMonitor", "id": "AzureMonitor", "properties": {"region": "", "systemService": "AzureMonitor", "addressPrefixes": ["52.237.0.0/16"]}}
+]}`
+
+func newTestTrafficClassifier(t *testing.T) (*TrafficClassifier, string) {
+	dir, err := ioutil.TempDir("", "nsg-parser-traffic")
+	require.Nil(t, err)
+	path := filepath.Join(dir, "ServiceTags_Public.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(testServiceTags), 0644))
+	classifier, err := NewTrafficClassifier(TrafficConfig{
+		ServiceTagsFile: path,
+		VNets: []TrafficVNet{
+			{Name: "prod-vnet", CIDRs: []string{"10.5.0.0/16"}},
+			{Name: "dev-vnet", CIDRs: []string{"10.6.0.0/16"}},
+		},
+		PeeredCIDRs: []string{"10.8.0.0/16"},
+		OnPremCIDRs: []string{"192.168.0.0/16"},
+	})
+	require.Nil(t, err)
+	return classifier, dir
+}
+
+// classifyTuple classifies a flow from 10.5.16.4 to destination.
+func classifyTuple(t *testing.T, classifier *TrafficClassifier, destination string) *CEFEvent {
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,"+destination+",44931,443,T,O,A,B,,,,")[0]
+	classifier.Enrich(event)
+	return event
+}
+
+func TestTrafficClassifier(t *testing.T) {
+	classifier, dir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(dir)
+
+	for destination, trafficType := range map[string]string{
+		"40.85.232.72":  TrafficAzure,
+		"10.5.17.20":    TrafficIntraVNet,
+		"10.6.0.5":      TrafficCrossVNet,
+		"10.8.1.1":      TrafficCrossVNet,
+		"192.168.1.10":  TrafficOnPrem,
+		"94.102.49.190": TrafficInternet,
+		"172.16.0.9":    TrafficPrivate,
+	} {
+		event := classifyTuple(t, classifier, destination)
+		assert.Equal(t, trafficType, event.Extension["trafficType"], destination)
+		assert.Equal(t, NetworkVNet, event.Extension["sourceNetwork"], destination)
+		assert.Equal(t, "prod-vnet", event.Extension["sourceVnet"], destination)
+	}
+
+	event := classifyTuple(t, classifier, "40.85.232.72")
+	assert.Equal(t, NetworkAzure, event.Extension["destinationNetwork"])
+	assert.Equal(t, "Storage.CanadaCentral", event.Extension["destinationServiceTag"])
+	assert.NotContains(t, event.Extension, "sourceServiceTag")
+	event = classifyTuple(t, classifier, "40.85.193.1")
+	assert.Equal(t, "AzureCloud.canadacentral", event.Extension["destinationServiceTag"])
+	event = classifyTuple(t, classifier, "40.64.0.1")
+	assert.Equal(t, "AzureCloud", event.Extension["destinationServiceTag"])
+	event = classifyTuple(t, classifier, "10.6.0.5")
+	assert.Equal(t, "dev-vnet", event.Extension["destinationVnet"])
+	event = classifyTuple(t, classifier, "10.8.1.1")
+	assert.Equal(t, NetworkPeered, event.Extension["destinationNetwork"])
+}
+
+func TestTrafficClassifierAppGw(t *testing.T) {
+	classifier, dir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(dir)
+
+	events := loadTestAppGwFirewallEvents(t)
+	classifier.Enrich(events[0])
+	assert.Equal(t, TrafficAzure, events[0].Extension["trafficType"])
+	assert.Equal(t, "AzureMonitor", events[0].Extension["sourceServiceTag"])
+	assert.NotContains(t, events[0].Extension, "destinationNetwork")
+}
+
+func TestTrafficClassifierInventoryVNets(t *testing.T) {
+	dir, path := writeTestInventory(t, "inventory.csv", testInventoryCSV)
+	defer os.RemoveAll(dir)
+	inventory, err := NewAssetInventory(path, time.Minute)
+	require.Nil(t, err)
+	classifier, err := NewTrafficClassifier(TrafficConfig{OnPremCIDRs: []string{"192.168.0.0/16"}})
+	require.Nil(t, err)
+
+	event := sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0]
+	inventory.Enrich(event)
+	classifier.Enrich(event)
+	assert.Equal(t, TrafficIntraVNet, event.Extension["trafficType"])
+
+	// Without the inventory the VNets are unknown.
+	event = sessionTestEvents(t, "1542110377,10.5.16.4,10.5.17.20,44931,1433,T,O,A,B,,,,")[0]
+	classifier.Enrich(event)
+	assert.Equal(t, TrafficPrivate, event.Extension["trafficType"])
+	assert.Equal(t, NetworkPrivate, event.Extension["destinationNetwork"])
+}
+
+func TestTrafficClassifierReload(t *testing.T) {
+	classifier, dir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(dir)
+	assert.Equal(t, TrafficInternet, classifyTuple(t, classifier, "94.102.49.190").Extension["trafficType"])
+
+	path := filepath.Join(dir, "ServiceTags_Public.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"values": [{"name": "AzureFrontDoor.Backend",
+		"properties": {"systemService": "AzureFrontDoor", "addressPrefixes": ["94.102.49.0/24"]}}]}`), 0644))
+	classifier.lastCheck = time.Now().Add(-time.Hour)
+	event := classifyTuple(t, classifier, "94.102.49.190")
+	assert.Equal(t, TrafficAzure, event.Extension["trafficType"])
+	assert.Equal(t, "AzureFrontDoor.Backend", event.Extension["destinationServiceTag"])
+
+	// A broken file keeps the loaded tags.
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"values": [`), 0644))
+	classifier.lastCheck = time.Now().Add(-time.Hour)
+	assert.Equal(t, TrafficAzure, classifyTuple(t, classifier, "94.102.49.190").Extension["trafficType"])
+}
+
+func TestNewTrafficClassifierErrors(t *testing.T) {
+	_, err := NewTrafficClassifier(TrafficConfig{VNets: []TrafficVNet{{Name: "prod-vnet"}}})
+	assert.NotNil(t, err)
+	_, err = NewTrafficClassifier(TrafficConfig{OnPremCIDRs: []string{"192.168.0.0"}})
+	assert.NotNil(t, err)
+	_, err = NewTrafficClassifier(TrafficConfig{ServiceTagsFile: "missing.json"})
+	assert.NotNil(t, err)
+	assert.False(t, TrafficConfig{ReloadInterval: 60}.Enabled())
+}
+
+func TestTrafficTypesFiltersAndRoutes(t *testing.T) {
+	classifier, dir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(dir)
+	internet := classifyTuple(t, classifier, "94.102.49.190")
+	azure := classifyTuple(t, classifier, "40.85.232.72")
+
+	chain, err := NewFilterChain("test", []Filter{{Name: "no_platform", Mode: FilterExclude, TrafficTypes: []string{"Azure-Platform"}}}, "")
+	require.Nil(t, err)
+	assert.True(t, chain.Keep(internet))
+	assert.False(t, chain.Keep(azure))
+	_, err = NewFilterChain("test", []Filter{{Name: "bad", Mode: FilterExclude, TrafficTypes: []string{"external"}}}, "")
+	assert.NotNil(t, err)
+
+	table, err := NewRouteTable([]Route{{Name: "internet", TrafficTypes: []string{TrafficInternet}, Destinations: []string{"siem"}}},
+		[]string{"archive"}, []string{"siem", "archive"})
+	require.Nil(t, err)
+	assert.Equal(t, []*CEFEvent{internet}, table.Select("siem", []*CEFEvent{internet, azure}))
+	assert.Equal(t, []*CEFEvent{azure}, table.Select("archive", []*CEFEvent{internet, azure}))
+	_, err = NewRouteTable([]Route{{Name: "bad", TrafficTypes: []string{"external"}, Destinations: []string{"siem"}}}, nil, []string{"siem"})
+	assert.NotNil(t, err)
+}
+
+func TestTrafficTypeFormats(t *testing.T) {
+	classifier, dir := newTestTrafficClassifier(t)
+	defer os.RemoveAll(dir)
+	event := classifyTuple(t, classifier, "40.85.232.72")
+
+	text, err := event.CEFText()
+	require.Nil(t, err)
+	assert.Contains(t, text, "trafficType=azure-platform")
+
+	flat, err := flatFormatter{}.Format(event)
+	require.Nil(t, err)
+	flowLog := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(flat, &flowLog))
+	assert.Equal(t, "azure-platform", flowLog["trafficType"])
+	assert.Equal(t, "Storage.CanadaCentral", flowLog["destinationServiceTag"])
+	assert.Equal(t, "azure-platform", EventFields(event)["trafficType"])
+
+	eve, err := eveFormatter{}.Format(event)
+	require.Nil(t, err)
+	assert.Contains(t, string(eve), `"traffic_type":"azure-platform"`)
+
+	ecs, err := NewECSDocument(event)
+	require.Nil(t, err)
+	assert.Equal(t, "azure-platform", schemaValue(t, ecs, "azure", "traffic_type"))
+	assert.Equal(t, "Storage.CanadaCentral", schemaValue(t, ecs, "azure", "destination", "service_tag"))
+	ocsf, err := NewOCSFEvent(event)
+	require.Nil(t, err)
+	assert.Equal(t, "vnet", schemaValue(t, ocsf, "src_endpoint", "zone"))
+	assert.Equal(t, "azure-platform", schemaValue(t, ocsf, "unmapped", "traffic_type"))
+
+	row, err := nsgFlowParquetRow(event)
+	require.Nil(t, err)
+	data, err := parquetData(parquetTables[LogFamilyNsgFlow].schema, row)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("azure-platform"), data["trafficType"])
+}
@@ -0,0 +1,275 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatZeek renders flow tuples as Zeek conn.log TSV records.
+	FormatZeek = "zeek"
+	// FormatZeekJSON renders flow tuples as Zeek conn.log JSON records.
+	FormatZeekJSON = "zeek-json"
+	// FormatEVE renders flow tuples as Suricata EVE flow events.
+	FormatEVE = "eve"
+
+	zeekUnset = "-"
+	// Suricata limits flow ids to 51 bits so that they survive JSON
+	// consumers using doubles.
+	eveFlowIDMask = 1<<51 - 1
+)
+
+var (
+	zeekFields = []string{"ts", "uid", "id.orig_h", "id.orig_p", "id.resp_h", "id.resp_p", "proto", "service", "duration",
+		"orig_bytes", "resp_bytes", "conn_state", "local_orig", "local_resp", "missed_bytes", "history",
+		"orig_pkts", "orig_ip_bytes", "resp_pkts", "resp_ip_bytes", "tunnel_parents", "community_id"}
+	zeekTypes = []string{"time", "string", "addr", "port", "addr", "port", "enum", "string", "interval",
+		"count", "count", "string", "bool", "bool", "count", "string",
+		"count", "count", "count", "count", "set[string]", "string"}
+
+	// Denied flows are attempts without a reply. Allowed version 1 tuples
+	// carry no state and are treated as midstream traffic.
+	zeekConnStates = map[string]string{"B": "S1", "C": "S1", "E": "SF", "": "OTH"}
+	eveFlowStates  = map[string]string{"B": "new", "C": "established", "E": "closed"}
+	flowProtocols  = map[string]uint8{"T": 6, "U": 17}
+)
+
+// zeekFormatter renders nsg_flow events as conn.log TSV records, starting
+// files with the conn.log header. Other log families are skipped.
+type zeekFormatter struct{}
+
+func (zeekFormatter) Header(family string) ([]byte, error) {
+	if family != LogFamilyNsgFlow {
+		return nil, fmt.Errorf("no zeek log for log family %q", family)
+	}
+	header := []string{
+		`#separator \x09`,
+		"#set_separator\t,",
+		"#empty_field\t(empty)",
+		"#unset_field\t" + zeekUnset,
+		"#path\tconn",
+		"#fields\t" + strings.Join(zeekFields, "\t"),
+		"#types\t" + strings.Join(zeekTypes, "\t"),
+	}
+	return []byte(strings.Join(header, "\n")), nil
+}
+
+func (zeekFormatter) Format(event *CEFEvent) ([]byte, error) {
+	values, err := newZeekConn(event)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(values, "\t")), nil
+}
+
+// zeekJSONFormatter renders nsg_flow events as conn.log JSON records, as
+// written by Zeek with LogAscii::use_json. Unset fields are left out.
+type zeekJSONFormatter struct{}
+
+func (zeekJSONFormatter) Format(event *CEFEvent) ([]byte, error) {
+	values, err := newZeekConn(event)
+	if err != nil {
+		return nil, err
+	}
+	record := map[string]interface{}{}
+	for i, field := range zeekFields {
+		switch {
+		case values[i] == zeekUnset:
+		case zeekTypes[i] == "bool":
+			record[field] = values[i] == "T"
+		case zeekTypes[i] == "time" || zeekTypes[i] == "port" || zeekTypes[i] == "count":
+			record[field] = json.Number(values[i])
+		default:
+			record[field] = values[i]
+		}
+	}
+	return json.Marshal(record)
+}
+
+// newZeekConn returns the conn.log values of a flow tuple in the order of
+// zeekFields. Version 2 counters are IP level, so they map to the ip_bytes
+// columns and the payload byte columns are unset.
+func newZeekConn(event *CEFEvent) ([]string, error) {
+	if event.LogFamily() != LogFamilyNsgFlow {
+		return nil, ErrSkipEvent
+	}
+	flowLog, err := NewNsgFlowLog(event)
+	if err != nil {
+		return nil, err
+	}
+	conn := map[string]string{
+		"ts":        strconv.FormatFloat(float64(event.Time.UnixNano())/float64(time.Second), 'f', 6, 64),
+		"uid":       zeekUID(flowLog),
+		"id.orig_h": flowLog.SourceIP,
+		"id.orig_p": strconv.Itoa(flowLog.SourcePort),
+		"id.resp_h": flowLog.DestinationIP,
+		"id.resp_p": strconv.Itoa(flowLog.DestinationPort),
+		"proto":     ecsTransports[flowLog.Protocol],
+	}
+	if flowLog.LastSeen > 0 {
+		conn["duration"] = strconv.FormatInt(flowLog.LastSeen-flowLog.Time, 10) + ".000000"
+	}
+	conn["conn_state"] = zeekConnStates[flowLog.FlowState]
+	if flowLog.Traffic == "D" {
+		conn["conn_state"] = "S0"
+	}
+	// The NSG sees flows from the NIC, so the local side follows the
+	// direction.
+	switch flowLog.TrafficFlow {
+	case "O":
+		conn["local_orig"] = "T"
+	case "I":
+		conn["local_resp"] = "T"
+	}
+	if flowLog.PacketsSent != nil {
+		conn["orig_pkts"] = strconv.FormatInt(*flowLog.PacketsSent, 10)
+		conn["orig_ip_bytes"] = strconv.FormatInt(*flowLog.BytesSent, 10)
+		conn["resp_pkts"] = strconv.FormatInt(*flowLog.PacketsReceived, 10)
+		conn["resp_ip_bytes"] = strconv.FormatInt(*flowLog.BytesReceived, 10)
+	}
+	conn["community_id"] = communityID(flowLog)
+
+	values := make([]string, len(zeekFields))
+	for i, field := range zeekFields {
+		values[i] = conn[field]
+		if values[i] == "" {
+			values[i] = zeekUnset
+		}
+	}
+	return values, nil
+}
+
+type eveFlow struct {
+	PacketsToServer *int64 `json:"pkts_toserver,omitempty"`
+	PacketsToClient *int64 `json:"pkts_toclient,omitempty"`
+	BytesToServer   *int64 `json:"bytes_toserver,omitempty"`
+	BytesToClient   *int64 `json:"bytes_toclient,omitempty"`
+	Start           string `json:"start"`
+	End             string `json:"end"`
+	Age             int    `json:"age"`
+	State           string `json:"state,omitempty"`
+	Reason          string `json:"reason"`
+	Alerted         bool   `json:"alerted"`
+}
+
+// eveNsg carries the NSG fields that EVE has no place for.
+type eveNsg struct {
+	Rule                  string `json:"rule,omitempty"`
+	Mac                   string `json:"mac,omitempty"`
+	Decision              string `json:"decision"`
+	TrafficType           string `json:"traffic_type,omitempty"`
+	SourceServiceTag      string `json:"src_service_tag,omitempty"`
+	DestinationServiceTag string `json:"dest_service_tag,omitempty"`
+}
+
+type eveEvent struct {
+	Timestamp   string  `json:"timestamp"`
+	FlowID      uint64  `json:"flow_id"`
+	EventType   string  `json:"event_type"`
+	Host        string  `json:"host,omitempty"`
+	SrcIP       string  `json:"src_ip"`
+	SrcPort     int     `json:"src_port"`
+	DestIP      string  `json:"dest_ip"`
+	DestPort    int     `json:"dest_port"`
+	Proto       string  `json:"proto"`
+	CommunityID string  `json:"community_id"`
+	Flow        eveFlow `json:"flow"`
+	Nsg         eveNsg  `json:"nsg"`
+}
+
+// eveFormatter renders nsg_flow events as Suricata EVE flow events. Other
+// log families are skipped.
+type eveFormatter struct{}
+
+func (eveFormatter) Format(event *CEFEvent) ([]byte, error) {
+	if event.LogFamily() != LogFamilyNsgFlow {
+		return nil, ErrSkipEvent
+	}
+	flowLog, err := NewNsgFlowLog(event)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := event.Time.UTC().Format("2006-01-02T15:04:05.000000-0700")
+	end, age := timestamp, 0
+	if flowLog.LastSeen > 0 {
+		end = time.Unix(flowLog.LastSeen, 0).UTC().Format("2006-01-02T15:04:05.000000-0700")
+		age = int(flowLog.LastSeen - flowLog.Time)
+	}
+	eve := eveEvent{
+		Timestamp:   timestamp,
+		FlowID:      flowHash(flowLog) & eveFlowIDMask,
+		EventType:   "flow",
+		Host:        flowLog.NsgName,
+		SrcIP:       flowLog.SourceIP,
+		SrcPort:     flowLog.SourcePort,
+		DestIP:      flowLog.DestinationIP,
+		DestPort:    flowLog.DestinationPort,
+		Proto:       strings.ToUpper(ecsTransports[flowLog.Protocol]),
+		CommunityID: communityID(flowLog),
+		Flow: eveFlow{
+			PacketsToServer: flowLog.PacketsSent,
+			PacketsToClient: flowLog.PacketsReceived,
+			BytesToServer:   flowLog.BytesSent,
+			BytesToClient:   flowLog.BytesReceived,
+			Start:           timestamp,
+			End:             end,
+			Age:             age,
+			State:           eveFlowStates[flowLog.FlowState],
+			Reason:          "timeout",
+		},
+		Nsg: eveNsg{Rule: flowLog.Rule, Mac: flowLog.Mac, Decision: ecsActions[flowLog.Traffic], TrafficType: flowLog.TrafficType,
+			SourceServiceTag: flowLog.SourceServiceTag, DestinationServiceTag: flowLog.DestinationServiceTag},
+	}
+	return json.Marshal(eve)
+}
+
+// flowHash identifies the flow of a tuple on its NIC. Every tuple of a
+// version 2 flow hashes the same.
+func flowHash(flowLog *NsgFlowLog) uint64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s|%s|%s|%d|%s|%d", flowLog.SystemID, flowLog.Protocol,
+		flowLog.SourceIP, flowLog.SourcePort, flowLog.DestinationIP, flowLog.DestinationPort)
+	return hash.Sum64()
+}
+
+// zeekUID renders the flow hash as a Zeek style connection uid.
+func zeekUID(flowLog *NsgFlowLog) string {
+	return "C" + new(big.Int).SetUint64(flowHash(flowLog)).Text(62)
+}
+
+// communityID returns the version 1 Community ID of a flow tuple with the
+// default seed, see https://github.com/corelight/community-id-spec.
+func communityID(flowLog *NsgFlowLog) string {
+	sourceIP := net.ParseIP(flowLog.SourceIP)
+	destinationIP := net.ParseIP(flowLog.DestinationIP)
+	if sourceIP == nil || destinationIP == nil {
+		return ""
+	}
+	if ip := sourceIP.To4(); ip != nil {
+		sourceIP, destinationIP = ip, destinationIP.To4()
+	}
+	sourcePort, destinationPort := uint16(flowLog.SourcePort), uint16(flowLog.DestinationPort)
+	if order := bytes.Compare(sourceIP, destinationIP); order > 0 || order == 0 && sourcePort > destinationPort {
+		sourceIP, destinationIP = destinationIP, sourceIP
+		sourcePort, destinationPort = destinationPort, sourcePort
+	}
+
+	hash := sha1.New()
+	binary.Write(hash, binary.BigEndian, uint16(0))
+	hash.Write(sourceIP)
+	hash.Write(destinationIP)
+	hash.Write([]byte{flowProtocols[flowLog.Protocol], 0})
+	binary.Write(hash, binary.BigEndian, sourcePort)
+	binary.Write(hash, binary.BigEndian, destinationPort)
+	return "1:" + base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestZeekFormatter(t *testing.T) {
+	formatter, err := NewEventFormatter(FormatZeek)
+	require.Nil(t, err)
+	headerFormatter, ok := formatter.(HeaderFormatter)
+	require.True(t, ok)
+	header, err := headerFormatter.Header(LogFamilyNsgFlow)
+	require.Nil(t, err)
+	headerLines := strings.Split(string(header), "\n")
+	assert.Equal(t, "#path\tconn", headerLines[4])
+	fields := strings.Split(headerLines[5], "\t")[1:]
+	_, err = headerFormatter.Header(LogFamilyAppGwAccess)
+	assert.Error(t, err)
+
+	line, err := formatter.Format(loadTestEvents("nsg_flow_events.json", t)[0])
+	require.Nil(t, err)
+	values := strings.Split(string(line), "\t")
+	require.Equal(t, len(fields), len(values))
+	conn := map[string]string{}
+	for i, field := range fields {
+		conn[field] = values[i]
+	}
+	assert.Equal(t, "1497038813.000000", conn["ts"])
+	assert.Equal(t, "10.193.160.4", conn["id.orig_h"])
+	assert.Equal(t, "46010", conn["id.orig_p"])
+	assert.Equal(t, "40.85.232.72", conn["id.resp_h"])
+	assert.Equal(t, "443", conn["id.resp_p"])
+	assert.Equal(t, "tcp", conn["proto"])
+	assert.Equal(t, "OTH", conn["conn_state"])
+	assert.Equal(t, "T", conn["local_orig"])
+	assert.Equal(t, "-", conn["orig_ip_bytes"])
+	assert.True(t, strings.HasPrefix(conn["uid"], "C"))
+
+	_, err = formatter.Format(loadTestAppGwEvents(t)[0])
+	assert.Equal(t, ErrSkipEvent, err)
+}
+
+func TestZeekJSONFormatter(t *testing.T) {
+	formatter, err := NewEventFormatter(FormatZeekJSON)
+	require.Nil(t, err)
+	_, ok := formatter.(HeaderFormatter)
+	assert.False(t, ok)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	states := map[string]int{}
+	for _, event := range events {
+		line, err := formatter.Format(event)
+		require.Nil(t, err)
+		conn := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal(line, &conn))
+		states[conn["conn_state"].(string)]++
+		if conn["resp_ip_bytes"] == float64(27072) {
+			assert.Equal(t, "SF", conn["conn_state"])
+			assert.Equal(t, float64(52), conn["orig_pkts"])
+			assert.Equal(t, float64(29952), conn["orig_ip_bytes"])
+			assert.Equal(t, float64(47), conn["resp_pkts"])
+		}
+		assert.Nil(t, conn["service"])
+	}
+	assert.NotZero(t, states["S0"], "denied flows")
+	assert.NotZero(t, states["SF"])
+}
+
+func TestEVEFormatter(t *testing.T) {
+	formatter, err := NewEventFormatter(FormatEVE)
+	require.Nil(t, err)
+
+	events := loadTestEvents("nsg_flow_events_v2.json", t)
+	line, err := formatter.Format(events[0])
+	require.Nil(t, err)
+	eve := eveEvent{}
+	require.Nil(t, json.Unmarshal(line, &eve))
+	assert.Equal(t, "flow", eve.EventType)
+	assert.Equal(t, "UDP", eve.Proto)
+	assert.Equal(t, "new", eve.Flow.State)
+	assert.Equal(t, "denied", eve.Nsg.Decision)
+	assert.True(t, eve.FlowID > 0 && eve.FlowID < 1<<51)
+	assert.True(t, strings.HasSuffix(eve.Timestamp, ".000000+0000"))
+
+	line, err = formatter.Format(events[5])
+	require.Nil(t, err)
+	eve = eveEvent{}
+	require.Nil(t, json.Unmarshal(line, &eve))
+	assert.Equal(t, "closed", eve.Flow.State)
+	assert.Equal(t, int64(52), *eve.Flow.PacketsToServer)
+	assert.Equal(t, int64(27072), *eve.Flow.BytesToClient)
+
+	_, err = formatter.Format(loadTestAppGwFirewallEvents(t)[0])
+	assert.Equal(t, ErrSkipEvent, err)
+}
+
+func TestCommunityID(t *testing.T) {
+	// Example from the Community ID specification.
+	flowLog := &NsgFlowLog{Protocol: "T", SourceIP: "128.232.110.120", SourcePort: 34855,
+		DestinationIP: "66.35.250.204", DestinationPort: 80}
+	assert.Equal(t, "1:LQU9qZlK+B5F3KDmev6m5PMibrg=", communityID(flowLog))
+
+	reply := &NsgFlowLog{Protocol: "T", SourceIP: "66.35.250.204", SourcePort: 80,
+		DestinationIP: "128.232.110.120", DestinationPort: 34855}
+	assert.Equal(t, communityID(flowLog), communityID(reply))
+	assert.NotEqual(t, zeekUID(flowLog), zeekUID(reply))
+}